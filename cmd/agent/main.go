@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	goContext "context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ClusterOperator/kubepi/pkg/tunnel"
+	"github.com/ClusterOperator/kubepi/pkg/version"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	k8sError "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	agentTokenKey = "token"
+	clusterHeader = "X-KubePi-Cluster"
+)
+
+var (
+	serverURL          string
+	clusterName        string
+	registrationToken  string
+	secretName         string
+	credentialsDir     string
+	insecureSkipVerify bool
+)
+
+var logger = logrus.New()
+
+func init() {
+	RootCmd.Flags().StringVar(&serverURL, "server", os.Getenv("KUBEPI_SERVER"), "kubepi url, e.g. https://kubepi.example.com")
+	RootCmd.Flags().StringVar(&clusterName, "cluster", os.Getenv("KUBEPI_CLUSTER"), "cluster name in kubepi")
+	RootCmd.Flags().StringVar(&registrationToken, "registration-token", os.Getenv("KUBEPI_REGISTRATION_TOKEN"), "one-time registration token shown when the cluster is created")
+	RootCmd.Flags().StringVar(&secretName, "secret-name", "kubepi-agent-token", "secret in the agent namespace to save the agent token")
+	RootCmd.Flags().StringVar(&credentialsDir, "credentials-dir", "/var/run/secrets/kubernetes.io/serviceaccount", "directory of the service account token and ca.crt used by kubepi")
+	RootCmd.Flags().BoolVar(&insecureSkipVerify, "insecure-skip-tls-verify", false, "skip verifying the kubepi certificate")
+}
+
+var RootCmd = &cobra.Command{
+	Use:   "kubepi-agent",
+	Short: "Connect a cluster behind NAT to kubepi through a reverse tunnel",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if serverURL == "" || clusterName == "" {
+			return errors.New("--server and --cluster are required")
+		}
+		logger.Infof("kubepi-agent %s connecting cluster %s to %s", version.Version, clusterName, serverURL)
+		token, err := agentToken()
+		if err != nil {
+			return err
+		}
+		backoff := time.Second
+		for {
+			start := time.Now()
+			err := connect(token)
+			if err != nil {
+				logger.Errorf("tunnel closed: %s", err.Error())
+			} else {
+				logger.Info("tunnel closed")
+			}
+			if time.Since(start) > time.Minute {
+				backoff = time.Second
+			}
+			time.Sleep(backoff)
+			if backoff < time.Minute {
+				backoff *= 2
+			}
+		}
+	},
+}
+
+func httpClient() *http.Client {
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: insecureSkipVerify},
+		},
+	}
+}
+
+// agentToken reads the agent token saved in the secret, the agent registers with the
+// registration token and saves the returned agent token when the secret does not exist
+func agentToken() (string, error) {
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return "", err
+	}
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return "", err
+	}
+	ns, err := os.ReadFile(path.Join(credentialsDir, "namespace"))
+	if err != nil {
+		return "", err
+	}
+	namespace := strings.TrimSpace(string(ns))
+	ctx := goContext.Background()
+	secret, err := client.CoreV1().Secrets(namespace).Get(ctx, secretName, metav1.GetOptions{})
+	if err == nil {
+		return string(secret.Data[agentTokenKey]), nil
+	}
+	if !k8sError.IsNotFound(err) {
+		return "", err
+	}
+	if registrationToken == "" {
+		return "", fmt.Errorf("secret %s/%s not found, --registration-token is required", namespace, secretName)
+	}
+	token, err := register()
+	if err != nil {
+		return "", err
+	}
+	_, err = client.CoreV1().Secrets(namespace).Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: namespace},
+		Data:       map[string][]byte{agentTokenKey: []byte(token)},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("registered, but can not save agent token: %s", err.Error())
+	}
+	logger.Infof("registered, agent token saved in secret %s/%s", namespace, secretName)
+	return token, nil
+}
+
+func register() (string, error) {
+	bearerToken, err := os.ReadFile(path.Join(credentialsDir, "token"))
+	if err != nil {
+		return "", err
+	}
+	ca, err := os.ReadFile(path.Join(credentialsDir, "ca.crt"))
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(map[string]string{
+		"cluster":           clusterName,
+		"registrationToken": registrationToken,
+		"bearerToken":       strings.TrimSpace(string(bearerToken)),
+		"caCertificate":     string(ca),
+	})
+	if err != nil {
+		return "", err
+	}
+	resp, err := httpClient().Post(strings.TrimSuffix(serverURL, "/")+"/kubepi/api/v1/agent/register", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+		Data    struct {
+			AgentToken string `json:"agentToken"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("register failed with status %s", resp.Status)
+	}
+	if !result.Success || result.Data.AgentToken == "" {
+		return "", fmt.Errorf("register failed: %s", result.Message)
+	}
+	return result.Data.AgentToken, nil
+}
+
+func connect(token string) error {
+	u, err := url.Parse(strings.TrimSuffix(serverURL, "/") + "/kubepi/api/v1/agent/ws")
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "https" {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
+	}
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 30 * time.Second,
+		TLSClientConfig:  &tls.Config{InsecureSkipVerify: insecureSkipVerify},
+	}
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+token)
+	header.Set(clusterHeader, clusterName)
+	ws, resp, err := dialer.Dial(u.String(), header)
+	if err != nil {
+		if resp != nil {
+			return fmt.Errorf("%s, status %s", err.Error(), resp.Status)
+		}
+		return err
+	}
+	logger.Info("tunnel established")
+	apiServer := net.JoinHostPort(os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT"))
+	return tunnel.ServeAgent(goContext.Background(), tunnel.NewConn(ws), func() (net.Conn, error) {
+		return net.DialTimeout("tcp", apiServer, 10*time.Second)
+	})
+}
+
+func main() {
+	if err := RootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ClusterOperator/kubepi/internal/server"
+	"github.com/ClusterOperator/kubepi/migrate"
+	"github.com/ClusterOperator/kubepi/pkg/backup"
+	"github.com/ClusterOperator/kubepi/pkg/storage"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var backupDir string
+
+func init() {
+	backupCreateCmd.Flags().StringVar(&backupDir, "dir", "", "directory to save the backup, default is spec.backup.dir")
+	backupCmd.AddCommand(backupCreateCmd)
+	backupCmd.AddCommand(backupRestoreCmd)
+	RootCmd.AddCommand(backupCmd)
+}
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Backup and restore kubepi data",
+}
+
+var backupCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a backup, use the /systems/backups api instead while the server is running",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := server.LoadConfig(configPath)
+		if err != nil {
+			return err
+		}
+		if backupDir != "" {
+			c.Spec.Backup.Dir = backupDir
+		}
+		db, err := server.OpenDB(c.Spec.DB)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		name, err := server.WriteBackup(db, c.Spec.Backup)
+		if err != nil {
+			return err
+		}
+		fmt.Println(name)
+		return nil
+	},
+}
+
+var backupRestoreCmd = &cobra.Command{
+	Use:   "restore <backup file>",
+	Short: "Restore a backup into the configured database, the server must be stopped",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := server.LoadConfig(configPath)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		a, err := backup.Read(f)
+		if err != nil {
+			return err
+		}
+		if a.Manifest.MigrationVersion > migrate.LatestVersion() {
+			return fmt.Errorf("backup was created by kubepi %s with db version %d, which is newer than the supported db version %d",
+				a.Manifest.Version, a.Manifest.MigrationVersion, migrate.LatestVersion())
+		}
+		dbType := c.Spec.DB.Type
+		if dbType == "" || dbType == storage.TypeBolt {
+			if err := a.RestoreBolt(server.BoltFilePath(c.Spec.DB)); err != nil {
+				return err
+			}
+		} else {
+			db, err := server.OpenDB(c.Spec.DB)
+			if err != nil {
+				return err
+			}
+			err = a.RestoreRecords(db.(storage.Importer))
+			_ = db.Close()
+			if err != nil {
+				return err
+			}
+		}
+		db, err := server.OpenDB(c.Spec.DB)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		migrate.RunMigrate(db, logrus.New())
+		fmt.Printf("restored backup created at %s by kubepi %s\n", a.Manifest.CreateAt.Format("2006-01-02 15:04:05"), a.Manifest.Version)
+		return nil
+	},
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	v1Config "github.com/ClusterOperator/kubepi/internal/model/v1/config"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/cluster"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/clusterapp"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/clusterbinding"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/clusterrepo"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/common"
+	"github.com/ClusterOperator/kubepi/pkg/kubernetes"
+	"github.com/ClusterOperator/kubepi/pkg/storage"
+	"github.com/asdine/storm/v3"
+	"github.com/spf13/cobra"
+)
+
+var clusterCleanRBAC bool
+
+func init() {
+	clusterRemoveCmd.Flags().BoolVar(&clusterCleanRBAC, "clean-rbac", false, "also remove the rbac resources created by kubepi in the cluster")
+	clusterCmd.AddCommand(clusterListCmd, clusterRemoveCmd)
+	RootCmd.AddCommand(clusterCmd)
+}
+
+var clusterCmd = &cobra.Command{
+	Use:   "cluster",
+	Short: "Manage kubepi clusters, the server must be stopped",
+}
+
+var clusterListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List clusters",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withDB(func(c *v1Config.Config, db storage.DB) error {
+			cs, err := cluster.NewService().List(common.DBOptions{DB: db})
+			if err != nil && !errors.Is(err, storm.ErrNotFound) {
+				return err
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tAPI SERVER\tAUTHENTICATION\tVERSION\tCREATED BY\tCREATE AT")
+			for _, c := range cs {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", c.Name, c.Spec.Connect.Forward.ApiServer, c.Spec.Authentication.Mode,
+					c.Status.Version, c.CreatedBy, c.CreateAt.Format("2006-01-02 15:04:05"))
+			}
+			return w.Flush()
+		})
+	},
+}
+
+var clusterRemoveCmd = &cobra.Command{
+	Use:   "remove <cluster>",
+	Short: "Remove a cluster and its bindings, repos and apps",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withDB(func(c *v1Config.Config, db storage.DB) error {
+			name := args[0]
+			clusterService := cluster.NewService()
+			clusterBindingService := clusterbinding.NewService()
+			cs, err := clusterService.Get(name, common.DBOptions{DB: db})
+			if err != nil {
+				if errors.Is(err, storm.ErrNotFound) {
+					return fmt.Errorf("cluster %s not found", name)
+				}
+				return err
+			}
+			tx, err := db.Begin(true)
+			if err != nil {
+				return err
+			}
+			txOptions := common.DBOptions{DB: tx}
+			if err := removeCluster(name, clusterService, clusterBindingService, txOptions); err != nil {
+				_ = tx.Rollback()
+				return fmt.Errorf("delete cluster failed: %s", err.Error())
+			}
+			if err := tx.Commit(); err != nil {
+				return err
+			}
+			if clusterCleanRBAC {
+				if err := kubernetes.NewKubernetes(cs).CleanAllRBACResource(); err != nil {
+					fmt.Printf("clean rbac resources of cluster %s failed: %s\n", name, err.Error())
+				}
+				if kubernetes.ServiceAccountMode(cs) {
+					if err := kubernetes.NewKubernetes(cs).DeleteServiceAccount(); err != nil {
+						fmt.Printf("delete service account of cluster %s failed: %s\n", name, err.Error())
+					}
+				}
+			}
+			fmt.Printf("cluster %s removed\n", name)
+			return nil
+		})
+	},
+}
+
+func removeCluster(name string, clusterService cluster.Service, clusterBindingService clusterbinding.Service, options common.DBOptions) error {
+	if err := clusterService.Delete(name, options); err != nil {
+		return err
+	}
+	if err := clusterrepo.NewService().DeleteByCluster(name, options); err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	if err := clusterapp.NewService().DeleteByCluster(name, options); err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	bindings, err := clusterBindingService.GetClusterBindingByClusterName(name, options)
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	for i := range bindings {
+		if err := clusterBindingService.Delete(bindings[i].Name, options); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	v1Config "github.com/ClusterOperator/kubepi/internal/model/v1/config"
+	"github.com/ClusterOperator/kubepi/internal/server"
+	"github.com/ClusterOperator/kubepi/pkg/storage"
+)
+
+// withDB 打开配置中的数据库供离线命令使用，开启加密时返回解密后的存储，运行这些命令前需要停止服务
+func withDB(f func(c *v1Config.Config, db storage.DB) error) error {
+	c, err := server.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	d, err := server.OpenDB(c.Spec.DB)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	db, err := server.WithEncryption(d, c.Spec.Encryption)
+	if err != nil {
+		return err
+	}
+	return f(c, db)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ClusterOperator/kubepi/internal/server"
+	"github.com/ClusterOperator/kubepi/pkg/encryption"
+	"github.com/ClusterOperator/kubepi/pkg/storage/encrypted"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	encryptionCmd.AddCommand(encryptionGenerateKeyCmd)
+	encryptionCmd.AddCommand(encryptionRotateCmd)
+	RootCmd.AddCommand(encryptionCmd)
+}
+
+var encryptionCmd = &cobra.Command{
+	Use:   "encryption",
+	Short: "Manage encryption of stored credentials",
+}
+
+var encryptionGenerateKeyCmd = &cobra.Command{
+	Use:   "generate-key",
+	Short: "Generate a new master key for spec.encryption.key",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, err := encryption.GenerateKey()
+		if err != nil {
+			return err
+		}
+		fmt.Println(key)
+		return nil
+	},
+}
+
+var encryptionRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Re-encrypt every stored credential with the current master key, the server must be stopped",
+	Long: `Re-encrypt every stored credential with the current master key.
+Move the old key to spec.encryption.previousKeys and set the new key before running this command,
+after it succeeds the old key can be removed from previousKeys.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := server.LoadConfig(configPath)
+		if err != nil {
+			return err
+		}
+		if !c.Spec.Encryption.Enable {
+			return errors.New("spec.encryption.enable is false")
+		}
+		d, err := server.OpenDB(c.Spec.DB)
+		if err != nil {
+			return err
+		}
+		defer d.Close()
+		db, err := server.WithEncryption(d, c.Spec.Encryption)
+		if err != nil {
+			return err
+		}
+		updated, err := db.(*encrypted.DB).Rotate()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("re-encrypted %d records\n", updated)
+		return nil
+	},
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	v1Config "github.com/ClusterOperator/kubepi/internal/model/v1/config"
+	"github.com/ClusterOperator/kubepi/internal/server"
+	"github.com/ClusterOperator/kubepi/pkg/storage"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	exportOutput    string
+	importOverwrite bool
+)
+
+func init() {
+	exportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "file to write, default is stdout")
+	importCmd.Flags().BoolVar(&importOverwrite, "overwrite", false, "overwrite existing records with the same name")
+	RootCmd.AddCommand(exportCmd, importCmd)
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export users, roles, clusters and repos as yaml, credentials in the file are not encrypted",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withDB(func(c *v1Config.Config, db storage.DB) error {
+			e, err := server.ExportData(db)
+			if err != nil {
+				return err
+			}
+			bs, err := yaml.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if exportOutput == "" {
+				_, err = os.Stdout.Write(bs)
+				return err
+			}
+			return os.WriteFile(exportOutput, bs, 0600)
+		})
+	},
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Import users, roles, clusters and repos from a file created by export",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		bs, err := os.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		var e server.Export
+		if err := yaml.UnmarshalStrict(bs, &e); err != nil {
+			return err
+		}
+		return withDB(func(c *v1Config.Config, db storage.DB) error {
+			r, err := server.ImportData(db, &e, importOverwrite)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("created: %d, updated: %d, skipped: %d\n", r.Created, r.Updated, r.Skipped)
+			return nil
+		})
+	},
+}
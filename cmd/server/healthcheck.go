@@ -0,0 +1,35 @@
+package main
+
+import (
+	"time"
+
+	"github.com/ClusterOperator/kubepi/internal/server"
+	"github.com/spf13/cobra"
+)
+
+var healthCheckTimeout time.Duration
+
+func init() {
+	healthCheckCmd.Flags().StringVar(&serverBindHost, "server-bind-host", "", "kubepi bind address")
+	healthCheckCmd.Flags().IntVar(&serverBindPort, "server-bind-port", 0, "kubepi bind port")
+	healthCheckCmd.Flags().DurationVar(&healthCheckTimeout, "timeout", 3*time.Second, "request timeout")
+	RootCmd.AddCommand(healthCheckCmd)
+}
+
+var healthCheckCmd = &cobra.Command{
+	Use:   "healthcheck",
+	Short: "Check the healthz endpoint of the local server, address and scheme are read from the config",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := server.LoadConfig(configPath)
+		if err != nil {
+			return err
+		}
+		if serverBindHost != "" {
+			c.Spec.Server.Bind.Host = serverBindHost
+		}
+		if serverBindPort != 0 {
+			c.Spec.Server.Bind.Port = serverBindPort
+		}
+		return server.HealthCheck(c, healthCheckTimeout)
+	},
+}
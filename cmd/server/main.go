@@ -49,7 +49,7 @@ var IpCommonDictionary []byte
 func init() {
 	RootCmd.Flags().StringVar(&serverBindHost, "server-bind-host", "", "kubepi bind address")
 	RootCmd.Flags().IntVar(&serverBindPort, "server-bind-port", 0, "kubepi bind port")
-	RootCmd.Flags().StringVarP(&configPath, "config-path", "c", "", "config file path")
+	RootCmd.PersistentFlags().StringVarP(&configPath, "config-path", "c", "", "config file path")
 }
 
 var RootCmd = &cobra.Command{
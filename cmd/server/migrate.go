@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	v1Config "github.com/ClusterOperator/kubepi/internal/model/v1/config"
+	"github.com/ClusterOperator/kubepi/internal/server"
+	"github.com/ClusterOperator/kubepi/migrate"
+	"github.com/ClusterOperator/kubepi/pkg/storage"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	migrateTo         int
+	migrateDryRun     bool
+	migrateSkipBackup bool
+)
+
+func init() {
+	for _, c := range []*cobra.Command{migrateUpCmd, migrateDownCmd} {
+		c.Flags().BoolVar(&migrateDryRun, "dry-run", false, "run the migrations in a transaction and rollback it")
+		c.Flags().BoolVar(&migrateSkipBackup, "skip-backup", false, "do not create a backup before migration")
+		migrateCmd.AddCommand(c)
+	}
+	migrateUpCmd.Flags().IntVar(&migrateTo, "to", -1, "target db version, default is the latest version")
+	migrateDownCmd.Flags().IntVar(&migrateTo, "to", -1, "target db version, default is the previous version")
+	migrateCmd.AddCommand(migrateStatusCmd)
+	RootCmd.AddCommand(migrateCmd)
+}
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Manage kubepi db version, the server must be stopped before up or down",
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the current db version and all migrations",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withDB(func(c *v1Config.Config, db storage.DB) error {
+			current, ss, err := migrate.Status(db)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("current db version: %d, latest db version: %d\n", current, migrate.LatestVersion())
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "VERSION\tAPPLIED\tREVERSIBLE\tMESSAGE")
+			for _, s := range ss {
+				fmt.Fprintf(w, "%d\t%t\t%t\t%s\n", s.Version, s.Applied, s.Reversible, s.Message)
+			}
+			return w.Flush()
+		})
+	},
+}
+
+var migrateUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Upgrade the db to the latest or the specified version",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withDB(func(c *v1Config.Config, db storage.DB) error {
+			to := migrateTo
+			if to < 0 {
+				to = migrate.LatestVersion()
+			}
+			return runMigrate(c, db, to, migrate.Up)
+		})
+	},
+}
+
+var migrateDownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Rollback the db to the previous or the specified version",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withDB(func(c *v1Config.Config, db storage.DB) error {
+			to := migrateTo
+			if to < 0 {
+				current, err := migrate.CurrentVersion(db)
+				if err != nil {
+					return err
+				}
+				to = current - 1
+			}
+			return runMigrate(c, db, to, migrate.Down)
+		})
+	},
+}
+
+type migrateFunc func(db storage.DB, to int, dryRun bool, logger *logrus.Logger) (int, error)
+
+func runMigrate(c *v1Config.Config, db storage.DB, to int, f migrateFunc) error {
+	if !migrateDryRun && !migrateSkipBackup {
+		name, err := server.BackupBeforeMigrate(db, c.Spec.Backup, to)
+		if err != nil {
+			return fmt.Errorf("can not create backup before migration, use --skip-backup to skip it: %s", err.Error())
+		}
+		if name != "" {
+			fmt.Printf("backup %s created\n", name)
+		}
+	}
+	version, err := f(db, to, migrateDryRun, logrus.New())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("db version: %d\n", version)
+	return nil
+}
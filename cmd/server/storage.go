@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ClusterOperator/kubepi/internal/server"
+	"github.com/ClusterOperator/kubepi/migrate"
+	"github.com/ClusterOperator/kubepi/pkg/storage"
+	"github.com/ClusterOperator/kubepi/pkg/storage/boltdb"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var storageMigrateFrom string
+
+func init() {
+	storageMigrateCmd.Flags().StringVar(&storageMigrateFrom, "from", "", "bolt database file to copy from, default is kubepi.db in spec.db.path")
+	storageCmd.AddCommand(storageMigrateCmd)
+	RootCmd.AddCommand(storageCmd)
+}
+
+var storageCmd = &cobra.Command{
+	Use:   "storage",
+	Short: "Manage kubepi storage backend",
+}
+
+var storageMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Copy an existing bolt database into the configured sql database",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := server.LoadConfig(configPath)
+		if err != nil {
+			return err
+		}
+		if c.Spec.DB.Type != storage.TypeSqlite && c.Spec.DB.Type != storage.TypePostgres {
+			return fmt.Errorf("spec.db.type must be %s or %s, got %q", storage.TypeSqlite, storage.TypePostgres, c.Spec.DB.Type)
+		}
+		from := storageMigrateFrom
+		if from == "" {
+			from = server.BoltFilePath(c.Spec.DB)
+		}
+		src, err := boltdb.Open(from)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		dst, err := server.OpenDB(c.Spec.DB)
+		if err != nil {
+			return err
+		}
+		defer dst.Close()
+		importer, ok := dst.(storage.Importer)
+		if !ok {
+			return fmt.Errorf("database type %s does not support import", dst.Type())
+		}
+		return migrate.CopyStorage(src, importer, logrus.New())
+	},
+}
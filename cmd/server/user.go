@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	v1 "github.com/ClusterOperator/kubepi/internal/model/v1"
+	v1Config "github.com/ClusterOperator/kubepi/internal/model/v1/config"
+	v1User "github.com/ClusterOperator/kubepi/internal/model/v1/user"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/common"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/user"
+	"github.com/ClusterOperator/kubepi/pkg/storage"
+	"github.com/asdine/storm/v3"
+	"github.com/spf13/cobra"
+)
+
+var (
+	userPassword string
+	userEmail    string
+	userNickName string
+	userAdmin    bool
+	userYes      bool
+)
+
+func init() {
+	userResetPasswordCmd.Flags().StringVar(&userPassword, "password", "", "new password, a random password is generated and printed when empty")
+	userCreateCmd.Flags().StringVar(&userPassword, "password", "", "password, a random password is generated and printed when empty")
+	userCreateCmd.Flags().StringVar(&userEmail, "email", "", "email of the user")
+	userCreateCmd.Flags().StringVar(&userNickName, "nick-name", "", "nick name of the user, default is the user name")
+	userCreateCmd.Flags().BoolVar(&userAdmin, "admin", false, "create an administrator")
+	_ = userCreateCmd.MarkFlagRequired("email")
+	userSetLocalCmd.Flags().BoolVarP(&userYes, "yes", "y", false, "do not ask for confirmation")
+	userCmd.AddCommand(userResetPasswordCmd, userCreateCmd, userUnlockCmd, userSetLocalCmd)
+	mfaCmd.AddCommand(mfaDisableCmd)
+	RootCmd.AddCommand(userCmd, mfaCmd)
+}
+
+var userCmd = &cobra.Command{
+	Use:   "user",
+	Short: "Manage kubepi users, the server must be stopped",
+}
+
+var userResetPasswordCmd = &cobra.Command{
+	Use:   "reset-password <user>",
+	Short: "Reset the password of a user",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withDB(func(c *v1Config.Config, db storage.DB) error {
+			u, err := getUser(db, args[0])
+			if err != nil {
+				return err
+			}
+			if u.Type == v1User.LDAP {
+				fmt.Printf("user %s logins with ldap, run `user set-local` to switch it to local password\n", u.Name)
+			}
+			password, err := passwordOrRandom()
+			if err != nil {
+				return err
+			}
+			if err := user.NewService().ResetPassword(u.Name, password, common.DBOptions{DB: db}); err != nil {
+				return err
+			}
+			printPassword(u.Name, password)
+			return nil
+		})
+	},
+}
+
+var userCreateCmd = &cobra.Command{
+	Use:   "create <user>",
+	Short: "Create a local user",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withDB(func(c *v1Config.Config, db storage.DB) error {
+			password, err := passwordOrRandom()
+			if err != nil {
+				return err
+			}
+			nickName := userNickName
+			if nickName == "" {
+				nickName = args[0]
+			}
+			u := v1User.User{
+				BaseModel: v1.BaseModel{
+					ApiVersion: "v1",
+					Kind:       "User",
+					CreatedBy:  "kubepi-server",
+				},
+				Metadata: v1.Metadata{
+					Name: args[0],
+				},
+				NickName: nickName,
+				Email:    userEmail,
+				Language: "zh-CN",
+				IsAdmin:  userAdmin,
+				Type:     v1User.LOCAL,
+				Authenticate: v1User.Authenticate{
+					Password: password,
+				},
+			}
+			if err := user.NewService().Create(&u, common.DBOptions{DB: db}); err != nil {
+				if errors.Is(err, storm.ErrAlreadyExists) {
+					return fmt.Errorf("user name or email already exists")
+				}
+				return err
+			}
+			printPassword(u.Name, password)
+			return nil
+		})
+	},
+}
+
+var userUnlockCmd = &cobra.Command{
+	Use:   "unlock <user>",
+	Short: "Unlock a user who can not pass multi-factor authentication, for example after losing the device",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withDB(func(c *v1Config.Config, db storage.DB) error {
+			u, err := getUser(db, args[0])
+			if err != nil {
+				return err
+			}
+			if !u.Mfa.Enable {
+				fmt.Printf("user %s is not locked by mfa\n", u.Name)
+				return nil
+			}
+			if err := db.UpdateField(u, "Mfa", v1User.Mfa{}); err != nil {
+				return err
+			}
+			fmt.Printf("user %s unlocked, mfa is disabled and can be enabled again after login\n", u.Name)
+			return nil
+		})
+	},
+}
+
+var userSetLocalCmd = &cobra.Command{
+	Use:   "set-local <user>",
+	Short: "Permanently switch a ldap user to local password login, for example when the ldap server is unavailable",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withDB(func(c *v1Config.Config, db storage.DB) error {
+			u, err := getUser(db, args[0])
+			if err != nil {
+				return err
+			}
+			if u.Type != v1User.LDAP {
+				fmt.Printf("user %s already logins with local password\n", u.Name)
+				return nil
+			}
+			// 切换后 ldap 同步不会再更新该用户，需要确认
+			prompt := fmt.Sprintf("user %s will login with local password instead of ldap and will not be synced from ldap any more, continue?", u.Name)
+			if !userYes && !confirm(cmd, prompt) {
+				fmt.Println("canceled")
+				return nil
+			}
+			if err := db.UpdateField(u, "Type", v1User.LOCAL); err != nil {
+				return err
+			}
+			fmt.Printf("user %s switched to local password, run `user reset-password` to set its password\n", u.Name)
+			return nil
+		})
+	},
+}
+
+var mfaCmd = &cobra.Command{
+	Use:   "mfa",
+	Short: "Manage multi-factor authentication of users, the server must be stopped",
+}
+
+var mfaDisableCmd = &cobra.Command{
+	Use:   "disable <user>",
+	Short: "Disable multi-factor authentication of a user",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withDB(func(c *v1Config.Config, db storage.DB) error {
+			u, err := getUser(db, args[0])
+			if err != nil {
+				return err
+			}
+			if err := db.UpdateField(u, "Mfa", v1User.Mfa{}); err != nil {
+				return err
+			}
+			fmt.Printf("mfa of user %s disabled\n", u.Name)
+			return nil
+		})
+	},
+}
+
+func getUser(db storage.Node, name string) (*v1User.User, error) {
+	u, err := user.NewService().GetByNameOrEmail(name, common.DBOptions{DB: db})
+	if err != nil {
+		if errors.Is(err, storm.ErrNotFound) {
+			return nil, fmt.Errorf("user %s not found", name)
+		}
+		return nil, err
+	}
+	return u, nil
+}
+
+// confirm 在终端中询问是否继续，只有输入 y 或 yes 时返回 true
+func confirm(cmd *cobra.Command, prompt string) bool {
+	fmt.Fprintf(cmd.OutOrStdout(), "%s [y/N]: ", prompt)
+	answer, _ := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+func passwordOrRandom() (string, error) {
+	if userPassword != "" {
+		return userPassword, nil
+	}
+	bs := make([]byte, 12)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bs), nil
+}
+
+func printPassword(name, password string) {
+	if userPassword == "" {
+		fmt.Printf("password of user %s: %s\n", name, password)
+		return
+	}
+	fmt.Printf("password of user %s updated\n", name)
+}
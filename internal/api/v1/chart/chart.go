@@ -1,6 +1,7 @@
 package chart
 
 import (
+	"github.com/ClusterOperator/kubepi/internal/api/v1/session"
 	"github.com/ClusterOperator/kubepi/internal/service/v1/chart"
 	pkgV1 "github.com/ClusterOperator/kubepi/pkg/api/v1"
 	"github.com/kataras/iris/v12"
@@ -18,11 +19,20 @@ func NewHandler() *Handler {
 	}
 }
 
+// service 返回以当前用户身份操作集群的 chart service，管理员使用集群的管理员凭据
+func (h *Handler) service(ctx *context.Context) chart.Service {
+	profile, ok := ctx.Values().Get("profile").(session.UserProfile)
+	if !ok || profile.IsAdministrator {
+		return h.chartService
+	}
+	return h.chartService.AsUser(profile.Name)
+}
+
 func (h *Handler) DeleteRepo() iris.Handler {
 	return func(ctx *context.Context) {
 		cluster := ctx.Params().GetString("cluster")
 		name := ctx.Params().GetString("name")
-		if err := h.chartService.RemoveRepo(cluster, name); err != nil {
+		if err := h.service(ctx).RemoveRepo(cluster, name); err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
 			return
@@ -35,7 +45,7 @@ func (h *Handler) GetChart() iris.Handler {
 		name := ctx.Params().GetString("name")
 		cluster := ctx.Params().GetString("cluster")
 		repo := ctx.URLParam("repo")
-		cs, err := h.chartService.GetCharts(cluster, repo, name)
+		cs, err := h.service(ctx).GetCharts(cluster, repo, name)
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
@@ -47,7 +57,7 @@ func (h *Handler) GetChart() iris.Handler {
 func (h *Handler) ListRepo() iris.Handler {
 	return func(ctx *context.Context) {
 		cluster := ctx.Params().GetString("cluster")
-		entrys, err := h.chartService.SearchRepo(cluster)
+		entrys, err := h.service(ctx).SearchRepo(cluster)
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
@@ -68,7 +78,7 @@ func (h *Handler) GetRepo() iris.Handler {
 	return func(ctx *context.Context) {
 		cluster := ctx.Params().GetString("cluster")
 		name := ctx.Params().GetString("name")
-		re, err := h.chartService.GetRepo(cluster, name)
+		re, err := h.service(ctx).GetRepo(cluster, name)
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
@@ -87,7 +97,7 @@ func (h *Handler) AddRepo() iris.Handler {
 			ctx.Values().Set("message", err.Error())
 		}
 
-		err := h.chartService.AddRepo(cluster, &req.RepoCreate)
+		err := h.service(ctx).AddRepo(cluster, &req.RepoCreate)
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
@@ -106,7 +116,7 @@ func (h *Handler) UpdateRepo() iris.Handler {
 			ctx.Values().Set("message", err.Error())
 		}
 
-		err := h.chartService.UpdateRepo(cluster, &req.RepoUpdate)
+		err := h.service(ctx).UpdateRepo(cluster, &req.RepoUpdate)
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
@@ -121,7 +131,7 @@ func (h *Handler) SyncRepo() iris.Handler {
 		name := ctx.Params().GetString("name")
 		cluster := ctx.Params().GetString("cluster")
 
-		err := h.chartService.SyncRepo(cluster, name)
+		err := h.service(ctx).SyncRepo(cluster, name)
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
@@ -138,7 +148,7 @@ func (h *Handler) ListCharts() iris.Handler {
 		pattern := ctx.URLParam("pattern")
 		repo := ctx.URLParam("repo")
 		cluster := ctx.Params().GetString("cluster")
-		charts, total, err := h.chartService.ListCharts(cluster, repo, pageNum, pageSize, pattern)
+		charts, total, err := h.service(ctx).ListCharts(cluster, repo, pageNum, pageSize, pattern)
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
@@ -164,7 +174,7 @@ func (h *Handler) GetChartByVersion() iris.Handler {
 		cluster := ctx.Params().GetString("cluster")
 		repo := ctx.URLParam("repo")
 		version := ctx.URLParam("version")
-		cs, err := h.chartService.GetChartByVersion(cluster, repo, name, version)
+		cs, err := h.service(ctx).GetChartByVersion(cluster, repo, name, version)
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
@@ -179,7 +189,7 @@ func (h *Handler) GetChartForUpdate() iris.Handler {
 		name := ctx.Params().GetString("name")
 		cluster := ctx.Params().GetString("cluster")
 		chart := ctx.URLParam("chart")
-		cs, err := h.chartService.GetChartsUpdate(cluster, chart, name)
+		cs, err := h.service(ctx).GetChartsUpdate(cluster, chart, name)
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
@@ -196,7 +206,7 @@ func (h *Handler) InstallChart() iris.Handler {
 			ctx.StatusCode(iris.StatusBadRequest)
 			ctx.Values().Set("message", err.Error())
 		}
-		err := h.chartService.InstallChart(req.Cluster, req.Repo, req.Namespace, req.Name, req.ChartName, req.ChartVersion, req.Values)
+		err := h.service(ctx).InstallChart(req.Cluster, req.Repo, req.Namespace, req.Name, req.ChartName, req.ChartVersion, req.Values)
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
@@ -213,7 +223,7 @@ func (h *Handler) UpdateChart() iris.Handler {
 			ctx.StatusCode(iris.StatusBadRequest)
 			ctx.Values().Set("message", err.Error())
 		}
-		err := h.chartService.UpgradeChart(req.Cluster, req.Namespace, req.Repo, req.Name, req.ChartName, req.ChartVersion, req.Values)
+		err := h.service(ctx).UpgradeChart(req.Cluster, req.Namespace, req.Repo, req.Name, req.ChartName, req.ChartVersion, req.Values)
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
@@ -230,7 +240,7 @@ func (h *Handler) AllInstalled() iris.Handler {
 		pattern := ctx.URLParam("pattern")
 		namespace := ctx.URLParam("namespace")
 		cluster := ctx.Params().GetString("cluster")
-		installed, total, err := h.chartService.ListAllInstalled(cluster, namespace, pageNum, pageSize, pattern)
+		installed, total, err := h.service(ctx).ListAllInstalled(cluster, namespace, pageNum, pageSize, pattern)
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
@@ -245,7 +255,7 @@ func (h *Handler) UnInstall() iris.Handler {
 		cluster := ctx.Params().GetString("cluster")
 		name := ctx.Params().GetString("name")
 		namespace := ctx.Params().GetString("namespace")
-		err := h.chartService.UnInstallChart(cluster, namespace, name)
+		err := h.service(ctx).UnInstallChart(cluster, namespace, name)
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
@@ -259,7 +269,7 @@ func (h *Handler) GetAppDetail() iris.Handler {
 	return func(ctx *context.Context) {
 		cluster := ctx.Params().GetString("cluster")
 		name := ctx.Params().GetString("name")
-		data, err := h.chartService.GetAppDetail(cluster, name)
+		data, err := h.service(ctx).GetAppDetail(cluster, name)
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
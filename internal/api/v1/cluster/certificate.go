@@ -0,0 +1,276 @@
+package cluster
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ClusterOperator/kubepi/internal/api/v1/session"
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
+	"github.com/ClusterOperator/kubepi/internal/server"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/common"
+	"github.com/ClusterOperator/kubepi/pkg/certificate"
+	"github.com/ClusterOperator/kubepi/pkg/kubernetes"
+	"github.com/asdine/storm/v3"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+)
+
+const (
+	certificateValid    = "Valid"
+	certificateExpiring = "Expiring"
+	certificateExpired  = "Expired"
+	certificateInvalid  = "Invalid"
+	certificateRevoked  = "Revoked"
+)
+
+// certRenewInterval 是后台检查成员证书有效期的间隔
+const certRenewInterval = time.Hour
+
+// CertificateStatus 是成员证书的有效期和状态
+type CertificateStatus struct {
+	SerialNumber string    `json:"serialNumber"`
+	NotBefore    time.Time `json:"notBefore"`
+	NotAfter     time.Time `json:"notAfter"`
+	State        string    `json:"state"`
+	// Reason 是证书被吊销的原因，只有 Revoked 状态的证书有值
+	Reason string `json:"reason,omitempty"`
+}
+
+// needsRenewal 判断证书是否需要重新签发，剩余有效期不足总有效期的三分之一时重新签发，
+// 这样不依赖集群签发证书的有效期配置
+func needsRenewal(cert *x509.Certificate, now time.Time) bool {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return cert.NotAfter.Sub(now) < lifetime/3
+}
+
+// certificateStatus 解析成员的证书，没有证书时（例如模拟用户模式的集群）返回 nil
+func certificateStatus(data []byte, now time.Time) *CertificateStatus {
+	if len(data) == 0 {
+		return nil
+	}
+	cert, err := certificate.ParseX509Certificate(data)
+	if err != nil {
+		return &CertificateStatus{State: certificateInvalid}
+	}
+	status := &CertificateStatus{
+		SerialNumber: cert.SerialNumber.Text(16),
+		NotBefore:    cert.NotBefore,
+		NotAfter:     cert.NotAfter,
+		State:        certificateValid,
+	}
+	switch {
+	case now.After(cert.NotAfter):
+		status.State = certificateExpired
+	case needsRenewal(cert, now):
+		status.State = certificateExpiring
+	}
+	return status
+}
+
+func revokedMember(r v1Cluster.RevokedCertificate) Member {
+	return Member{
+		Name:           r.UserRef,
+		CreateAt:       r.CreateAt,
+		ClusterRoles:   []string{},
+		NamespaceRoles: []NamespaceRoles{},
+		Certificate: &CertificateStatus{
+			SerialNumber: r.SerialNumber,
+			NotAfter:     r.NotAfter,
+			State:        certificateRevoked,
+			Reason:       r.Reason,
+		},
+	}
+}
+
+// updateUserCert 为成员签发新的证书，原有的证书记录为已替换
+func (h *Handler) updateUserCert(client kubernetes.Interface, binding *v1Cluster.Binding) error {
+	csr, err := client.CreateCommonUser(binding.UserRef)
+	if err != nil {
+		return err
+	}
+	return h.replaceUserCert(binding, csr, common.DBOptions{})
+}
+
+func (h *Handler) replaceUserCert(binding *v1Cluster.Binding, cert []byte, options common.DBOptions) error {
+	if err := h.clusterBindingService.Revoke(binding, v1Cluster.RevokeReasonRotated, options); err != nil {
+		return err
+	}
+	binding.Certificate = cert
+	if err := h.clusterBindingService.UpdateClusterBinding(binding.Name, binding, options); err != nil {
+		return err
+	}
+	kubernetes.DefaultCache.InvalidateUser(binding.ClusterRef, binding.UserRef)
+	return nil
+}
+
+// startCertificateRenewal 启动时以及之后定期为即将过期的成员证书重新签发证书，
+// 多实例部署时每个周期只由一个实例执行，避免为同一成员重复签发
+func (h *Handler) startCertificateRenewal() {
+	go func() {
+		ticker := time.NewTicker(certRenewInterval)
+		defer ticker.Stop()
+		for {
+			acquired, err := server.AcquireSchedule("certificate-renewal", certRenewInterval)
+			if err != nil {
+				server.Logger().Errorf("acquire certificate renewal schedule failed: %s", err.Error())
+			} else if acquired {
+				h.renewCertificates()
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+func (h *Handler) renewCertificates() {
+	if err := h.clusterBindingService.PruneRevoked(common.DBOptions{}); err != nil {
+		server.Logger().Errorf("prune revoked certificates failed: %s", err.Error())
+	}
+	clusters, err := h.clusterService.List(common.DBOptions{})
+	if err != nil {
+		server.Logger().Errorf("list clusters failed: %s", err.Error())
+		return
+	}
+	now := time.Now()
+	for i := range clusters {
+		c := &clusters[i]
+		if kubernetes.Impersonation(c) {
+			continue
+		}
+		bindings, err := h.clusterBindingService.GetClusterBindingByClusterName(c.Name, common.DBOptions{})
+		if err != nil {
+			if !errors.Is(err, storm.ErrNotFound) {
+				server.Logger().Errorf("list members of cluster %s failed: %s", c.Name, err.Error())
+			}
+			continue
+		}
+		k := kubernetes.NewKubernetes(c)
+		for j := range bindings {
+			cert, err := certificate.ParseX509Certificate(bindings[j].Certificate)
+			if err == nil && !needsRenewal(cert, now) {
+				continue
+			}
+			if err := h.updateUserCert(k, &bindings[j]); err != nil {
+				server.Logger().Errorf("renew certificate of %s in cluster %s failed: %s", bindings[j].UserRef, c.Name, err.Error())
+				continue
+			}
+			server.Logger().Infof("certificate of %s in cluster %s renewed", bindings[j].UserRef, c.Name)
+		}
+	}
+}
+
+// RotateMemberCertificate 为成员重新签发证书
+func (h *Handler) RotateMemberCertificate() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		memberName := ctx.Params().GetString("member")
+		c, err := h.clusterService.Get(name, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", fmt.Sprintf("get cluster failed: %s", err.Error()))
+			return
+		}
+		if kubernetes.Impersonation(c) {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "members of cluster in impersonation mode have no certificate")
+			return
+		}
+		binding, err := h.clusterBindingService.GetBindingByClusterNameAndUserName(name, memberName, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", fmt.Sprintf("get cluster binding failed: %s", err.Error()))
+			return
+		}
+		if err := h.updateUserCert(kubernetes.NewKubernetes(c), binding); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", fmt.Sprintf("create common user failed: %s", err.Error()))
+			return
+		}
+		ctx.Values().Set("data", certificateStatus(binding.Certificate, time.Now()))
+	}
+}
+
+// RotatePrivateKey 更换集群签发成员证书使用的私钥并为所有成员重新签发证书，只有管理员可以操作。
+// 全部证书签发成功后才保存新的私钥，签发失败时保持原有的私钥和证书
+func (h *Handler) RotatePrivateKey() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		profile := ctx.Values().Get("profile").(session.UserProfile)
+		if !profile.IsAdministrator {
+			ctx.StatusCode(iris.StatusForbidden)
+			ctx.Values().Set("message", "only administrator can rotate the private key of cluster")
+			return
+		}
+		c, err := h.clusterService.Get(name, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", fmt.Sprintf("get cluster failed: %s", err.Error()))
+			return
+		}
+		if kubernetes.Impersonation(c) {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "members of cluster in impersonation mode have no certificate")
+			return
+		}
+		bindings, err := h.clusterBindingService.GetClusterBindingByClusterName(name, common.DBOptions{})
+		if err != nil && !errors.Is(err, storm.ErrNotFound) {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		privateKey, err := certificate.GeneratePrivateKey()
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		rotated := *c
+		rotated.PrivateKey = privateKey
+		k := kubernetes.NewKubernetes(&rotated)
+		certs := make([][]byte, len(bindings))
+		for i := range bindings {
+			certs[i], err = k.CreateCommonUser(bindings[i].UserRef)
+			if err != nil {
+				ctx.StatusCode(iris.StatusInternalServerError)
+				ctx.Values().Set("message", fmt.Sprintf("create common user %s failed: %s", bindings[i].UserRef, err.Error()))
+				return
+			}
+		}
+
+		tx, err := server.DB().Begin(true)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		txOptions := common.DBOptions{DB: tx}
+		if err := h.clusterService.UpdatePrivateKey(c.Name, privateKey, txOptions); err != nil {
+			_ = tx.Rollback()
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		members := make([]Member, 0, len(bindings))
+		for i := range bindings {
+			if err := h.replaceUserCert(&bindings[i], certs[i], txOptions); err != nil {
+				_ = tx.Rollback()
+				ctx.StatusCode(iris.StatusInternalServerError)
+				ctx.Values().Set("message", err.Error())
+				return
+			}
+			members = append(members, Member{
+				Name:        bindings[i].UserRef,
+				BindingName: bindings[i].Name,
+				CreateAt:    bindings[i].CreateAt,
+				Certificate: certificateStatus(certs[i], time.Now()),
+			})
+		}
+		_ = tx.Commit()
+		// 证书使用新的私钥，清理所有成员的 transport
+		for i := range bindings {
+			kubernetes.DefaultCache.InvalidateUser(name, bindings[i].UserRef)
+		}
+		ctx.Values().Set("data", members)
+	}
+}
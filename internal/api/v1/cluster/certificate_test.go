@@ -0,0 +1,51 @@
+package cluster
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestCertificateStatus(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notBefore := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(255),
+		Subject:      pkix.Name{CommonName: "alice"},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(30 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	for _, c := range []struct {
+		now   time.Time
+		state string
+	}{
+		{notBefore.Add(time.Hour), certificateValid},
+		{notBefore.Add(21 * 24 * time.Hour), certificateExpiring},
+		{notBefore.Add(31 * 24 * time.Hour), certificateExpired},
+	} {
+		s := certificateStatus(cert, c.now)
+		if s.State != c.state || s.SerialNumber != "ff" || !s.NotAfter.Equal(tmpl.NotAfter.Truncate(time.Second)) {
+			t.Fatalf("expect %s, got %+v", c.state, s)
+		}
+	}
+	if s := certificateStatus(nil, time.Now()); s != nil {
+		t.Fatalf("expect nil status, got %+v", s)
+	}
+	if s := certificateStatus([]byte("invalid"), time.Now()); s.State != certificateInvalid {
+		t.Fatalf("expect invalid, got %+v", s)
+	}
+}
@@ -6,9 +6,9 @@ import (
 	"fmt"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/ClusterOperator/kubepi/internal/service/v1/clusterapp"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/clustergroup"
 	"github.com/ClusterOperator/kubepi/internal/service/v1/clusterrepo"
 	"github.com/ClusterOperator/kubepi/internal/service/v1/imagerepo"
 
@@ -23,7 +23,12 @@ import (
 	pkgV1 "github.com/ClusterOperator/kubepi/pkg/api/v1"
 	"github.com/ClusterOperator/kubepi/pkg/certificate"
 	"github.com/ClusterOperator/kubepi/pkg/kubernetes"
+	"github.com/ClusterOperator/kubepi/pkg/logging"
+	"github.com/ClusterOperator/kubepi/pkg/metrics"
+	"github.com/ClusterOperator/kubepi/pkg/terminal"
+	"github.com/ClusterOperator/kubepi/pkg/tunnel"
 	"github.com/asdine/storm/v3"
+	"github.com/google/uuid"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
 	authV1 "k8s.io/api/authorization/v1"
@@ -36,6 +41,8 @@ type Handler struct {
 	clusterRepoService    clusterrepo.Service
 	imageRepoService      imagerepo.Service
 	clusterAppService     clusterapp.Service
+	clusterGroupService   clustergroup.Service
+	health                *healthMonitor
 }
 
 func NewHandler() *Handler {
@@ -45,6 +52,8 @@ func NewHandler() *Handler {
 		clusterRepoService:    clusterrepo.NewService(),
 		imageRepoService:      imagerepo.NewService(),
 		clusterAppService:     clusterapp.NewService(),
+		clusterGroupService:   clustergroup.NewService(),
+		health:                newHealthMonitor(),
 	}
 }
 
@@ -72,10 +81,22 @@ func (h *Handler) CreateCluster() iris.Handler {
 		if req.CaDataStr != "" {
 			req.CaCertificate.CertData = []byte(req.CaDataStr)
 		}
-		if req.Spec.Authentication.Mode == "certificate" {
+		if req.Spec.Authentication.Mode == "certificate" || req.BootstrapMode == "certificate" {
 			req.Spec.Authentication.Certificate.CertData = []byte(req.CertDataStr)
 			req.Spec.Authentication.Certificate.KeyData = []byte(req.KeyDataStr)
 		}
+		switch req.Spec.MemberAuthMode {
+		case "", v1Cluster.MemberAuthCertificate, v1Cluster.MemberAuthImpersonation:
+		default:
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", fmt.Sprintf("unsupported member auth mode %s", req.Spec.MemberAuthMode))
+			return
+		}
+		if err := v1Cluster.ValidateLabels(req.Labels); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
 		if req.Spec.Connect.Forward.ApiServer != "" {
 			if !strings.HasPrefix(req.Spec.Connect.Forward.ApiServer, "https://") && !strings.HasPrefix(req.Spec.Connect.Forward.ApiServer, "http://") {
 				req.Spec.Connect.Forward.ApiServer = fmt.Sprintf("%s%s", "https://", req.Spec.Connect.Forward.ApiServer)
@@ -90,144 +111,163 @@ func (h *Handler) CreateCluster() iris.Handler {
 			return
 		}
 		req.PrivateKey = privateKey
-		client := kubernetes.NewKubernetes(&req.Cluster)
-		if err := client.Ping(); err != nil {
-			ctx.StatusCode(iris.StatusInternalServerError)
+		// 保存前生成 UUID，serviceAccount 模式在集群中创建的资源名称包含集群的 UUID
+		req.UUID = uuid.New().String()
+		u := ctx.Values().Get("profile")
+		profile := u.(session.UserProfile)
+		if err := h.checkLabelScope(ctx, profile, "create", req.Labels); err != nil {
+			ctx.StatusCode(iris.StatusForbidden)
 			ctx.Values().Set("message", err.Error())
 			return
 		}
-		v, _ := client.Version()
-		req.Status.Version = v.GitVersion
-		if req.Spec.Authentication.Mode == "configFile" {
-			kubeCfg, err := client.Config()
-			if err != nil {
-				ctx.StatusCode(iris.StatusInternalServerError)
-				ctx.Values().Set("message", err.Error())
+		if req.Spec.Connect.Direction == v1Cluster.DirectionReverse {
+			if kubernetes.ServiceAccountMode(&req.Cluster) {
+				ctx.StatusCode(iris.StatusBadRequest)
+				ctx.Values().Set("message", "cluster connected by agent does not support service account mode")
 				return
 			}
-			req.Spec.Connect.Forward.ApiServer = kubeCfg.Host
-		}
-		u := ctx.Values().Get("profile")
-		profile := u.(session.UserProfile)
-		req.CreatedBy = profile.Name
-
-		tx, err := server.DB().Begin(true)
-		if err != nil {
-			ctx.StatusCode(iris.StatusInternalServerError)
-			ctx.Values().Set("message", err.Error())
+			h.createReverseCluster(ctx, &req, profile.Name)
 			return
 		}
-		txOptions := common.DBOptions{DB: tx}
-		req.Cluster.Status.Phase = clusterStatusSaved
-		if err := h.clusterService.Create(&req.Cluster, txOptions); err != nil {
-			_ = tx.Rollback()
-			ctx.StatusCode(iris.StatusInternalServerError)
-			ctx.Values().Set("message", err.Error())
-			return
+		create := func() error {
+			if kubernetes.ServiceAccountMode(&req.Cluster) {
+				return h.createServiceAccountCluster(&req.Cluster, req.BootstrapMode, profile)
+			}
+			return h.createForwardCluster(&req.Cluster, profile)
 		}
-
-		requiredPermissions := map[string][]string{
-			"namespaces":       {"get", "post", "delete"},
-			"clusterroles":     {"get", "post", "delete"},
-			"clusterrolebings": {"get", "post", "delete"},
-			"roles":            {"get", "post", "delete"},
-			"rolebindings":     {"get", "post", "delete"},
-		}
-		notAllowed, err := checkRequiredPermissions(client, requiredPermissions)
-		if err != nil {
-			_ = tx.Rollback()
+		if err := create(); err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
+			var permissionErr permissionRequiredError
+			if errors.As(err, &permissionErr) {
+				ctx.Values().Set("message", []string{"permission %s required", string(permissionErr)})
+				return
+			}
 			ctx.Values().Set("message", err.Error())
 			return
 		}
-		if notAllowed != "" {
-			_ = tx.Rollback()
-			ctx.StatusCode(iris.StatusInternalServerError)
-			ctx.Values().Set("message", []string{"permission %s required", notAllowed})
-			return
-		}
-		_ = tx.Commit()
 		ctx.Values().Set("data", &req)
-		go func() {
-			req.Status.Phase = clusterStatusInitializing
-			if e := h.clusterService.Update(req.Name, &req.Cluster, common.DBOptions{}); e != nil {
-				server.Logger().Errorf("can not update cluster status %s", err)
-				return
-			}
-			if err := client.CreateDefaultClusterRoles(); err != nil {
-				req.Status.Phase = clusterStatusFailed
-				req.Status.Message = err.Error()
-				if e := h.clusterService.Update(req.Name, &req.Cluster, common.DBOptions{}); e != nil {
-					server.Logger().Errorf("can not update cluster status %s", err)
-					return
-				}
-				server.Logger().Errorf("can not init  built in clusterroles %s", err)
-				return
-			}
-			if !profile.IsAdministrator {
-				binding := v1Cluster.Binding{
-					BaseModel: v1.BaseModel{
-						Kind: "ClusterBinding",
-					},
-					Metadata: v1.Metadata{
-						Name: fmt.Sprintf("%s-%s-cluster-binding", req.Name, profile.Name),
-					},
-					UserRef:    profile.Name,
-					ClusterRef: req.Name,
-				}
-				if err := h.clusterBindingService.CreateClusterBinding(&binding, common.DBOptions{}); err != nil {
-					ctx.StatusCode(iris.StatusInternalServerError)
-					ctx.Values().Set("message", err.Error())
-					return
-				}
-				if err := client.CreateOrUpdateClusterRoleBinding("cluster-owner", profile.Name, true); err != nil {
-					_ = tx.Rollback()
-					ctx.StatusCode(iris.StatusInternalServerError)
-					ctx.Values().Set("message", err.Error())
-					return
-				}
-				if err := h.updateUserCert(client, &binding); err != nil {
-					req.Status.Phase = clusterStatusFailed
-					req.Status.Message = err.Error()
-					if e := h.clusterService.Update(req.Name, &req.Cluster, common.DBOptions{}); e != nil {
-						server.Logger().Errorf("can not update cluster status %s", err)
-						return
-					}
-					server.Logger().Errorf("can not create cluster user  %s", err)
-					return
-				}
-				req.Status.Phase = clusterStatusCompleted
-				if e := h.clusterService.Update(req.Name, &req.Cluster, common.DBOptions{}); e != nil {
-					server.Logger().Errorf("can not update cluster status %s", err)
-					return
-				}
-			} else {
-				req.Status.Phase = clusterStatusCompleted
-				if e := h.clusterService.Update(req.Name, &req.Cluster, common.DBOptions{}); e != nil {
-					server.Logger().Errorf("can not update cluster status %s", err)
-					return
-				}
-			}
-			err = client.CreateAppMarketCRD()
-			if err != nil {
-				server.Logger().Errorf("create app-market crd failed %s", err)
-			}
-		}()
 	}
 }
 
-func (h *Handler) updateUserCert(client kubernetes.Interface, binding *v1Cluster.Binding) error {
-	csr, err := client.CreateCommonUser(binding.UserRef)
+// permissionRequiredError 表示集群的凭据缺少 KubePi 需要的权限
+type permissionRequiredError string
+
+func (e permissionRequiredError) Error() string {
+	return fmt.Sprintf("permission %s required", string(e))
+}
+
+// createForwardCluster 连接集群并检查凭据的权限，保存后在后台初始化集群，c 中需要已经生成私钥
+func (h *Handler) createForwardCluster(c *v1Cluster.Cluster, profile session.UserProfile) error {
+	client := kubernetes.NewKubernetes(c)
+	if err := client.Ping(); err != nil {
+		return err
+	}
+	v, _ := client.Version()
+	c.Status.Version = v.GitVersion
+	if c.Spec.Authentication.Mode == "configFile" {
+		kubeCfg, err := client.Config()
+		if err != nil {
+			return err
+		}
+		c.Spec.Connect.Forward.ApiServer = kubeCfg.Host
+	}
+	c.CreatedBy = profile.Name
+
+	tx, err := server.DB().Begin(true)
 	if err != nil {
 		return err
 	}
-	binding.Certificate = csr
-	if err := h.clusterBindingService.UpdateClusterBinding(binding.Name, binding, common.DBOptions{}); err != nil {
+	txOptions := common.DBOptions{DB: tx}
+	c.Status.Phase = clusterStatusSaved
+	if err := h.clusterService.Create(c, txOptions); err != nil {
+		_ = tx.Rollback()
 		return err
 	}
+
+	requiredPermissions := map[string][]string{
+		"namespaces":       {"get", "post", "delete"},
+		"clusterroles":     {"get", "post", "delete"},
+		"clusterrolebings": {"get", "post", "delete"},
+		"roles":            {"get", "post", "delete"},
+		"rolebindings":     {"get", "post", "delete"},
+	}
+	if kubernetes.Impersonation(c) {
+		requiredPermissions["users"] = []string{"impersonate"}
+		requiredPermissions["groups"] = []string{"impersonate"}
+	}
+	notAllowed, err := checkRequiredPermissions(client, requiredPermissions)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if notAllowed != "" {
+		_ = tx.Rollback()
+		return permissionRequiredError(notAllowed)
+	}
+	_ = tx.Commit()
+	go h.initCluster(c, client, profile.Name, profile.IsAdministrator)
 	return nil
 }
 
+// initCluster 在集群中创建内置的集群角色，创建者不是管理员时为其绑定 cluster-owner 角色并签发证书
+func (h *Handler) initCluster(c *v1Cluster.Cluster, client kubernetes.Interface, userName string, isAdministrator bool) {
+	c.Status.Phase = clusterStatusInitializing
+	if err := h.clusterService.Update(c.Name, c, common.DBOptions{}); err != nil {
+		server.Logger().Errorf("can not update cluster status %s", err)
+		return
+	}
+	fail := func(err error) {
+		c.Status.Phase = clusterStatusFailed
+		c.Status.Message = err.Error()
+		if e := h.clusterService.Update(c.Name, c, common.DBOptions{}); e != nil {
+			server.Logger().Errorf("can not update cluster status %s", e)
+		}
+	}
+	if err := client.CreateDefaultClusterRoles(); err != nil {
+		fail(err)
+		server.Logger().Errorf("can not init  built in clusterroles %s", err)
+		return
+	}
+	if !isAdministrator {
+		binding := v1Cluster.Binding{
+			BaseModel: v1.BaseModel{
+				Kind: "ClusterBinding",
+			},
+			Metadata: v1.Metadata{
+				Name: fmt.Sprintf("%s-%s-cluster-binding", c.Name, userName),
+			},
+			UserRef:    userName,
+			ClusterRef: c.Name,
+		}
+		if err := h.clusterBindingService.CreateClusterBinding(&binding, common.DBOptions{}); err != nil {
+			fail(err)
+			server.Logger().Errorf("can not create cluster binding %s", err)
+			return
+		}
+		if err := client.CreateOrUpdateClusterRoleBinding("cluster-owner", userName, true); err != nil {
+			fail(err)
+			server.Logger().Errorf("can not bind cluster-owner to %s: %s", userName, err)
+			return
+		}
+		// 模拟用户模式下使用管理员凭据代理请求，不需要为用户签发证书
+		if !kubernetes.Impersonation(c) {
+			if err := h.updateUserCert(client, &binding); err != nil {
+				fail(err)
+				server.Logger().Errorf("can not create cluster user  %s", err)
+				return
+			}
+		}
+	}
+	c.Status.Phase = clusterStatusCompleted
+	if err := h.clusterService.Update(c.Name, c, common.DBOptions{}); err != nil {
+		server.Logger().Errorf("can not update cluster status %s", err)
+		return
+	}
+	if err := client.CreateAppMarketCRD(); err != nil {
+		server.Logger().Errorf("create app-market crd failed %s", err)
+	}
+}
+
 func checkRequiredPermissions(client kubernetes.Interface, requiredPermissions map[string][]string) (string, error) {
 	wg := sync.WaitGroup{}
 	errCh := make(chan error)
@@ -285,12 +325,30 @@ func (h *Handler) SearchClusters() iris.Handler {
 		}
 		u := ctx.Values().Get("profile")
 		profile := u.(session.UserProfile)
-		clusters, total, err := h.clusterService.Search(pageNum, pageSize, conditions.Conditions, common.DBOptions{})
+		selector, err := h.requestSelector(ctx)
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		scope := h.clusterScope(ctx, profile, "list")
+		// 需要按 selector 或角色的集群范围过滤时，查询所有符合条件的集群后再分页
+		filtered := selector != nil || !scope.All()
+		num, size := pageNum, pageSize
+		if filtered {
+			num, size = 0, 0
+		}
+		clusters, total, err := h.clusterService.Search(num, size, conditions.Conditions, common.DBOptions{})
 		if err != nil && err != storm.ErrNotFound {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
 			return
 		}
+		if filtered {
+			clusters = filterClusters(clusters, selector, scope)
+			total = len(clusters)
+			clusters = paginate(clusters, pageNum, pageSize)
+		}
 		result := make([]Cluster, 0)
 		for i := range clusters {
 
@@ -313,26 +371,15 @@ func (h *Handler) SearchClusters() iris.Handler {
 			}
 			result = append(result, c)
 		}
+		// 资源信息和健康状态来自后台检查的结果，不再同步请求集群
 		if showExtra {
-			ctx1, cancel := goContext.WithTimeout(goContext.Background(), 2*time.Second)
-			defer cancel()
-
-			wg := sync.WaitGroup{}
-			go func(result []Cluster) {
-				for i := range result {
-					wg.Add(1)
-					c := kubernetes.NewKubernetes(&result[i].Cluster)
-					go func(i int, ctx1 goContext.Context) {
-						defer wg.Done()
-						info, _ := getExtraClusterInfo(ctx1, c)
-						result[i].ExtraClusterInfo = info
-					}(i, ctx1)
+			for i := range result {
+				if info := h.health.extra(result[i].Name); info != nil {
+					result[i].ExtraClusterInfo = *info
 				}
-				wg.Wait()
-				cancel()
-			}(result)
-
-			<-ctx1.Done()
+				result[i].ExtraClusterInfo.Health = clusterHealthy(result[i].Status.Phase)
+				result[i].ExtraClusterInfo.Message = result[i].Status.Message
+			}
 		}
 		ctx.Values().Set("data", pkgV1.Page{Items: result, Total: total})
 	}
@@ -371,7 +418,7 @@ func getExtraClusterInfo(context goContext.Context, client kubernetes.Interface)
 		memory := nodes[i].Status.Allocatable.Memory().AsApproximateFloat64()
 		totalMemory += memory
 	}
-	podsList, err := c.CoreV1().Pods("").List(goContext.TODO(), metav1.ListOptions{})
+	podsList, err := c.CoreV1().Pods("").List(context, metav1.ListOptions{})
 	if err != nil {
 		return ExtraClusterInfo{Health: true, Message: err.Error()}, err
 	}
@@ -449,17 +496,54 @@ func (h *Handler) UpdateCluster() iris.Handler {
 			return
 		}
 		if req.WithLabel {
+			if err := v1Cluster.ValidateLabels(req.Labels); err != nil {
+				ctx.StatusCode(iris.StatusBadRequest)
+				ctx.Values().Set("message", err.Error())
+				return
+			}
+			u := ctx.Values().Get("profile")
+			profile := u.(session.UserProfile)
+			if err := h.checkLabelScope(ctx, profile, "update", req.Labels); err != nil {
+				ctx.StatusCode(iris.StatusForbidden)
+				ctx.Values().Set("message", err.Error())
+				return
+			}
 			c.Labels = req.Labels
 			if req.Labels == nil {
 				req.Labels = []string{}
 			}
+		} else if c.Spec.Connect.Direction == v1Cluster.DirectionReverse {
+			// reverse 模式的连接信息由 agent 注册时上报
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", fmt.Sprintf("cluster %s is connected by agent, connection can not be updated", name))
+			return
 		} else {
-			c.Spec.Authentication.ConfigFileContent = []byte(req.ConfigFileContent)
-			c.Spec.Authentication.Certificate.CertData = []byte(req.CertData)
-			c.Spec.Authentication.Certificate.KeyData = []byte(req.KeyData)
+			keepToken := req.Mode == v1Cluster.AuthModeServiceAccount && req.BootstrapMode == ""
+			if keepToken && !kubernetes.ServiceAccountMode(c) {
+				ctx.StatusCode(iris.StatusBadRequest)
+				ctx.Values().Set("message", "bootstrap credential is required for service account mode")
+				return
+			}
+			// serviceAccount 模式下不提供引导凭据时保持当前的 token，只更新地址和代理
+			if !keepToken {
+				c.Spec.Authentication.ConfigFileContent = []byte(req.ConfigFileContent)
+				c.Spec.Authentication.Certificate.CertData = []byte(req.CertData)
+				c.Spec.Authentication.Certificate.KeyData = []byte(req.KeyData)
+				c.Spec.Authentication.Mode = req.Mode
+				c.Spec.Authentication.BearerToken = req.Token
+				c.Spec.Authentication.ServiceAccount = v1Cluster.ServiceAccountToken{}
+			}
 			c.Spec.Connect.Forward.ApiServer = req.ApiServer
-			c.Spec.Authentication.Mode = req.Mode
-			c.Spec.Authentication.BearerToken = req.Token
+			if req.Proxy != nil {
+				c.Spec.Connect.Forward.Proxy = *req.Proxy
+			}
+			if req.Mode == v1Cluster.AuthModeServiceAccount && req.BootstrapMode != "" {
+				if _, err := bootstrapServiceAccount(c, req.BootstrapMode); err != nil {
+					ctx.StatusCode(iris.StatusInternalServerError)
+					ctx.Values().Set("message", err.Error())
+					return
+				}
+			}
 
 			client := kubernetes.NewKubernetes(c)
 			if err := client.Ping(); err != nil {
@@ -485,6 +569,8 @@ func (h *Handler) UpdateCluster() iris.Handler {
 			ctx.Values().Set("message", err.Error())
 			return
 		}
+		kubernetes.DefaultCache.Invalidate(name)
+		h.health.probeNow(name)
 	}
 }
 
@@ -494,13 +580,21 @@ func (h *Handler) UpdateCluster() iris.Handler {
 // @Description List all clusters
 // @Accept  json
 // @Produce  json
+// @Param labelSelector query string false "集群标签的 label selector，例如 env=dev"
+// @Param group query string false "集群分组名称"
 // @Success 200 {object} []v1Cluster.Cluster
 // @Security ApiKeyAuth
 // @Router /clusters [get]
 func (h *Handler) ListClusters() iris.Handler {
 	return func(ctx *context.Context) {
+		selector, err := h.requestSelector(ctx)
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
 		var clusters []v1Cluster.Cluster
-		clusters, err := h.clusterService.List(common.DBOptions{})
+		clusters, err = h.clusterService.List(common.DBOptions{})
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
@@ -509,6 +603,7 @@ func (h *Handler) ListClusters() iris.Handler {
 		resultClusters := make([]Cluster, 0)
 		u := ctx.Values().Get("profile")
 		profile := u.(session.UserProfile)
+		clusters = filterClusters(clusters, selector, h.clusterScope(ctx, profile, "list"))
 		for i := range clusters {
 			mbs, err := h.clusterBindingService.GetClusterBindingByClusterName(clusters[i].Name, common.DBOptions{})
 			if err != nil && !errors.Is(err, storm.ErrNotFound) {
@@ -594,15 +689,36 @@ func (h *Handler) DeleteCluster() iris.Handler {
 				return
 			}
 		}
+		if err := h.clusterBindingService.DeleteRevokedByCluster(name, txOptions); err != nil {
+			_ = tx.Rollback()
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", fmt.Sprintf("delete cluster failed: %s", err.Error()))
+			return
+		}
 		k := kubernetes.NewKubernetes(c)
 		_ = k.CleanAllRBACResource()
+		// 最后删除 ServiceAccount，之前的清理仍然使用它的 token
+		if kubernetes.ServiceAccountMode(c) {
+			_ = k.DeleteServiceAccount()
+		}
 		_ = tx.Commit()
+		tunnel.Default.Disconnect(name)
+		kubernetes.DefaultCache.Invalidate(name)
+		kubernetes.DefaultInformers.Stop(name)
+		metrics.DeleteCluster(name)
 		ctx.StatusCode(iris.StatusOK)
 	}
 }
 
-func Install(parent iris.Party) {
+func Install(parent, noAuthParty iris.Party) {
 	handler := NewHandler()
+	metrics.RegisterActiveSessions("terminal", terminal.TerminalSessions.Len)
+	metrics.RegisterActiveSessions("log", logging.LogSessions.Len)
+	terminal.SessionRestorer = handler.restoreTerminalSession
+	logging.SessionRestorer = handler.restoreLoggingSession
+	handler.startCertificateRenewal()
+	handler.startHealthMonitor()
+	handler.startTokenRefresh()
 	sp := parent.Party("/clusters")
 	sp.Post("", handler.CreateCluster())
 	sp.Get("", handler.ListClusters())
@@ -610,11 +726,15 @@ func Install(parent iris.Party) {
 	sp.Put("/:name", handler.UpdateCluster())
 	sp.Delete("/:name", handler.DeleteCluster())
 	sp.Post("/search", handler.SearchClusters())
+	sp.Post("/import/preview", handler.PreviewImportClusters())
+	sp.Post("/import", handler.ImportClusters())
 	sp.Get("/:name/members", handler.ListClusterMembers())
 	sp.Post("/:name/members", handler.CreateClusterMember())
 	sp.Delete("/:name/members/:member", handler.DeleteClusterMember())
 	sp.Put("/:name/members/:member", handler.UpdateClusterMember())
 	sp.Get("/:name/members/:member", handler.GetClusterMember())
+	sp.Post("/:name/members/:member/certificate", handler.RotateMemberCertificate())
+	sp.Post("/:name/private-key", handler.RotatePrivateKey())
 	sp.Get("/:name/clusterroles", handler.ListClusterRoles())
 	sp.Post("/:name/clusterroles", handler.CreateClusterRole())
 	sp.Put("/:name/clusterroles/:clusterrole", handler.UpdateClusterRole())
@@ -628,4 +748,22 @@ func Install(parent iris.Party) {
 	sp.Get("/:name/repos/detail", handler.ListClusterReposDetail())
 	sp.Post("/:name/repos", handler.AddCLusterRepo())
 	sp.Delete("/:name/repos/:repo", handler.DeleteClusterRepo())
+	sp.Get("/:name/agent/manifest", handler.GetAgentManifest())
+	sp.Get("/:name/health", handler.GetClusterHealth())
+	sp.Get("/:name/informer-cache", handler.GetInformerCache())
+	sp.Put("/:name/informer-cache", handler.UpdateInformerCache())
+	gp := parent.Party("/clustergroups")
+	gp.Get("", handler.ListClusterGroups())
+	gp.Post("", handler.CreateClusterGroup())
+	gp.Post("/search", handler.SearchClusterGroups())
+	gp.Get("/:name", handler.GetClusterGroup())
+	gp.Put("/:name", handler.UpdateClusterGroup())
+	gp.Delete("/:name", handler.DeleteClusterGroup())
+	gp.Get("/:name/clusters", handler.ListClusterGroupClusters())
+	noAuthParty.Post("/agent/register", handler.RegisterAgent())
+	// 路径中的 ws 使 websocket 连接不被统一的响应格式处理
+	noAuthParty.Get("/agent/ws", handler.ConnectAgent())
+	noAuthParty.Get("/agent/ws/forward", handler.ForwardTunnel())
+	// agent 只连接到其中一个实例，其他实例经由该实例的隧道访问集群
+	tunnel.Default.SetFallback(dialTunnelOwner)
 }
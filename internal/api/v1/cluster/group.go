@@ -0,0 +1,228 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ClusterOperator/kubepi/internal/api/v1/commons"
+	"github.com/ClusterOperator/kubepi/internal/api/v1/session"
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
+	v1Role "github.com/ClusterOperator/kubepi/internal/model/v1/role"
+	"github.com/ClusterOperator/kubepi/internal/server"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/common"
+	pkgV1 "github.com/ClusterOperator/kubepi/pkg/api/v1"
+	"github.com/asdine/storm/v3"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// requestSelector 返回请求参数 labelSelector 和 group 组合的 selector，都为空时返回 nil
+func (h *Handler) requestSelector(ctx *context.Context) (labels.Selector, error) {
+	var selector labels.Selector
+	if group := ctx.URLParam("group"); group != "" {
+		s, err := h.clusterGroupService.Selector(group, common.DBOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("get cluster group %s failed: %s", group, err.Error())
+		}
+		selector = s
+	}
+	if raw := ctx.URLParam("labelSelector"); raw != "" {
+		s, err := labels.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid labelSelector: %s", err.Error())
+		}
+		if selector == nil {
+			return s, nil
+		}
+		requirements, _ := s.Requirements()
+		selector = selector.Add(requirements...)
+	}
+	return selector, nil
+}
+
+// clusterScope 返回用户的角色允许以 verb 访问的集群范围
+func (h *Handler) clusterScope(ctx *context.Context, profile session.UserProfile, verb string) *commons.ClusterScope {
+	if profile.IsAdministrator {
+		return commons.AllClusters()
+	}
+	roles, _ := ctx.Values().Get("roles").([]v1Role.Role)
+	return commons.NewClusterScope(roles, verb, h.clusterGroupService)
+}
+
+// checkLabelScope 检查带有 ls 标签的集群是否在用户以 verb 访问的集群范围内，避免受限的用户创建范围外的集群
+// 或者把集群的标签修改到范围外
+func (h *Handler) checkLabelScope(ctx *context.Context, profile session.UserProfile, verb string, ls []string) error {
+	if !h.clusterScope(ctx, profile, verb).Allowed(&v1Cluster.Cluster{Labels: ls}) {
+		return fmt.Errorf("user %s can not %s cluster with labels %v", profile.Name, verb, ls)
+	}
+	return nil
+}
+
+// filterClusters 返回匹配 selector 并且在角色集群范围内的集群，selector 为 nil 时不按标签过滤
+func filterClusters(clusters []v1Cluster.Cluster, selector labels.Selector, scope *commons.ClusterScope) []v1Cluster.Cluster {
+	result := make([]v1Cluster.Cluster, 0, len(clusters))
+	for i := range clusters {
+		if selector != nil && !selector.Matches(clusters[i].LabelSet()) {
+			continue
+		}
+		if !scope.Allowed(&clusters[i]) {
+			continue
+		}
+		result = append(result, clusters[i])
+	}
+	return result
+}
+
+func paginate(clusters []v1Cluster.Cluster, num, size int) []v1Cluster.Cluster {
+	if num <= 0 || size <= 0 {
+		return clusters
+	}
+	start, end := (num-1)*size, num*size
+	if start > len(clusters) {
+		start = len(clusters)
+	}
+	if end > len(clusters) {
+		end = len(clusters)
+	}
+	return clusters[start:end]
+}
+
+func validateGroup(group *v1Cluster.Group) error {
+	if group.Name == "" {
+		return errors.New("cluster group name is required")
+	}
+	// 空的 selector 会匹配所有集群，需要明确指定
+	if group.Selector == "" {
+		return errors.New("cluster group selector is required")
+	}
+	if _, err := labels.Parse(group.Selector); err != nil {
+		return fmt.Errorf("invalid selector: %s", err.Error())
+	}
+	return nil
+}
+
+// ListClusterGroups 返回所有集群分组
+func (h *Handler) ListClusterGroups() iris.Handler {
+	return func(ctx *context.Context) {
+		groups, err := h.clusterGroupService.List(common.DBOptions{})
+		if err != nil && !errors.Is(err, storm.ErrNotFound) {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", groups)
+	}
+}
+
+func (h *Handler) SearchClusterGroups() iris.Handler {
+	return func(ctx *context.Context) {
+		pageNum, _ := ctx.Values().GetInt(pkgV1.PageNum)
+		pageSize, _ := ctx.Values().GetInt(pkgV1.PageSize)
+		var conditions commons.SearchConditions
+		if err := ctx.ReadJSON(&conditions); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		groups, total, err := h.clusterGroupService.Search(pageNum, pageSize, conditions.Conditions, common.DBOptions{})
+		if err != nil && !errors.Is(err, storm.ErrNotFound) {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", pkgV1.Page{Items: groups, Total: total})
+	}
+}
+
+func (h *Handler) CreateClusterGroup() iris.Handler {
+	return func(ctx *context.Context) {
+		var req v1Cluster.Group
+		if err := ctx.ReadJSON(&req); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		if err := validateGroup(&req); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		profile := ctx.Values().Get("profile").(session.UserProfile)
+		req.CreatedBy = profile.Name
+		if err := h.clusterGroupService.Create(&req, common.DBOptions{}); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", &req)
+	}
+}
+
+func (h *Handler) GetClusterGroup() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		group, err := h.clusterGroupService.Get(name, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", group)
+	}
+}
+
+func (h *Handler) UpdateClusterGroup() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		var req v1Cluster.Group
+		if err := ctx.ReadJSON(&req); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		req.Name = name
+		if err := validateGroup(&req); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		if err := h.clusterGroupService.Update(name, &req, common.DBOptions{}); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", &req)
+	}
+}
+
+func (h *Handler) DeleteClusterGroup() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		// 在同一个事务中检查角色引用并删除分组
+		tx, _ := server.DB().Begin(true)
+		if err := h.clusterGroupService.Delete(name, common.DBOptions{DB: tx}); err != nil {
+			_ = tx.Rollback()
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		_ = tx.Commit()
+		ctx.StatusCode(iris.StatusOK)
+	}
+}
+
+// ListClusterGroupClusters 返回分组当前包含的集群，非管理员只返回角色允许访问的集群
+func (h *Handler) ListClusterGroupClusters() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		clusters, err := h.clusterGroupService.Clusters(name, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		profile := ctx.Values().Get("profile").(session.UserProfile)
+		ctx.Values().Set("data", filterClusters(clusters, nil, h.clusterScope(ctx, profile, "list")))
+	}
+}
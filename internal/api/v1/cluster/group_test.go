@@ -0,0 +1,65 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/ClusterOperator/kubepi/internal/api/v1/commons"
+	v1 "github.com/ClusterOperator/kubepi/internal/model/v1"
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
+	v1Role "github.com/ClusterOperator/kubepi/internal/model/v1/role"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestFilterClusters(t *testing.T) {
+	var clusters []v1Cluster.Cluster
+	for name, ls := range map[string][]string{
+		"prod-cn": {"env=prod", "region=cn"},
+		"prod-us": {"env=prod", "region=us"},
+		"dev":     {"env=dev"},
+	} {
+		clusters = append(clusters, v1Cluster.Cluster{Metadata: v1.Metadata{Name: name}, Labels: ls})
+	}
+	names := func(cs []v1Cluster.Cluster) map[string]bool {
+		m := map[string]bool{}
+		for i := range cs {
+			m[cs[i].Name] = true
+		}
+		return m
+	}
+
+	selector, _ := labels.Parse("env=prod,region!=us")
+	if got := names(filterClusters(clusters, selector, commons.AllClusters())); len(got) != 1 || !got["prod-cn"] {
+		t.Fatalf("unexpected clusters %v", got)
+	}
+
+	roles := []v1Role.Role{{Rules: []v1Role.PolicyRule{
+		{Resource: []string{"clusters"}, Verbs: []string{"list"}, ClusterSelector: "env=dev"},
+		{Resource: []string{"clusters"}, Verbs: []string{"get"}, ClusterSelector: "region=us"},
+	}}}
+	scope := commons.NewClusterScope(roles, "list", nil)
+	if got := names(filterClusters(clusters, nil, scope)); len(got) != 1 || !got["dev"] {
+		t.Fatalf("unexpected clusters %v", got)
+	}
+
+	roles[0].Rules = append(roles[0].Rules, v1Role.PolicyRule{Resource: []string{"*"}, Verbs: []string{"list"}})
+	if scope := commons.NewClusterScope(roles, "list", nil); !scope.All() {
+		t.Fatal("rule without selector should allow all clusters")
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	clusters := make([]v1Cluster.Cluster, 5)
+	cases := []struct {
+		num, size, expect int
+	}{
+		{0, 0, 5},
+		{1, 2, 2},
+		{3, 2, 1},
+		{4, 2, 0},
+	}
+	for _, c := range cases {
+		if got := len(paginate(clusters, c.num, c.size)); got != c.expect {
+			t.Errorf("page %d size %d: expect %d, got %d", c.num, c.size, c.expect, got)
+		}
+	}
+}
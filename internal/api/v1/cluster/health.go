@@ -0,0 +1,327 @@
+package cluster
+
+import (
+	goContext "context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
+	"github.com/ClusterOperator/kubepi/internal/server"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/common"
+	"github.com/ClusterOperator/kubepi/pkg/kubernetes"
+	"github.com/ClusterOperator/kubepi/pkg/metrics"
+	"github.com/asdine/storm/v3"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+var (
+	// healthCheckInterval 是集群正常时健康检查的间隔，检查失败后按次数加倍，最长为 healthCheckMaxBackoff
+	healthCheckInterval   = time.Minute
+	healthCheckMaxBackoff = 10 * time.Minute
+	// healthCheckTimeout 是单个集群一次检查的超时时间
+	healthCheckTimeout = 10 * time.Second
+	// extraInfoInterval 是统计节点和 pod 资源的间隔，统计需要列出所有 pod，比健康检查的间隔长
+	extraInfoInterval = 5 * time.Minute
+	// healthCheckConcurrency 是同时检查的集群数量
+	healthCheckConcurrency = 10
+)
+
+// maxHealthHistory 是保存的健康状态变化的数量
+const maxHealthHistory = 10
+
+// monitoredPhases 是需要健康检查的集群状态，正在初始化、初始化失败和等待 agent 连接的集群不检查
+var monitoredPhases = map[string]bool{
+	clusterStatusCompleted:    true,
+	clusterStatusReady:        true,
+	clusterStatusUnreachable:  true,
+	clusterStatusUnauthorized: true,
+	clusterStatusDegraded:     true,
+}
+
+// ClusterHealth 是集群的健康状态和最近一次统计的资源信息
+type ClusterHealth struct {
+	Phase         string                   `json:"phase"`
+	Version       string                   `json:"version"`
+	Message       string                   `json:"message"`
+	LastProbeTime time.Time                `json:"lastProbeTime"`
+	NextProbeTime time.Time                `json:"nextProbeTime"`
+	History       []v1Cluster.HealthRecord `json:"history"`
+	Extra         *ExtraClusterInfo        `json:"extra,omitempty"`
+}
+
+type probeState struct {
+	failures  int
+	nextProbe time.Time
+	extra     *ExtraClusterInfo
+	extraAt   time.Time
+}
+
+// healthMonitor 在后台定期检查集群并更新 Cluster.Status，集群列表直接使用检查的结果，不再同步请求集群
+type healthMonitor struct {
+	mu     sync.Mutex
+	states map[string]*probeState
+}
+
+func newHealthMonitor() *healthMonitor {
+	return &healthMonitor{states: map[string]*probeState{}}
+}
+
+func (m *healthMonitor) state(name string) *probeState {
+	s, ok := m.states[name]
+	if !ok {
+		s = &probeState{}
+		m.states[name] = s
+	}
+	return s
+}
+
+// extra 返回集群最近一次统计的资源信息，还没有统计过时返回 nil
+func (m *healthMonitor) extra(name string) *ExtraClusterInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.states[name]; ok {
+		return s.extra
+	}
+	return nil
+}
+
+func (m *healthMonitor) nextProbe(name string) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.states[name]; ok {
+		return s.nextProbe
+	}
+	return time.Time{}
+}
+
+// probeNow 使集群在下一轮立即检查，在集群的连接信息修改后调用
+func (m *healthMonitor) probeNow(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.states[name]; ok {
+		s.failures = 0
+		s.nextProbe = time.Time{}
+	}
+}
+
+// backoff 返回连续失败 failures 次后下一次检查的间隔
+func backoff(failures int) time.Duration {
+	d := healthCheckInterval
+	for i := 0; i < failures && d < healthCheckMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > healthCheckMaxBackoff {
+		d = healthCheckMaxBackoff
+	}
+	return d
+}
+
+// startHealthMonitor 定期检查集群的状态，多实例部署时每一轮只由一个实例检查
+func (h *Handler) startHealthMonitor() {
+	go func() {
+		ticker := time.NewTicker(healthCheckInterval / 2)
+		defer ticker.Stop()
+		for {
+			acquired, err := server.AcquireSchedule("cluster-health", healthCheckInterval/2)
+			if err != nil {
+				server.Logger().Errorf("acquire cluster health schedule failed: %s", err.Error())
+			} else if acquired {
+				h.checkClusters()
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// checkClusters 检查所有到期的集群
+func (h *Handler) checkClusters() {
+	clusters, err := h.clusterService.List(common.DBOptions{})
+	if err != nil {
+		server.Logger().Errorf("list clusters failed: %s", err.Error())
+		return
+	}
+	now := time.Now()
+	names := map[string]bool{}
+	var due []v1Cluster.Cluster
+	h.health.mu.Lock()
+	for i := range clusters {
+		names[clusters[i].Name] = true
+		if monitoredPhases[clusters[i].Status.Phase] && !now.Before(h.health.state(clusters[i].Name).nextProbe) {
+			due = append(due, clusters[i])
+		}
+	}
+	// 清理已删除集群的状态
+	for name := range h.health.states {
+		if !names[name] {
+			delete(h.health.states, name)
+		}
+	}
+	h.health.mu.Unlock()
+
+	sem := make(chan struct{}, healthCheckConcurrency)
+	var wg sync.WaitGroup
+	for i := range due {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(c *v1Cluster.Cluster) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			h.checkCluster(c)
+		}(&due[i])
+	}
+	wg.Wait()
+}
+
+func (h *Handler) checkCluster(c *v1Cluster.Cluster) {
+	ctx, cancel := goContext.WithTimeout(goContext.Background(), healthCheckTimeout)
+	defer cancel()
+	k := kubernetes.NewKubernetes(c)
+	phase, gitVersion, message := probeCluster(ctx, k)
+
+	now := time.Now()
+	h.health.mu.Lock()
+	s := h.health.state(c.Name)
+	if phase == clusterStatusReady || phase == clusterStatusDegraded {
+		s.failures = 0
+	} else {
+		s.failures++
+	}
+	s.nextProbe = now.Add(backoff(s.failures))
+	refreshExtra := phase != clusterStatusUnreachable && phase != clusterStatusUnauthorized && now.Sub(s.extraAt) >= extraInfoInterval
+	if refreshExtra {
+		s.extraAt = now
+	}
+	h.health.mu.Unlock()
+
+	if refreshExtra {
+		extraCtx, extraCancel := goContext.WithTimeout(goContext.Background(), healthCheckTimeout)
+		info, _ := getExtraClusterInfo(extraCtx, k)
+		extraCancel()
+		h.health.mu.Lock()
+		s.extra = &info
+		h.health.mu.Unlock()
+	}
+	metrics.SetClusterHealth(c.Name, clusterHealthy(phase))
+
+	// 在事务中重新读取集群，避免覆盖检查期间 agent 更新的状态
+	_, err := h.updateStatus(c.Name, func(s *v1Cluster.Status) {
+		*s = updateHealthStatus(*s, phase, gitVersion, message, now)
+	})
+	// 集群在检查期间被删除时不需要更新
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		server.Logger().Errorf("can not update cluster status %s", err)
+	}
+}
+
+// updateHealthStatus 写入检查结果，状态变化时记录到 History
+func updateHealthStatus(status v1Cluster.Status, phase, gitVersion, message string, now time.Time) v1Cluster.Status {
+	if status.Phase != phase || status.Message != message {
+		status.History = append(status.History, v1Cluster.HealthRecord{Time: now, Phase: phase, Message: message})
+		if len(status.History) > maxHealthHistory {
+			status.History = status.History[len(status.History)-maxHealthHistory:]
+		}
+	}
+	status.Phase = phase
+	status.Message = message
+	if gitVersion != "" {
+		status.Version = gitVersion
+	}
+	status.LastProbeTime = now
+	return status
+}
+
+// probeCluster 检查集群的连接、认证、readyz 和节点状态，返回集群的状态、版本和说明
+func probeCluster(ctx goContext.Context, k kubernetes.Interface) (phase, gitVersion, message string) {
+	client, err := k.Client()
+	if err != nil {
+		return clusterStatusUnreachable, "", err.Error()
+	}
+	rest := client.Discovery().RESTClient()
+	raw, err := rest.Get().AbsPath("/version").Do(ctx).Raw()
+	if err != nil {
+		return failedPhase(err), "", err.Error()
+	}
+	var v version.Info
+	if err := json.Unmarshal(raw, &v); err == nil {
+		gitVersion = v.GitVersion
+	}
+	nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		if apierrors.IsUnauthorized(err) || apierrors.IsForbidden(err) {
+			return clusterStatusUnauthorized, gitVersion, err.Error()
+		}
+		return clusterStatusDegraded, gitVersion, err.Error()
+	}
+	// 1.16 之前的集群没有 readyz
+	if _, err := rest.Get().AbsPath("/readyz").Do(ctx).Raw(); err != nil && !apierrors.IsNotFound(err) {
+		return failedPhase(err), gitVersion, fmt.Sprintf("readyz check failed: %s", err.Error())
+	}
+	notReady := 0
+	for i := range nodes.Items {
+		ready := false
+		for _, c := range nodes.Items[i].Status.Conditions {
+			if c.Type == "Ready" && c.Status == "True" {
+				ready = true
+			}
+		}
+		if !ready {
+			notReady++
+		}
+	}
+	if notReady > 0 {
+		return clusterStatusDegraded, gitVersion, fmt.Sprintf("%d/%d nodes not ready", notReady, len(nodes.Items))
+	}
+	return clusterStatusReady, gitVersion, ""
+}
+
+// failedPhase 根据请求的错误判断集群状态，集群返回了错误响应时为 Degraded，无法连接时为 Unreachable
+func failedPhase(err error) string {
+	if apierrors.IsUnauthorized(err) || apierrors.IsForbidden(err) {
+		return clusterStatusUnauthorized
+	}
+	var status apierrors.APIStatus
+	if errors.As(err, &status) {
+		return clusterStatusDegraded
+	}
+	return clusterStatusUnreachable
+}
+
+// clusterHealthy 判断集群是否可用，还没有经过健康检查的集群按初始化完成时的状态处理
+func clusterHealthy(phase string) bool {
+	return phase == clusterStatusReady || phase == clusterStatusDegraded || phase == clusterStatusCompleted
+}
+
+// GetClusterHealth 返回后台健康检查的结果
+func (h *Handler) GetClusterHealth() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		c, err := h.clusterService.Get(name, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		history := c.Status.History
+		if history == nil {
+			history = []v1Cluster.HealthRecord{}
+		}
+		ctx.Values().Set("data", ClusterHealth{
+			Phase:         c.Status.Phase,
+			Version:       c.Status.Version,
+			Message:       c.Status.Message,
+			LastProbeTime: c.Status.LastProbeTime,
+			NextProbeTime: h.health.nextProbe(name),
+			History:       history,
+			Extra:         h.health.extra(name),
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package cluster
+
+import (
+	goContext "context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	v1 "github.com/ClusterOperator/kubepi/internal/model/v1"
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
+	"github.com/ClusterOperator/kubepi/pkg/kubernetes"
+)
+
+func TestProbeCluster(t *testing.T) {
+	var readyz, nodes int
+	nodeReady := "True"
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Unauthorized","code":401}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/version":
+			_, _ = w.Write([]byte(`{"major":"1","minor":"29","gitVersion":"v1.29.0"}`))
+		case "/api/v1/nodes":
+			w.WriteHeader(nodes)
+			_, _ = w.Write([]byte(`{"kind":"NodeList","apiVersion":"v1","items":[{"metadata":{"name":"n1"},"status":{"conditions":[{"type":"Ready","status":"` + nodeReady + `"}]}}]}`))
+		case "/readyz":
+			w.WriteHeader(readyz)
+			_, _ = w.Write([]byte("ok"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	old := kubernetes.DefaultCache
+	kubernetes.DefaultCache = kubernetes.NewClientCache(time.Minute)
+	defer func() { kubernetes.DefaultCache = old }()
+
+	newCluster := func(name, token, server string) kubernetes.Interface {
+		c := &v1Cluster.Cluster{Metadata: v1.Metadata{Name: name, UUID: name}}
+		c.Spec.Connect.Direction = v1Cluster.DirectionForward
+		c.Spec.Connect.Forward.ApiServer = server
+		c.Spec.Authentication.Mode = "bearer"
+		c.Spec.Authentication.BearerToken = token
+		return kubernetes.NewKubernetes(c)
+	}
+	k := newCluster("c1", "token", apiServer.URL)
+	for _, c := range []struct {
+		readyz, nodes int
+		nodeReady     string
+		phase         string
+	}{
+		{http.StatusOK, http.StatusOK, "True", clusterStatusReady},
+		{http.StatusNotFound, http.StatusOK, "True", clusterStatusReady},
+		{http.StatusInternalServerError, http.StatusOK, "True", clusterStatusDegraded},
+		{http.StatusOK, http.StatusOK, "False", clusterStatusDegraded},
+		{http.StatusOK, http.StatusForbidden, "True", clusterStatusUnauthorized},
+	} {
+		readyz, nodes, nodeReady = c.readyz, c.nodes, c.nodeReady
+		phase, gitVersion, message := probeCluster(goContext.Background(), k)
+		if phase != c.phase || gitVersion != "v1.29.0" {
+			t.Fatalf("expect %s, got %s %s %s", c.phase, phase, gitVersion, message)
+		}
+	}
+	if phase, _, _ := probeCluster(goContext.Background(), newCluster("c2", "wrong", apiServer.URL)); phase != clusterStatusUnauthorized {
+		t.Fatalf("expect unauthorized, got %s", phase)
+	}
+	apiServer.Close()
+	if phase, _, _ := probeCluster(goContext.Background(), newCluster("c3", "token", apiServer.URL)); phase != clusterStatusUnreachable {
+		t.Fatalf("expect unreachable, got %s", phase)
+	}
+}
+
+func TestUpdateHealthStatus(t *testing.T) {
+	var status v1Cluster.Status
+	now := time.Now()
+	for i := 0; i < maxHealthHistory+2; i++ {
+		phase := clusterStatusReady
+		if i%2 == 1 {
+			phase = clusterStatusUnreachable
+		}
+		status = updateHealthStatus(status, phase, "v1.29.0", "", now)
+		status = updateHealthStatus(status, phase, "", "", now)
+	}
+	if len(status.History) != maxHealthHistory || status.Version != "v1.29.0" || status.Phase != clusterStatusUnreachable {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if backoff(0) != healthCheckInterval || backoff(1) != 2*healthCheckInterval || backoff(10) != healthCheckMaxBackoff {
+		t.Fatal("unexpected backoff")
+	}
+}
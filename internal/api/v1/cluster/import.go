@@ -0,0 +1,321 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ClusterOperator/kubepi/internal/api/v1/session"
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/common"
+	"github.com/ClusterOperator/kubepi/pkg/certificate"
+	"github.com/ClusterOperator/kubepi/pkg/kubernetes"
+	"github.com/asdine/storm/v3"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// importPingTimeout 是预览时检查单个 context 能否连接的超时时间
+var importPingTimeout = 10 * time.Second
+
+// ImportPreviewRequest 是需要解析的 kubeconfig
+type ImportPreviewRequest struct {
+	ConfigFileContent string `json:"configFileContent"`
+}
+
+// ImportContext 是 kubeconfig 中一个 context 的连接信息和检查结果
+type ImportContext struct {
+	Context   string `json:"context"`
+	Cluster   string `json:"cluster"`
+	Server    string `json:"server"`
+	User      string `json:"user"`
+	Namespace string `json:"namespace"`
+	Current   bool   `json:"current"`
+	// Supported 为 false 时 Message 是无法导入的原因，例如使用 exec 插件认证的用户
+	Supported bool   `json:"supported"`
+	Reachable bool   `json:"reachable"`
+	Version   string `json:"version"`
+	Message   string `json:"message"`
+}
+
+// ImportClusterRequest 选择 kubeconfig 中的 context 导入为集群
+type ImportClusterRequest struct {
+	ConfigFileContent string `json:"configFileContent"`
+	// AuthMode 为 serviceAccount 时 context 的凭据只用于创建 ServiceAccount，为空时使用 configFile 模式
+	AuthMode       string                `json:"authMode"`
+	MemberAuthMode string                `json:"memberAuthMode"`
+	Clusters       []ImportClusterOption `json:"clusters"`
+}
+
+type ImportClusterOption struct {
+	Context     string   `json:"context"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Labels      []string `json:"labels"`
+}
+
+// ImportClusterResult 是单个集群的导入结果，导入失败不影响其他集群
+type ImportClusterResult struct {
+	Context string `json:"context"`
+	Name    string `json:"name"`
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// unsupportedReason 检查 context 的集群和用户是否可以在服务端使用，可以使用时返回空字符串。
+// exec 插件和 auth-provider 依赖本地的命令和配置，引用本地文件的证书和 token 在服务端不存在
+func unsupportedReason(cfg *clientcmdapi.Config, name string) string {
+	c := cfg.Contexts[name]
+	cluster, ok := cfg.Clusters[c.Cluster]
+	if !ok {
+		return fmt.Sprintf("cluster %s not found", c.Cluster)
+	}
+	if cluster.CertificateAuthority != "" {
+		return fmt.Sprintf("cluster %s references local file %s", c.Cluster, cluster.CertificateAuthority)
+	}
+	user, ok := cfg.AuthInfos[c.AuthInfo]
+	if !ok {
+		return fmt.Sprintf("user %s not found", c.AuthInfo)
+	}
+	if user.Exec != nil {
+		return fmt.Sprintf("user %s uses exec plugin %s which is not supported", c.AuthInfo, user.Exec.Command)
+	}
+	if user.AuthProvider != nil {
+		return fmt.Sprintf("user %s uses auth provider %s which is not supported", c.AuthInfo, user.AuthProvider.Name)
+	}
+	for _, f := range []string{user.ClientCertificate, user.ClientKey, user.TokenFile} {
+		if f != "" {
+			return fmt.Sprintf("user %s references local file %s", c.AuthInfo, f)
+		}
+	}
+	return ""
+}
+
+// contextKubeconfig 返回只包含一个 context 的 kubeconfig，作为导入后集群的 configFile
+func contextKubeconfig(cfg *clientcmdapi.Config, name string) ([]byte, error) {
+	single := cfg.DeepCopy()
+	single.CurrentContext = name
+	if err := clientcmdapi.MinifyConfig(single); err != nil {
+		return nil, err
+	}
+	return clientcmd.Write(*single)
+}
+
+// previewContexts 按名称顺序返回 kubeconfig 中所有 context 的连接信息，不检查连接
+func previewContexts(cfg *clientcmdapi.Config) []ImportContext {
+	names := make([]string, 0, len(cfg.Contexts))
+	for name := range cfg.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	contexts := make([]ImportContext, 0, len(names))
+	for _, name := range names {
+		c := cfg.Contexts[name]
+		item := ImportContext{
+			Context:   name,
+			Cluster:   c.Cluster,
+			User:      c.AuthInfo,
+			Namespace: c.Namespace,
+			Current:   name == cfg.CurrentContext,
+		}
+		if cluster, ok := cfg.Clusters[c.Cluster]; ok {
+			item.Server = cluster.Server
+		}
+		item.Message = unsupportedReason(cfg, name)
+		item.Supported = item.Message == ""
+		contexts = append(contexts, item)
+	}
+	return contexts
+}
+
+func loadKubeconfig(content string) (*clientcmdapi.Config, error) {
+	if strings.TrimSpace(content) == "" {
+		return nil, errors.New("kubeconfig is empty")
+	}
+	cfg, err := clientcmd.Load([]byte(content))
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Contexts) == 0 {
+		return nil, errors.New("no context found in kubeconfig")
+	}
+	return cfg, nil
+}
+
+// pingContext 使用 context 的凭据连接集群，超时后不再等待
+func pingContext(content []byte) (string, error) {
+	c := &v1Cluster.Cluster{Spec: v1Cluster.Spec{
+		Connect:        v1Cluster.Connect{Direction: v1Cluster.DirectionForward},
+		Authentication: v1Cluster.Authentication{Mode: "configFile", ConfigFileContent: content},
+	}}
+	type result struct {
+		version string
+		err     error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		client := kubernetes.NewKubernetes(c)
+		if err := client.Ping(); err != nil {
+			ch <- result{err: err}
+			return
+		}
+		v, _ := client.Version()
+		ch <- result{version: v.GitVersion}
+	}()
+	select {
+	case r := <-ch:
+		return r.version, r.err
+	case <-time.After(importPingTimeout):
+		return "", fmt.Errorf("connect timeout after %s", importPingTimeout)
+	}
+}
+
+// PreviewImportClusters 解析包含多个 context 的 kubeconfig，返回每个 context 的连接信息以及能否连接
+func (h *Handler) PreviewImportClusters() iris.Handler {
+	return func(ctx *context.Context) {
+		var req ImportPreviewRequest
+		if err := ctx.ReadJSON(&req); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		cfg, err := loadKubeconfig(req.ConfigFileContent)
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", fmt.Sprintf("parse kubeconfig failed: %s", err.Error()))
+			return
+		}
+		contexts := previewContexts(cfg)
+		var wg sync.WaitGroup
+		for i := range contexts {
+			if !contexts[i].Supported {
+				continue
+			}
+			wg.Add(1)
+			go func(item *ImportContext) {
+				defer wg.Done()
+				content, err := contextKubeconfig(cfg, item.Context)
+				if err != nil {
+					item.Supported = false
+					item.Message = err.Error()
+					return
+				}
+				item.Version, err = pingContext(content)
+				if err != nil {
+					item.Message = err.Error()
+					return
+				}
+				item.Reachable = true
+			}(&contexts[i])
+		}
+		wg.Wait()
+		ctx.Values().Set("data", contexts)
+	}
+}
+
+// ImportClusters 将选择的 context 分别导入为集群，每个集群使用只包含自己 context 的 kubeconfig，
+// 返回每个集群的导入结果
+func (h *Handler) ImportClusters() iris.Handler {
+	return func(ctx *context.Context) {
+		var req ImportClusterRequest
+		if err := ctx.ReadJSON(&req); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		switch req.MemberAuthMode {
+		case "", v1Cluster.MemberAuthCertificate, v1Cluster.MemberAuthImpersonation:
+		default:
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", fmt.Sprintf("unsupported member auth mode %s", req.MemberAuthMode))
+			return
+		}
+		switch req.AuthMode {
+		case "", "configFile", v1Cluster.AuthModeServiceAccount:
+		default:
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", fmt.Sprintf("unsupported auth mode %s", req.AuthMode))
+			return
+		}
+		if len(req.Clusters) == 0 {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "no context selected")
+			return
+		}
+		cfg, err := loadKubeconfig(req.ConfigFileContent)
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", fmt.Sprintf("parse kubeconfig failed: %s", err.Error()))
+			return
+		}
+		profile := ctx.Values().Get("profile").(session.UserProfile)
+		names := map[string]bool{}
+		results := make([]ImportClusterResult, 0, len(req.Clusters))
+		for i := range req.Clusters {
+			option := req.Clusters[i]
+			result := ImportClusterResult{Context: option.Context, Name: option.Name}
+			if names[option.Name] {
+				result.Message = fmt.Sprintf("cluster name %s is duplicated", option.Name)
+			} else if err := h.importCluster(cfg, option, req, profile); err != nil {
+				result.Message = err.Error()
+			} else {
+				result.Success = true
+			}
+			names[option.Name] = true
+			results = append(results, result)
+		}
+		ctx.Values().Set("data", results)
+	}
+}
+
+func (h *Handler) importCluster(cfg *clientcmdapi.Config, option ImportClusterOption, req ImportClusterRequest, profile session.UserProfile) error {
+	if option.Name == "" {
+		return errors.New("cluster name is required")
+	}
+	if _, ok := cfg.Contexts[option.Context]; !ok {
+		return fmt.Errorf("context %s not found", option.Context)
+	}
+	if reason := unsupportedReason(cfg, option.Context); reason != "" {
+		return errors.New(reason)
+	}
+	if err := v1Cluster.ValidateLabels(option.Labels); err != nil {
+		return err
+	}
+	if _, err := h.clusterService.Get(option.Name, common.DBOptions{}); err == nil {
+		return fmt.Errorf("cluster %s already exists", option.Name)
+	} else if !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	content, err := contextKubeconfig(cfg, option.Context)
+	if err != nil {
+		return err
+	}
+	privateKey, err := certificate.GeneratePrivateKey()
+	if err != nil {
+		return err
+	}
+	labels := option.Labels
+	if labels == nil {
+		labels = []string{}
+	}
+	c := &v1Cluster.Cluster{
+		Spec: v1Cluster.Spec{
+			Connect:        v1Cluster.Connect{Direction: v1Cluster.DirectionForward},
+			Authentication: v1Cluster.Authentication{Mode: "configFile", ConfigFileContent: content},
+			MemberAuthMode: req.MemberAuthMode,
+		},
+		PrivateKey: privateKey,
+		Labels:     labels,
+	}
+	c.Name = option.Name
+	c.Description = option.Description
+	if req.AuthMode == v1Cluster.AuthModeServiceAccount {
+		return h.createServiceAccountCluster(c, "configFile", profile)
+	}
+	return h.createForwardCluster(c, profile)
+}
@@ -0,0 +1,186 @@
+package cluster
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ClusterOperator/kubepi/internal/api/v1/session"
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/cluster"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/common"
+	"github.com/asdine/storm/v3"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const importKubeconfig = `apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com:6443
+- name: prod
+  cluster:
+    server: https://prod.example.com:6443
+    certificate-authority: /etc/kubernetes/ca.crt
+- name: eks
+  cluster:
+    server: https://eks.example.com
+users:
+- name: dev-admin
+  user:
+    token: dev-token
+- name: prod-admin
+  user:
+    token: prod-token
+- name: eks-user
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1beta1
+      command: aws
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: dev-admin
+    namespace: default
+- name: prod
+  context:
+    cluster: prod
+    user: prod-admin
+- name: eks
+  context:
+    cluster: eks
+    user: eks-user
+`
+
+func TestPreviewContexts(t *testing.T) {
+	cfg, err := loadKubeconfig(importKubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contexts := previewContexts(cfg)
+	if len(contexts) != 3 {
+		t.Fatalf("expected 3 contexts, got %d", len(contexts))
+	}
+	byName := map[string]ImportContext{}
+	for _, c := range contexts {
+		byName[c.Context] = c
+	}
+	dev := byName["dev"]
+	if !dev.Supported || !dev.Current || dev.Server != "https://dev.example.com:6443" || dev.User != "dev-admin" || dev.Namespace != "default" {
+		t.Errorf("unexpected dev context %+v", dev)
+	}
+	if eks := byName["eks"]; eks.Supported || !strings.Contains(eks.Message, "exec plugin aws") {
+		t.Errorf("exec plugin user should be unsupported, got %+v", eks)
+	}
+	if prod := byName["prod"]; prod.Supported || !strings.Contains(prod.Message, "/etc/kubernetes/ca.crt") {
+		t.Errorf("cluster referencing local file should be unsupported, got %+v", prod)
+	}
+}
+
+func TestContextKubeconfig(t *testing.T) {
+	cfg, err := loadKubeconfig(importKubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := contextKubeconfig(cfg, "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	single, err := clientcmd.Load(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if single.CurrentContext != "prod" || len(single.Contexts) != 1 || len(single.Clusters) != 1 || len(single.AuthInfos) != 1 {
+		t.Fatalf("expected only the prod context, got %+v", single)
+	}
+	if single.AuthInfos["prod-admin"].Token != "prod-token" {
+		t.Errorf("credential of prod-admin not kept")
+	}
+	if _, ok := cfg.Contexts["dev"]; !ok || cfg.CurrentContext != "dev" {
+		t.Errorf("original kubeconfig should not be modified")
+	}
+}
+
+func TestLoadKubeconfig(t *testing.T) {
+	if _, err := loadKubeconfig(" "); err == nil {
+		t.Error("expected error for empty kubeconfig")
+	}
+	if _, err := loadKubeconfig("apiVersion: v1\nkind: Config\n"); err == nil {
+		t.Error("expected error for kubeconfig without context")
+	}
+}
+
+type fakeClusterService struct {
+	cluster.Service
+}
+
+func (fakeClusterService) Get(string, common.DBOptions) (*v1Cluster.Cluster, error) {
+	return nil, storm.ErrNotFound
+}
+
+func TestImportServiceAccountCluster(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	apiServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		key := r.Method + " " + r.URL.Path
+		requests = append(requests, key)
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case key == "GET /api/v1/namespaces" && token == "dev-token":
+			_, _ = w.Write([]byte(`{"kind":"NamespaceList","apiVersion":"v1","items":[]}`))
+		case key == "POST /apis/rbac.authorization.k8s.io/v1/clusterrolebindings",
+			key == "POST /api/v1/namespaces/kube-system/serviceaccounts":
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write(body)
+		case strings.HasSuffix(key, "/token"):
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"kind":"TokenRequest","apiVersion":"authentication.k8s.io/v1","status":{"token":"sa-token","expirationTimestamp":"2030-01-01T00:00:00Z"}}`))
+		case r.Method == http.MethodDelete:
+			_, _ = w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Success"}`))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Unauthorized","code":401}`))
+		}
+	}))
+	defer apiServer.Close()
+
+	// kubeconfig 只在 https 连接上发送凭据
+	content := strings.ReplaceAll(importKubeconfig, "server: https://dev.example.com:6443", "server: "+apiServer.URL+"\n    insecure-skip-tls-verify: true")
+	cfg, err := loadKubeconfig(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &Handler{clusterService: fakeClusterService{}}
+	req := ImportClusterRequest{AuthMode: v1Cluster.AuthModeServiceAccount}
+	// 保存集群需要数据库，这里让 ServiceAccount 的 token 访问集群失败，只验证 ServiceAccount 的创建和回收
+	err = h.importCluster(cfg, ImportClusterOption{Context: "dev", Name: "dev"}, req, session.UserProfile{Name: "admin"})
+	if err == nil || strings.Contains(err.Error(), "uuid") {
+		t.Fatalf("expect the service account token to be rejected, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var name string
+	for _, r := range requests {
+		if strings.HasSuffix(r, "/token") {
+			name = strings.TrimSuffix(strings.TrimPrefix(r, "POST /api/v1/namespaces/kube-system/serviceaccounts/"), "/token")
+		}
+	}
+	if len(name) != len("kubepi-")+36 || !strings.HasPrefix(name, "kubepi-") {
+		t.Fatalf("service account should be named after the cluster uuid, requests %v", requests)
+	}
+	deleted := "DELETE /apis/rbac.authorization.k8s.io/v1/clusterrolebindings/" + name
+	if requests[len(requests)-1] != deleted {
+		t.Fatalf("service account created by the failed import should be deleted, requests %v", requests)
+	}
+}
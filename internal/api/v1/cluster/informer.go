@@ -0,0 +1,74 @@
+package cluster
+
+import (
+	"github.com/ClusterOperator/kubepi/internal/api/v1/session"
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/common"
+	"github.com/ClusterOperator/kubepi/pkg/kubernetes"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+)
+
+// InformerCacheStatus 是集群 informer 缓存的开关和当前缓存的资源
+type InformerCacheStatus struct {
+	Enabled   bool                               `json:"enabled"`
+	Resources []kubernetes.ResourceInformerStats `json:"resources"`
+}
+
+func informerCacheStatus(c *v1Cluster.Cluster) InformerCacheStatus {
+	status := InformerCacheStatus{Enabled: c.Spec.InformerCache.Enabled, Resources: []kubernetes.ResourceInformerStats{}}
+	for _, s := range kubernetes.DefaultInformers.Stats() {
+		if s.Cluster == c.Name {
+			status.Resources = s.Resources
+		}
+	}
+	return status
+}
+
+// GetInformerCache 返回集群 informer 缓存的状态
+func (h *Handler) GetInformerCache() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		c, err := h.clusterService.Get(name, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", informerCacheStatus(c))
+	}
+}
+
+// UpdateInformerCache 开启或关闭集群的 informer 缓存，只有管理员可以修改
+func (h *Handler) UpdateInformerCache() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		profile := ctx.Values().Get("profile").(session.UserProfile)
+		if !profile.IsAdministrator {
+			ctx.StatusCode(iris.StatusForbidden)
+			ctx.Values().Set("message", "only administrator can change the informer cache of cluster")
+			return
+		}
+		var req v1Cluster.InformerCache
+		if err := ctx.ReadJSON(&req); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		if err := h.clusterService.UpdateInformerCache(name, req, common.DBOptions{}); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		c, err := h.clusterService.Get(name, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		if !req.Enabled {
+			kubernetes.DefaultInformers.Stop(name)
+		}
+		ctx.Values().Set("data", informerCacheStatus(c))
+	}
+}
@@ -1,8 +1,9 @@
 package cluster
 
 import (
+	"github.com/ClusterOperator/kubepi/internal/api/v1/session"
+	"github.com/ClusterOperator/kubepi/internal/server"
 	"github.com/ClusterOperator/kubepi/internal/service/v1/common"
-	"github.com/ClusterOperator/kubepi/pkg/kubernetes"
 	"github.com/ClusterOperator/kubepi/pkg/logging"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
@@ -58,24 +59,74 @@ func (h *Handler) LoggingHandler() iris.Handler {
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 		}
-		c, err := h.clusterService.Get(clusterName, common.DBOptions{})
-		if err != nil {
-			ctx.StatusCode(iris.StatusInternalServerError)
-			ctx.Values().Set("message", err)
-			return
+		t := loggingTicket{
+			Cluster:    clusterName,
+			Namespace:  namespace,
+			Pod:        podName,
+			Container:  containerName,
+			TailLines:  tailLines,
+			Follow:     follow,
+			Previous:   previous,
+			Timestamps: timestamps,
 		}
-		k := kubernetes.NewKubernetes(c)
-		client, err := k.Client()
-		if err != nil {
+		if profile := ctx.Values().Get("profile").(session.UserProfile); !profile.IsAdministrator {
+			t.User = profile.Name
+		}
+		if err := h.startLoggingSession(sessionId, t); err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err)
 			return
 		}
-		logging.LogSessions.Set(sessionId, logging.LogSession{
-			Id:    sessionId,
-			Bound: make(chan error),
-		})
-		go logging.WaitForLoggingStream(client, namespace, podName, containerName, tailLines, follow, previous, timestamps, sessionId)
-		ctx.Values().Set("data", TerminalResponse{ID: sessionId})
+		if err := server.SaveTicket(server.TicketKindLogging, sessionId, t); err != nil {
+			server.Logger().Errorf("save logging ticket failed: %s", err.Error())
+		}
+		ctx.Values().Set("data", TerminalResponse{ID: sessionId, Instance: server.InstanceID()})
+	}
+}
+
+// loggingTicket 是日志会话的创建参数，用于在其他副本中恢复会话
+type loggingTicket struct {
+	Cluster    string `json:"cluster"`
+	Namespace  string `json:"namespace"`
+	Pod        string `json:"pod"`
+	Container  string `json:"container"`
+	TailLines  int    `json:"tailLines"`
+	Follow     bool   `json:"follow"`
+	Previous   bool   `json:"previous"`
+	Timestamps bool   `json:"timestamps"`
+	// User 是创建会话的非管理员用户，模拟用户模式下以该用户的身份读取日志
+	User string `json:"user"`
+}
+
+func (h *Handler) startLoggingSession(sessionId string, t loggingTicket) error {
+	c, err := h.clusterService.Get(t.Cluster, common.DBOptions{})
+	if err != nil {
+		return err
+	}
+	_, client, err := sessionClient(c, t.User)
+	if err != nil {
+		return err
+	}
+	logging.LogSessions.Set(sessionId, logging.LogSession{
+		Id:    sessionId,
+		Bound: make(chan error),
+	})
+	go func() {
+		logging.WaitForLoggingStream(client, t.Namespace, t.Pod, t.Container, t.TailLines, t.Follow, t.Previous, t.Timestamps, sessionId)
+		server.DeleteTicket(server.TicketKindLogging, sessionId)
+	}()
+	return nil
+}
+
+// restoreLoggingSession 恢复由其他副本创建的日志会话
+func (h *Handler) restoreLoggingSession(sessionId string) bool {
+	var t loggingTicket
+	if err := server.LoadTicketData(server.TicketKindLogging, sessionId, &t); err != nil {
+		return false
+	}
+	if err := h.startLoggingSession(sessionId, t); err != nil {
+		server.Logger().Errorf("restore logging session %s failed: %s", sessionId, err.Error())
+		return false
 	}
+	return true
 }
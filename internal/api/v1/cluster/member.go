@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/ClusterOperator/kubepi/internal/api/v1/session"
 	v1 "github.com/ClusterOperator/kubepi/internal/model/v1"
@@ -146,6 +147,7 @@ func (h *Handler) GetClusterMember() iris.Handler {
 		member.ClusterRoles = make([]string, 0)
 		member.NamespaceRoles = make([]NamespaceRoles, 0)
 		member.Name = binding.UserRef
+		member.Certificate = certificateStatus(binding.Certificate, time.Now())
 		set := collectons.NewStringSet()
 		for i := range clusterRoleBindings.Items {
 			set.Add(clusterRoleBindings.Items[i].RoleRef.Name)
@@ -192,13 +194,29 @@ func (h *Handler) ListClusterMembers() iris.Handler {
 			return
 		}
 		members := make([]Member, 0)
+		now := time.Now()
 		for i := range bindings {
 			members = append(members, Member{
 				Name:        bindings[i].UserRef,
 				BindingName: bindings[i].Name,
 				CreateAt:    bindings[i].CreateAt,
+				Certificate: certificateStatus(bindings[i].Certificate, now),
 			})
 		}
+		// revoked=true 时同时返回被移除成员的证书，证书过期前仍然可以通过集群的认证
+		if revoked, _ := ctx.URLParamBool("revoked"); revoked {
+			rs, err := h.clusterBindingService.ListRevoked(name, common.DBOptions{})
+			if err != nil {
+				ctx.StatusCode(iris.StatusInternalServerError)
+				ctx.Values().Set("message", err.Error())
+				return
+			}
+			for i := range rs {
+				if rs[i].Reason == v1Cluster.RevokeReasonRemoved {
+					members = append(members, revokedMember(rs[i]))
+				}
+			}
+		}
 		ctx.Values().Set("data", members)
 	}
 }
@@ -257,14 +275,16 @@ func (h *Handler) CreateClusterMember() iris.Handler {
 		}
 
 		k := kubernetes.NewKubernetes(c)
-		cert, err := k.CreateCommonUser(req.Name)
-		if err != nil {
-			_ = tx.Rollback()
-			ctx.StatusCode(iris.StatusInternalServerError)
-			ctx.Values().Set("message", fmt.Sprintf("create common user failed: %s", err.Error()))
-			return
+		if !kubernetes.Impersonation(c) {
+			cert, err := k.CreateCommonUser(req.Name)
+			if err != nil {
+				_ = tx.Rollback()
+				ctx.StatusCode(iris.StatusInternalServerError)
+				ctx.Values().Set("message", fmt.Sprintf("create common user failed: %s", err.Error()))
+				return
+			}
+			binding.Certificate = cert
 		}
-		binding.Certificate = cert
 		if err := h.clusterBindingService.CreateClusterBinding(&binding, common.DBOptions{DB: tx}); err != nil {
 			_ = tx.Rollback()
 			ctx.StatusCode(iris.StatusInternalServerError)
@@ -292,6 +312,7 @@ func (h *Handler) CreateClusterMember() iris.Handler {
 			}
 		}
 		_ = tx.Commit()
+		kubernetes.DefaultCache.InvalidateUser(name, req.Name)
 		ctx.Values().Set("data", req)
 	}
 }
@@ -344,6 +365,12 @@ func (h *Handler) DeleteClusterMember() iris.Handler {
 			ctx.Values().Set("message", fmt.Sprintf("delete cluster binding failed: %s", err.Error()))
 			return
 		}
+		if err := h.clusterBindingService.Revoke(binding, v1Cluster.RevokeReasonRemoved, common.DBOptions{DB: tx}); err != nil {
+			_ = tx.Rollback()
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", fmt.Sprintf("revoke certificate failed: %s", err.Error()))
+			return
+		}
 		k := kubernetes.NewKubernetes(c)
 		if err := k.CleanManagedClusterRoleBinding(memberName); err != nil {
 			server.Logger().Errorf("can not delete cluster member %s : %s", memberName, err)
@@ -352,5 +379,6 @@ func (h *Handler) DeleteClusterMember() iris.Handler {
 			server.Logger().Errorf("can not delete cluster member %s : %s", memberName, err)
 		}
 		_ = tx.Commit()
+		kubernetes.DefaultCache.InvalidateUser(name, memberName)
 	}
 }
@@ -0,0 +1,144 @@
+package cluster
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ClusterOperator/kubepi/internal/api/v1/session"
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
+	"github.com/ClusterOperator/kubepi/internal/server"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/common"
+	"github.com/ClusterOperator/kubepi/pkg/kubernetes"
+	"github.com/google/uuid"
+)
+
+var (
+	// serviceAccountTokenExpiration 是请求的 token 有效期，集群可能按 --service-account-max-token-expiration 缩短
+	serviceAccountTokenExpiration = 7 * 24 * time.Hour
+	// tokenRefreshInterval 是后台检查 token 有效期的间隔
+	tokenRefreshInterval = 10 * time.Minute
+)
+
+// bootstrapServiceAccount 使用 c 中 bootstrapMode 对应的引导凭据创建 ServiceAccount 并签发 token，
+// 成功后 c 改为 serviceAccount 模式并清除引导凭据，created 表示 ServiceAccount 是否由本次调用创建
+func bootstrapServiceAccount(c *v1Cluster.Cluster, bootstrapMode string) (created bool, err error) {
+	switch bootstrapMode {
+	case "bearer", "certificate", "configFile":
+	default:
+		return false, fmt.Errorf("unsupported bootstrap mode %s", bootstrapMode)
+	}
+	// ServiceAccount 的名称包含集群的 UUID，新建或导入的集群此时还没有保存，需要提前生成
+	if c.UUID == "" {
+		c.UUID = uuid.New().String()
+	}
+	bootstrap := *c
+	bootstrap.Spec.Authentication.Mode = bootstrapMode
+	k := kubernetes.NewKubernetes(&bootstrap)
+	if err := k.Ping(); err != nil {
+		return false, err
+	}
+	if bootstrapMode == "configFile" {
+		kubeCfg, err := k.Config()
+		if err != nil {
+			return false, err
+		}
+		c.Spec.Connect.Forward.ApiServer = kubeCfg.Host
+		if len(kubeCfg.CAData) > 0 {
+			c.CaCertificate.CertData = kubeCfg.CAData
+		}
+	}
+	created, err = k.CreateServiceAccount()
+	if err != nil {
+		return false, fmt.Errorf("create service account failed: %s", err.Error())
+	}
+	token, expireAt, err := k.RequestServiceAccountToken(serviceAccountTokenExpiration)
+	if err != nil {
+		if created {
+			_ = k.DeleteServiceAccount()
+		}
+		return false, fmt.Errorf("request service account token failed: %s", err.Error())
+	}
+	c.Spec.Authentication = v1Cluster.Authentication{
+		Mode:           v1Cluster.AuthModeServiceAccount,
+		BearerToken:    token,
+		ServiceAccount: v1Cluster.ServiceAccountToken{IssuedAt: time.Now(), ExpireAt: expireAt},
+	}
+	return created, nil
+}
+
+// createServiceAccountCluster 创建 serviceAccount 模式的集群，创建失败时删除本次创建的 ServiceAccount
+func (h *Handler) createServiceAccountCluster(c *v1Cluster.Cluster, bootstrapMode string, profile session.UserProfile) error {
+	created, err := bootstrapServiceAccount(c, bootstrapMode)
+	if err != nil {
+		return err
+	}
+	if err := h.createForwardCluster(c, profile); err != nil {
+		if created {
+			_ = kubernetes.NewKubernetes(c).DeleteServiceAccount()
+		}
+		return err
+	}
+	return nil
+}
+
+// needsTokenRefresh 判断 token 是否需要刷新，剩余有效期不足总有效期的三分之一时刷新
+func needsTokenRefresh(t v1Cluster.ServiceAccountToken, now time.Time) bool {
+	if t.ExpireAt.IsZero() {
+		return true
+	}
+	return t.ExpireAt.Sub(now) < t.ExpireAt.Sub(t.IssuedAt)/3
+}
+
+// startTokenRefresh 定期刷新 serviceAccount 模式集群的 token，启动时立即检查一次，
+// 避免 KubePi 停止期间 token 过期。多实例部署时每个周期只由一个实例刷新
+func (h *Handler) startTokenRefresh() {
+	go func() {
+		ticker := time.NewTicker(tokenRefreshInterval)
+		defer ticker.Stop()
+		for {
+			acquired, err := server.AcquireSchedule("serviceaccount-token-refresh", tokenRefreshInterval)
+			if err != nil {
+				server.Logger().Errorf("acquire service account token refresh schedule failed: %s", err.Error())
+			} else if acquired {
+				h.refreshTokens()
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+func (h *Handler) refreshTokens() {
+	clusters, err := h.clusterService.List(common.DBOptions{})
+	if err != nil {
+		server.Logger().Errorf("list clusters failed: %s", err.Error())
+		return
+	}
+	now := time.Now()
+	for i := range clusters {
+		c := &clusters[i]
+		if !kubernetes.ServiceAccountMode(c) || !needsTokenRefresh(c.Spec.Authentication.ServiceAccount, now) {
+			continue
+		}
+		if err := h.refreshToken(c); err != nil {
+			server.Logger().Errorf("refresh service account token of cluster %s failed: %s", c.Name, err.Error())
+			continue
+		}
+		server.Logger().Infof("service account token of cluster %s refreshed", c.Name)
+	}
+}
+
+// refreshToken 使用当前的 token 为 ServiceAccount 签发新的 token，只更新集群的认证信息
+func (h *Handler) refreshToken(c *v1Cluster.Cluster) error {
+	token, expireAt, err := kubernetes.NewKubernetes(c).RequestServiceAccountToken(serviceAccountTokenExpiration)
+	if err != nil {
+		return err
+	}
+	authentication := c.Spec.Authentication
+	authentication.BearerToken = token
+	authentication.ServiceAccount = v1Cluster.ServiceAccountToken{IssuedAt: time.Now(), ExpireAt: expireAt}
+	if err := h.clusterService.UpdateAuthentication(c.Name, authentication, common.DBOptions{}); err != nil {
+		return err
+	}
+	kubernetes.DefaultCache.Invalidate(c.Name)
+	return nil
+}
@@ -0,0 +1,25 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
+)
+
+func TestNeedsTokenRefresh(t *testing.T) {
+	issuedAt := time.Now()
+	token := v1Cluster.ServiceAccountToken{IssuedAt: issuedAt, ExpireAt: issuedAt.Add(3 * time.Hour)}
+	if needsTokenRefresh(token, issuedAt.Add(time.Hour)) {
+		t.Error("token with 2/3 lifetime left should not be refreshed")
+	}
+	if !needsTokenRefresh(token, issuedAt.Add(2*time.Hour+time.Minute)) {
+		t.Error("token with less than 1/3 lifetime left should be refreshed")
+	}
+	if !needsTokenRefresh(token, issuedAt.Add(4*time.Hour)) {
+		t.Error("expired token should be refreshed")
+	}
+	if !needsTokenRefresh(v1Cluster.ServiceAccountToken{}, issuedAt) {
+		t.Error("token without expiration should be refreshed")
+	}
+}
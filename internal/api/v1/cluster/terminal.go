@@ -1,24 +1,51 @@
 package cluster
 
 import (
+	"github.com/ClusterOperator/kubepi/internal/api/v1/session"
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
+	"github.com/ClusterOperator/kubepi/internal/server"
 	"github.com/ClusterOperator/kubepi/internal/service/v1/common"
 	"github.com/ClusterOperator/kubepi/pkg/kubernetes"
 	"github.com/ClusterOperator/kubepi/pkg/terminal"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
+	k8sClient "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/remotecommand"
 )
 
 type TerminalResponse struct {
 	ID string `json:"id"`
+	// Instance 是创建会话的实例，多副本部署时连接 sockjs 可以携带 session 参数以转发到该实例
+	Instance string `json:"instance"`
+}
+
+// terminalTicket 是终端会话的创建参数，用于在其他副本中恢复会话
+type terminalTicket struct {
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	Shell     string `json:"shell"`
+	// User 是创建会话的非管理员用户，模拟用户模式下以该用户的身份连接容器
+	User string `json:"user"`
 }
 
 func (h *Handler) TerminalSessionHandler() iris.Handler {
 	return func(ctx *context.Context) {
-		namespace := ctx.URLParam("namespace")
-		podName := ctx.URLParam("podName")
-		containerName := ctx.URLParam("containerName")
-		shell := ctx.URLParam("shell")
+		t := terminalTicket{
+			Cluster:   ctx.Params().GetString("name"),
+			Namespace: ctx.URLParam("namespace"),
+			Pod:       ctx.URLParam("podName"),
+			Container: ctx.URLParam("containerName"),
+			Shell:     ctx.URLParam("shell"),
+		}
+		if profile := ctx.Values().Get("profile").(session.UserProfile); !profile.IsAdministrator {
+			t.User = profile.Name
+		}
+		if t.Shell == "" {
+			t.Shell = "sh"
+		}
 
 		sessionID, err := terminal.GenTerminalSessionId()
 		if err != nil {
@@ -26,36 +53,68 @@ func (h *Handler) TerminalSessionHandler() iris.Handler {
 			ctx.Values().Set("message", err)
 			return
 		}
-		clusterName := ctx.Params().GetString("name")
-		c, err := h.clusterService.Get(clusterName, common.DBOptions{})
-		if err != nil {
+		if err := h.startTerminalSession(sessionID, t); err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err)
 			return
 		}
-		k := kubernetes.NewKubernetes(c)
+		if err := server.SaveTicket(server.TicketKindTerminal, sessionID, t); err != nil {
+			server.Logger().Errorf("save terminal ticket failed: %s", err.Error())
+		}
+		resp := TerminalResponse{ID: sessionID, Instance: server.InstanceID()}
+		ctx.Values().Set("data", resp)
+	}
+}
+
+func (h *Handler) startTerminalSession(sessionID string, t terminalTicket) error {
+	c, err := h.clusterService.Get(t.Cluster, common.DBOptions{})
+	if err != nil {
+		return err
+	}
+	conf, client, err := sessionClient(c, t.User)
+	if err != nil {
+		return err
+	}
+	terminal.TerminalSessions.Set(sessionID, terminal.TerminalSession{
+		Id:       sessionID,
+		Bound:    make(chan error),
+		SizeChan: make(chan remotecommand.TerminalSize),
+	})
+	go func() {
+		terminal.WaitForTerminal(client, conf, t.Namespace, t.Pod, t.Container, sessionID, t.Shell)
+		server.DeleteTicket(server.TicketKindTerminal, sessionID)
+	}()
+	return nil
+}
+
+// restoreTerminalSession 恢复由其他副本创建的终端会话
+func (h *Handler) restoreTerminalSession(sessionID string) bool {
+	var t terminalTicket
+	if err := server.LoadTicketData(server.TicketKindTerminal, sessionID, &t); err != nil {
+		return false
+	}
+	if err := h.startTerminalSession(sessionID, t); err != nil {
+		server.Logger().Errorf("restore terminal session %s failed: %s", sessionID, err.Error())
+		return false
+	}
+	return true
+}
+
+// sessionClient 返回终端和日志会话访问集群的客户端，模拟用户模式下以成员的身份访问，其他情况使用管理员凭据
+func sessionClient(c *v1Cluster.Cluster, user string) (*rest.Config, *k8sClient.Clientset, error) {
+	k := kubernetes.NewKubernetes(c)
+	if !kubernetes.Impersonation(c) || user == "" {
 		conf, err := k.Config()
 		if err != nil {
-			ctx.StatusCode(iris.StatusInternalServerError)
-			ctx.Values().Set("message", err)
-			return
+			return nil, nil, err
 		}
 		client, err := k.Client()
-		if err != nil {
-			ctx.StatusCode(iris.StatusInternalServerError)
-			ctx.Values().Set("message", err)
-			return
-		}
-		if shell == "" {
-			shell = "sh"
-		}
-		terminal.TerminalSessions.Set(sessionID, terminal.TerminalSession{
-			Id:       sessionID,
-			Bound:    make(chan error),
-			SizeChan: make(chan remotecommand.TerminalSize),
-		})
-		go terminal.WaitForTerminal(client, conf, namespace, podName, containerName, sessionID, shell)
-		resp := TerminalResponse{ID: sessionID}
-		ctx.Values().Set("data", resp)
+		return conf, client, err
+	}
+	conf, err := k.ImpersonatedConfig(user)
+	if err != nil {
+		return nil, nil, err
 	}
+	client, err := k8sClient.NewForConfig(conf)
+	return conf, client, err
 }
@@ -0,0 +1,418 @@
+package cluster
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
+	"github.com/ClusterOperator/kubepi/internal/server"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/common"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/user"
+	"github.com/ClusterOperator/kubepi/pkg/kubernetes"
+	"github.com/ClusterOperator/kubepi/pkg/tunnel"
+	"github.com/ClusterOperator/kubepi/pkg/version"
+	"github.com/asdine/storm/v3"
+	"github.com/gorilla/websocket"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+)
+
+const (
+	// reverseApiServer 是 agent 所在集群内 API Server 的地址，连接经由隧道到达 agent 后再转发
+	reverseApiServer   = "https://kubernetes.default.svc"
+	agentClusterHeader = "X-KubePi-Cluster"
+	// forwardTunnelPath 是其他实例经由当前实例的隧道连接集群的地址，路径中的 ws 使连接不被统一的响应格式处理
+	forwardTunnelPath = "/kubepi/api/v1/agent/ws/forward"
+	// tunnelTicketRefreshInterval 是隧道连接期间刷新 ticket 有效期的间隔
+	tunnelTicketRefreshInterval = time.Minute
+)
+
+// tunnelTicket 记录集群的隧道所在的实例，其他实例凭 Secret 经由该实例连接集群
+type tunnelTicket struct {
+	Secret string `json:"secret"`
+}
+
+var agentUpgrader = websocket.Upgrader{
+	ReadBufferSize:  32 * 1024,
+	WriteBufferSize: 32 * 1024,
+	// agent 不是浏览器，不校验 Origin
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// createReverseCluster 保存 reverse 模式的集群，集群此时不可达，不做连通性和权限检查，
+// 返回的一次性注册 token 用于部署 agent
+func (h *Handler) createReverseCluster(ctx *context.Context, req *Cluster, createdBy string) {
+	token, err := tunnel.NewToken()
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.Values().Set("message", err.Error())
+		return
+	}
+	req.Spec.Connect.Forward = v1Cluster.Forward{ApiServer: reverseApiServer}
+	req.Spec.Connect.Reverse = v1Cluster.Reverse{RegistrationToken: token}
+	req.Spec.Authentication = v1Cluster.Authentication{Mode: "bearer"}
+	req.CaCertificate = v1Cluster.Certificate{}
+	req.CreatedBy = createdBy
+	req.Status = v1Cluster.Status{Phase: clusterStatusWaitingAgent}
+	if err := h.clusterService.Create(&req.Cluster, common.DBOptions{}); err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.Values().Set("message", err.Error())
+		return
+	}
+	ctx.Values().Set("data", req)
+}
+
+// Register Agent
+// @Tags clusters
+// @Summary Register Agent
+// @Description 集群内的 agent 使用一次性注册 token 注册，上报访问集群的 token 和 CA，换取 agent token
+// @Accept  json
+// @Produce  json
+// @Param request body AgentRegisterRequest true "request"
+// @Success 200 {object} AgentRegisterResponse
+// @Router /agent/register [post]
+func (h *Handler) RegisterAgent() iris.Handler {
+	return func(ctx *context.Context) {
+		var req AgentRegisterRequest
+		if err := ctx.ReadJSON(&req); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		if req.BearerToken == "" {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "bearer token is required")
+			return
+		}
+		c, err := h.clusterService.Get(req.Cluster, common.DBOptions{})
+		if err != nil || c.Spec.Connect.Direction != v1Cluster.DirectionReverse || c.Spec.Connect.Reverse.RegistrationToken == "" ||
+			subtle.ConstantTimeCompare([]byte(req.RegistrationToken), []byte(c.Spec.Connect.Reverse.RegistrationToken)) != 1 {
+			ctx.StatusCode(iris.StatusUnauthorized)
+			ctx.Values().Set("message", "invalid cluster or registration token")
+			return
+		}
+		agentToken, err := tunnel.NewToken()
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		tx, err := server.DB().Begin(true)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		txOptions := common.DBOptions{DB: tx}
+		reverse := v1Cluster.Reverse{AgentTokenHash: tunnel.HashToken(agentToken)}
+		if err := h.clusterService.UpdateReverse(c.Name, req.BearerToken, []byte(req.CaCertificate), reverse, txOptions); err != nil {
+			_ = tx.Rollback()
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		_ = tx.Commit()
+		server.Logger().Infof("agent of cluster %s registered from %s", c.Name, ctx.RemoteAddr())
+		ctx.Values().Set("data", AgentRegisterResponse{AgentToken: agentToken})
+	}
+}
+
+// ConnectAgent 将 agent 的 websocket 连接注册为集群的隧道，请求在隧道关闭前不会返回
+func (h *Handler) ConnectAgent() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.GetHeader(agentClusterHeader)
+		token := strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+		c, err := h.clusterService.Get(name, common.DBOptions{})
+		if err != nil || c.Spec.Connect.Direction != v1Cluster.DirectionReverse || !tunnel.VerifyToken(token, c.Spec.Connect.Reverse.AgentTokenHash) {
+			ctx.StatusCode(iris.StatusUnauthorized)
+			ctx.Values().Set("message", "invalid cluster or agent token")
+			return
+		}
+		ws, err := agentUpgrader.Upgrade(ctx.ResponseWriter(), ctx.Request(), nil)
+		if err != nil {
+			server.Logger().Errorf("upgrade agent connection of cluster %s failed: %s", name, err.Error())
+			return
+		}
+		done, err := tunnel.Default.Open(name, tunnel.NewConn(ws))
+		if err != nil {
+			_ = ws.Close()
+			server.Logger().Errorf("open tunnel of cluster %s failed: %s", name, err.Error())
+			return
+		}
+		server.Logger().Infof("agent of cluster %s connected from %s", name, ctx.RemoteAddr())
+		keepTunnelTicket(name, done)
+		h.agentConnected(name, ctx.RemoteAddr())
+		<-done
+		server.Logger().Infof("agent of cluster %s disconnected", name)
+		// 新的连接会替换旧的连接，此时集群仍是已连接状态
+		if !tunnel.Default.Connected(name) {
+			h.agentDisconnected(name)
+		}
+	}
+}
+
+// ForwardTunnel 将其他实例的连接经由当前实例的隧道转发到集群，agent 只连接到其中一个实例
+func (h *Handler) ForwardTunnel() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.GetHeader(agentClusterHeader)
+		secret := strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+		var data tunnelTicket
+		t, err := server.LoadTicket(server.TicketKindTunnel, name)
+		if err == nil {
+			err = json.Unmarshal(t.Data, &data)
+		}
+		if err != nil || t.Instance != server.InstanceID() || data.Secret == "" ||
+			subtle.ConstantTimeCompare([]byte(secret), []byte(data.Secret)) != 1 {
+			ctx.StatusCode(iris.StatusUnauthorized)
+			ctx.Values().Set("message", "invalid cluster or tunnel secret")
+			return
+		}
+		upstream, err := tunnel.Default.Dial(name)
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadGateway)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ws, err := agentUpgrader.Upgrade(ctx.ResponseWriter(), ctx.Request(), nil)
+		if err != nil {
+			_ = upstream.Close()
+			server.Logger().Errorf("upgrade tunnel connection of cluster %s failed: %s", name, err.Error())
+			return
+		}
+		tunnel.Pipe(tunnel.NewConn(ws), upstream)
+	}
+}
+
+// dialTunnelOwner 在集群的 agent 连接到其他实例时，经由该实例的隧道连接集群
+func dialTunnelOwner(name string) (net.Conn, error) {
+	t, err := server.LoadTicket(server.TicketKindTunnel, name)
+	if err != nil || t.Instance == server.InstanceID() {
+		return nil, fmt.Errorf("cluster %s: %w", name, tunnel.ErrNotConnected)
+	}
+	var data tunnelTicket
+	if err := json.Unmarshal(t.Data, &data); err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	header.Set(agentClusterHeader, name)
+	header.Set("Authorization", "Bearer "+data.Secret)
+	ws, err := server.DialOwner(t, forwardTunnelPath, header)
+	if err != nil {
+		return nil, fmt.Errorf("cluster %s: %w", name, err)
+	}
+	return tunnel.NewConn(ws), nil
+}
+
+// keepTunnelTicket 记录集群的隧道在当前实例上，隧道关闭前定期刷新 ticket 的有效期。
+// 内存会话存储只支持单个实例，此时不保存 ticket
+func keepTunnelTicket(name string, done <-chan struct{}) {
+	secret, err := tunnel.NewToken()
+	if err != nil {
+		server.Logger().Errorf("create tunnel secret of cluster %s failed: %s", name, err.Error())
+		return
+	}
+	save := func() {
+		if err := server.SaveTicket(server.TicketKindTunnel, name, tunnelTicket{Secret: secret}); err != nil {
+			server.Logger().Errorf("save tunnel ticket of cluster %s failed: %s", name, err.Error())
+		}
+	}
+	save()
+	go func() {
+		ticker := time.NewTicker(tunnelTicketRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				save()
+			case <-done:
+				// agent 可能已经重新连接，只删除本次连接保存的 ticket
+				var data tunnelTicket
+				if server.LoadTicketData(server.TicketKindTunnel, name, &data) == nil && data.Secret == secret {
+					server.DeleteTicket(server.TicketKindTunnel, name)
+				}
+				return
+			}
+		}
+	}()
+}
+
+func (h *Handler) agentConnected(name string, address string) {
+	c, err := h.clusterService.Get(name, common.DBOptions{})
+	if err != nil {
+		server.Logger().Errorf("can not get cluster %s: %s", name, err.Error())
+		return
+	}
+	client := kubernetes.NewKubernetes(c)
+	version := ""
+	if v, err := client.Version(); err == nil {
+		version = v.GitVersion
+	}
+	c, err = h.updateStatus(name, func(s *v1Cluster.Status) {
+		s.Agent.Connected = true
+		s.Agent.Address = address
+		s.Agent.LastConnectAt = time.Now()
+		if version != "" {
+			s.Version = version
+		}
+	})
+	if err != nil {
+		server.Logger().Errorf("can not update cluster status %s", err)
+		return
+	}
+	if c.Status.Phase != clusterStatusWaitingAgent {
+		return
+	}
+	// agent 首次连接后才能初始化集群
+	isAdministrator := false
+	if u, err := user.NewService().GetByNameOrEmail(c.CreatedBy, common.DBOptions{}); err == nil {
+		isAdministrator = u.IsAdmin
+	}
+	go h.initCluster(c, client, c.CreatedBy, isAdministrator)
+}
+
+func (h *Handler) agentDisconnected(name string) {
+	_, err := h.updateStatus(name, func(s *v1Cluster.Status) {
+		s.Agent.Connected = false
+		s.Agent.LastDisconnectAt = time.Now()
+	})
+	// 集群已被删除时不需要更新
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		server.Logger().Errorf("can not update cluster status %s", err)
+	}
+}
+
+// updateStatus 在事务中读取集群最新的状态并修改，只写入状态，避免 agent 连接、健康检查和集群初始化互相覆盖
+func (h *Handler) updateStatus(name string, fn func(s *v1Cluster.Status)) (*v1Cluster.Cluster, error) {
+	tx, err := server.DB().Begin(true)
+	if err != nil {
+		return nil, err
+	}
+	txOptions := common.DBOptions{DB: tx}
+	c, err := h.clusterService.Get(name, txOptions)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	fn(&c.Status)
+	if err := h.clusterService.UpdateStatus(name, c.Status, txOptions); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	return c, tx.Commit()
+}
+
+// Get Agent Manifest
+// @Tags clusters
+// @Summary Get Agent Manifest
+// @Description 获取部署 agent 的 yaml，server 为集群内可访问的 KubePi 地址
+// @Accept  json
+// @Produce  json
+// @Param cluster path string true "集群名称"
+// @Param server query string true "KubePi 地址"
+// @Param image query string false "agent 镜像"
+// @Success 200 {string} string
+// @Security ApiKeyAuth
+// @Router /clusters/{cluster}/agent/manifest [get]
+func (h *Handler) GetAgentManifest() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		c, err := h.clusterService.Get(name, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", fmt.Sprintf("get cluster failed: %s", err.Error()))
+			return
+		}
+		if c.Spec.Connect.Direction != v1Cluster.DirectionReverse {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", fmt.Sprintf("cluster %s is not connected by agent", name))
+			return
+		}
+		serverURL := ctx.URLParam("server")
+		if serverURL == "" {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "server is required")
+			return
+		}
+		image := ctx.URLParamDefault("image", "kubeoperator/kubepi-server:"+version.Version)
+		ctx.Values().Set("data", fmt.Sprintf(agentManifest, name, serverURL, c.Spec.Connect.Reverse.RegistrationToken, image))
+	}
+}
+
+// agentManifest 中 agent 使用 kubepi-agent 的长期 token 供 KubePi 访问集群，并将 agent token 保存在 secret 中
+const agentManifest = `apiVersion: v1
+kind: Namespace
+metadata:
+  name: kubepi-agent
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: kubepi-agent
+  namespace: kubepi-agent
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: kubepi-agent-credentials
+  namespace: kubepi-agent
+  annotations:
+    kubernetes.io/service-account.name: kubepi-agent
+type: kubernetes.io/service-account-token
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: kubepi-agent
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: cluster-admin
+subjects:
+- kind: ServiceAccount
+  name: kubepi-agent
+  namespace: kubepi-agent
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: kubepi-agent
+  namespace: kubepi-agent
+spec:
+  replicas: 1
+  selector:
+    matchLabels:
+      app: kubepi-agent
+  template:
+    metadata:
+      labels:
+        app: kubepi-agent
+    spec:
+      serviceAccountName: kubepi-agent
+      containers:
+      - name: agent
+        image: %[4]s
+        command:
+        - kubepi-agent
+        - --credentials-dir=/var/run/kubepi-agent
+        env:
+        - name: KUBEPI_CLUSTER
+          value: "%[1]s"
+        - name: KUBEPI_SERVER
+          value: "%[2]s"
+        - name: KUBEPI_REGISTRATION_TOKEN
+          value: "%[3]s"
+        volumeMounts:
+        - name: credentials
+          mountPath: /var/run/kubepi-agent
+          readOnly: true
+      volumes:
+      - name: credentials
+        secret:
+          secretName: kubepi-agent-credentials
+`
@@ -12,6 +12,15 @@ const (
 	clusterStatusFailed       = "Failed"
 	clusterStatusCompleted    = "Completed"
 	clusterStatusSaved        = "Saved"
+	// clusterStatusWaitingAgent 表示 reverse 模式的集群在等待 agent 首次连接
+	clusterStatusWaitingAgent = "WaitingAgent"
+
+	// 以下是初始化完成后由健康检查维护的状态
+	clusterStatusReady        = "Ready"
+	clusterStatusUnreachable  = "Unreachable"
+	clusterStatusUnauthorized = "Unauthorized"
+	// clusterStatusDegraded 表示 apiserver 可以访问，但 readyz 检查失败或者有节点未就绪
+	clusterStatusDegraded = "Degraded"
 )
 
 type Cluster struct {
@@ -23,17 +32,24 @@ type Cluster struct {
 	Accessable           bool             `json:"accessable"`
 	MemberCount          int              `json:"memberCount"`
 	ExtraClusterInfo     ExtraClusterInfo `json:"extraClusterInfo"`
+
+	// BootstrapMode 是 serviceAccount 模式下引导凭据的认证方式，引导凭据只用于创建 ServiceAccount
+	BootstrapMode string `json:"bootstrapMode"`
 }
 
 type UpdateCluster struct {
-	Mode              string   `json:"mode"`
-	ApiServer         string   `json:"apiServer"`
-	Token             string   `json:"token"`
-	KeyData           string   `json:"keyData"`
-	CertData          string   `json:"certData"`
-	ConfigFileContent string   `json:"configFileContent"`
-	WithLabel         bool     `json:"withLabel"`
-	Labels            []string `json:"labels"`
+	Mode              string `json:"mode"`
+	ApiServer         string `json:"apiServer"`
+	Token             string `json:"token"`
+	KeyData           string `json:"keyData"`
+	CertData          string `json:"certData"`
+	ConfigFileContent string `json:"configFileContent"`
+	// BootstrapMode 不为空时使用提供的引导凭据重新创建 ServiceAccount 并签发 token
+	BootstrapMode string `json:"bootstrapMode"`
+	// Proxy 为空时保持原有的代理配置
+	Proxy     *v1Cluster.Proxy `json:"proxy"`
+	WithLabel bool             `json:"withLabel"`
+	Labels    []string         `json:"labels"`
 }
 
 type ExtraClusterInfo struct {
@@ -58,6 +74,8 @@ type Member struct {
 	BindingName    string           `json:"bindingName"`
 	CreateAt       time.Time        `json:"createAt"`
 	NamespaceRoles []NamespaceRoles `json:"namespaceRoles"`
+	// Certificate 是成员证书的状态，模拟用户模式的集群中成员没有证书
+	Certificate *CertificateStatus `json:"certificate,omitempty"`
 }
 
 type Privilege struct {
@@ -72,3 +90,14 @@ type CreateRepo struct {
 	Repos   []string
 	Cluster string
 }
+
+type AgentRegisterRequest struct {
+	Cluster           string `json:"cluster"`
+	RegistrationToken string `json:"registrationToken"`
+	BearerToken       string `json:"bearerToken"`
+	CaCertificate     string `json:"caCertificate"`
+}
+
+type AgentRegisterResponse struct {
+	AgentToken string `json:"agentToken"`
+}
@@ -0,0 +1,74 @@
+package commons
+
+import (
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
+	v1Role "github.com/ClusterOperator/kubepi/internal/model/v1/role"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/clustergroup"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/common"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ClusterScope 是角色规则允许以某个 verb 访问的集群范围
+type ClusterScope struct {
+	all       bool
+	selectors []labels.Selector
+}
+
+// AllClusters 返回可以访问所有集群的范围，用于管理员
+func AllClusters() *ClusterScope {
+	return &ClusterScope{all: true}
+}
+
+// NewClusterScope 根据角色中 clusters 资源的规则计算可以访问的集群，没有 clusterSelector 和 clusterGroups 的规则
+// 可以访问所有集群，无效的 selector 和不存在的分组不匹配任何集群
+func NewClusterScope(roles []v1Role.Role, verb string, groupService clustergroup.Service) *ClusterScope {
+	scope := &ClusterScope{}
+	for i := range roles {
+		for _, rule := range roles[i].Rules {
+			if !contains(rule.Resource, "clusters") || !contains(rule.Verbs, verb) {
+				continue
+			}
+			if rule.ClusterSelector == "" && len(rule.ClusterGroups) == 0 {
+				return AllClusters()
+			}
+			if rule.ClusterSelector != "" {
+				if selector, err := labels.Parse(rule.ClusterSelector); err == nil {
+					scope.selectors = append(scope.selectors, selector)
+				}
+			}
+			for _, g := range rule.ClusterGroups {
+				if selector, err := groupService.Selector(g, common.DBOptions{}); err == nil {
+					scope.selectors = append(scope.selectors, selector)
+				}
+			}
+		}
+	}
+	return scope
+}
+
+// All 判断是否可以访问所有集群
+func (s *ClusterScope) All() bool {
+	return s.all
+}
+
+func (s *ClusterScope) Allowed(c *v1Cluster.Cluster) bool {
+	if s.all {
+		return true
+	}
+	set := c.LabelSet()
+	for _, selector := range s.selectors {
+		if selector.Matches(set) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(items []string, item string) bool {
+	for i := range items {
+		if items[i] == item || items[i] == "*" {
+			return true
+		}
+	}
+	return false
+}
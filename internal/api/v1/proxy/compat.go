@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/ClusterOperator/kubepi/pkg/kubernetes"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// versionRewrite 记录请求路径中被替换的 API 版本，请求和响应中的对象在请求的版本和集群提供的版本之间转换
+type versionRewrite struct {
+	resource  string
+	requested schema.GroupVersion
+	served    schema.GroupVersion
+}
+
+func (r versionRewrite) changed() bool {
+	return r.requested != r.served
+}
+
+// parseResourcePath 解析路径中的 group/version 和资源，prefix 是路径中 group/version 的部分，例如 /apis/batch/v1
+func parseResourcePath(path string) (prefix string, gvr schema.GroupVersionResource, ok bool) {
+	ss := strings.Split(strings.Trim(path, "/"), "/")
+	var gv schema.GroupVersion
+	switch {
+	case len(ss) >= 3 && ss[0] == "api":
+		gv, prefix, ss = schema.GroupVersion{Version: ss[1]}, "/"+strings.Join(ss[:2], "/"), ss[2:]
+	case len(ss) >= 4 && ss[0] == "apis":
+		gv, prefix, ss = schema.GroupVersion{Group: ss[1], Version: ss[2]}, "/"+strings.Join(ss[:3], "/"), ss[3:]
+	default:
+		return "", gvr, false
+	}
+	if len(ss) >= 3 && ss[0] == "namespaces" {
+		ss = ss[2:]
+	}
+	return prefix, gv.WithResource(ss[0]), true
+}
+
+// compatibleVersion 将路径中的 API 版本替换为集群实际提供的版本，例如旧集群中的 batch/v1beta1 cronjobs，
+// 无法获取集群的 API 发现信息时不修改路径
+func compatibleVersion(k kubernetes.Interface, path *string) versionRewrite {
+	prefix, gvr, ok := parseResourcePath(*path)
+	if !ok {
+		return versionRewrite{}
+	}
+	r := versionRewrite{resource: gvr.Resource, requested: gvr.GroupVersion(), served: gvr.GroupVersion()}
+	served, err := k.ServedVersion(gvr)
+	if err != nil || served == gvr {
+		return r
+	}
+	r.served = served.GroupVersion()
+	servedPrefix := "/apis/" + r.served.String()
+	if r.served.Group == "" {
+		servedPrefix = "/api/" + r.served.Version
+	}
+	p := strings.TrimPrefix(*path, "/")
+	*path = servedPrefix + strings.TrimPrefix("/"+p, prefix)
+	return r
+}
+
+// convertRequest 将请求体中的对象转换为集群提供的版本，无法解析的请求体原样转发
+func (r versionRewrite) convertRequest(body io.Reader) io.Reader {
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return bytes.NewReader(raw)
+	}
+	return bytes.NewReader(convertJSON(raw, r.resource, r.requested, r.served))
+}
+
+// convertResponse 将集群返回的对象转换为请求的版本
+func (r versionRewrite) convertResponse(raw []byte) []byte {
+	return convertJSON(raw, r.resource, r.served, r.requested)
+}
+
+// convertItems 将多个 namespace 合并后的列表项转换为请求的版本
+func (r versionRewrite) convertItems(items ItemList) {
+	for i := range items {
+		if item, ok := items[i].(map[string]interface{}); ok {
+			kubernetes.ConvertObject(r.resource, r.served, r.requested, item)
+		}
+	}
+}
+
+func convertJSON(raw []byte, resource string, from, to schema.GroupVersion) []byte {
+	var obj map[string]interface{}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return raw
+	}
+	kubernetes.ConvertObject(resource, from, to, obj)
+	converted, err := json.Marshal(obj)
+	if err != nil {
+		return raw
+	}
+	return converted
+}
@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestParseResourcePath(t *testing.T) {
+	cases := []struct {
+		path     string
+		prefix   string
+		resource schema.GroupVersionResource
+		ok       bool
+	}{
+		{"/api/v1/pods", "/api/v1", schema.GroupVersionResource{Version: "v1", Resource: "pods"}, true},
+		{"/api/v1/namespaces/default", "/api/v1", schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}, true},
+		{"/apis/batch/v1/namespaces/default/cronjobs/backup/status", "/apis/batch/v1", schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "cronjobs"}, true},
+		{"/apis/batch/v1", "", schema.GroupVersionResource{}, false},
+		{"/version", "", schema.GroupVersionResource{}, false},
+	}
+	for _, c := range cases {
+		prefix, gvr, ok := parseResourcePath(c.path)
+		if ok != c.ok || prefix != c.prefix || gvr != c.resource {
+			t.Errorf("%s: unexpected %s %v %v", c.path, prefix, gvr, ok)
+		}
+	}
+}
+
+func TestVersionRewrite(t *testing.T) {
+	r := versionRewrite{
+		resource:  "cronjobs",
+		requested: schema.GroupVersion{Group: "batch", Version: "v1"},
+		served:    schema.GroupVersion{Group: "batch", Version: "v1beta1"},
+	}
+	raw := r.convertResponse([]byte(`{"apiVersion":"batch/v1beta1","kind":"CronJobList","items":[{"metadata":{"name":"backup"}}]}`))
+	var obj map[string]interface{}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		t.Fatal(err)
+	}
+	if obj["apiVersion"] != "batch/v1" {
+		t.Fatalf("unexpected response %s", raw)
+	}
+	if raw := r.convertResponse([]byte("not json")); string(raw) != "not json" {
+		t.Fatalf("unexpected response %s", raw)
+	}
+}
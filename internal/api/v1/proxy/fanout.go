@@ -0,0 +1,230 @@
+package proxy
+
+import (
+	goContext "context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	pkgV1 "github.com/ClusterOperator/kubepi/pkg/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	// fanOutConcurrency 是同时请求的 namespace 数量
+	fanOutConcurrency = 10
+	// namespaceTimeout 是单个 namespace 请求的超时时间
+	namespaceTimeout = 15 * time.Second
+)
+
+var errInvalidContinue = errors.New("invalid continue token")
+
+// namespaceWarning 记录请求失败的 namespace，其余 namespace 的结果照常返回
+type namespaceWarning struct {
+	Namespace string `json:"namespace"`
+	Message   string `json:"message"`
+}
+
+// multiNamespacePage 是多 namespace 查询的结果，continue 不为空时还有后续数据
+type multiNamespacePage struct {
+	*pkgV1.Page
+	Warnings []namespaceWarning `json:"warnings,omitempty"`
+	Continue string             `json:"continue,omitempty"`
+}
+
+// namespaceContinue 记录分页读取到的 namespace 和该 namespace 在集群中的 continue
+type namespaceContinue struct {
+	Namespace string `json:"namespace"`
+	Continue  string `json:"continue,omitempty"`
+}
+
+func encodeContinue(c namespaceContinue) string {
+	bs, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(bs)
+}
+
+func decodeContinue(token string) (namespaceContinue, error) {
+	var c namespaceContinue
+	bs, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return c, errInvalidContinue
+	}
+	if err := json.Unmarshal(bs, &c); err != nil || c.Namespace == "" {
+		return c, errInvalidContinue
+	}
+	return c, nil
+}
+
+type namespaceResult struct {
+	namespace string
+	list      *NamespaceResourceContainer
+	forbidden bool
+	err       error
+}
+
+// fetchNamespace 请求单个 namespace 的列表，continue 和 limit 替换客户端传入的值
+func fetchNamespace(reqCtx goContext.Context, client *http.Client, apiUrl url.URL, ns string, cont string, limit int64) namespaceResult {
+	result := namespaceResult{namespace: ns}
+	newUrl := apiUrl
+	newUrl.Path = addUrlNamespace(apiUrl.Path, ns)
+	query := newUrl.Query()
+	query.Del("continue")
+	query.Del("limit")
+	if cont != "" {
+		query.Set("continue", cont)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.FormatInt(limit, 10))
+	}
+	newUrl.RawQuery = query.Encode()
+
+	ctx, cancel := goContext.WithTimeout(reqCtx, namespaceTimeout)
+	defer cancel()
+	list, code, err := getList(ctx, client, newUrl.String())
+	result.list, result.forbidden, result.err = list, code == http.StatusForbidden, err
+	return result
+}
+
+// getList 请求资源列表，集群返回的状态码不是 200 时响应内容作为错误返回
+func getList(ctx goContext.Context, client *http.Client, apiUrl string) (*NamespaceResourceContainer, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiUrl, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, errors.New(string(body))
+	}
+	var nc NamespaceResourceContainer
+	if err := json.Unmarshal(body, &nc); err != nil {
+		return nil, resp.StatusCode, err
+	}
+	return &nc, resp.StatusCode, nil
+}
+
+// fetchMultiNamespaceResource 合并多个 namespace 的列表结果，客户端指定 limit 时按 namespace 顺序分页读取，
+// 否则由固定数量的 worker 并发读取。没有权限的 namespace 直接跳过，其他失败的 namespace 记录在 Warnings 中
+func fetchMultiNamespaceResource(reqCtx goContext.Context, client *http.Client, namespaces []string, apiUrl url.URL) (*NamespaceResourceContainer, error) {
+	query := apiUrl.Query()
+	limit, _ := strconv.ParseInt(query.Get("limit"), 10, 64)
+	if limit > 0 {
+		return fetchNamespacePages(reqCtx, client, namespaces, apiUrl, limit, query.Get("continue"))
+	}
+
+	results := make([]namespaceResult, len(namespaces))
+	fanOut(len(namespaces), func(i int) {
+		results[i] = fetchNamespace(reqCtx, client, apiUrl, namespaces[i], "", 0)
+	})
+	return mergeNamespaceResults(results, "")
+}
+
+// fanOut 由 fanOutConcurrency 个 worker 对 0 到 n-1 依次执行 fn，所有调用结束后返回
+func fanOut(n int, fn func(i int)) {
+	jobs := make(chan int)
+	workers := fanOutConcurrency
+	if workers > n {
+		workers = n
+	}
+	wg := &sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+func fetchNamespacePages(reqCtx goContext.Context, client *http.Client, namespaces []string, apiUrl url.URL, limit int64, token string) (*NamespaceResourceContainer, error) {
+	sorted := append([]string{}, namespaces...)
+	sort.Strings(sorted)
+	start, cont := 0, ""
+	if token != "" {
+		c, err := decodeContinue(token)
+		if err != nil {
+			return nil, err
+		}
+		// namespace 被删除或者不再有权限时从下一个 namespace 开始
+		start = sort.SearchStrings(sorted, c.Namespace)
+		if start < len(sorted) && sorted[start] == c.Namespace {
+			cont = c.Continue
+		}
+	}
+	var results []namespaceResult
+	var next string
+	remaining := limit
+	for i := start; i < len(sorted); i++ {
+		r := fetchNamespace(reqCtx, client, apiUrl, sorted[i], cont, remaining)
+		cont = ""
+		results = append(results, r)
+		if r.list == nil {
+			continue
+		}
+		remaining -= int64(len(r.list.Items))
+		if r.list.Continue != "" {
+			next = encodeContinue(namespaceContinue{Namespace: sorted[i], Continue: r.list.Continue})
+			break
+		}
+		if remaining <= 0 {
+			if i+1 < len(sorted) {
+				next = encodeContinue(namespaceContinue{Namespace: sorted[i+1]})
+			}
+			break
+		}
+	}
+	return mergeNamespaceResults(results, next)
+}
+
+func mergeNamespaceResults(results []namespaceResult, next string) (*NamespaceResourceContainer, error) {
+	merged := NamespaceResourceContainer{Items: []interface{}{}}
+	merged.ListMeta = metav1.ListMeta{Continue: next}
+	succeeded := 0
+	var forbidden []error
+	for _, r := range results {
+		if r.list != nil {
+			succeeded++
+			merged.TypeMeta = r.list.TypeMeta
+			merged.Items = append(merged.Items, r.list.Items...)
+			merged.Namespaces = append(merged.Namespaces, r.namespace)
+			continue
+		}
+		if r.forbidden {
+			forbidden = append(forbidden, r.err)
+			continue
+		}
+		merged.Warnings = append(merged.Warnings, namespaceWarning{Namespace: r.namespace, Message: r.err.Error()})
+	}
+	if succeeded == 0 {
+		if len(merged.Warnings) > 0 {
+			w := merged.Warnings[0]
+			return nil, fmt.Errorf("namespace %s: %s", w.Namespace, w.Message)
+		}
+		if len(results) == 1 && len(forbidden) == 1 {
+			return nil, forbidden[0]
+		}
+	}
+	return &merged, nil
+}
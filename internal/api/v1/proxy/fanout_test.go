@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	goContext "context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetchMultiNamespaceResource(t *testing.T) {
+	oldConcurrency, oldTimeout := fanOutConcurrency, namespaceTimeout
+	fanOutConcurrency, namespaceTimeout = 2, 200*time.Millisecond
+	defer func() { fanOutConcurrency, namespaceTimeout = oldConcurrency, oldTimeout }()
+
+	var inFlight, maxInFlight atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		// /api/v1/namespaces/<ns>/pods
+		ns := strings.Split(r.URL.Path, "/")[4]
+		switch ns {
+		case "forbidden":
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		case "broken":
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		case "slow":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		// 每个 namespace 有 3 个 pod，支持 limit 和 continue
+		start, _ := strconv.Atoi(r.URL.Query().Get("continue"))
+		end := 3
+		if limit, _ := strconv.Atoi(r.URL.Query().Get("limit")); limit > 0 && start+limit < end {
+			end = start + limit
+		}
+		var items []string
+		for i := start; i < end; i++ {
+			items = append(items, fmt.Sprintf(`{"metadata":{"name":"%s-%d","namespace":"%s"}}`, ns, i, ns))
+		}
+		cont := ""
+		if end < 3 {
+			cont = strconv.Itoa(end)
+		}
+		_, _ = fmt.Fprintf(w, `{"kind":"PodList","apiVersion":"v1","metadata":{"continue":"%s"},"items":[%s]}`, cont, strings.Join(items, ","))
+	}))
+	defer backend.Close()
+
+	apiUrl, _ := url.Parse(backend.URL + "/api/v1/pods")
+	namespaces := []string{"a", "forbidden", "b", "broken", "slow", "c"}
+	resp, err := fetchMultiNamespaceResource(goContext.Background(), backend.Client(), namespaces, *apiUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Items) != 9 || resp.Kind != "PodList" {
+		t.Fatalf("expect 9 pods from a, b and c, got %d", len(resp.Items))
+	}
+	if len(resp.Warnings) != 2 || resp.Warnings[0].Namespace != "broken" || resp.Warnings[1].Namespace != "slow" {
+		t.Fatalf("unexpected warnings %+v", resp.Warnings)
+	}
+	if m := maxInFlight.Load(); m > 2 {
+		t.Fatalf("expect at most 2 concurrent requests, got %d", m)
+	}
+
+	// 按 namespace 顺序分页读取
+	var names []string
+	token := ""
+	for page := 0; page < 10; page++ {
+		u := *apiUrl
+		u.RawQuery = url.Values{"limit": {"2"}, "continue": {token}}.Encode()
+		resp, err := fetchMultiNamespaceResource(goContext.Background(), backend.Client(), []string{"c", "a", "b"}, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Items) > 2 {
+			t.Fatalf("expect at most 2 items, got %d", len(resp.Items))
+		}
+		for _, item := range resp.Items {
+			names = append(names, item.(map[string]interface{})["metadata"].(map[string]interface{})["name"].(string))
+		}
+		if token = resp.Continue; token == "" {
+			break
+		}
+	}
+	if strings.Join(names, ",") != "a-0,a-1,a-2,b-0,b-1,b-2,c-0,c-1,c-2" {
+		t.Fatalf("unexpected pages %v", names)
+	}
+
+	u := *apiUrl
+	u.RawQuery = "limit=2&continue=invalid"
+	if _, err := fetchMultiNamespaceResource(goContext.Background(), backend.Client(), namespaces, u); err != errInvalidContinue {
+		t.Fatalf("expect invalid continue error, got %v", err)
+	}
+	if _, err := fetchMultiNamespaceResource(goContext.Background(), backend.Client(), []string{"forbidden"}, *apiUrl); err == nil {
+		t.Fatal("expect forbidden error for a single namespace")
+	}
+}
@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// listQuery 是列表结果的过滤和排序条件，labelSelector 和 fieldSelector 直接转发给集群，其余条件在 KubePi 中处理:
+//   - keywords: 忽略大小写的正则，匹配名称、namespace 或 message，不是合法的正则时按普通字符串匹配
+//   - filter: JSONPath 过滤条件，例如 status.phase=Running 或 status.phase!=Running，可以指定多个
+//   - sortBy: 排序使用的 JSONPath，例如 metadata.name，sortOrder 为 asc 或 desc，默认 asc
+type listQuery struct {
+	keywords *regexp.Regexp
+	filters  []jsonPathFilter
+	sortBy   *jsonpath.JSONPath
+	desc     bool
+}
+
+type jsonPathFilter struct {
+	path   *jsonpath.JSONPath
+	value  string
+	negate bool
+}
+
+func parseListQuery(query url.Values) (*listQuery, error) {
+	q := &listQuery{}
+	if keywords := query.Get("keywords"); keywords != "" {
+		re, err := regexp.Compile("(?i)" + keywords)
+		if err != nil {
+			re = regexp.MustCompile("(?i)" + regexp.QuoteMeta(keywords))
+		}
+		q.keywords = re
+	}
+	for _, f := range query["filter"] {
+		for _, expr := range strings.Split(f, ",") {
+			if expr == "" {
+				continue
+			}
+			filter, err := parseJSONPathFilter(expr)
+			if err != nil {
+				return nil, err
+			}
+			q.filters = append(q.filters, filter)
+		}
+	}
+	if sortBy := query.Get("sortBy"); sortBy != "" {
+		p, err := parseJSONPath(sortBy)
+		if err != nil {
+			return nil, err
+		}
+		q.sortBy = p
+	}
+	switch order := strings.ToLower(query.Get("sortOrder")); order {
+	case "", "asc":
+	case "desc":
+		q.desc = true
+	default:
+		return nil, fmt.Errorf("invalid sortOrder %s, expect asc or desc", order)
+	}
+	return q, nil
+}
+
+// empty 表示没有 KubePi 处理的条件，列表结果可以原样返回
+func (q *listQuery) empty() bool {
+	return q.keywords == nil && len(q.filters) == 0 && q.sortBy == nil
+}
+
+func parseJSONPathFilter(expr string) (jsonPathFilter, error) {
+	negate := false
+	path, value, ok := strings.Cut(expr, "!=")
+	if ok {
+		negate = true
+	} else if path, value, ok = strings.Cut(expr, "="); !ok {
+		return jsonPathFilter{}, fmt.Errorf("invalid filter %s, expect <jsonpath>=<value> or <jsonpath>!=<value>", expr)
+	}
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return jsonPathFilter{}, err
+	}
+	return jsonPathFilter{path: p, value: strings.TrimPrefix(value, "="), negate: negate}, nil
+}
+
+// parseJSONPath 支持 status.phase、.status.phase 和 {.status.phase} 三种写法
+func parseJSONPath(path string) (*jsonpath.JSONPath, error) {
+	path = strings.TrimSpace(path)
+	if !strings.HasPrefix(path, "{") {
+		path = "{." + strings.TrimPrefix(path, ".") + "}"
+	}
+	p := jsonpath.New("filter").AllowMissingKeys(true)
+	if err := p.Parse(path); err != nil {
+		return nil, fmt.Errorf("invalid jsonpath %s: %s", path, err.Error())
+	}
+	return p, nil
+}
+
+func (f jsonPathFilter) Match(item interface{}) bool {
+	matched := false
+	for _, v := range jsonPathValues(f.path, item) {
+		if v == f.value {
+			matched = true
+			break
+		}
+	}
+	return matched != f.negate
+}
+
+func (n keywordsMatcher) Match(item interface{}) bool {
+	pageItem, ok := item.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	if md, ok := pageItem["metadata"].(map[string]interface{}); ok {
+		if ns, ok := md["namespace"].(string); ok && n.keywords.MatchString(ns) {
+			return true
+		}
+		if name, ok := md["name"].(string); ok && n.keywords.MatchString(name) {
+			return true
+		}
+	}
+	if message, ok := pageItem["message"].(string); ok && n.keywords.MatchString(message) {
+		return true
+	}
+	return false
+}
+
+// apply 依次按关键字和 JSONPath 条件过滤，然后排序，没有指定 sortBy 时使用 defaultSort
+func (q *listQuery) apply(items ItemList, defaultSort func(ItemList)) ItemList {
+	if q.keywords != nil {
+		items = fieldFilter(items, keywordsMatcher{keywords: q.keywords})
+	}
+	for _, f := range q.filters {
+		items = fieldFilter(items, f)
+	}
+	if q.sortBy == nil {
+		if defaultSort != nil {
+			defaultSort(items)
+		}
+		return items
+	}
+	keys := make(map[int]string, len(items))
+	indexes := make([]int, len(items))
+	for i := range items {
+		indexes[i] = i
+		keys[i] = strings.Join(jsonPathValues(q.sortBy, items[i]), ",")
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		c := compareValues(keys[indexes[i]], keys[indexes[j]])
+		if q.desc {
+			return c > 0
+		}
+		return c < 0
+	})
+	sorted := make(ItemList, len(items))
+	for i, idx := range indexes {
+		sorted[i] = items[idx]
+	}
+	return sorted
+}
+
+func jsonPathValues(p *jsonpath.JSONPath, item interface{}) []string {
+	results, err := p.FindResults(item)
+	if err != nil {
+		return nil
+	}
+	var values []string
+	for _, rs := range results {
+		for _, r := range rs {
+			if r.Kind() == reflect.Interface && r.IsNil() {
+				continue
+			}
+			values = append(values, fmt.Sprint(r.Interface()))
+		}
+	}
+	return values
+}
+
+// compareValues 依次尝试按数字、时间和字符串比较
+func compareValues(a, b string) int {
+	if fa, err := strconv.ParseFloat(a, 64); err == nil {
+		if fb, err := strconv.ParseFloat(b, 64); err == nil {
+			switch {
+			case fa < fb:
+				return -1
+			case fa > fb:
+				return 1
+			}
+			return 0
+		}
+	}
+	if ta, err := time.Parse(time.RFC3339, a); err == nil {
+		if tb, err := time.Parse(time.RFC3339, b); err == nil {
+			return ta.Compare(tb)
+		}
+	}
+	return strings.Compare(a, b)
+}
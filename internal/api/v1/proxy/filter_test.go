@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+)
+
+func testItems(t *testing.T) ItemList {
+	var items ItemList
+	err := json.Unmarshal([]byte(`[
+		{"metadata":{"name":"web-1","namespace":"default","creationTimestamp":"2024-01-02T00:00:00Z"},"status":{"phase":"Running","restartCount":10}},
+		{"metadata":{"name":"db-1","namespace":"prod","creationTimestamp":"2024-01-01T00:00:00Z"},"status":{"phase":"Pending","restartCount":2}},
+		{"metadata":{"name":"Web-2","namespace":"prod","creationTimestamp":"2024-01-03T00:00:00Z"},"status":{"phase":"Running","restartCount":1}}
+	]`), &items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return items
+}
+
+func names(items ItemList) []string {
+	var ns []string
+	for _, item := range items {
+		ns = append(ns, item.(map[string]interface{})["metadata"].(map[string]interface{})["name"].(string))
+	}
+	return ns
+}
+
+func TestListQuery(t *testing.T) {
+	cases := []struct {
+		query  string
+		expect []string
+	}{
+		{query: "keywords=WEB", expect: []string{"web-1", "Web-2"}},
+		{query: "keywords=^db-", expect: []string{"db-1"}},
+		{query: "keywords=web-[", expect: nil},
+		{query: "filter=status.phase=Running", expect: []string{"web-1", "Web-2"}},
+		{query: "filter=status.phase!=Running", expect: []string{"db-1"}},
+		{query: "filter=metadata.namespace=prod,status.phase=Running", expect: []string{"Web-2"}},
+		{query: "filter={.status.phase}=Pending", expect: []string{"db-1"}},
+		{query: "sortBy=status.restartCount", expect: []string{"Web-2", "db-1", "web-1"}},
+		{query: "sortBy=.metadata.creationTimestamp&sortOrder=desc", expect: []string{"Web-2", "web-1", "db-1"}},
+		{query: "keywords=web&sortBy=metadata.name&sortOrder=DESC", expect: []string{"web-1", "Web-2"}},
+	}
+	for _, c := range cases {
+		values, _ := url.ParseQuery(c.query)
+		q, err := parseListQuery(values)
+		if err != nil {
+			t.Fatalf("%s: %v", c.query, err)
+		}
+		got := names(q.apply(testItems(t), nil))
+		if len(got) != len(c.expect) {
+			t.Fatalf("%s: expect %v, got %v", c.query, c.expect, got)
+		}
+		for i := range got {
+			if got[i] != c.expect[i] {
+				t.Fatalf("%s: expect %v, got %v", c.query, c.expect, got)
+			}
+		}
+	}
+
+	for _, query := range []string{"filter=status.phase", "sortOrder=up", "sortBy={.status"} {
+		values, _ := url.ParseQuery(query)
+		if _, err := parseListQuery(values); err == nil {
+			t.Fatalf("%s: expect error", query)
+		}
+	}
+
+	q, _ := parseListQuery(url.Values{"labelSelector": {"app=web"}})
+	if !q.empty() {
+		t.Fatal("label selector is handled by the cluster")
+	}
+}
@@ -0,0 +1,197 @@
+package proxy
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ClusterOperator/kubepi/internal/api/v1/session"
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
+	"github.com/ClusterOperator/kubepi/pkg/kubernetes"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+	authV1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// informerBypassParams 是 informer 缓存无法处理的查询参数，带有这些参数的请求直接转发给集群
+var informerBypassParams = []string{"fieldSelector", "limit", "continue", "resourceVersion", "resourceVersionMatch", "watch"}
+
+const (
+	// accessReviewTTL 是用户权限检查结果的缓存时间，成员权限变化后最多经过这个时间生效
+	accessReviewTTL = 30 * time.Second
+	// maxAccessReviews 是权限检查结果缓存的数量，超过后清理过期的结果
+	maxAccessReviews = 10000
+)
+
+type accessReview struct {
+	allowed  bool
+	expireAt time.Time
+}
+
+// accessReviews 缓存用户对资源的 list 权限，informer 使用管理员证书读取数据，返回前需要检查用户的权限
+type accessReviews struct {
+	mu      sync.Mutex
+	entries map[string]accessReview
+}
+
+var userAccess = &accessReviews{entries: map[string]accessReview{}}
+
+// allowed 返回用户在每个 namespace 中是否可以 list 资源，namespace 为空表示整个集群
+func (a *accessReviews) allowed(k kubernetes.Interface, cluster, user string, gvr schema.GroupVersionResource, namespaces []string) map[string]bool {
+	result := make(map[string]bool, len(namespaces))
+	var mu sync.Mutex
+	fanOut(len(namespaces), func(i int) {
+		ok := a.check(k, cluster, user, gvr, namespaces[i])
+		mu.Lock()
+		result[namespaces[i]] = ok
+		mu.Unlock()
+	})
+	return result
+}
+
+func (a *accessReviews) check(k kubernetes.Interface, cluster, user string, gvr schema.GroupVersionResource, namespace string) bool {
+	key := strings.Join([]string{cluster, user, gvr.String(), namespace}, "/")
+	a.mu.Lock()
+	r, ok := a.entries[key]
+	a.mu.Unlock()
+	if ok && time.Now().Before(r.expireAt) {
+		return r.allowed
+	}
+	rs, err := k.UserHasPermission(user, authV1.ResourceAttributes{
+		Verb:      "list",
+		Group:     gvr.Group,
+		Version:   gvr.Version,
+		Resource:  gvr.Resource,
+		Namespace: namespace,
+	})
+	if err != nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.entries) >= maxAccessReviews {
+		now := time.Now()
+		for k, r := range a.entries {
+			if now.After(r.expireAt) {
+				delete(a.entries, k)
+			}
+		}
+	}
+	a.entries[key] = accessReview{allowed: rs.Allowed, expireAt: time.Now().Add(accessReviewTTL)}
+	return rs.Allowed
+}
+
+// parseListPath 解析列表请求的资源和 namespace，例如 /api/v1/namespaces/default/pods 和 /apis/apps/v1/deployments
+func parseListPath(path string) (schema.GroupVersionResource, string, bool) {
+	ss := strings.Split(strings.Trim(path, "/"), "/")
+	var gv schema.GroupVersion
+	switch {
+	case len(ss) >= 3 && ss[0] == "api":
+		gv, ss = schema.GroupVersion{Version: ss[1]}, ss[2:]
+	case len(ss) >= 4 && ss[0] == "apis":
+		gv, ss = schema.GroupVersion{Group: ss[1], Version: ss[2]}, ss[3:]
+	default:
+		return schema.GroupVersionResource{}, "", false
+	}
+	switch {
+	case len(ss) == 1:
+		return gv.WithResource(ss[0]), "", true
+	case len(ss) == 3 && ss[0] == "namespaces":
+		return gv.WithResource(ss[2]), ss[1], true
+	}
+	return schema.GroupVersionResource{}, "", false
+}
+
+func itemNamespace(item interface{}) string {
+	o, ok := item.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	md, ok := o["metadata"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	ns, _ := md["namespace"].(string)
+	return ns
+}
+
+// serveFromInformer 使用 informer 缓存响应列表请求，返回格式与直接请求集群时相同，
+// 缓存不可用或者用户没有权限时返回 false，由调用者请求集群
+func (h *Handler) serveFromInformer(ctx *context.Context, c *v1Cluster.Cluster, k kubernetes.Interface, profile session.UserProfile, apiUrl *url.URL, namespace string, canVisitAll, search bool, query *listQuery) bool {
+	gvr, ns, ok := parseListPath(apiUrl.Path)
+	if !ok {
+		return false
+	}
+	if _, ok := kubernetes.Cacheable(gvr); !ok {
+		return false
+	}
+	params := apiUrl.Query()
+	for _, p := range informerBypassParams {
+		if params.Get(p) != "" {
+			return false
+		}
+	}
+	selector, err := labels.Parse(params.Get("labelSelector"))
+	if err != nil {
+		return false
+	}
+	if ns == "" {
+		ns = namespace
+	}
+
+	multiNamespace := !profile.IsAdministrator && !canVisitAll && ns == ""
+	var allowed map[string]bool
+	if !profile.IsAdministrator {
+		namespaces := []string{ns}
+		if multiNamespace {
+			namespaces, err = k.GetUserNamespaceNames(profile.Name)
+			if err != nil {
+				return false
+			}
+		}
+		allowed = userAccess.allowed(k, c.Name, profile.Name, gvr, namespaces)
+		if !multiNamespace && !allowed[ns] {
+			return false
+		}
+	}
+	list, ok := kubernetes.DefaultInformers.List(c, gvr, ns, selector)
+	if !ok {
+		return false
+	}
+	items := list.Items
+	if multiNamespace {
+		items = make(ItemList, 0, len(list.Items))
+		for _, item := range list.Items {
+			if allowed[itemNamespace(item)] {
+				items = append(items, item)
+			}
+		}
+	}
+	listObj := K8sListObj{
+		Kind:       list.Kind,
+		ApiVersion: list.APIVersion,
+		Metadata:   map[string]interface{}{"resourceVersion": list.ResourceVersion},
+		Items:      items,
+	}
+	ctx.Header("X-KubePi-Cache", "informer")
+	if multiNamespace || search {
+		p, err := pagerAndSearch(ctx, listObj, query)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return true
+		}
+		if multiNamespace {
+			_ = ctx.JSON(multiNamespacePage{Page: p})
+		} else {
+			_ = ctx.JSON(p)
+		}
+		return true
+	}
+	listObj.Items = query.apply(listObj.Items, nil)
+	_ = ctx.JSON(listObj)
+	return true
+}
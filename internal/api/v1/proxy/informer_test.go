@@ -0,0 +1,30 @@
+package proxy
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestParseListPath(t *testing.T) {
+	cases := []struct {
+		path      string
+		gvr       schema.GroupVersionResource
+		namespace string
+		ok        bool
+	}{
+		{path: "/api/v1/pods", gvr: schema.GroupVersionResource{Version: "v1", Resource: "pods"}, ok: true},
+		{path: "/api/v1/namespaces/default/events", gvr: schema.GroupVersionResource{Version: "v1", Resource: "events"}, namespace: "default", ok: true},
+		{path: "/apis/apps/v1/deployments", gvr: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, ok: true},
+		{path: "/apis/apps/v1/namespaces/prod/deployments", gvr: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, namespace: "prod", ok: true},
+		{path: "/api/v1/namespaces/default/pods/web"},
+		{path: "/api/v1/namespaces/default/pods/web/log"},
+		{path: "/version"},
+	}
+	for _, c := range cases {
+		gvr, ns, ok := parseListPath(c.path)
+		if ok != c.ok || gvr != c.gvr || ns != c.namespace {
+			t.Errorf("%s: expect %v %s %v, got %v %s %v", c.path, c.gvr, c.namespace, c.ok, gvr, ns, ok)
+		}
+	}
+}
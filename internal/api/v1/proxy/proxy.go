@@ -2,47 +2,51 @@ package proxy
 
 import (
 	"encoding/json"
-	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"regexp"
 	"sort"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/ClusterOperator/kubepi/internal/api/v1/session"
 	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
 	"github.com/ClusterOperator/kubepi/internal/service/v1/cluster"
 	"github.com/ClusterOperator/kubepi/internal/service/v1/clusterbinding"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/clustergroup"
 	"github.com/ClusterOperator/kubepi/internal/service/v1/common"
 	pkgV1 "github.com/ClusterOperator/kubepi/pkg/api/v1"
 	"github.com/ClusterOperator/kubepi/pkg/kubernetes"
+	"github.com/ClusterOperator/kubepi/pkg/metrics"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/rest"
 )
 
 type Handler struct {
 	clusterService        cluster.Service
 	clusterBindingService clusterbinding.Service
+	clusterGroupService   clustergroup.Service
 }
 
 func NewHandler() *Handler {
 	return &Handler{
 		clusterService:        cluster.NewService(),
 		clusterBindingService: clusterbinding.NewService(),
+		clusterGroupService:   clustergroup.NewService(),
 	}
 }
 
 type NamespaceResourceContainer struct {
 	metav1.TypeMeta `json:",inline"`
 	metav1.ListMeta `json:"metadata,omitempty" protobuf:"bytes,1,opt,name=metadata"`
-	Items           []interface{} `json:"items"`
-	Namespaces      []string      `json:"namespaces"`
+	Items           []interface{}      `json:"items"`
+	Namespaces      []string           `json:"namespaces"`
+	Warnings        []namespaceWarning `json:"warnings,omitempty"`
 }
 
 func (h *Handler) KubernetesAPIProxy() iris.Handler {
@@ -51,11 +55,16 @@ func (h *Handler) KubernetesAPIProxy() iris.Handler {
 		name := ctx.Params().GetString("name")
 		proxyPath := ensureProxyPathValid(ctx.Params().GetString("p"))
 		namespace := ctx.URLParam("namespace")
-		keywords := ctx.URLParam("keywords")
 		search := false
 		if ctx.URLParamExists("search") {
 			search, _ = ctx.URLParamBool("search")
 		}
+		query, err := parseListQuery(ctx.Request().URL.Query())
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
 
 		requestMethod := ctx.Request().Method
 		// 获取当亲集群
@@ -68,23 +77,18 @@ func (h *Handler) KubernetesAPIProxy() iris.Handler {
 		// 获取session
 		u := ctx.Values().Get("profile")
 		profile := u.(session.UserProfile)
-		// 生成transport
-		ts, err := h.generateTLSTransport(c, profile)
-		if err != nil {
-			ctx.StatusCode(iris.StatusInternalServerError)
-			ctx.Values().Set("message", err)
-			return
-		}
-		// 生成httpClient
-		httpClient := http.Client{Transport: ts}
 		k := kubernetes.NewKubernetes(c)
-		clusterVersionMinor, err := k.VersionMinor()
+		// 获取缓存的transport
+		ts, err := h.generateTLSTransport(k, c, profile)
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err)
 			return
 		}
-		compatibleClusterVersion(clusterVersionMinor, &proxyPath)
+		// 生成httpClient
+		httpClient := http.Client{Transport: metrics.InstrumentRoundTripper(c.Name, ts)}
+		// 集群不提供请求的版本时使用集群提供的版本，请求和响应中的对象在两个版本之间转换
+		rewrite := compatibleVersion(k, &proxyPath)
 
 		//判断是否已经包含了namespace的查询
 		hasNsFilter := hasNamespaceFilter(proxyPath)
@@ -121,8 +125,16 @@ func (h *Handler) KubernetesAPIProxy() iris.Handler {
 			ctx.Values().Set("message", err)
 			return
 		}
+		// labelSelector 和 fieldSelector 随查询参数一起转发给集群
 		apiUrl.RawQuery = ctx.Request().URL.RawQuery
-		if http.MethodGet == requestMethod && namespace == "" && namespaced && !canVisitAll {
+		stream := isStreamRequest(ctx.Request(), proxyPath)
+		// 开启了 informer 缓存的集群，列表请求优先使用缓存
+		if http.MethodGet == requestMethod && !stream && c.Spec.InformerCache.Enabled &&
+			h.serveFromInformer(ctx, c, k, profile, apiUrl, namespace, canVisitAll, search, query) {
+			return
+		}
+		// watch 等流式请求无法合并多个 namespace 的结果，由集群根据用户证书鉴权
+		if http.MethodGet == requestMethod && namespace == "" && namespaced && !canVisitAll && !stream {
 			// 调用多namespace 逻辑
 			allowedNamespaces, err := k.GetUserNamespaceNames(profile.Name)
 			if err != nil {
@@ -130,12 +142,20 @@ func (h *Handler) KubernetesAPIProxy() iris.Handler {
 				ctx.Values().Set("message", err)
 				return
 			}
-			resp, err := fetchMultiNamespaceResource(&httpClient, allowedNamespaces, *apiUrl)
+			resp, err := fetchMultiNamespaceResource(ctx.Request().Context(), &httpClient, allowedNamespaces, *apiUrl)
 			if err != nil {
-				ctx.StatusCode(iris.StatusInternalServerError)
-				ctx.Values().Set("message", err)
+				if errors.Is(err, errInvalidContinue) {
+					ctx.StatusCode(iris.StatusBadRequest)
+				} else {
+					ctx.StatusCode(iris.StatusInternalServerError)
+				}
+				ctx.Values().Set("message", err.Error())
 				return
 			}
+			if rewrite.changed() {
+				rewrite.convertItems(resp.Items)
+				resp.APIVersion = rewrite.requested.String()
+			}
 			klo := K8sListObj{
 				Kind:       resp.Kind,
 				ApiVersion: resp.APIVersion,
@@ -143,20 +163,30 @@ func (h *Handler) KubernetesAPIProxy() iris.Handler {
 				Items:      resp.Items,
 			}
 
-			p, err := pagerAndSearch(ctx, klo, keywords)
+			p, err := pagerAndSearch(ctx, klo, query)
 			if err != nil {
 				ctx.StatusCode(iris.StatusInternalServerError)
 				ctx.Values().Set("message", err)
 				return
 			}
-			_ = ctx.JSON(p)
+			_ = ctx.JSON(multiNamespacePage{Page: p, Warnings: resp.Warnings, Continue: resp.Continue})
 			return
 		}
 		if http.MethodGet == requestMethod && namespaced && namespace != "" && !hasNsFilter {
 			apiUrl.Path = addUrlNamespace(apiUrl.Path, namespace)
 		}
+		// watch 等流式请求中的对象不做版本转换
+		if stream {
+			streamProxy(ctx, httpClient.Transport, apiUrl)
+			return
+		}
 
-		req, err := http.NewRequest(ctx.Request().Method, apiUrl.String(), ctx.Request().Body)
+		var body io.Reader = ctx.Request().Body
+		if rewrite.changed() && requestMethod != http.MethodGet && requestMethod != http.MethodDelete {
+			body = rewrite.convertRequest(body)
+		}
+		// 客户端断开时取消上游请求
+		req, err := http.NewRequestWithContext(ctx.Request().Context(), ctx.Request().Method, apiUrl.String(), body)
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err)
@@ -175,6 +205,9 @@ func (h *Handler) KubernetesAPIProxy() iris.Handler {
 		if resp.StatusCode == http.StatusForbidden {
 			resp.StatusCode = http.StatusInternalServerError
 		}
+		if rewrite.changed() && resp.StatusCode < http.StatusBadRequest {
+			rawResp = rewrite.convertResponse(rawResp)
+		}
 		if req.Method == http.MethodGet && search {
 			var listObj K8sListObj
 			if err := json.Unmarshal(rawResp, &listObj); err != nil {
@@ -182,7 +215,7 @@ func (h *Handler) KubernetesAPIProxy() iris.Handler {
 				ctx.Values().Set("message", err)
 				return
 			}
-			p, err := pagerAndSearch(ctx, listObj, keywords)
+			p, err := pagerAndSearch(ctx, listObj, query)
 			if err != nil {
 				ctx.StatusCode(iris.StatusInternalServerError)
 				ctx.Values().Set("message", err.Error())
@@ -191,6 +224,15 @@ func (h *Handler) KubernetesAPIProxy() iris.Handler {
 			_ = ctx.JSON(p)
 			return
 		}
+		// 不分页时同样可以过滤和排序，返回的列表格式与集群一致
+		if req.Method == http.MethodGet && !query.empty() && resp.StatusCode == http.StatusOK {
+			var listObj K8sListObj
+			if err := json.Unmarshal(rawResp, &listObj); err == nil && strings.HasSuffix(listObj.Kind, "List") {
+				listObj.Items = query.apply(listObj.Items, nil)
+				_ = ctx.JSON(listObj)
+				return
+			}
+		}
 		ctx.StatusCode(resp.StatusCode)
 		ctx.Values().Set("message", string(rawResp))
 		_, _ = ctx.Write(rawResp)
@@ -199,16 +241,15 @@ func (h *Handler) KubernetesAPIProxy() iris.Handler {
 
 var timeTemplate = "2006-01-02T15:04:05Z"
 
-func pagerAndSearch(ctx *context.Context, listObj K8sListObj, keywords string) (*pkgV1.Page, error) {
+func pagerAndSearch(ctx *context.Context, listObj K8sListObj, query *listQuery) (*pkgV1.Page, error) {
 	num, err1 := ctx.Values().GetInt("pageNum")
 	size, err2 := ctx.Values().GetInt("pageSize")
 	var p pkgV1.Page
-	if listObj.Kind != "NodeList" {
-		listObj.Sort()
-	}
-	if keywords != "" {
-		listObj.Items = fieldFilter(listObj.Items, withNamespaceAndNameMatcher(keywords))
-	}
+	listObj.Items = query.apply(listObj.Items, func(items ItemList) {
+		if listObj.Kind != "NodeList" {
+			sort.Sort(items)
+		}
+	})
 	if err1 == nil && err2 == nil {
 		tt, items, err := pageFilter(num, size, listObj.Items)
 		if err != nil {
@@ -295,17 +336,7 @@ type fieldMatcher interface {
 }
 
 type keywordsMatcher struct {
-	keywords string
-}
-
-func compatibleClusterVersion(minor int, path *string) {
-	p := *path
-	if minor <= 18 {
-		if strings.Contains(p, "networking.k8s.io/v1") && strings.Contains(p, "ingresses") {
-			p = strings.Replace(p, "networking.k8s.io/v1", "networking.k8s.io/v1beta1", -1)
-		}
-	}
-	*path = p
+	keywords *regexp.Regexp
 }
 
 func hasNamespaceFilter(path string) bool {
@@ -318,26 +349,6 @@ func hasNamespaceFilter(path string) bool {
 	return false
 }
 
-func (n keywordsMatcher) Match(item interface{}) bool {
-	pageItem := item.(map[string]interface{})
-	if pageItem["metadata"].(map[string]interface{})["namespace"] != nil && pageItem["metadata"].(map[string]interface{})["namespace"].(string) == n.keywords {
-		return true
-	}
-	if strings.Contains(pageItem["metadata"].(map[string]interface{})["name"].(string), n.keywords) {
-		return true
-	}
-	if pageItem["message"] != nil && strings.Contains(strings.ToLower(pageItem["message"].(string)), strings.ToLower(n.keywords)) {
-		return true
-	}
-	return false
-}
-
-func withNamespaceAndNameMatcher(keywords string) fieldMatcher {
-	return &keywordsMatcher{
-		keywords: keywords,
-	}
-}
-
 func fieldFilter(data []interface{}, fms ...fieldMatcher) []interface{} {
 	var result []interface{}
 	for i := range data {
@@ -362,84 +373,18 @@ func pageFilter(num, size int, data []interface{}) (int, []interface{}, error) {
 	return total, result, nil
 }
 
-func fetchMultiNamespaceResource(client *http.Client, namespaces []string, apiUrl url.URL) (*NamespaceResourceContainer, error) {
-	wg := &sync.WaitGroup{}
-	var mergedContainer NamespaceResourceContainer
-	var responses []*http.Response
-	var es []error
-	for i := range namespaces {
-		wg.Add(1)
-		ns := namespaces[i]
-		go func() {
-			newUrl := apiUrl
-			newUrl.Path = addUrlNamespace(apiUrl.Path, ns)
-			resp, err := client.Get(newUrl.String())
-			if err != nil {
-				es = append(es, err)
-				wg.Done()
-				return
-			}
-			responses = append(responses, resp)
-			wg.Done()
-		}()
-
-	}
-	wg.Wait()
-	var forbidden int
-	var forbiddenMessage []string
-	for i := range responses {
-		r := responses[i]
-		body, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			return nil, err
-		}
-		if r.StatusCode != http.StatusOK {
-			if r.StatusCode == http.StatusForbidden {
-				forbidden++
-				forbiddenMessage = append(forbiddenMessage, string(body))
-				continue
-			} else {
-				return nil, errors.New(string(body))
-			}
-		}
-		var nc NamespaceResourceContainer
-		if err := json.Unmarshal(body, &nc); err != nil {
-			return nil, err
-		}
-		mergedContainer.TypeMeta = nc.TypeMeta
-		mergedContainer.ListMeta = nc.ListMeta
-		mergedContainer.Items = append(mergedContainer.Items, nc.Items...)
-	}
-	if len(namespaces) == 1 && forbidden == 1 {
-		return nil, errors.New(strings.Join(forbiddenMessage, ""))
-	}
-	return &mergedContainer, nil
-}
-
-func (h *Handler) generateTLSTransport(c *v1Cluster.Cluster, profile session.UserProfile) (http.RoundTripper, error) {
+func (h *Handler) generateTLSTransport(k kubernetes.Interface, c *v1Cluster.Cluster, profile session.UserProfile) (http.RoundTripper, error) {
 	if profile.IsAdministrator {
-		c := kubernetes.NewKubernetes(c)
-		adminConfig, err := c.Config()
-		if err != nil {
-			return nil, err
-		}
-		return rest.TransportFor(adminConfig)
-
+		return k.Transport()
 	}
-
 	binding, err := h.clusterBindingService.GetBindingByClusterNameAndUserName(c.Name, profile.Name, common.DBOptions{})
 	if err != nil {
 		return nil, err
 	}
-	kubeConf := &rest.Config{
-		Host: c.Spec.Connect.Forward.ApiServer,
-		TLSClientConfig: rest.TLSClientConfig{
-			Insecure: true,
-			CertData: binding.Certificate,
-			KeyData:  pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: c.PrivateKey}),
-		},
+	if kubernetes.Impersonation(c) {
+		return k.ImpersonatedTransport(profile.Name)
 	}
-	return rest.TransportFor(kubeConf)
+	return k.UserTransport(profile.Name, binding.Certificate)
 }
 
 func ensureProxyPathValid(path string) string {
@@ -467,6 +412,11 @@ func addUrlNamespace(path string, ns string) string {
 
 func Install(parent iris.Party) {
 	handler := NewHandler()
+	metrics.RegisterCache("client", func() metrics.CacheStats {
+		s := kubernetes.DefaultCache.Stats()
+		return metrics.CacheStats{Hits: s.Hits, Misses: s.Misses, Evictions: s.Evictions, Entries: s.Clusters}
+	})
+	parent.Get("/search", handler.SearchResources())
 	sp := parent.Party("/proxy")
 	sp.Any("/:name/k8s/{p:path}", handler.KubernetesAPIProxy())
 }
@@ -0,0 +1,374 @@
+package proxy
+
+import (
+	goContext "context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ClusterOperator/kubepi/internal/api/v1/session"
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/common"
+	pkgV1 "github.com/ClusterOperator/kubepi/pkg/api/v1"
+	"github.com/ClusterOperator/kubepi/pkg/kubernetes"
+	"github.com/ClusterOperator/kubepi/pkg/metrics"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// clusterSearchTimeout 是搜索单个集群的超时时间，超时的集群记录在结果的 errors 中
+var clusterSearchTimeout = 15 * time.Second
+
+// SearchResult 是跨集群搜索结果中的一个资源
+type SearchResult struct {
+	Cluster           string                 `json:"cluster"`
+	Namespace         string                 `json:"namespace"`
+	Name              string                 `json:"name"`
+	Kind              string                 `json:"kind"`
+	APIVersion        string                 `json:"apiVersion"`
+	Status            string                 `json:"status"`
+	Labels            map[string]interface{} `json:"labels"`
+	CreationTimestamp string                 `json:"creationTimestamp"`
+}
+
+// ClusterSearchError 记录搜索失败或超时的集群
+type ClusterSearchError struct {
+	Cluster string `json:"cluster"`
+	Message string `json:"message"`
+}
+
+type SearchResponse struct {
+	Total    int                  `json:"total"`
+	Items    []SearchResult       `json:"items"`
+	Clusters []string             `json:"clusters"`
+	Errors   []ClusterSearchError `json:"errors"`
+}
+
+type searchQuery struct {
+	kind          string
+	name          string
+	namespace     string
+	labelSelector string
+	clusterLabels []string
+	// clusterSelector 和 clusterGroup 按集群标签选择搜索的集群
+	clusterSelector labels.Selector
+	clusterGroup    string
+}
+
+func parseSearchQuery(query url.Values) (*searchQuery, error) {
+	q := &searchQuery{
+		kind:          query.Get("kind"),
+		name:          query.Get("name"),
+		namespace:     query.Get("namespace"),
+		labelSelector: query.Get("labelSelector"),
+		clusterGroup:  query.Get("clusterGroup"),
+	}
+	if q.kind == "" {
+		return nil, errors.New("kind is required")
+	}
+	if q.name == "" && q.labelSelector == "" {
+		return nil, errors.New("name or labelSelector is required")
+	}
+	if _, err := labels.Parse(q.labelSelector); err != nil {
+		return nil, fmt.Errorf("invalid labelSelector: %s", err.Error())
+	}
+	if raw := query.Get("clusterSelector"); raw != "" {
+		selector, err := labels.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid clusterSelector: %s", err.Error())
+		}
+		q.clusterSelector = selector
+	}
+	for _, l := range query["clusterLabels"] {
+		for _, label := range strings.Split(l, ",") {
+			if label = strings.TrimSpace(label); label != "" {
+				q.clusterLabels = append(q.clusterLabels, label)
+			}
+		}
+	}
+	return q, nil
+}
+
+// matchClusterLabels 判断集群是否包含所有指定的 key=value 标签
+func matchClusterLabels(c *v1Cluster.Cluster, clusterLabels []string) bool {
+	set := c.LabelSet()
+	for k, v := range v1Cluster.ParseLabels(clusterLabels) {
+		if value, ok := set[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// clusterSelector 返回 clusterGroup 和 clusterSelector 组合的集群 selector，都为空时返回 nil
+func (h *Handler) clusterSelector(q *searchQuery) (labels.Selector, error) {
+	if q.clusterGroup == "" {
+		return q.clusterSelector, nil
+	}
+	selector, err := h.clusterGroupService.Selector(q.clusterGroup, common.DBOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get cluster group %s failed: %s", q.clusterGroup, err.Error())
+	}
+	if q.clusterSelector != nil {
+		requirements, _ := q.clusterSelector.Requirements()
+		selector = selector.Add(requirements...)
+	}
+	return selector, nil
+}
+
+// SearchResources 在用户可以访问的所有集群中搜索资源，每个集群使用用户自己的凭据，
+// 例如 /search?kind=deployment&name=nginx&clusterSelector=env=prod,region!=cn
+func (h *Handler) SearchResources() iris.Handler {
+	return func(ctx *context.Context) {
+		q, err := parseSearchQuery(ctx.Request().URL.Query())
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		selector, err := h.clusterSelector(q)
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		profile := ctx.Values().Get("profile").(session.UserProfile)
+		clusters, err := h.userClusters(profile)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		resp := SearchResponse{Items: []SearchResult{}, Clusters: []string{}, Errors: []ClusterSearchError{}}
+		var matched []*v1Cluster.Cluster
+		for _, c := range clusters {
+			if selector != nil && !selector.Matches(c.LabelSet()) {
+				continue
+			}
+			if matchClusterLabels(c, q.clusterLabels) {
+				matched = append(matched, c)
+				resp.Clusters = append(resp.Clusters, c.Name)
+			}
+		}
+
+		var mu sync.Mutex
+		fanOut(len(matched), func(i int) {
+			items, errs := h.searchClusterWithTimeout(ctx.Request().Context(), matched[i], profile, q)
+			mu.Lock()
+			defer mu.Unlock()
+			resp.Items = append(resp.Items, items...)
+			resp.Errors = append(resp.Errors, errs...)
+		})
+		sort.Slice(resp.Items, func(i, j int) bool {
+			a, b := resp.Items[i], resp.Items[j]
+			if a.Cluster != b.Cluster {
+				return a.Cluster < b.Cluster
+			}
+			if a.Namespace != b.Namespace {
+				return a.Namespace < b.Namespace
+			}
+			return a.Name < b.Name
+		})
+		sort.Slice(resp.Errors, func(i, j int) bool {
+			return resp.Errors[i].Cluster < resp.Errors[j].Cluster
+		})
+		resp.Total = len(resp.Items)
+		num, err1 := ctx.Values().GetInt(pkgV1.PageNum)
+		size, err2 := ctx.Values().GetInt(pkgV1.PageSize)
+		if err1 == nil && err2 == nil && num > 0 && size > 0 {
+			start, end := (num-1)*size, num*size
+			if start > len(resp.Items) {
+				start = len(resp.Items)
+			}
+			if end > len(resp.Items) {
+				end = len(resp.Items)
+			}
+			resp.Items = resp.Items[start:end]
+		}
+		ctx.Values().Set("data", resp)
+	}
+}
+
+// userClusters 返回用户可以访问的集群，管理员可以访问所有集群，其他用户只能访问作为成员的集群
+func (h *Handler) userClusters(profile session.UserProfile) ([]*v1Cluster.Cluster, error) {
+	var clusters []*v1Cluster.Cluster
+	if profile.IsAdministrator {
+		cs, err := h.clusterService.List(common.DBOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for i := range cs {
+			clusters = append(clusters, &cs[i])
+		}
+		return clusters, nil
+	}
+	bindings, err := h.clusterBindingService.GetBindingsByUserName(profile.Name, common.DBOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range bindings {
+		c, err := h.clusterService.Get(b.ClusterRef, common.DBOptions{})
+		if err != nil {
+			continue
+		}
+		clusters = append(clusters, c)
+	}
+	return clusters, nil
+}
+
+// searchClusterWithTimeout 在 clusterSearchTimeout 内返回集群的搜索结果，超时后放弃等待
+func (h *Handler) searchClusterWithTimeout(reqCtx goContext.Context, c *v1Cluster.Cluster, profile session.UserProfile, q *searchQuery) ([]SearchResult, []ClusterSearchError) {
+	ctx, cancel := goContext.WithTimeout(reqCtx, clusterSearchTimeout)
+	defer cancel()
+	type result struct {
+		items []SearchResult
+		errs  []ClusterSearchError
+	}
+	ch := make(chan result, 1)
+	go func() {
+		items, errs := h.searchCluster(ctx, c, profile, q)
+		ch <- result{items: items, errs: errs}
+	}()
+	select {
+	case r := <-ch:
+		return r.items, r.errs
+	case <-ctx.Done():
+		return nil, []ClusterSearchError{{Cluster: c.Name, Message: fmt.Sprintf("search timeout after %s", clusterSearchTimeout)}}
+	}
+}
+
+func (h *Handler) searchCluster(ctx goContext.Context, c *v1Cluster.Cluster, profile session.UserProfile, q *searchQuery) ([]SearchResult, []ClusterSearchError) {
+	fail := func(err error) ([]SearchResult, []ClusterSearchError) {
+		return nil, []ClusterSearchError{{Cluster: c.Name, Message: err.Error()}}
+	}
+	k := kubernetes.NewKubernetes(c)
+	ts, err := h.generateTLSTransport(k, c, profile)
+	if err != nil {
+		return fail(err)
+	}
+	client := &http.Client{Transport: metrics.InstrumentRoundTripper(c.Name, ts)}
+	gvr, namespaced, err := k.ResolveResource(q.kind)
+	if err != nil {
+		return fail(err)
+	}
+	apiUrl, err := url.Parse(c.Spec.Connect.Forward.ApiServer + resourcePath(gvr))
+	if err != nil {
+		return fail(err)
+	}
+	params := url.Values{}
+	if q.labelSelector != "" {
+		params.Set("labelSelector", q.labelSelector)
+	}
+	if q.name != "" {
+		params.Set("fieldSelector", fields.OneTermEqualSelector("metadata.name", q.name).String())
+	}
+	apiUrl.RawQuery = params.Encode()
+
+	var list *NamespaceResourceContainer
+	var errs []ClusterSearchError
+	switch {
+	case namespaced && q.namespace != "":
+		apiUrl.Path = addUrlNamespace(apiUrl.Path, q.namespace)
+		list, _, err = getList(ctx, client, apiUrl.String())
+	case namespaced && !profile.IsAdministrator:
+		// 与代理相同，只能访问部分 namespace 的用户分别查询每个 namespace
+		var canVisitAll bool
+		if canVisitAll, err = k.CanVisitAllNamespace(profile.Name); err != nil {
+			return fail(err)
+		}
+		if canVisitAll {
+			list, _, err = getList(ctx, client, apiUrl.String())
+			break
+		}
+		var namespaces []string
+		if namespaces, err = k.GetUserNamespaceNames(profile.Name); err != nil {
+			return fail(err)
+		}
+		list, err = fetchMultiNamespaceResource(ctx, client, namespaces, *apiUrl)
+		if list != nil {
+			for _, w := range list.Warnings {
+				errs = append(errs, ClusterSearchError{Cluster: c.Name, Message: fmt.Sprintf("namespace %s: %s", w.Namespace, w.Message)})
+			}
+		}
+	default:
+		list, _, err = getList(ctx, client, apiUrl.String())
+	}
+	if err != nil {
+		return fail(err)
+	}
+	kind := strings.TrimSuffix(list.Kind, "List")
+	apiVersion := list.APIVersion
+	if apiVersion == "" {
+		apiVersion = gvr.GroupVersion().String()
+	}
+	items := make([]SearchResult, 0, len(list.Items))
+	for _, item := range list.Items {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		md, _ := obj["metadata"].(map[string]interface{})
+		r := SearchResult{Cluster: c.Name, Kind: kind, APIVersion: apiVersion, Status: resourceStatus(obj)}
+		r.Name, _ = md["name"].(string)
+		r.Namespace, _ = md["namespace"].(string)
+		r.Labels, _ = md["labels"].(map[string]interface{})
+		r.CreationTimestamp, _ = md["creationTimestamp"].(string)
+		items = append(items, r)
+	}
+	return items, errs
+}
+
+func resourcePath(gvr schema.GroupVersionResource) string {
+	if gvr.Group == "" {
+		return fmt.Sprintf("/api/%s/%s", gvr.Version, gvr.Resource)
+	}
+	return fmt.Sprintf("/apis/%s/%s/%s", gvr.Group, gvr.Version, gvr.Resource)
+}
+
+// resourceStatus 返回资源的状态摘要: phase、就绪副本数或者 Ready 等 condition
+func resourceStatus(obj map[string]interface{}) string {
+	status, _ := obj["status"].(map[string]interface{})
+	spec, _ := obj["spec"].(map[string]interface{})
+	if status == nil {
+		return ""
+	}
+	if phase, ok := status["phase"].(string); ok {
+		return phase
+	}
+	if replicas, ok := spec["replicas"]; ok {
+		return fmt.Sprintf("%d/%d", count(status["readyReplicas"]), count(replicas))
+	}
+	if desired, ok := status["desiredNumberScheduled"]; ok {
+		return fmt.Sprintf("%d/%d", count(status["numberReady"]), count(desired))
+	}
+	conditions, _ := status["conditions"].([]interface{})
+	for _, t := range []string{"Ready", "Available", "Complete", "Failed"} {
+		for _, c := range conditions {
+			cond, _ := c.(map[string]interface{})
+			if cond["type"] == t {
+				return fmt.Sprintf("%s=%v", t, cond["status"])
+			}
+		}
+	}
+	return ""
+}
+
+func count(v interface{}) int64 {
+	switch n := v.(type) {
+	case float64:
+		return int64(n)
+	case int64:
+		return n
+	case int:
+		return int64(n)
+	}
+	return 0
+}
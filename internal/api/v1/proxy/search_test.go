@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	values, _ := url.ParseQuery("kind=deploy&name=nginx&clusterLabels=env=prod,region=cn&clusterLabels=team=a")
+	q, err := parseSearchQuery(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.kind != "deploy" || q.name != "nginx" || len(q.clusterLabels) != 3 {
+		t.Fatalf("unexpected query %+v", q)
+	}
+	values, _ = url.ParseQuery("kind=deploy&name=nginx&clusterSelector=env%3Dprod,region!%3Dcn&clusterGroup=prod")
+	q, err = parseSearchQuery(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.clusterGroup != "prod" || !q.clusterSelector.Matches(labels.Set{"env": "prod", "region": "us"}) || q.clusterSelector.Matches(labels.Set{"env": "prod", "region": "cn"}) {
+		t.Fatalf("unexpected query %+v", q)
+	}
+	for _, query := range []string{"name=nginx", "kind=pod", "kind=pod&labelSelector=app+in+(", "kind=pod&labelSelector=app%3D%3D%3D", "kind=pod&name=nginx&clusterSelector=env+in+("} {
+		values, _ := url.ParseQuery(query)
+		if _, err := parseSearchQuery(values); err == nil {
+			t.Fatalf("%s: expect error", query)
+		}
+	}
+
+	c := &v1Cluster.Cluster{Labels: []string{"env=prod", "region=cn"}}
+	if !matchClusterLabels(c, []string{"env=prod"}) || !matchClusterLabels(c, nil) {
+		t.Fatal("expect cluster matched")
+	}
+	if matchClusterLabels(c, []string{"env=prod", "team=a"}) || matchClusterLabels(c, []string{"env"}) {
+		t.Fatal("expect cluster not matched")
+	}
+}
+
+func TestResourceStatus(t *testing.T) {
+	cases := map[string]string{
+		`{"status":{"phase":"Running"}}`:                                                                         "Running",
+		`{"spec":{"replicas":3},"status":{"readyReplicas":2}}`:                                                   "2/3",
+		`{"spec":{"replicas":0},"status":{}}`:                                                                    "0/0",
+		`{"status":{"desiredNumberScheduled":5,"numberReady":5}}`:                                                "5/5",
+		`{"status":{"conditions":[{"type":"Progressing","status":"True"},{"type":"Complete","status":"True"}]}}`: "Complete=True",
+		`{"spec":{}}`: "",
+	}
+	for raw, expect := range cases {
+		var obj map[string]interface{}
+		if err := json.Unmarshal([]byte(raw), &obj); err != nil {
+			t.Fatal(err)
+		}
+		if got := resourceStatus(obj); got != expect {
+			t.Errorf("%s: expect %q, got %q", raw, expect, got)
+		}
+	}
+}
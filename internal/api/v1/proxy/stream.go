@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	goContext "context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+)
+
+// streamHeaders 是转发到集群的请求头，客户端的 Cookie、Authorization 和 Impersonate-* 等请求头不会被转发
+var streamHeaders = []string{
+	"Accept",
+	"Content-Type",
+	"Connection",
+	"Upgrade",
+	"Sec-Websocket-Key",
+	"Sec-Websocket-Version",
+	"Sec-Websocket-Protocol",
+	"Sec-Websocket-Extensions",
+	"X-Stream-Protocol-Version",
+}
+
+// isStreamRequest 判断请求的响应是否需要边读边写: watch、follow 日志以及 exec、attach、port-forward 的协议升级
+func isStreamRequest(r *http.Request, path string) bool {
+	if r.Header.Get("Upgrade") != "" {
+		return true
+	}
+	query := r.URL.Query()
+	if isTrue(query.Get("watch")) || strings.Contains(path, "/watch/") {
+		return true
+	}
+	return strings.HasSuffix(path, "/log") && isTrue(query.Get("follow"))
+}
+
+func isTrue(v string) bool {
+	return v == "true" || v == "1"
+}
+
+type upstreamError struct {
+	code int
+	body []byte
+}
+
+func (e *upstreamError) Error() string {
+	return string(e.body)
+}
+
+// streamProxy 将请求转发到 apiUrl，收到的数据立即写回客户端，协议升级后双向转发，
+// 客户端断开时上游请求随请求的 context 一起取消
+func streamProxy(ctx *context.Context, transport http.RoundTripper, apiUrl *url.URL) {
+	p := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			u := *apiUrl
+			pr.Out.URL = &u
+			pr.Out.Host = ""
+			header := http.Header{}
+			for _, k := range streamHeaders {
+				if vs := pr.Out.Header.Values(k); len(vs) > 0 {
+					header[http.CanonicalHeaderKey(k)] = vs
+				}
+			}
+			pr.Out.Header = header
+		},
+		Transport:     transport,
+		FlushInterval: -1,
+		ModifyResponse: func(resp *http.Response) error {
+			if resp.StatusCode < http.StatusBadRequest {
+				return nil
+			}
+			body, _ := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			return &upstreamError{code: resp.StatusCode, body: body}
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			var ue *upstreamError
+			if errors.As(err, &ue) {
+				code := ue.code
+				if code == http.StatusForbidden {
+					code = http.StatusInternalServerError
+				}
+				ctx.StatusCode(code)
+				ctx.Values().Set("message", string(ue.body))
+				_, _ = ctx.Write(ue.body)
+				return
+			}
+			if errors.Is(r.Context().Err(), goContext.Canceled) {
+				return
+			}
+			ctx.StatusCode(iris.StatusBadGateway)
+			ctx.Values().Set("message", err.Error())
+		},
+	}
+	p.ServeHTTP(ctx.ResponseWriter(), ctx.Request())
+}
@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+)
+
+func TestIsStreamRequest(t *testing.T) {
+	cases := []struct {
+		url     string
+		upgrade string
+		stream  bool
+	}{
+		{url: "/api/v1/pods?watch=true", stream: true},
+		{url: "/api/v1/pods?watch=1", stream: true},
+		{url: "/api/v1/watch/pods", stream: true},
+		{url: "/api/v1/namespaces/default/pods/p/log?follow=true", stream: true},
+		{url: "/api/v1/namespaces/default/pods/p/exec?command=sh", upgrade: "websocket", stream: true},
+		{url: "/api/v1/pods?watch=false"},
+		{url: "/api/v1/namespaces/default/pods/p/log"},
+		{url: "/api/v1/pods?limit=500"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, c.url, nil)
+		if c.upgrade != "" {
+			r.Header.Set("Upgrade", c.upgrade)
+		}
+		if got := isStreamRequest(r, r.URL.Path); got != c.stream {
+			t.Errorf("%s: expect stream %v, got %v", c.url, c.stream, got)
+		}
+	}
+}
+
+func TestStreamProxy(t *testing.T) {
+	canceled := make(chan struct{})
+	upgrader := websocket.Upgrader{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != "" {
+			http.Error(w, "client credentials should not be forwarded", http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v1/pods":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"type":"ADDED"}` + "\n"))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			close(canceled)
+		case "/api/v1/namespaces/default/pods/p/exec":
+			ws, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer ws.Close()
+			for {
+				mt, msg, err := ws.ReadMessage()
+				if err != nil {
+					return
+				}
+				_ = ws.WriteMessage(mt, msg)
+			}
+		case "/api/v1/forbidden":
+			http.Error(w, "forbidden", http.StatusForbidden)
+		}
+	}))
+	defer backend.Close()
+
+	app := iris.New()
+	app.Any("/{p:path}", func(ctx *context.Context) {
+		u, _ := url.Parse(backend.URL + ctx.Request().URL.Path)
+		u.RawQuery = ctx.Request().URL.RawQuery
+		streamProxy(ctx, http.DefaultTransport, u)
+	})
+	if err := app.Build(); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(app)
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/pods?watch=true", nil)
+	req.Header.Set("Authorization", "Bearer kubepi-session")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || !strings.Contains(line, "ADDED") {
+		t.Fatalf("unexpected watch response %d %q", resp.StatusCode, line)
+	}
+	_ = resp.Body.Close()
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream watch should be canceled after the client disconnected")
+	}
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/api/v1/namespaces/default/pods/p/exec", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	if err := ws.WriteMessage(websocket.BinaryMessage, []byte("ls")); err != nil {
+		t.Fatal(err)
+	}
+	_, msg, err := ws.ReadMessage()
+	if err != nil || string(msg) != "ls" {
+		t.Fatalf("unexpected websocket message %q, %v", msg, err)
+	}
+
+	resp, err = http.Get(srv.URL + "/api/v1/forbidden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expect forbidden mapped to 500, got %d", resp.StatusCode)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/ClusterOperator/kubepi/internal/api/v1/session"
 	v1Role "github.com/ClusterOperator/kubepi/internal/model/v1/role"
 	"github.com/ClusterOperator/kubepi/internal/server"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/clustergroup"
 	"github.com/ClusterOperator/kubepi/internal/service/v1/common"
 	"github.com/ClusterOperator/kubepi/internal/service/v1/role"
 	"github.com/ClusterOperator/kubepi/internal/service/v1/rolebinding"
@@ -14,17 +15,40 @@ import (
 	"github.com/asdine/storm/v3"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 type Handler struct {
-	roleService        role.Service
-	roleBindingService rolebinding.Service
+	roleService         role.Service
+	roleBindingService  rolebinding.Service
+	clusterGroupService clustergroup.Service
+}
+
+// validateRules 检查规则中的集群 selector 是否合法以及引用的集群分组是否存在
+func (h *Handler) validateRules(rules []v1Role.PolicyRule) error {
+	for i := range rules {
+		if rules[i].ClusterSelector != "" {
+			if _, err := labels.Parse(rules[i].ClusterSelector); err != nil {
+				return fmt.Errorf("invalid cluster selector %s: %s", rules[i].ClusterSelector, err.Error())
+			}
+		}
+		for _, g := range rules[i].ClusterGroups {
+			if _, err := h.clusterGroupService.Get(g, common.DBOptions{}); err != nil {
+				if errors.Is(err, storm.ErrNotFound) {
+					return fmt.Errorf("cluster group %s not found", g)
+				}
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 func NewHandler() *Handler {
 	return &Handler{
-		roleService:        role.NewService(),
-		roleBindingService: rolebinding.NewService(),
+		roleService:         role.NewService(),
+		roleBindingService:  rolebinding.NewService(),
+		clusterGroupService: clustergroup.NewService(),
 	}
 }
 
@@ -91,6 +115,11 @@ func (h *Handler) CreateRole() iris.Handler {
 			ctx.Values().Set("message", err.Error())
 			return
 		}
+		if err := h.validateRules(req.Rules); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
 		u := ctx.Values().Get("profile")
 		profile := u.(session.UserProfile)
 		req.CreatedBy = profile.Name
@@ -167,6 +196,11 @@ func (h *Handler) UpdateRole() iris.Handler {
 			ctx.Values().Set("message", err.Error())
 			return
 		}
+		if err := h.validateRules(req.Rules); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
 		if err := h.roleService.Update(roleName, &req, common.DBOptions{}); err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
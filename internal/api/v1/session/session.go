@@ -21,6 +21,7 @@ import (
 	"github.com/ClusterOperator/kubepi/pkg/collectons"
 	"github.com/ClusterOperator/kubepi/pkg/kubernetes"
 	"github.com/ClusterOperator/kubepi/pkg/logging"
+	"github.com/ClusterOperator/kubepi/pkg/metrics"
 	"github.com/ClusterOperator/kubepi/pkg/network/ip"
 	"github.com/ClusterOperator/kubepi/pkg/terminal"
 	"github.com/asdine/storm/v3"
@@ -92,6 +93,10 @@ func (h *Handler) IsLogin() iris.Handler {
 // @Router /sessions [post]
 func (h *Handler) Login() iris.Handler {
 	return func(ctx *context.Context) {
+		loginMethod := v1User.LOCAL
+		defer func() {
+			metrics.ObserveLogin(strings.ToLower(loginMethod), ctx.GetStatusCode() < iris.StatusBadRequest)
+		}()
 		var loginCredential LoginCredential
 		if err := ctx.ReadJSON(&loginCredential); err != nil {
 			ctx.StatusCode(iris.StatusBadRequest)
@@ -111,6 +116,7 @@ func (h *Handler) Login() iris.Handler {
 		}
 
 		if u.Type == v1User.LDAP {
+			loginMethod = v1User.LDAP
 			if !h.ldapService.CheckStatus() {
 				ctx.StatusCode(iris.StatusInternalServerError)
 				ctx.Values().Set("message", "ldap is not enable!")
@@ -1,6 +1,15 @@
 package session
 
-import v1 "k8s.io/api/rbac/v1"
+import (
+	"encoding/gob"
+
+	v1 "k8s.io/api/rbac/v1"
+)
+
+func init() {
+	// 使用 bolt 或 redis 会话存储时，会话中的 profile 通过 gob 序列化
+	gob.Register(UserProfile{})
+}
 
 type LoginCredential struct {
 	Username   string `json:"username"`
@@ -6,6 +6,7 @@ import (
 	"github.com/ClusterOperator/kubepi/internal/server"
 	"github.com/ClusterOperator/kubepi/internal/service/v1/common"
 	"github.com/ClusterOperator/kubepi/internal/service/v1/sso"
+	"github.com/ClusterOperator/kubepi/pkg/metrics"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
 	"strings"
@@ -102,6 +103,9 @@ func (h *Handler) LoginSso() iris.Handler {
 
 func (h *Handler) CallbackSso() iris.Handler {
 	return func(ctx *context.Context) {
+		defer func() {
+			metrics.ObserveLogin("sso", ctx.GetStatusCode() < iris.StatusBadRequest)
+		}()
 		ssos, err := h.ssoService.List(common.DBOptions{})
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
@@ -0,0 +1,95 @@
+package system
+
+import (
+	"os"
+	"path"
+
+	"github.com/ClusterOperator/kubepi/internal/api/v1/session"
+	"github.com/ClusterOperator/kubepi/internal/server"
+	"github.com/ClusterOperator/kubepi/pkg/backup"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+)
+
+func administratorOnly(ctx *context.Context) bool {
+	profile := ctx.Values().Get("profile").(session.UserProfile)
+	if !profile.IsAdministrator {
+		ctx.StatusCode(iris.StatusForbidden)
+		ctx.Values().Set("message", "only administrator can manage system settings")
+		return false
+	}
+	return true
+}
+
+func (h *Handler) CreateBackup() iris.Handler {
+	return func(ctx *context.Context) {
+		if !administratorOnly(ctx) {
+			return
+		}
+		name, err := server.CreateBackup()
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", backup.File{Name: name})
+	}
+}
+
+func (h *Handler) ListBackups() iris.Handler {
+	return func(ctx *context.Context) {
+		if !administratorOnly(ctx) {
+			return
+		}
+		files, err := backup.List(server.BackupDir())
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", files)
+	}
+}
+
+func (h *Handler) DownloadBackup() iris.Handler {
+	return func(ctx *context.Context) {
+		if !administratorOnly(ctx) {
+			return
+		}
+		name := ctx.Params().GetString("name")
+		if !backup.IsValidFileName(name) {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "invalid backup name")
+			return
+		}
+		bs, err := os.ReadFile(path.Join(server.BackupDir(), name))
+		if err != nil {
+			ctx.StatusCode(iris.StatusNotFound)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Header("Content-Type", server.ContentTypeDownload)
+		ctx.Header("Content-Disposition", "attachment;filename="+name)
+		ctx.Header("Content-Transfer-Encoding", "binary")
+		_, _ = ctx.Write(bs)
+	}
+}
+
+func (h *Handler) DeleteBackup() iris.Handler {
+	return func(ctx *context.Context) {
+		if !administratorOnly(ctx) {
+			return
+		}
+		name := ctx.Params().GetString("name")
+		if !backup.IsValidFileName(name) {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "invalid backup name")
+			return
+		}
+		if err := os.Remove(path.Join(server.BackupDir(), name)); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package system
+
+import (
+	"github.com/ClusterOperator/kubepi/pkg/kubernetes"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+)
+
+// GetClientCacheStats 返回集群连接缓存的统计信息
+func (h *Handler) GetClientCacheStats() iris.Handler {
+	return func(ctx *context.Context) {
+		if !administratorOnly(ctx) {
+			return
+		}
+		ctx.Values().Set("data", kubernetes.DefaultCache.Stats())
+	}
+}
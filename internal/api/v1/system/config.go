@@ -0,0 +1,18 @@
+package system
+
+import (
+	"github.com/ClusterOperator/kubepi/internal/config"
+	"github.com/ClusterOperator/kubepi/internal/server"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+)
+
+// GetConfig 返回当前生效的配置(配置文件、环境变量与命令行参数合并后的结果)，敏感信息已被隐藏
+func (h *Handler) GetConfig() iris.Handler {
+	return func(ctx *context.Context) {
+		if !administratorOnly(ctx) {
+			return
+		}
+		ctx.Values().Set("data", config.Redact(server.Config()))
+	}
+}
@@ -73,4 +73,10 @@ func Install(parent iris.Party) {
 	sp := parent.Party("/systems")
 	sp.Post("/login/logs/search", handler.LoginLogsSearch())
 	sp.Post("/operation/logs/search", handler.OperationLogsSearch())
+	sp.Get("/config", handler.GetConfig())
+	sp.Get("/client-cache", handler.GetClientCacheStats())
+	sp.Get("/backups", handler.ListBackups())
+	sp.Post("/backups", handler.CreateBackup())
+	sp.Get("/backups/:name", handler.DownloadBackup())
+	sp.Delete("/backups/:name", handler.DeleteBackup())
 }
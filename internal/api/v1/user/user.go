@@ -7,6 +7,7 @@ import (
 	"github.com/ClusterOperator/kubepi/internal/api/v1/commons"
 	"github.com/ClusterOperator/kubepi/internal/api/v1/session"
 	v1 "github.com/ClusterOperator/kubepi/internal/model/v1"
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
 	v1Role "github.com/ClusterOperator/kubepi/internal/model/v1/role"
 	v1User "github.com/ClusterOperator/kubepi/internal/model/v1/user"
 	"github.com/ClusterOperator/kubepi/internal/server"
@@ -246,6 +247,12 @@ func (h *Handler) DeleteUser() iris.Handler {
 				ctx.Values().Set("message", err.Error())
 				return
 			}
+			if err := h.clusterBindingService.Revoke(&cbs[i], v1Cluster.RevokeReasonRemoved, txOptions); err != nil {
+				_ = tx.Rollback()
+				ctx.StatusCode(iris.StatusInternalServerError)
+				ctx.Values().Set("message", err.Error())
+				return
+			}
 		}
 		if err := h.userService.Delete(userName, txOptions); err != nil {
 			_ = tx.Rollback()
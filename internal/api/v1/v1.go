@@ -8,6 +8,7 @@ import (
 	"github.com/ClusterOperator/kubepi/internal/api/v1/sso"
 	"io/ioutil"
 	"strings"
+	"time"
 
 	"github.com/ClusterOperator/kubepi/internal/api/v1/mfa"
 	"github.com/ClusterOperator/kubepi/internal/server"
@@ -17,6 +18,7 @@ import (
 
 	"github.com/ClusterOperator/kubepi/internal/api/v1/chart"
 	"github.com/ClusterOperator/kubepi/internal/api/v1/cluster"
+	"github.com/ClusterOperator/kubepi/internal/api/v1/commons"
 	"github.com/ClusterOperator/kubepi/internal/api/v1/imagerepo"
 	"github.com/ClusterOperator/kubepi/internal/api/v1/ldap"
 	"github.com/ClusterOperator/kubepi/internal/api/v1/proxy"
@@ -29,6 +31,8 @@ import (
 	v1 "github.com/ClusterOperator/kubepi/internal/model/v1"
 	v1Role "github.com/ClusterOperator/kubepi/internal/model/v1/role"
 	v1System "github.com/ClusterOperator/kubepi/internal/model/v1/system"
+	v1ClusterService "github.com/ClusterOperator/kubepi/internal/service/v1/cluster"
+	v1ClusterGroupService "github.com/ClusterOperator/kubepi/internal/service/v1/clustergroup"
 	"github.com/ClusterOperator/kubepi/internal/service/v1/common"
 	v1RoleService "github.com/ClusterOperator/kubepi/internal/service/v1/role"
 	v1RoleBindingService "github.com/ClusterOperator/kubepi/internal/service/v1/rolebinding"
@@ -36,6 +40,7 @@ import (
 	pkgV1 "github.com/ClusterOperator/kubepi/pkg/api/v1"
 	"github.com/ClusterOperator/kubepi/pkg/collectons"
 	"github.com/ClusterOperator/kubepi/pkg/i18n"
+	"github.com/ClusterOperator/kubepi/pkg/metrics"
 	"github.com/asdine/storm/v3"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
@@ -44,6 +49,9 @@ import (
 
 var resourceWhiteList = WhiteList{"sessions", "proxy", "ws", "charts", "webkubectl", "apps", "mfa", "pod"}
 
+// bindingScopedResources 的访问范围由用户所属的集群和用户在集群中的权限决定，不需要 KubePi 角色授权
+var bindingScopedResources = WhiteList{"search"}
+
 type WhiteList []string
 
 func (w WhiteList) In(name string) bool {
@@ -211,6 +219,14 @@ func logHandler() iris.Handler {
 	}
 }
 
+func metricsHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		start := time.Now()
+		ctx.Next()
+		metrics.ObserveHTTPRequest(ctx.GetCurrentRoute().Path(), ctx.Method(), ctx.GetStatusCode(), start)
+	}
+}
+
 func resourceExtractHandler() iris.Handler {
 	return func(ctx *context.Context) {
 		path := ctx.Request().URL.Path
@@ -303,7 +319,7 @@ func apiResourceHandler(party iris.Party) iris.Handler {
 				if len(ss) >= 5 {
 					resourceName := ss[4]
 					//过滤session资源
-					if resourceWhiteList.In(resourceName) {
+					if resourceWhiteList.In(resourceName) || bindingScopedResources.In(resourceName) {
 						continue
 					}
 					if _, ok := resourceMap[resourceName]; !ok {
@@ -328,7 +344,7 @@ func apiResourceHandler(party iris.Party) iris.Handler {
 	}
 }
 
-func roleAccessHandler() iris.Handler {
+func roleAccessHandler(clusterService v1ClusterService.Service, clusterGroupService v1ClusterGroupService.Service) iris.Handler {
 	return func(ctx *context.Context) {
 		//// 查询角色的 resources
 		//// 通过api resource 过滤出来资源主体,method 过滤操作
@@ -350,7 +366,7 @@ func roleAccessHandler() iris.Handler {
 			rs := ctx.Values().Get("roles")
 			roles := rs.([]v1Role.Role)
 			requestResource := ctx.Values().GetString("resource")
-			if requestResource != "" {
+			if requestResource != "" && !bindingScopedResources.In(requestResource) {
 				currentRoute := ctx.GetCurrentRoute()
 				requestVerb := getVerbByRoute(currentRoute.Path(), currentRoute.Method())
 				resourceMatched, methodMatch := matchRoles(requestResource, requestVerb, roles)
@@ -359,6 +375,15 @@ func roleAccessHandler() iris.Handler {
 					ctx.Values().Set("message", []string{"user %s can not access resource %s %s", u.Name, requestResource, requestVerb})
 					return
 				}
+				// 规则通过 clusterSelector 或 clusterGroups 限制了集群时，只能访问匹配的集群
+				if requestResource == "clusters" && strings.Contains(currentRoute.Path(), "/:name") {
+					c, err := clusterService.Get(ctx.Params().GetString("name"), common.DBOptions{})
+					if err == nil && !commons.NewClusterScope(roles, requestVerb, clusterGroupService).Allowed(c) {
+						ctx.StopWithStatus(iris.StatusForbidden)
+						ctx.Values().Set("message", []string{"user %s can not access resource %s %s", u.Name, requestResource, requestVerb})
+						return
+					}
+				}
 			}
 		}
 
@@ -420,6 +445,7 @@ func WarpedJwtHandler() iris.Handler {
 func AddV1Route(app iris.Party) {
 
 	v1Party := app.Party("/v1")
+	v1Party.Use(metricsHandler())
 
 	session.Install(v1Party)
 	mfa.Install(v1Party)
@@ -432,12 +458,12 @@ func AddV1Route(app iris.Party) {
 	authParty.Use(authHandler())
 	authParty.Use(resourceExtractHandler())
 	authParty.Use(roleHandler())
-	authParty.Use(roleAccessHandler())
+	authParty.Use(roleAccessHandler(v1ClusterService.NewService(), v1ClusterGroupService.NewService()))
 	authParty.Use(resourceNameInvalidHandler())
 	authParty.Use(logHandler())
 	authParty.Get("/", apiResourceHandler(authParty))
 	user.Install(authParty)
-	cluster.Install(authParty)
+	cluster.Install(authParty, v1Party)
 	role.Install(authParty)
 	system.Install(authParty)
 	proxy.Install(authParty)
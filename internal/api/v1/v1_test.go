@@ -0,0 +1,58 @@
+package v1
+
+import (
+	"testing"
+
+	"github.com/ClusterOperator/kubepi/internal/api/v1/session"
+	v1 "github.com/ClusterOperator/kubepi/internal/model/v1"
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
+	v1Role "github.com/ClusterOperator/kubepi/internal/model/v1/role"
+	v1ClusterService "github.com/ClusterOperator/kubepi/internal/service/v1/cluster"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/common"
+	"github.com/asdine/storm/v3"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+	"github.com/kataras/iris/v12/httptest"
+)
+
+type fakeClusterService struct {
+	v1ClusterService.Service
+	clusters map[string][]string
+}
+
+func (f fakeClusterService) Get(name string, _ common.DBOptions) (*v1Cluster.Cluster, error) {
+	ls, ok := f.clusters[name]
+	if !ok {
+		return nil, storm.ErrNotFound
+	}
+	return &v1Cluster.Cluster{Metadata: v1.Metadata{Name: name}, Labels: ls}, nil
+}
+
+func TestRoleAccessHandler(t *testing.T) {
+	clusterService := fakeClusterService{clusters: map[string][]string{
+		"prod": {"env=prod"},
+		"dev":  {"env=dev"},
+	}}
+	roles := []v1Role.Role{{Rules: []v1Role.PolicyRule{
+		{Resource: []string{"clusters"}, Verbs: []string{"get", "update"}, ClusterSelector: "env=dev"},
+	}}}
+	app := iris.New()
+	app.Use(func(ctx *context.Context) {
+		ctx.Values().Set("profile", session.UserProfile{Name: "tom"})
+		ctx.Values().Set("roles", roles)
+		ctx.Values().Set("resource", "clusters")
+		ctx.Next()
+	})
+	app.Use(roleAccessHandler(clusterService, nil))
+	ok := func(ctx *context.Context) { ctx.StatusCode(iris.StatusOK) }
+	app.Get("/clusters/:name", ok)
+	app.Put("/clusters/:name", ok)
+	app.Delete("/clusters/:name", ok)
+
+	e := httptest.New(t, app)
+	e.GET("/clusters/dev").Expect().Status(iris.StatusOK)
+	e.PUT("/clusters/dev").Expect().Status(iris.StatusOK)
+	e.GET("/clusters/prod").Expect().Status(iris.StatusForbidden)
+	e.PUT("/clusters/prod").Expect().Status(iris.StatusForbidden)
+	e.DELETE("/clusters/dev").Expect().Status(iris.StatusForbidden)
+}
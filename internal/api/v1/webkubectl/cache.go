@@ -30,3 +30,9 @@ func (t *TerminalSessions) Delete(key string) {
 	defer t.mutex.Unlock()
 	delete(t.data, key)
 }
+
+func (t *TerminalSessions) Len() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return len(t.data)
+}
@@ -0,0 +1,137 @@
+package webkubectl
+
+import (
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ClusterOperator/kubepi/internal/server"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/common"
+	"github.com/ClusterOperator/kubepi/pkg/kubernetes"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+	"github.com/kataras/iris/v12/middleware/jwt"
+)
+
+// proxyTokenMaxAge 是模拟用户模式下 kubeconfig 中 token 的有效期
+const proxyTokenMaxAge = 12 * time.Hour
+
+var errInvalidProxyToken = errors.New("invalid webkubectl proxy token")
+
+// proxyClaims 是 kubectl 访问代理时携带的 token 内容，代理以 User 的身份访问 Cluster
+type proxyClaims struct {
+	Cluster string `json:"cluster"`
+	User    string `json:"user"`
+}
+
+// proxyTokenKey 从 jwt 密钥派生签名密钥，避免代理 token 被当作登录 token 使用
+func proxyTokenKey() []byte {
+	sum := sha256.Sum256([]byte("webkubectl-proxy:" + server.Config().Spec.Jwt.Key))
+	return sum[:]
+}
+
+func signProxyToken(key []byte, claims proxyClaims) (string, error) {
+	token, err := jwt.NewSigner(jwt.HS256, key, proxyTokenMaxAge).Sign(claims)
+	if err != nil {
+		return "", err
+	}
+	return string(token), nil
+}
+
+func verifyProxyToken(key []byte, token string) (proxyClaims, error) {
+	var claims proxyClaims
+	verified, err := jwt.NewVerifier(jwt.HS256, key).VerifyToken([]byte(token))
+	if err != nil {
+		return claims, err
+	}
+	if err := verified.Claims(&claims); err != nil {
+		return claims, err
+	}
+	if claims.Cluster == "" || claims.User == "" {
+		return claims, errInvalidProxyToken
+	}
+	return claims, nil
+}
+
+// proxyRequestHeaders 返回转发给集群的请求头，去掉客户端携带的凭据和模拟用户的请求头，由 transport 设置模拟的用户
+func proxyRequestHeaders(in http.Header) http.Header {
+	header := in.Clone()
+	for k := range header {
+		if strings.HasPrefix(http.CanonicalHeaderKey(k), "Impersonate-") {
+			header.Del(k)
+		}
+	}
+	header.Del("Authorization")
+	header.Del("Cookie")
+	return header
+}
+
+// ProxyHandler 以 token 中用户的身份将 kubectl 的请求转发到集群，只用于开启了模拟用户模式的集群，
+// kubeconfig 中不包含集群的管理员凭据
+func (h *Handler) ProxyHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		token := strings.TrimSpace(strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer"))
+		claims, err := verifyProxyToken(proxyTokenKey(), token)
+		if err != nil {
+			ctx.StatusCode(iris.StatusUnauthorized)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		c, err := h.clusterService.Get(claims.Cluster, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusForbidden)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		if !kubernetes.Impersonation(c) {
+			ctx.StatusCode(iris.StatusForbidden)
+			ctx.Values().Set("message", "cluster is not in impersonation mode")
+			return
+		}
+		// 成员被移除后 token 立即失效
+		if _, err := h.clusterBindingService.GetBindingByClusterNameAndUserName(claims.Cluster, claims.User, common.DBOptions{}); err != nil {
+			ctx.StatusCode(iris.StatusForbidden)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		k := kubernetes.NewKubernetes(c)
+		cfg, err := k.Config()
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		transport, err := k.ImpersonatedTransport(claims.User)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		apiUrl, err := url.Parse(strings.TrimSuffix(cfg.Host, "/") + "/" + ctx.Params().GetString("p"))
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		apiUrl.RawQuery = ctx.Request().URL.RawQuery
+		p := &httputil.ReverseProxy{
+			Rewrite: func(pr *httputil.ProxyRequest) {
+				u := *apiUrl
+				pr.Out.URL = &u
+				pr.Out.Host = ""
+				pr.Out.Header = proxyRequestHeaders(pr.Out.Header)
+			},
+			Transport:     transport,
+			FlushInterval: -1,
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				ctx.StatusCode(iris.StatusBadGateway)
+				ctx.Values().Set("message", err.Error())
+			},
+		}
+		p.ServeHTTP(ctx.ResponseWriter(), ctx.Request())
+	}
+}
@@ -0,0 +1,44 @@
+package webkubectl
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestProxyToken(t *testing.T) {
+	key := []byte("key")
+	token, err := signProxyToken(key, proxyClaims{Cluster: "c1", User: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := verifyProxyToken(key, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Cluster != "c1" || claims.User != "alice" {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+	if _, err := verifyProxyToken([]byte("other"), token); err == nil {
+		t.Fatal("token signed by other key should be rejected")
+	}
+	empty, _ := signProxyToken(key, proxyClaims{Cluster: "c1"})
+	if _, err := verifyProxyToken(key, empty); err == nil {
+		t.Fatal("token without user should be rejected")
+	}
+}
+
+func TestProxyRequestHeaders(t *testing.T) {
+	in := http.Header{}
+	in.Set("Authorization", "Bearer token")
+	in.Set("Cookie", "a=b")
+	in.Set("Impersonate-User", "admin")
+	in.Set("Impersonate-Extra-Scopes", "x")
+	in.Set("Accept", "application/json")
+	out := proxyRequestHeaders(in)
+	if len(out) != 1 || out.Get("Accept") != "application/json" {
+		t.Fatalf("unexpected headers %v", out)
+	}
+	if in.Get("Authorization") == "" {
+		t.Fatal("original headers should not be modified")
+	}
+}
@@ -3,9 +3,14 @@ package webkubectl
 import "k8s.io/client-go/rest"
 
 type Session struct {
-	User    string
-	config  *rest.Config
-	Cluster string `json:"cluster"`
+	User   string
+	config *rest.Config
+	// proxyURL 是集群配置的代理地址，写入生成的 kubeconfig
+	proxyURL string
+	// proxyToken 不为空时 kubectl 通过 KubePi 的代理以模拟用户的方式访问集群，proxyServer 是代理的地址
+	proxyToken  string
+	proxyServer string
+	Cluster     string `json:"cluster"`
 }
 
 type SessionResponse struct {
@@ -4,15 +4,19 @@ import (
 	"encoding/pem"
 	"fmt"
 	"github.com/ClusterOperator/kubepi/internal/api/v1/session"
+	"github.com/ClusterOperator/kubepi/internal/server"
 	"github.com/ClusterOperator/kubepi/internal/service/v1/cluster"
 	"github.com/ClusterOperator/kubepi/internal/service/v1/clusterbinding"
 	"github.com/ClusterOperator/kubepi/internal/service/v1/common"
 	"github.com/ClusterOperator/kubepi/pkg/kubernetes"
+	"github.com/ClusterOperator/kubepi/pkg/metrics"
 	"github.com/google/uuid"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"strings"
 )
 
 type Handler struct {
@@ -41,16 +45,29 @@ func (h *Handler) GetConfigFile() iris.Handler {
 		sess := h.sessionCache.Get(sessionId)
 		if sess != nil {
 			h.sessionCache.Delete(sessionId)
+			server.DeleteTicket(server.TicketKindWebkubectl, sessionId)
 		} else {
-			ctx.StatusCode(iris.StatusInternalServerError)
-			ctx.Values().Set("message", fmt.Sprintf("can not find sessionId: %s in memory", sessionId))
-			return
+			// 会话由无法访问的副本创建或者当前实例重启过时根据 ticket 重新生成配置
+			s, err := h.restoreSession(sessionId)
+			if err != nil {
+				ctx.StatusCode(iris.StatusInternalServerError)
+				ctx.Values().Set("message", fmt.Sprintf("can not find sessionId: %s in memory", sessionId))
+				return
+			}
+			sess = s
 		}
 
 		ctx.Header("Content-Type", "application/download")
 		ctx.Header("Content-Disposition", "attachment;filename=config")
 		ctx.Header("Content-Transfer-Encoding", "binary")
 
+		if sess.proxyToken != "" {
+			scheme := "http"
+			if ctx.Request().TLS != nil {
+				scheme = "https"
+			}
+			sess.proxyServer = fmt.Sprintf("%s://%s%s", scheme, ctx.Host(), strings.TrimSuffix(ctx.Path(), "/session")+"/proxy")
+		}
 		cc := toCmdConfig(sess)
 		bs, err := clientcmd.Write(*cc)
 		if err != nil {
@@ -67,12 +84,20 @@ func toCmdConfig(sess *Session) *clientcmdapi.Config {
 	cc.Clusters[sess.Cluster] = &clientcmdapi.Cluster{
 		Server:                sess.config.Host,
 		InsecureSkipTLSVerify: true,
+		ProxyURL:              sess.proxyURL,
 	}
 	cc.AuthInfos[sess.User] = &clientcmdapi.AuthInfo{
 		ClientCertificateData: sess.config.CertData,
 		ClientKeyData:         sess.config.KeyData,
 		Token:                 sess.config.BearerToken,
 	}
+	if sess.proxyToken != "" {
+		cc.Clusters[sess.Cluster] = &clientcmdapi.Cluster{
+			Server:                sess.proxyServer,
+			InsecureSkipTLSVerify: true,
+		}
+		cc.AuthInfos[sess.User] = &clientcmdapi.AuthInfo{Token: sess.proxyToken}
+	}
 	contextName := fmt.Sprintf("%s@%s", sess.Cluster, sess.User)
 	cc.Contexts[contextName] = &clientcmdapi.Context{
 		Cluster:  sess.Cluster,
@@ -84,8 +109,8 @@ func toCmdConfig(sess *Session) *clientcmdapi.Config {
 
 func (h *Handler) CreateSession() iris.Handler {
 	return func(ctx *context.Context) {
-		var sess Session
-		if err := ctx.ReadJSON(&sess); err != nil {
+		var req Session
+		if err := ctx.ReadJSON(&req); err != nil {
 			ctx.StatusCode(iris.StatusBadRequest)
 			ctx.Values().Set("message", err.Error())
 			return
@@ -93,39 +118,84 @@ func (h *Handler) CreateSession() iris.Handler {
 		u := ctx.Values().Get("profile")
 		profile := u.(session.UserProfile)
 
-		c, err := h.clusterService.Get(sess.Cluster, common.DBOptions{})
+		t := sessionTicket{Cluster: req.Cluster, User: profile.Name, IsAdministrator: profile.IsAdministrator}
+		sess, err := h.newSession(t)
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
 			return
 		}
-		k := kubernetes.NewKubernetes(c)
-		cfg, err := k.Config()
+		sessionId := uuid.New().String()
+		h.sessionCache.Put(sessionId, sess)
+		// ticket 中只保存集群和用户，不保存凭据
+		if err := server.SaveTicket(server.TicketKindWebkubectl, sessionId, t); err != nil {
+			server.Logger().Errorf("save webkubectl ticket failed: %s", err.Error())
+		}
+		ctx.Values().Set("data", &SessionResponse{Token: sessionId})
+	}
+}
+
+type sessionTicket struct {
+	Cluster         string `json:"cluster"`
+	User            string `json:"user"`
+	IsAdministrator bool   `json:"isAdministrator"`
+}
+
+func (h *Handler) newSession(t sessionTicket) (*Session, error) {
+	c, err := h.clusterService.Get(t.Cluster, common.DBOptions{})
+	if err != nil {
+		return nil, err
+	}
+	k := kubernetes.NewKubernetes(c)
+	cfg, err := k.Config()
+	if err != nil {
+		return nil, err
+	}
+	if !t.IsAdministrator && kubernetes.Impersonation(c) {
+		// 模拟用户模式下成员没有证书，kubeconfig 中也不能包含管理员凭据
+		if _, err := h.clusterBindingService.GetBindingByClusterNameAndUserName(t.Cluster, t.User, common.DBOptions{}); err != nil {
+			return nil, err
+		}
+		token, err := signProxyToken(proxyTokenKey(), proxyClaims{Cluster: t.Cluster, User: t.User})
 		if err != nil {
-			ctx.StatusCode(iris.StatusInternalServerError)
-			ctx.Values().Set("message", err.Error())
-			return
+			return nil, err
 		}
-		if !profile.IsAdministrator {
-			rb, err := h.clusterBindingService.GetBindingByClusterNameAndUserName(sess.Cluster, profile.Name, common.DBOptions{})
-			if err != nil {
-				ctx.StatusCode(iris.StatusInternalServerError)
-				ctx.Values().Set("message", err.Error())
-				return
-			}
-			cfg.CertData = rb.Certificate
-			cfg.KeyData = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: c.PrivateKey})
+		return &Session{User: t.User, Cluster: t.Cluster, config: &rest.Config{}, proxyToken: token}, nil
+	}
+	if !t.IsAdministrator {
+		rb, err := h.clusterBindingService.GetBindingByClusterNameAndUserName(t.Cluster, t.User, common.DBOptions{})
+		if err != nil {
+			return nil, err
 		}
-		sess.config = cfg
-		sess.User = profile.Name
-		sessionId := uuid.New().String()
-		h.sessionCache.Put(sessionId, &sess)
-		ctx.Values().Set("data", &SessionResponse{Token: sessionId})
+		cfg.CertData = rb.Certificate
+		cfg.KeyData = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: c.PrivateKey})
+	}
+	sess := &Session{User: t.User, Cluster: t.Cluster, config: cfg}
+	// kubectl 同样需要通过集群配置的代理访问集群
+	proxyURL, err := k.ProxyURL()
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL != nil {
+		sess.proxyURL = proxyURL.String()
+	}
+	return sess, nil
+}
+
+func (h *Handler) restoreSession(sessionId string) (*Session, error) {
+	var t sessionTicket
+	if err := server.LoadTicketData(server.TicketKindWebkubectl, sessionId, &t); err != nil {
+		return nil, err
 	}
+	server.DeleteTicket(server.TicketKindWebkubectl, sessionId)
+	return h.newSession(t)
 }
 
 func Install(authParent, noAuthParty iris.Party) {
 	handler := NewHandler()
+	metrics.RegisterActiveSessions("webkubectl", handler.sessionCache.Len)
 	authParent.Post("/webkubectl/session", handler.CreateSession())
-	noAuthParty.Get("/webkubectl/session", handler.GetConfigFile())
+	// 会话缓存在创建会话的实例中，由该实例返回配置并清理缓存和 ticket
+	noAuthParty.Get("/webkubectl/session", server.ForwardToOwner(server.TicketKindWebkubectl, server.URLParamSessionID("token")), handler.GetConfigFile())
+	noAuthParty.Any("/webkubectl/proxy/{p:path}", handler.ProxyHandler())
 }
@@ -1,6 +1,7 @@
 package ws
 
 import (
+	"github.com/ClusterOperator/kubepi/internal/server"
 	"github.com/ClusterOperator/kubepi/pkg/logging"
 	"github.com/ClusterOperator/kubepi/pkg/terminal"
 	"github.com/kataras/iris/v12"
@@ -10,11 +11,11 @@ import (
 func Install(parent iris.Party) {
 	wsParty := parent.Party("/ws")
 	h := terminal.CreateAttachHandler("/terminal/sockjs")
-	wsParty.Any("/terminal/sockjs/{p:path}", func(ctx *context.Context) {
+	wsParty.Any("/terminal/sockjs/{p:path}", server.ForwardToOwner(server.TicketKindTerminal, server.SockJSSessionID), func(ctx *context.Context) {
 		h.ServeHTTP(ctx.ResponseWriter(), ctx.Request())
 	})
 	l := logging.CreateLoggingHandler("logging/sockjs")
-	wsParty.Any("/logging/sockjs/{p:path}", func(ctx *context.Context) {
+	wsParty.Any("/logging/sockjs/{p:path}", server.ForwardToOwner(server.TicketKindLogging, server.SockJSSessionID), func(ctx *context.Context) {
 		l.ServeHTTP(ctx.ResponseWriter(), ctx.Request())
 	})
 }
@@ -4,12 +4,14 @@ import (
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
+	"math/big"
+	"strconv"
+
 	"github.com/ClusterOperator/kubepi/internal/model/v1/config"
 	"github.com/ClusterOperator/kubepi/pkg/file"
 	"github.com/coreos/etcd/pkg/fileutil"
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
-	"math/big"
-	"strconv"
 )
 
 const configNotFoundSkipErr = "config file not found in %s, skip"
@@ -21,14 +23,41 @@ var configFilePaths = []string{
 }
 
 func ReadConfig(c *config.Config, path ...string) error {
+	v, err := readConfig(c, path...)
+	if err != nil {
+		return err
+	}
+	if c.Spec.Jwt.Key == "" {
+		c.Spec.Jwt.Key = generate(32)
+		// 没有配置文件时(例如只通过环境变量配置的容器)，生成的密钥只在本次运行中有效
+		if v.ConfigFileUsed() == "" {
+			return nil
+		}
+		v.Set("spec.jwt.key", c.Spec.Jwt.Key)
+		if err := v.WriteConfig(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func configDirs(path ...string) []string {
+	var dirs []string
+	for _, p := range append(append([]string{}, configFilePaths...), path...) {
+		if p == "" {
+			continue
+		}
+		dirs = append(dirs, file.ReplaceHomeDir(p))
+	}
+	return dirs
+}
+
+func readConfig(c *config.Config, path ...string) (*viper.Viper, error) {
 	v := viper.New()
 	v.SetConfigName("app")
 	v.SetConfigType("yaml")
 
-	configFilePaths = append(configFilePaths, path...)
-	for i := range configFilePaths {
-		realDir := file.ReplaceHomeDir(configFilePaths[i])
-
+	for _, realDir := range configDirs(path...) {
 		if exists := fileutil.Exist(realDir); !exists {
 			fmt.Println(fmt.Sprintf(configNotFoundSkipErr, realDir))
 			continue
@@ -40,30 +69,51 @@ func ReadConfig(c *config.Config, path ...string) error {
 			continue
 		}
 		if err := v.MergeInConfig(); err != nil {
-			fmt.Println(fmt.Sprintf(configMergeErr, configFilePaths))
+			fmt.Println(fmt.Sprintf(configMergeErr, realDir))
 		}
 
 	}
 
 	var configMap map[string]interface{}
 	if err := v.Unmarshal(&configMap); err != nil {
-		return err
+		return nil, err
 	}
 	str, err := json.Marshal(&configMap)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if err := json.Unmarshal(str, &c); err != nil {
-		return nil
+		return v, nil
 	}
-	if c.Spec.Jwt.Key == "" {
-		c.Spec.Jwt.Key = generate(32)
-		v.Set("spec.jwt.key", c.Spec.Jwt.Key)
-		if err := v.WriteConfig(); err != nil {
-			return err
+	if err := ApplyEnv(&c.Spec); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// WatchConfig 监听配置文件的变化，文件变化时使用 newConfig 提供的默认值重新读取完整配置(包括环境变量)并回调 onChange
+func WatchConfig(newConfig func() *config.Config, onChange func(c *config.Config), path ...string) {
+	for _, realDir := range configDirs(path...) {
+		if exists := fileutil.Exist(realDir); !exists {
+			continue
 		}
+		v := viper.New()
+		v.SetConfigName("app")
+		v.SetConfigType("yaml")
+		v.AddConfigPath(realDir)
+		if err := v.ReadInConfig(); err != nil {
+			continue
+		}
+		v.OnConfigChange(func(in fsnotify.Event) {
+			c := newConfig()
+			if _, err := readConfig(c, path...); err != nil {
+				fmt.Println(fmt.Sprintf(configReadErr, in.Name, err.Error()))
+				return
+			}
+			onChange(c)
+		})
+		v.WatchConfig()
 	}
-	return nil
 }
 
 func generate(length int) string {
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ClusterOperator/kubepi/internal/model/v1/config"
+)
+
+// EnvPrefix 是配置项环境变量的前缀，变量名由 json 字段路径转换为大写并以 _ 连接，
+// 例如 spec.server.bind.port 对应 KUBEPI_SPEC_SERVER_BIND_PORT，列表使用逗号分隔
+const EnvPrefix = "KUBEPI_SPEC"
+
+const redactedValue = "******"
+
+// ApplyEnv 使用环境变量覆盖 spec 中对应的配置项
+func ApplyEnv(spec *config.Spec) error {
+	return applyEnv(reflect.ValueOf(spec).Elem(), EnvPrefix)
+}
+
+func fieldEnvName(prefix string, f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "" {
+		name = f.Name
+	}
+	return prefix + "_" + strings.ToUpper(name)
+}
+
+func applyEnv(v reflect.Value, prefix string) error {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		name := fieldEnvName(prefix, f)
+		if f.Type.Kind() == reflect.Struct {
+			if err := applyEnv(v.Field(i), name); err != nil {
+				return err
+			}
+			continue
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid value of %s: %s", name, err.Error())
+			}
+			field.SetBool(b)
+		case reflect.Int, reflect.Int32, reflect.Int64:
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid value of %s: %s", name, err.Error())
+			}
+			field.SetInt(n)
+		case reflect.Slice:
+			if field.Type().Elem().Kind() != reflect.String {
+				return fmt.Errorf("unsupported type of %s", name)
+			}
+			items := make([]string, 0)
+			for _, s := range strings.Split(value, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					items = append(items, s)
+				}
+			}
+			field.Set(reflect.ValueOf(items))
+		default:
+			return fmt.Errorf("unsupported type of %s", name)
+		}
+	}
+	return nil
+}
+
+var dsnPasswordPattern = regexp.MustCompile(`(?i)(password=)(\S+)`)
+
+// Redact 返回隐藏了密钥、密码等敏感信息的配置副本
+func Redact(c *config.Config) config.Config {
+	r := *c
+	if r.Spec.Jwt.Key != "" {
+		r.Spec.Jwt.Key = redactedValue
+	}
+	if r.Spec.Encryption.Key != "" {
+		r.Spec.Encryption.Key = redactedValue
+	}
+	previous := make([]string, len(r.Spec.Encryption.PreviousKeys))
+	for i := range previous {
+		previous[i] = redactedValue
+	}
+	r.Spec.Encryption.PreviousKeys = previous
+	r.Spec.DB.DSN = redactDSN(r.Spec.DB.DSN)
+	if r.Spec.Session.Redis.Password != "" {
+		r.Spec.Session.Redis.Password = redactedValue
+	}
+	if r.Spec.Metrics.Token != "" {
+		r.Spec.Metrics.Token = redactedValue
+	}
+	return r
+}
+
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return dsnPasswordPattern.ReplaceAllString(dsn, "${1}"+redactedValue)
+}
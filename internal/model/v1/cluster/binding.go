@@ -1,6 +1,10 @@
 package cluster
 
-import v1 "github.com/ClusterOperator/kubepi/internal/model/v1"
+import (
+	"time"
+
+	v1 "github.com/ClusterOperator/kubepi/internal/model/v1"
+)
 
 type Binding struct {
 	v1.BaseModel `storm:"inline"`
@@ -9,3 +13,22 @@ type Binding struct {
 	ClusterRef   string `json:"clusterRef" storm:"index"`
 	Certificate  []byte `json:"certificate"`
 }
+
+const (
+	// RevokeReasonRemoved 表示成员被移除
+	RevokeReasonRemoved = "removed"
+	// RevokeReasonRotated 表示证书被新签发的证书替换
+	RevokeReasonRotated = "rotated"
+)
+
+// RevokedCertificate 是被移除的成员或者被替换的证书。集群无法吊销客户端证书，证书在过期前仍然可以通过集群的认证，
+// 记录保存到证书过期为止
+type RevokedCertificate struct {
+	v1.BaseModel `storm:"inline"`
+	v1.Metadata  `storm:"inline"`
+	UserRef      string    `json:"userRef"`
+	ClusterRef   string    `json:"clusterRef" storm:"index"`
+	SerialNumber string    `json:"serialNumber"`
+	NotAfter     time.Time `json:"notAfter"`
+	Reason       string    `json:"reason"`
+}
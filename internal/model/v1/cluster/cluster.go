@@ -1,7 +1,13 @@
 package cluster
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
 	v1 "github.com/ClusterOperator/kubepi/internal/model/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 type Cluster struct {
@@ -14,15 +20,76 @@ type Cluster struct {
 	Labels        []string    `json:"labels"`
 }
 
+// LabelSet 返回集群的标签，Labels 中的标签是 key=value 格式，没有 = 的旧标签作为值为空的 key
+func (c *Cluster) LabelSet() labels.Set {
+	return ParseLabels(c.Labels)
+}
+
+func ParseLabels(ls []string) labels.Set {
+	set := labels.Set{}
+	for _, l := range ls {
+		key, value, _ := strings.Cut(l, "=")
+		set[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return set
+}
+
+// ValidateLabels 按 ParseLabels 的解析方式检查标签，key 和 value 的规则与 Kubernetes 的标签相同，
+// 兼容之前版本只有 key 的标签，这样的标签按 value 为空处理
+func ValidateLabels(ls []string) error {
+	keys := map[string]bool{}
+	for _, l := range ls {
+		key, value, _ := strings.Cut(l, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid label key %s: %s", key, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return fmt.Errorf("invalid label value %s: %s", value, strings.Join(errs, "; "))
+		}
+		if keys[key] {
+			return fmt.Errorf("duplicate label key %s", key)
+		}
+		keys[key] = true
+	}
+	return nil
+}
+
 type Spec struct {
 	Connect        Connect        `json:"connect" storm:"inline"`
 	Authentication Authentication `json:"authentication" storm:"inline"`
 	Local          bool           `json:"local"`
+	InformerCache  InformerCache  `json:"informerCache" storm:"inline"`
+	MemberAuthMode string         `json:"memberAuthMode"`
+}
+
+const (
+	// MemberAuthCertificate 为每个成员签发客户端证书，成员使用自己的证书访问集群，为空时同样使用这种方式
+	MemberAuthCertificate = "certificate"
+	// MemberAuthImpersonation 使用集群的管理凭据并通过 Impersonate-User 和 Impersonate-Group 请求头以成员的身份访问集群
+	MemberAuthImpersonation = "impersonation"
+)
+
+// InformerCache 开启后，代理的列表请求使用 informer 缓存的数据
+type InformerCache struct {
+	Enabled bool `json:"enabled"`
 }
 
+const (
+	DirectionForward = "forward"
+	DirectionReverse = "reverse"
+)
+
 type Connect struct {
 	Direction string  `json:"direction"`
 	Forward   Forward `json:"forward" storm:"inline"`
+	Reverse   Reverse `json:"reverse" storm:"inline"`
+}
+
+// Reverse 保存 reverse 模式下集群内 agent 的认证信息，agent 使用一次性的注册 token 换取长期有效的 agent token
+type Reverse struct {
+	RegistrationToken string `json:"registrationToken"`
+	AgentTokenHash    string `json:"agentTokenHash"`
 }
 
 type Forward struct {
@@ -41,6 +108,17 @@ type Authentication struct {
 	BearerToken       string      `json:"bearerToken"`
 	Certificate       Certificate `json:"certificate" storm:"inline"`
 	ConfigFileContent []byte      `json:"configFileContent"`
+	// ServiceAccount 是 serviceAccount 模式下 token 的签发时间和过期时间，token 保存在 BearerToken 中
+	ServiceAccount ServiceAccountToken `json:"serviceAccount" storm:"inline"`
+}
+
+// AuthModeServiceAccount 使用引导凭据在集群中创建 KubePi 专用的 ServiceAccount，之后使用 TokenRequest
+// 签发的 token 访问集群并定期刷新，引导凭据不保存
+const AuthModeServiceAccount = "serviceAccount"
+
+type ServiceAccountToken struct {
+	IssuedAt time.Time `json:"issuedAt"`
+	ExpireAt time.Time `json:"expireAt"`
 }
 
 type Certificate struct {
@@ -49,7 +127,27 @@ type Certificate struct {
 }
 
 type Status struct {
-	Version string `json:"version"`
-	Phase   string `json:"phase"`
-	Message string `json:"message"`
+	Version string      `json:"version"`
+	Phase   string      `json:"phase"`
+	Message string      `json:"message"`
+	Agent   AgentStatus `json:"agent"`
+	// LastProbeTime 是后台健康检查最后一次检查集群的时间
+	LastProbeTime time.Time `json:"lastProbeTime"`
+	// History 是最近几次健康状态的变化，最新的在最后
+	History []HealthRecord `json:"history"`
+}
+
+// HealthRecord 是一次健康状态的变化
+type HealthRecord struct {
+	Time    time.Time `json:"time"`
+	Phase   string    `json:"phase"`
+	Message string    `json:"message"`
+}
+
+// AgentStatus 是 reverse 模式下 agent 的连接状态
+type AgentStatus struct {
+	Connected        bool      `json:"connected"`
+	Address          string    `json:"address"`
+	LastConnectAt    time.Time `json:"lastConnectAt"`
+	LastDisconnectAt time.Time `json:"lastDisconnectAt"`
 }
@@ -0,0 +1,14 @@
+package cluster
+
+import "testing"
+
+func TestValidateLabels(t *testing.T) {
+	if err := ValidateLabels([]string{"env=prod", "example.com/team=", "region=cn-north_1", "legacy"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, ls := range [][]string{{"env", "env=prod"}, {"env=prod", "env=dev"}, {"-env=prod"}, {"env=prod value"}} {
+		if err := ValidateLabels(ls); err == nil {
+			t.Errorf("%v: expect error", ls)
+		}
+	}
+}
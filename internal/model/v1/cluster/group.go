@@ -0,0 +1,10 @@
+package cluster
+
+import v1 "github.com/ClusterOperator/kubepi/internal/model/v1"
+
+// Group 是按 label selector 选择的一组集群，集群的标签变化后自动加入或离开分组
+type Group struct {
+	v1.BaseModel `storm:"inline"`
+	v1.Metadata  `storm:"inline"`
+	Selector     string `json:"selector"`
+}
@@ -8,17 +8,24 @@ type Config struct {
 	Spec Spec `json:"spec"`
 }
 type Spec struct {
-	Server  ServerConfig  `json:"server"`
-	DB      DBConfig      `json:"db"`
-	Session SessionConfig `json:"session"`
-	Logger  LoggerConfig  `json:"logger"`
-	Jwt     JwtConfig     `json:"jwt"`
-	AppId   string        `json:"appId"`
+	Server        ServerConfig        `json:"server"`
+	DB            DBConfig            `json:"db"`
+	Session       SessionConfig       `json:"session"`
+	Logger        LoggerConfig        `json:"logger"`
+	Jwt           JwtConfig           `json:"jwt"`
+	Encryption    EncryptionConfig    `json:"encryption"`
+	Backup        BackupConfig        `json:"backup"`
+	Metrics       MetricsConfig       `json:"metrics"`
+	ClientCache   ClientCacheConfig   `json:"clientCache"`
+	InformerCache InformerCacheConfig `json:"informerCache"`
+	AppId         string              `json:"appId"`
 }
 
 type ServerConfig struct {
 	Bind BindConfig `json:"bind"`
 	SSL  SSLConfig  `json:"ssl"`
+	// AdvertiseAddress 是其他副本访问当前实例的地址，例如 http://10.0.0.1:80，为空时使用本机 IP 和监听端口
+	AdvertiseAddress string `json:"advertiseAddress"`
 }
 
 type BindConfig struct {
@@ -27,9 +34,21 @@ type BindConfig struct {
 }
 
 type SSLConfig struct {
-	Enable         bool   `json:"enable"`
-	Certificate    string `json:"certificate"`
-	CertificateKey string `json:"certificateKey"`
+	Enable         bool           `json:"enable"`
+	Certificate    string         `json:"certificate"`
+	CertificateKey string         `json:"certificateKey"`
+	Redirect       RedirectConfig `json:"redirect"`
+	ClientAuth     ClientAuth     `json:"clientAuth"`
+}
+
+type RedirectConfig struct {
+	Enable bool `json:"enable"`
+	Port   int  `json:"port"`
+}
+
+type ClientAuth struct {
+	Enable        bool   `json:"enable"`
+	CaCertificate string `json:"caCertificate"`
 }
 
 type LoggerConfig struct {
@@ -37,13 +56,62 @@ type LoggerConfig struct {
 }
 
 type DBConfig struct {
+	Type string `json:"type"`
 	Path string `json:"path"`
+	DSN  string `json:"dsn"`
 }
 
 type SessionConfig struct {
 	Expires int `json:"expires"`
+	// Store 会话存储类型: memory | bolt | redis，多副本部署时需要使用 redis
+	Store string      `json:"store"`
+	Path  string      `json:"path"`
+	Redis RedisConfig `json:"redis"`
+}
+
+type RedisConfig struct {
+	Addr     string `json:"addr"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	DB       int    `json:"db"`
+	Prefix   string `json:"prefix"`
 }
 
 type JwtConfig struct {
 	Key string `json:"key"`
 }
+
+type EncryptionConfig struct {
+	Enable       bool     `json:"enable"`
+	Key          string   `json:"key"`
+	KeyFile      string   `json:"keyFile"`
+	PreviousKeys []string `json:"previousKeys"`
+}
+
+type BackupConfig struct {
+	Enable    bool   `json:"enable"`
+	Dir       string `json:"dir"`
+	Interval  int    `json:"interval"`
+	Retention int    `json:"retention"`
+}
+
+type MetricsConfig struct {
+	Enable     bool     `json:"enable"`
+	Token      string   `json:"token"`
+	AllowedIPs []string `json:"allowedIPs"`
+}
+
+type ClientCacheConfig struct {
+	// TTL 集群连接、版本和 API 发现信息的缓存时间，单位为秒，小于 0 时不缓存
+	TTL int `json:"ttl"`
+}
+
+// InformerCacheConfig 是集群 informer 缓存的限制，缓存在集群设置中开启
+type InformerCacheConfig struct {
+	// IdleTimeout 集群没有访问后停止 informer 的时间，单位为秒
+	IdleTimeout int `json:"idleTimeout"`
+	// MaxStaleness watch 出错后不使用缓存的时间，单位为秒
+	MaxStaleness int `json:"maxStaleness"`
+	// MaxObjects 单个资源缓存的最大对象数量，超过后停止缓存该资源
+	MaxObjects int `json:"maxObjects"`
+}
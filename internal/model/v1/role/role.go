@@ -6,6 +6,9 @@ type PolicyRule struct {
 	Resource      []string `json:"resource"`
 	ResourceNames []string `json:"resourceNames"`
 	Verbs         []string `json:"verbs"`
+	// ClusterSelector 和 ClusterGroups 限制 clusters 资源的规则只对匹配的集群生效，都为空时对所有集群生效
+	ClusterSelector string   `json:"clusterSelector"`
+	ClusterGroups   []string `json:"clusterGroups"`
 }
 
 type Role struct {
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"os"
+	"path"
+	"time"
+
+	v1Config "github.com/ClusterOperator/kubepi/internal/model/v1/config"
+	"github.com/ClusterOperator/kubepi/migrate"
+	"github.com/ClusterOperator/kubepi/pkg/backup"
+	"github.com/ClusterOperator/kubepi/pkg/file"
+	"github.com/ClusterOperator/kubepi/pkg/storage"
+	"github.com/ClusterOperator/kubepi/pkg/version"
+)
+
+// WriteBackup 将 db 备份到备份目录中并按保留数量清理旧备份，返回备份文件名
+func WriteBackup(db storage.DB, c v1Config.BackupConfig) (string, error) {
+	dir := file.ReplaceHomeDir(c.Dir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	migrationVersion, err := migrate.CurrentVersion(db)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	name := backup.NewFileName(now)
+	f, err := os.CreateTemp(dir, "."+name+"-*")
+	if err != nil {
+		return "", err
+	}
+	tmp := f.Name()
+	err = backup.Write(f, db, backup.Manifest{
+		Version:          version.Version,
+		MigrationVersion: migrationVersion,
+		CreateAt:         now,
+	})
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return "", err
+	}
+	// 使用硬链接代替重命名，同名的备份已经存在时失败而不是覆盖
+	err = os.Link(tmp, path.Join(dir, name))
+	_ = os.Remove(tmp)
+	if err != nil {
+		return "", err
+	}
+	if _, err := backup.Prune(dir, c.Retention); err != nil {
+		return name, err
+	}
+	return name, nil
+}
+
+// BackupBeforeMigrate 在数据库需要从已有版本升级或回滚到 to 时先创建备份，无需迁移时返回空的文件名
+func BackupBeforeMigrate(db storage.DB, c v1Config.BackupConfig, to int) (string, error) {
+	current, err := migrate.CurrentVersion(db)
+	if err != nil {
+		return "", err
+	}
+	// 全新的数据库无需备份
+	if current == 0 || current == to {
+		return "", nil
+	}
+	return WriteBackup(db, c)
+}
+
+func BackupDir() string {
+	return file.ReplaceHomeDir(es.config.Load().Spec.Backup.Dir)
+}
+
+func CreateBackup() (string, error) {
+	return WriteBackup(es.db, es.config.Load().Spec.Backup)
+}
+
+// startBackupSchedule 定期备份数据库，每次执行时读取配置，配置热加载后在下个周期生效。
+// 多实例部署时每个周期只由一个实例备份
+func (e *KubePiServer) startBackupSchedule() {
+	go func() {
+		timer := time.NewTimer(backupInterval(e.config.Load().Spec.Backup))
+		defer timer.Stop()
+		for range timer.C {
+			c := e.config.Load().Spec.Backup
+			interval := backupInterval(c)
+			timer.Reset(interval)
+			if !c.Enable {
+				continue
+			}
+			acquired, err := acquireSchedule(e.db, "backup", interval, time.Now())
+			if err != nil {
+				e.logger.Errorf("acquire backup schedule failed: %s", err.Error())
+				continue
+			}
+			if !acquired {
+				continue
+			}
+			name, err := WriteBackup(e.db, c)
+			if err != nil {
+				e.logger.Errorf("scheduled backup failed: %s", err.Error())
+				continue
+			}
+			e.logger.Infof("scheduled backup %s created", name)
+		}
+	}()
+}
+
+func backupInterval(c v1Config.BackupConfig) time.Duration {
+	interval := time.Duration(c.Interval) * time.Hour
+	if interval <= 0 {
+		interval = 24 * time.Hour
+	}
+	return interval
+}
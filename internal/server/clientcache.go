@@ -0,0 +1,18 @@
+package server
+
+import (
+	"time"
+
+	"github.com/ClusterOperator/kubepi/pkg/kubernetes"
+)
+
+// setUpClientCache 设置集群连接缓存的有效期，小于 0 时不缓存
+func (e *KubePiServer) setUpClientCache() {
+	kubernetes.DefaultCache.SetTTL(time.Duration(e.config.Load().Spec.ClientCache.TTL) * time.Second)
+}
+
+// setUpInformerCache 设置集群 informer 缓存的限制
+func (e *KubePiServer) setUpInformerCache() {
+	c := e.config.Load().Spec.InformerCache
+	kubernetes.DefaultInformers.SetLimits(time.Duration(c.IdleTimeout)*time.Second, time.Duration(c.MaxStaleness)*time.Second, c.MaxObjects)
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	v1Config "github.com/ClusterOperator/kubepi/internal/model/v1/config"
+	"github.com/ClusterOperator/kubepi/pkg/file"
+	"github.com/ClusterOperator/kubepi/pkg/storage"
+	"github.com/ClusterOperator/kubepi/pkg/storage/boltdb"
+	"github.com/ClusterOperator/kubepi/pkg/storage/sqldb"
+	"github.com/coreos/etcd/pkg/fileutil"
+)
+
+const (
+	boltFileName   = "kubepi.db"
+	sqliteFileName = "kubepi.sqlite"
+)
+
+// BoltFilePath 返回 bolt 数据库文件路径
+func BoltFilePath(c v1Config.DBConfig) string {
+	return path.Join(file.ReplaceHomeDir(c.Path), boltFileName)
+}
+
+// OpenDB 根据配置打开存储后端，未配置类型时使用 bolt
+func OpenDB(c v1Config.DBConfig) (storage.DB, error) {
+	realDir := file.ReplaceHomeDir(c.Path)
+	dbType := c.Type
+	if dbType == "" {
+		dbType = storage.TypeBolt
+	}
+	if dbType == storage.TypeBolt || dbType == storage.TypeSqlite {
+		if !fileutil.Exist(realDir) {
+			if err := os.MkdirAll(realDir, 0755); err != nil {
+				return nil, fmt.Errorf("can not create database dir: %s message: %s", c.Path, err)
+			}
+		}
+	}
+	switch dbType {
+	case storage.TypeBolt:
+		return boltdb.Open(path.Join(realDir, boltFileName))
+	case storage.TypeSqlite:
+		dsn := c.DSN
+		if dsn == "" {
+			dsn = fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path.Join(realDir, sqliteFileName))
+		}
+		return sqldb.Open(storage.TypeSqlite, dsn)
+	case storage.TypePostgres:
+		if c.DSN == "" {
+			return nil, fmt.Errorf("database type %s requires dsn", dbType)
+		}
+		return sqldb.Open(storage.TypePostgres, c.DSN)
+	}
+	return nil, fmt.Errorf("unsupported database type %s", dbType)
+}
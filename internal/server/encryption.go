@@ -0,0 +1,85 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"reflect"
+
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
+	v1Config "github.com/ClusterOperator/kubepi/internal/model/v1/config"
+	v1ImageRepo "github.com/ClusterOperator/kubepi/internal/model/v1/imagerepo"
+	v1Ldap "github.com/ClusterOperator/kubepi/internal/model/v1/ldap"
+	v1Sso "github.com/ClusterOperator/kubepi/internal/model/v1/sso"
+	"github.com/ClusterOperator/kubepi/pkg/encryption"
+	"github.com/ClusterOperator/kubepi/pkg/file"
+	"github.com/ClusterOperator/kubepi/pkg/storage"
+	"github.com/ClusterOperator/kubepi/pkg/storage/encrypted"
+)
+
+// secretFields 是需要加密保存的凭据字段
+var secretFields = encrypted.Fields{
+	reflect.TypeOf(v1Cluster.Cluster{}): {
+		"PrivateKey",
+		"Spec.Authentication.BearerToken",
+		"Spec.Authentication.ConfigFileContent",
+		"Spec.Authentication.Certificate.KeyData",
+		"Spec.Connect.Forward.Proxy.Password",
+		"Spec.Connect.Reverse.RegistrationToken",
+	},
+	reflect.TypeOf(v1ImageRepo.ImageRepo{}): {"Credential.Password"},
+	reflect.TypeOf(v1Ldap.Ldap{}):           {"Password"},
+	reflect.TypeOf(v1Sso.Sso{}):             {"ClientSecret"},
+}
+
+func newEncryptor(c v1Config.EncryptionConfig) (*encryption.Encryptor, error) {
+	key := c.Key
+	if key == "" && c.KeyFile != "" {
+		bs, err := os.ReadFile(file.ReplaceHomeDir(c.KeyFile))
+		if err != nil {
+			return nil, err
+		}
+		key = string(bs)
+	}
+	if key == "" {
+		return nil, errors.New("encryption is enabled but neither key nor keyFile is set")
+	}
+	primary, err := encryption.ParseKey(key)
+	if err != nil {
+		return nil, err
+	}
+	var previous [][]byte
+	for i := range c.PreviousKeys {
+		k, err := encryption.ParseKey(c.PreviousKeys[i])
+		if err != nil {
+			return nil, err
+		}
+		previous = append(previous, k)
+	}
+	return encryption.NewEncryptor(primary, previous...)
+}
+
+// WithEncryption 在启用加密时返回透明加解密凭据字段的存储，否则原样返回
+func WithEncryption(db storage.DB, c v1Config.EncryptionConfig) (storage.DB, error) {
+	if !c.Enable {
+		return db, nil
+	}
+	e, err := newEncryptor(c)
+	if err != nil {
+		return nil, err
+	}
+	return encrypted.NewDB(db, e, secretFields), nil
+}
+
+func (e *KubePiServer) encryptCredentials() {
+	d, ok := e.db.(*encrypted.DB)
+	if !ok {
+		return
+	}
+	updated, err := d.Rotate()
+	if err != nil {
+		panic(err)
+	}
+	if updated > 0 {
+		e.logger.Infof("encrypted credentials of %d records", updated)
+	}
+}
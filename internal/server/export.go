@@ -0,0 +1,149 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	v1 "github.com/ClusterOperator/kubepi/internal/model/v1"
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
+	v1ClusterRepo "github.com/ClusterOperator/kubepi/internal/model/v1/clusterrepo"
+	v1ImageRepo "github.com/ClusterOperator/kubepi/internal/model/v1/imagerepo"
+	v1Role "github.com/ClusterOperator/kubepi/internal/model/v1/role"
+	v1User "github.com/ClusterOperator/kubepi/internal/model/v1/user"
+	"github.com/ClusterOperator/kubepi/pkg/storage"
+	"github.com/ClusterOperator/kubepi/pkg/version"
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+	"github.com/google/uuid"
+)
+
+const exportKind = "KubePiExport"
+
+// Export 是 export 命令导出的数据，用户密码为 bcrypt 哈希，集群和镜像仓库的凭据为明文
+type Export struct {
+	ApiVersion      string                      `json:"apiVersion"`
+	Kind            string                      `json:"kind"`
+	Version         string                      `json:"version"`
+	CreateAt        time.Time                   `json:"createAt"`
+	Users           []v1User.User               `json:"users"`
+	Roles           []v1Role.Role               `json:"roles"`
+	RoleBindings    []v1Role.Binding            `json:"roleBindings"`
+	Clusters        []v1Cluster.Cluster         `json:"clusters"`
+	ClusterBindings []v1Cluster.Binding         `json:"clusterBindings"`
+	ClusterGroups   []v1Cluster.Group           `json:"clusterGroups"`
+	ImageRepos      []v1ImageRepo.ImageRepo     `json:"imageRepos"`
+	ClusterRepos    []v1ClusterRepo.ClusterRepo `json:"clusterRepos"`
+
+	// RevokedCertificates 是仍未过期的被吊销的成员证书
+	RevokedCertificates []v1Cluster.RevokedCertificate `json:"revokedCertificates"`
+}
+
+type ImportResult struct {
+	Created int
+	Updated int
+	Skipped int
+}
+
+// ExportData 导出用户、角色、集群、集群分组、被吊销的成员证书、镜像仓库及它们之间的绑定关系
+func ExportData(db storage.Node) (*Export, error) {
+	e := Export{
+		ApiVersion: "v1",
+		Kind:       exportKind,
+		Version:    version.Version,
+		CreateAt:   time.Now(),
+	}
+	for _, to := range e.records() {
+		if err := db.All(to.records); err != nil && !errors.Is(err, storm.ErrNotFound) {
+			return nil, err
+		}
+	}
+	return &e, nil
+}
+
+// ImportData 在一个事务中导入数据，按名称匹配已有记录，已有的记录在 overwrite 时覆盖，否则跳过，
+// 内置的记录总是跳过
+func ImportData(db storage.DB, e *Export, overwrite bool) (*ImportResult, error) {
+	if e.Kind != exportKind {
+		return nil, fmt.Errorf("unsupported kind %q, expect %s", e.Kind, exportKind)
+	}
+	tx, err := db.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+	var result ImportResult
+	for _, r := range e.records() {
+		if err := importRecords(tx, r, overwrite, &result); err != nil {
+			_ = tx.Rollback()
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+type exportRecords struct {
+	kind    string
+	records interface{}
+	// key 返回用于匹配已有记录的条件，为空时按名称匹配
+	key func(item reflect.Value) q.Matcher
+}
+
+func (e *Export) records() []exportRecords {
+	return []exportRecords{
+		{kind: "user", records: &e.Users},
+		{kind: "role", records: &e.Roles},
+		{kind: "role binding", records: &e.RoleBindings},
+		{kind: "cluster", records: &e.Clusters},
+		{kind: "cluster binding", records: &e.ClusterBindings},
+		{kind: "cluster group", records: &e.ClusterGroups},
+		{kind: "revoked certificate", records: &e.RevokedCertificates},
+		{kind: "image repo", records: &e.ImageRepos},
+		{kind: "cluster repo", records: &e.ClusterRepos, key: func(item reflect.Value) q.Matcher {
+			r := item.Interface().(*v1ClusterRepo.ClusterRepo)
+			return q.And(q.Eq("Cluster", r.Cluster), q.Eq("Repo", r.Repo))
+		}},
+	}
+}
+
+func importRecords(tx storage.Node, r exportRecords, overwrite bool, result *ImportResult) error {
+	items := reflect.ValueOf(r.records).Elem()
+	for i := 0; i < items.Len(); i++ {
+		item := items.Index(i).Addr()
+		base := item.Elem().FieldByName("BaseModel").Addr().Interface().(*v1.BaseModel)
+		meta := item.Elem().FieldByName("Metadata").Addr().Interface().(*v1.Metadata)
+		key := q.Eq("Name", meta.Name)
+		if r.key != nil {
+			key = r.key(item)
+		}
+		existing := reflect.New(item.Elem().Type())
+		err := tx.Select(key).First(existing.Interface())
+		switch {
+		case err == nil:
+			old := existing.Elem().FieldByName("BaseModel").Interface().(v1.BaseModel)
+			if !overwrite || old.BuiltIn {
+				result.Skipped++
+				continue
+			}
+			meta.UUID = existing.Elem().FieldByName("Metadata").Interface().(v1.Metadata).UUID
+			base.UpdateAt = time.Now()
+			if err := tx.Save(item.Interface()); err != nil {
+				return fmt.Errorf("import %s %s failed: %s", r.kind, meta.Name, err.Error())
+			}
+			result.Updated++
+		case errors.Is(err, storm.ErrNotFound):
+			// 使用新的 ID，避免覆盖目标库中 ID 相同的其他记录
+			meta.UUID = uuid.New().String()
+			if err := tx.Save(item.Interface()); err != nil {
+				return fmt.Errorf("import %s %s failed: %s", r.kind, meta.Name, err.Error())
+			}
+			result.Created++
+		default:
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"path"
+	"testing"
+
+	v1 "github.com/ClusterOperator/kubepi/internal/model/v1"
+	v1ClusterRepo "github.com/ClusterOperator/kubepi/internal/model/v1/clusterrepo"
+	v1User "github.com/ClusterOperator/kubepi/internal/model/v1/user"
+	"github.com/ClusterOperator/kubepi/pkg/storage/boltdb"
+	"sigs.k8s.io/yaml"
+)
+
+func TestExportAndImport(t *testing.T) {
+	dir := t.TempDir()
+	src, err := boltdb.Open(path.Join(dir, "src.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	if err := src.Save(&v1User.User{
+		Metadata: v1.Metadata{Name: "tom", UUID: "1"},
+		Email:    "tom@example.com",
+		IsAdmin:  true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Save(&v1ClusterRepo.ClusterRepo{Metadata: v1.Metadata{UUID: "2"}, Cluster: "c1", Repo: "r1"}); err != nil {
+		t.Fatal(err)
+	}
+	e, err := ExportData(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs, err := yaml.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var imported Export
+	if err := yaml.UnmarshalStrict(bs, &imported); err != nil {
+		t.Fatal(err)
+	}
+
+	dst, err := boltdb.Open(path.Join(dir, "dst.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	r, err := ImportData(dst, &imported, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Created != 2 {
+		t.Fatalf("expect 2 records created, got %#v", r)
+	}
+	var u v1User.User
+	if err := dst.One("Name", "tom", &u); err != nil || !u.IsAdmin || u.Email != "tom@example.com" {
+		t.Fatalf("unexpected user %#v, %v", u, err)
+	}
+
+	if r, err = ImportData(dst, &imported, false); err != nil || r.Skipped != 2 {
+		t.Fatalf("expect 2 records skipped, got %#v, %v", r, err)
+	}
+	imported.Users[0].NickName = "Tom"
+	if r, err = ImportData(dst, &imported, true); err != nil || r.Updated != 2 {
+		t.Fatalf("expect 2 records updated, got %#v, %v", r, err)
+	}
+	var users []v1User.User
+	if err := dst.All(&users); err != nil || len(users) != 1 || users[0].NickName != "Tom" {
+		t.Fatalf("unexpected users %#v, %v", users, err)
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	v1Config "github.com/ClusterOperator/kubepi/internal/model/v1/config"
+	"github.com/ClusterOperator/kubepi/migrate"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+)
+
+const webkubectlAddr = "localhost:8080"
+
+type healthCheck struct {
+	name  string
+	check func() error
+}
+
+// setUpHealthCheck 注册无需认证的 /kubepi/healthz 和 /kubepi/readyz，
+// 与 metrics 相同挂载在 app 上，返回纯文本，携带 verbose 参数时列出每一项检查的结果，
+// exclude 参数可以跳过指定的检查，例如 /kubepi/readyz?exclude=webkubectl
+func (e *KubePiServer) setUpHealthCheck() {
+	e.app.Get("/kubepi/healthz", healthHandler("healthz", nil))
+	e.app.Get("/kubepi/readyz", healthHandler("readyz", []healthCheck{
+		{name: "db", check: e.checkDBWritable},
+		{name: "migration", check: e.checkMigration},
+		{name: "webkubectl", check: checkWebkubectl},
+	}))
+}
+
+func healthHandler(name string, checks []healthCheck) iris.Handler {
+	return func(ctx *context.Context) {
+		excluded := map[string]bool{}
+		for _, s := range ctx.URLParamSlice("exclude") {
+			excluded[s] = true
+		}
+		var b strings.Builder
+		failed := false
+		b.WriteString("[+]ping ok\n")
+		for _, c := range checks {
+			if excluded[c.name] {
+				fmt.Fprintf(&b, "[+]%s excluded: ok\n", c.name)
+				continue
+			}
+			if err := c.check(); err != nil {
+				failed = true
+				fmt.Fprintf(&b, "[-]%s failed: %s\n", c.name, err.Error())
+				continue
+			}
+			fmt.Fprintf(&b, "[+]%s ok\n", c.name)
+		}
+		ctx.ContentType("text/plain")
+		if failed {
+			ctx.StatusCode(iris.StatusServiceUnavailable)
+			b.WriteString(name + " check failed\n")
+			_, _ = ctx.WriteString(b.String())
+			return
+		}
+		if !ctx.URLParamExists("verbose") {
+			_, _ = ctx.WriteString("ok")
+			return
+		}
+		b.WriteString(name + " check passed\n")
+		_, _ = ctx.WriteString(b.String())
+	}
+}
+
+// checkDBWritable 通过写入一条记录确认数据库可写
+func (e *KubePiServer) checkDBWritable() error {
+	return e.db.Set("health", "readyz", time.Now().Unix())
+}
+
+func (e *KubePiServer) checkMigration() error {
+	current, err := migrate.CurrentVersion(e.db)
+	if err != nil {
+		return err
+	}
+	if latest := migrate.LatestVersion(); current < latest {
+		return fmt.Errorf("database version %d, expect %d", current, latest)
+	}
+	return nil
+}
+
+func checkWebkubectl() error {
+	conn, err := net.DialTimeout("tcp", webkubectlAddr, time.Second)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// HealthCheck 按配置中的监听地址和协议请求本机的 /kubepi/healthz，用于容器的健康检查
+func HealthCheck(c *v1Config.Config, timeout time.Duration) error {
+	client := &http.Client{Timeout: timeout}
+	if c.Spec.Server.SSL.Enable {
+		tlsConfig, err := peerTLSConfig(c.Spec.Server.SSL, "127.0.0.1")
+		if err != nil {
+			return err
+		}
+		client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	resp, err := client.Get(healthCheckURL(c.Spec.Server))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("healthz returns %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// healthCheckURL 返回本机的 healthz 地址，监听所有地址时使用回环地址
+func healthCheckURL(c v1Config.ServerConfig) string {
+	host := c.Bind.Host
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	scheme := "http"
+	if c.SSL.Enable {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/kubepi/healthz", scheme, net.JoinHostPort(host, fmt.Sprint(c.Bind.Port)))
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	gohttptest "net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	v1Config "github.com/ClusterOperator/kubepi/internal/model/v1/config"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/httptest"
+)
+
+func TestHealthHandler(t *testing.T) {
+	app := iris.New()
+	app.Get("/readyz", healthHandler("readyz", []healthCheck{
+		{name: "db", check: func() error { return nil }},
+		{name: "webkubectl", check: func() error { return errors.New("connection refused") }},
+	}))
+	e := httptest.New(t, app)
+	e.GET("/readyz").Expect().Status(http.StatusServiceUnavailable).
+		Body().Contains("[+]db ok").Contains("[-]webkubectl failed: connection refused")
+	e.GET("/readyz").WithQuery("exclude", "webkubectl").Expect().Status(http.StatusOK).Body().IsEqual("ok")
+	e.GET("/readyz").WithQuery("exclude", "webkubectl").WithQuery("verbose", "").Expect().Status(http.StatusOK).
+		Body().Contains("[+]webkubectl excluded: ok").Contains("readyz check passed")
+}
+
+func TestHealthCheck(t *testing.T) {
+	cases := map[string]v1Config.ServerConfig{
+		"http://127.0.0.1:80/kubepi/healthz":   {Bind: v1Config.BindConfig{Host: "0.0.0.0", Port: 80}},
+		"https://127.0.0.1:443/kubepi/healthz": {Bind: v1Config.BindConfig{Host: "::", Port: 443}, SSL: v1Config.SSLConfig{Enable: true}},
+		"http://[::1]:8080/kubepi/healthz":     {Bind: v1Config.BindConfig{Host: "::1", Port: 8080}},
+	}
+	for expect, c := range cases {
+		if got := healthCheckURL(c); got != expect {
+			t.Errorf("expect %s, got %s", expect, got)
+		}
+	}
+
+	status := http.StatusOK
+	s := gohttptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/kubepi/healthz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	defer s.Close()
+	host, port, _ := net.SplitHostPort(s.Listener.Addr().String())
+	c := getDefaultConfig()
+	c.Spec.Server.Bind.Host = host
+	c.Spec.Server.Bind.Port, _ = strconv.Atoi(port)
+	if err := HealthCheck(c, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	status = http.StatusServiceUnavailable
+	if err := HealthCheck(c, time.Second); err == nil {
+		t.Fatal("expect error")
+	}
+}
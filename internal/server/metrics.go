@@ -0,0 +1,75 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net"
+	"strings"
+
+	v1Config "github.com/ClusterOperator/kubepi/internal/model/v1/config"
+	"github.com/ClusterOperator/kubepi/pkg/metrics"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+)
+
+// setUpMetrics 注册 /kubepi/metrics，路由挂载在 app 上以绕过 rootRoute 的统一响应包装
+func (e *KubePiServer) setUpMetrics() {
+	c := e.config.Load().Spec.Metrics
+	if !c.Enable {
+		return
+	}
+	nets, err := parseAllowedIPs(c.AllowedIPs)
+	if err != nil {
+		panic(err)
+	}
+	if c.Token == "" && len(c.AllowedIPs) == 0 {
+		e.logger.Warn("neither metrics token nor allowed ips is configured, metrics can only be accessed from localhost")
+	}
+	e.app.Get("/kubepi/metrics", metricsAccessHandler(c, nets), iris.FromStd(metrics.Handler()))
+}
+
+func parseAllowedIPs(ips []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range ips {
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// metricsAccessHandler 配置了 token 时允许携带正确 Bearer token 的请求，配置了 allowedIPs 时允许来自其中的请求，
+// 两者都未配置时只允许本机访问
+func metricsAccessHandler(c v1Config.MetricsConfig, nets []*net.IPNet) iris.Handler {
+	return func(ctx *context.Context) {
+		if c.Token != "" {
+			token := strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(c.Token)) == 1 {
+				ctx.Next()
+				return
+			}
+		}
+		ip := net.ParseIP(ctx.RemoteAddr())
+		if ip != nil {
+			if c.Token == "" && len(nets) == 0 && ip.IsLoopback() {
+				ctx.Next()
+				return
+			}
+			for _, n := range nets {
+				if n.Contains(ip) {
+					ctx.Next()
+					return
+				}
+			}
+		}
+		ctx.StopWithStatus(iris.StatusForbidden)
+	}
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"testing"
+
+	v1Config "github.com/ClusterOperator/kubepi/internal/model/v1/config"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/httptest"
+)
+
+func TestMetricsAccessHandler(t *testing.T) {
+	nets, err := parseAllowedIPs([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := iris.New()
+	app.Get("/metrics", metricsAccessHandler(v1Config.MetricsConfig{Token: "secret"}, nets), func(ctx iris.Context) {
+		_, _ = ctx.WriteString("ok")
+	})
+	e := httptest.New(t, app)
+	e.GET("/metrics").Expect().Status(http.StatusForbidden)
+	e.GET("/metrics").WithHeader("Authorization", "Bearer wrong").Expect().Status(http.StatusForbidden)
+	e.GET("/metrics").WithHeader("Authorization", "Bearer secret").Expect().Status(http.StatusOK)
+
+	if !nets[0].Contains(net.ParseIP("10.1.2.3")) || !nets[1].Contains(net.ParseIP("192.168.1.1")) || nets[1].Contains(net.ParseIP("192.168.1.2")) {
+		t.Fatalf("unexpected allowed ips %v", nets)
+	}
+	if _, err := parseAllowedIPs([]string{"invalid"}); err == nil {
+		t.Fatal("expect error of invalid ip")
+	}
+}
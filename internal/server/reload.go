@@ -0,0 +1,57 @@
+package server
+
+import (
+	"reflect"
+
+	"github.com/ClusterOperator/kubepi/internal/config"
+	v1Config "github.com/ClusterOperator/kubepi/internal/model/v1/config"
+	"github.com/sirupsen/logrus"
+)
+
+// watchConfig 监听配置文件，日志级别、会话有效期、集群连接缓存时间和定时备份可以在运行时修改，其余配置修改后需要重启服务
+func (e *KubePiServer) watchConfig() {
+	config.WatchConfig(getDefaultConfig, e.reloadConfig, e.configCustomFilePath)
+}
+
+// reloadConfig 在当前配置的副本上应用可以热加载的配置，完成后整体替换当前配置
+func (e *KubePiServer) reloadConfig(c *v1Config.Config) {
+	current := e.config.Load()
+	next := *current
+	if c.Spec.Logger.Level != current.Spec.Logger.Level {
+		l, err := logrus.ParseLevel(c.Spec.Logger.Level)
+		if err != nil {
+			e.logger.Errorf("can not parse logger level %s, %s", c.Spec.Logger.Level, err.Error())
+		} else {
+			e.logger.SetLevel(l)
+			next.Spec.Logger = c.Spec.Logger
+			e.logger.Infof("logger level changed to %s", l.String())
+		}
+	}
+	if c.Spec.Session.Expires != current.Spec.Session.Expires {
+		setSessionExpires(c.Spec.Session.Expires)
+		next.Spec.Session = c.Spec.Session
+		e.logger.Infof("session expires changed to %d hours, applies to new sessions", c.Spec.Session.Expires)
+	}
+	next.Spec.ClientCache = c.Spec.ClientCache
+	next.Spec.InformerCache = c.Spec.InformerCache
+	// 定时备份每次执行时读取配置
+	next.Spec.Backup = c.Spec.Backup
+	e.config.Store(&next)
+	if c.Spec.ClientCache.TTL != current.Spec.ClientCache.TTL {
+		e.setUpClientCache()
+		e.logger.Infof("client cache ttl changed to %d seconds", c.Spec.ClientCache.TTL)
+	}
+	if c.Spec.InformerCache != current.Spec.InformerCache {
+		e.setUpInformerCache()
+		e.logger.Info("informer cache limits changed, informers will be restarted on next access")
+	}
+
+	// 命令行参数会覆盖监听地址，比较时忽略
+	c.Spec.Server.Bind = next.Spec.Server.Bind
+	if c.Spec.Jwt.Key == "" {
+		c.Spec.Jwt.Key = next.Spec.Jwt.Key
+	}
+	if !reflect.DeepEqual(c.Spec, next.Spec) {
+		e.logger.Warn("config file changed, restart the server to apply settings other than logger level, session expires, client cache ttl, informer cache limits and backup")
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"io"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestReloadConfig(t *testing.T) {
+	e := &KubePiServer{logger: logrus.New()}
+	e.logger.SetOutput(io.Discard)
+	e.config.Store(getDefaultConfig())
+	old := e.config.Load()
+	oldLevel, oldExpires := old.Spec.Logger.Level, old.Spec.Session.Expires
+
+	c := getDefaultConfig()
+	c.Spec.Logger.Level = "info"
+	c.Spec.Session.Expires = oldExpires + 1
+	c.Spec.Backup.Interval = 2
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_ = e.config.Load().Spec.Logger.Level
+		}
+	}()
+	e.reloadConfig(c)
+	<-done
+
+	if old.Spec.Logger.Level != oldLevel || old.Spec.Session.Expires != oldExpires {
+		t.Fatal("published config should not be modified")
+	}
+	current := e.config.Load()
+	if current.Spec.Logger.Level != "info" || current.Spec.Session.Expires != oldExpires+1 || current.Spec.Backup.Interval != 2 {
+		t.Fatalf("unexpected config %+v", current.Spec)
+	}
+	setSessionExpires(oldExpires)
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ClusterOperator/kubepi/pkg/storage"
+	"github.com/asdine/storm/v3"
+)
+
+const scheduleBucket = "schedule"
+
+// AcquireSchedule 为名称为 name 的定时任务抢占当前周期的执行权，
+// 多个实例共享同一个数据库时每个周期只有一个实例返回 true
+func AcquireSchedule(name string, interval time.Duration) (bool, error) {
+	return acquireSchedule(es.db, name, interval, time.Now())
+}
+
+func acquireSchedule(db storage.DB, name string, interval time.Duration, now time.Time) (bool, error) {
+	for {
+		u, ok := db.(interface{ Unwrap() storage.DB })
+		if !ok {
+			break
+		}
+		db = u.Unwrap()
+	}
+	period := now.Truncate(interval)
+	key := fmt.Sprintf("%s-%d", name, period.Unix())
+	acquired, err := insertOnce(db, key, InstanceID())
+	if err != nil || !acquired {
+		return acquired, err
+	}
+	// 清理之前周期的记录，失败不影响本次执行
+	_ = db.Delete(scheduleBucket, fmt.Sprintf("%s-%d", name, period.Add(-2*interval).Unix()))
+	return true, nil
+}
+
+// insertOnce 在 key 不存在时写入，支持 Inserter 的后端由数据库保证原子性，
+// bolt 只能被单个进程打开，写事务即可保证原子性
+func insertOnce(db storage.DB, key string, value string) (bool, error) {
+	if i, ok := db.(storage.Inserter); ok {
+		err := i.Insert(scheduleBucket, key, value)
+		if errors.Is(err, storm.ErrAlreadyExists) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	tx, err := db.Begin(true)
+	if err != nil {
+		return false, err
+	}
+	var holder string
+	err = tx.Get(scheduleBucket, key, &holder)
+	if err == nil {
+		_ = tx.Rollback()
+		return false, nil
+	}
+	if !errors.Is(err, storm.ErrNotFound) {
+		_ = tx.Rollback()
+		return false, err
+	}
+	if err := tx.Set(scheduleBucket, key, value); err != nil {
+		_ = tx.Rollback()
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/ClusterOperator/kubepi/pkg/storage"
+	"github.com/ClusterOperator/kubepi/pkg/storage/boltdb"
+	"github.com/ClusterOperator/kubepi/pkg/storage/sqldb"
+)
+
+func TestAcquireSchedule(t *testing.T) {
+	dir := t.TempDir()
+	bolt, err := boltdb.Open(path.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bolt.Close()
+	sqlite, err := sqldb.Open(storage.TypeSqlite, "file:"+path.Join(dir, "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.Close()
+
+	now := time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC)
+	for _, db := range []storage.DB{bolt, sqlite} {
+		steps := []struct {
+			name   string
+			now    time.Time
+			expect bool
+		}{
+			{"renew", now, true},
+			{"renew", now.Add(10 * time.Minute), false},
+			{"other", now, true},
+			{"renew", now.Add(time.Hour), true},
+		}
+		for _, s := range steps {
+			got, err := acquireSchedule(db, s.name, time.Hour, s.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != s.expect {
+				t.Errorf("%s %s at %s: expect %v, got %v", db.Type(), s.name, s.now, s.expect, got)
+			}
+		}
+	}
+}
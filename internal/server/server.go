@@ -7,9 +7,8 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"os"
-	"path"
 	"strings"
-	"time"
+	"sync/atomic"
 
 	"github.com/iris-contrib/swagger/v12"
 	"github.com/iris-contrib/swagger/v12/swaggerFiles"
@@ -20,10 +19,10 @@ import (
 	"github.com/ClusterOperator/kubepi/internal/config"
 	v1Config "github.com/ClusterOperator/kubepi/internal/model/v1/config"
 	"github.com/ClusterOperator/kubepi/migrate"
-	"github.com/ClusterOperator/kubepi/pkg/file"
 	"github.com/ClusterOperator/kubepi/pkg/i18n"
-	"github.com/asdine/storm/v3"
-	"github.com/coreos/etcd/pkg/fileutil"
+	"github.com/ClusterOperator/kubepi/pkg/kubernetes"
+	"github.com/ClusterOperator/kubepi/pkg/storage"
+	"github.com/ClusterOperator/kubepi/pkg/tunnel"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
 	"github.com/kataras/iris/v12/sessions"
@@ -45,7 +44,7 @@ type Option func(server *KubePiServer)
 func WithServerBindHost(host string) Option {
 	return func(server *KubePiServer) {
 		if host != "" {
-			server.config.Spec.Server.Bind.Host = host
+			server.config.Load().Spec.Server.Bind.Host = host
 		}
 	}
 }
@@ -53,7 +52,7 @@ func WithServerBindHost(host string) Option {
 func WithServerBindPort(port int) Option {
 	return func(server *KubePiServer) {
 		if port != 0 {
-			server.config.Spec.Server.Bind.Port = port
+			server.config.Load().Spec.Server.Bind.Port = port
 		}
 	}
 }
@@ -68,17 +67,19 @@ func WithCustomConfigFilePath(path string) Option {
 
 type KubePiServer struct {
 	app                  *iris.Application
-	db                   *storm.DB
+	db                   storage.DB
+	sessionDB            sessions.Database
+	advertiseAddress     string
 	logger               *logrus.Logger
 	configCustomFilePath string
-	config               *v1Config.Config
+	config               atomic.Pointer[v1Config.Config]
 	rootRoute            iris.Party
 }
 
 func NewKubePiSerer(opts ...Option) *KubePiServer {
 	c := &KubePiServer{}
 	c.app = iris.New()
-	c.config = getDefaultConfig()
+	c.config.Store(getDefaultConfig())
 	for _, op := range opts {
 		op(c)
 	}
@@ -90,7 +91,7 @@ func NewKubePiSerer(opts ...Option) *KubePiServer {
 }
 
 func (e *KubePiServer) setUpConfig() {
-	err := config.ReadConfig(e.config, e.configCustomFilePath)
+	err := config.ReadConfig(e.config.Load(), e.configCustomFilePath)
 	if err != nil {
 		panic(err)
 	}
@@ -101,26 +102,24 @@ func (e *KubePiServer) setUpLogger() {
 	klog.SetOutput(nil)
 	klog.V(0).Info("This log won't be printed.")
 	e.logger = logrus.New()
-	l, err := logrus.ParseLevel(e.config.Spec.Logger.Level)
+	l, err := logrus.ParseLevel(e.config.Load().Spec.Logger.Level)
 	if err != nil {
-		fmt.Printf("cant not parse logger level %s, %s,use default level: INFO", e.config.Spec.Logger.Level, err)
+		fmt.Printf("cant not parse logger level %s, %s,use default level: INFO", e.config.Load().Spec.Logger.Level, err)
 		return
 	}
 	e.logger.SetLevel(l)
 }
 
 func (e *KubePiServer) setUpDB() {
-	realDir := file.ReplaceHomeDir(e.config.Spec.DB.Path)
-	if !fileutil.Exist(realDir) {
-		if err := os.MkdirAll(realDir, 0755); err != nil {
-			panic(fmt.Errorf("can not create database dir: %s message: %s", e.config.Spec.DB.Path, err))
-		}
+	d, err := OpenDB(e.config.Load().Spec.DB)
+	if err != nil {
+		panic(err)
 	}
-	d, err := storm.Open(path.Join(realDir, "kubepi.db"))
+	e.logger.Infof("using %s database", d.Type())
+	e.db, err = WithEncryption(d, e.config.Load().Spec.Encryption)
 	if err != nil {
 		panic(err)
 	}
-	e.db = d
 }
 
 func (e *KubePiServer) setUpRootRoute() {
@@ -152,7 +151,14 @@ func (e *KubePiServer) setUpStaticFile() {
 }
 
 func (e *KubePiServer) setUpSession() {
-	SessionMgr = sessions.New(sessions.Config{Cookie: SessionCookieName, AllowReclaim: true, Expires: time.Duration(e.config.Spec.Session.Expires) * time.Hour})
+	db, err := OpenSessionDB(e.config.Load().Spec.Session, e.config.Load().Spec.DB)
+	if err != nil {
+		panic(err)
+	}
+	e.sessionDB = db
+	setSessionExpires(e.config.Load().Spec.Session.Expires)
+	SessionMgr = sessions.New(sessions.Config{Cookie: SessionCookieName, AllowReclaim: true, Expires: SessionExpires()})
+	SessionMgr.UseDatabase(reloadableExpiresDB{Database: db})
 	e.rootRoute.Use(SessionMgr.Handler())
 }
 
@@ -249,6 +255,12 @@ func (e *KubePiServer) setUpErrHandler() {
 }
 
 func (e *KubePiServer) runMigrations() {
+	name, err := BackupBeforeMigrate(e.db, e.config.Load().Spec.Backup, migrate.LatestVersion())
+	if err != nil {
+		e.logger.Warnf("can not create backup before migration: %s", err.Error())
+	} else if name != "" {
+		e.logger.Infof("backup %s created before migration", name)
+	}
 	migrate.RunMigrate(e.db, e.logger)
 }
 func (e *KubePiServer) setWebkubectlProxy() {
@@ -258,7 +270,7 @@ func (e *KubePiServer) setWebkubectlProxy() {
 			ctx.Request().URL.Path = strings.ReplaceAll(ctx.Request().URL.Path, "root", "")
 			ctx.Request().RequestURI = strings.ReplaceAll(ctx.Request().RequestURI, "root", "")
 		}
-		u, _ := url.Parse("http://localhost:8080")
+		u, _ := url.Parse("http://" + webkubectlAddr)
 		proxy := httputil.NewSingleHostReverseProxy(u)
 		proxy.ModifyResponse = func(resp *http.Response) error {
 			if resp.StatusCode == iris.StatusMovedPermanently {
@@ -275,6 +287,13 @@ func (e *KubePiServer) setWebkubectlProxy() {
 	e.rootRoute.Any("webkubectl", handler)
 }
 
+// setUpTunnel 启动 reverse 模式集群使用的本地代理，访问集群的请求经由代理进入 agent 建立的隧道
+func (e *KubePiServer) setUpTunnel() {
+	if err := tunnel.StartDefaultProxy(); err != nil {
+		e.logger.Errorf("can not start tunnel proxy: %s", err.Error())
+	}
+}
+
 func (e *KubePiServer) setUpTtyEntrypoint() {
 	f, err := os.OpenFile("init-kube.sh", os.O_CREATE|os.O_RDWR, 0755)
 	if err != nil {
@@ -293,10 +312,19 @@ func (e *KubePiServer) bootstrap() *KubePiServer {
 	e.setUpLogger()
 	e.setUpDB()
 	e.setUpSession()
+	e.setUpInstance()
+	e.watchConfig()
 	e.setResultHandler()
 	e.setUpErrHandler()
+	e.setUpMetrics()
+	e.setUpHealthCheck()
 	e.setWebkubectlProxy()
+	e.setUpTunnel()
+	e.setUpClientCache()
+	e.setUpInformerCache()
 	e.runMigrations()
+	e.encryptCredentials()
+	e.startBackupSchedule()
 	e.setUpTtyEntrypoint()
 	e.startTty()
 	return e
@@ -304,12 +332,13 @@ func (e *KubePiServer) bootstrap() *KubePiServer {
 
 var es *KubePiServer
 
-func DB() *storm.DB {
+func DB() storage.DB {
 	return es.db
 }
 
+// Config 返回当前配置，配置热加载后返回新的配置，调用方不能修改返回的配置
 func Config() *v1Config.Config {
-	return es.config
+	return es.config.Load()
 }
 
 func Logger() *logrus.Logger {
@@ -319,7 +348,24 @@ func Logger() *logrus.Logger {
 func Listen(route func(party iris.Party), options ...Option) error {
 	es = NewKubePiSerer(options...)
 	route(es.rootRoute)
-	return es.app.Run(iris.Addr(fmt.Sprintf("%s:%d", es.config.Spec.Server.Bind.Host, es.config.Spec.Server.Bind.Port)))
+	if es.config.Load().Spec.Server.SSL.Enable {
+		ln, err := es.tlsListener()
+		if err != nil {
+			return err
+		}
+		es.startHttpRedirect()
+		return es.app.Run(iris.Listener(ln))
+	}
+	return es.app.Run(iris.Addr(fmt.Sprintf("%s:%d", es.config.Load().Spec.Server.Bind.Host, es.config.Load().Spec.Server.Bind.Port)))
+}
+
+// LoadConfig 读取配置文件，供不启动服务的命令行工具使用
+func LoadConfig(customConfigFilePath string) (*v1Config.Config, error) {
+	c := getDefaultConfig()
+	if err := config.ReadConfig(c, customConfigFilePath); err != nil {
+		return nil, err
+	}
+	return c, nil
 }
 
 func getDefaultConfig() *v1Config.Config {
@@ -337,14 +383,33 @@ func getDefaultConfig() *v1Config.Config {
 				},
 				SSL: v1Config.SSLConfig{
 					Enable: false,
+					Redirect: v1Config.RedirectConfig{
+						Enable: false,
+						Port:   80,
+					},
 				},
 			},
 			DB: v1Config.DBConfig{
+				Type: storage.TypeBolt,
 				Path: "/var/lib/kubepi/db",
 			},
 			Session: v1Config.SessionConfig{
 				Expires: 72,
 			},
+			Backup: v1Config.BackupConfig{
+				Enable:    false,
+				Dir:       "/var/lib/kubepi/backup",
+				Interval:  24,
+				Retention: 7,
+			},
+			ClientCache: v1Config.ClientCacheConfig{
+				TTL: int(kubernetes.DefaultCacheTTL.Seconds()),
+			},
+			InformerCache: v1Config.InformerCacheConfig{
+				IdleTimeout:  int(kubernetes.DefaultInformerIdleTimeout.Seconds()),
+				MaxStaleness: int(kubernetes.DefaultInformerMaxStaleness.Seconds()),
+				MaxObjects:   kubernetes.DefaultInformerMaxObjects,
+			},
 			Logger: v1Config.LoggerConfig{Level: "debug"},
 			Jwt:    v1Config.JwtConfig{},
 		},
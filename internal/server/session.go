@@ -0,0 +1,218 @@
+package server
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"path"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	v1Config "github.com/ClusterOperator/kubepi/internal/model/v1/config"
+	"github.com/ClusterOperator/kubepi/pkg/file"
+	"github.com/kataras/golog"
+	"github.com/kataras/iris/v12/core/memstore"
+	"github.com/kataras/iris/v12/sessions"
+	"github.com/kataras/iris/v12/sessions/sessiondb/boltdb"
+	"github.com/kataras/iris/v12/sessions/sessiondb/redis"
+)
+
+const (
+	SessionStoreMemory = "memory"
+	SessionStoreBolt   = "bolt"
+	SessionStoreRedis  = "redis"
+
+	sessionFileName = "kubepi-session.db"
+)
+
+func init() {
+	// bolt 和 redis 会话存储需要序列化会话中的数据，使用 gob 以便读取时还原为原来的类型，
+	// 存入会话的结构体需要通过 gob.Register 注册
+	sessions.DefaultTranscoder = gobTranscoder{}
+}
+
+// sessionExpires 保存当前的会话有效期，配置热加载后对新建的会话生效
+var sessionExpires atomic.Int64
+
+func SessionExpires() time.Duration {
+	return time.Duration(sessionExpires.Load())
+}
+
+func setSessionExpires(hours int) {
+	sessionExpires.Store(int64(time.Duration(hours) * time.Hour))
+}
+
+// OpenSessionDB 根据配置打开会话存储，未配置时使用内存存储，
+// 内存存储只在当前进程中有效，多副本部署时需要使用 redis
+func OpenSessionDB(c v1Config.SessionConfig, dbConfig v1Config.DBConfig) (db sessions.Database, err error) {
+	switch c.Store {
+	case "", SessionStoreMemory:
+		db = newMemorySessionDB()
+	case SessionStoreBolt:
+		p := c.Path
+		if p == "" {
+			p = path.Join(dbConfig.Path, sessionFileName)
+		}
+		db, err = boltdb.New(file.ReplaceHomeDir(p), 0600)
+		if err != nil {
+			return nil, err
+		}
+	case SessionStoreRedis:
+		// iris 在无法连接 redis 时会 panic
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("can not connect to redis %s: %v", c.Redis.Addr, r)
+			}
+		}()
+		db = redis.New(redis.Config{
+			Network:   redis.DefaultRedisNetwork,
+			Addr:      c.Redis.Addr,
+			Username:  c.Redis.Username,
+			Password:  c.Redis.Password,
+			Database:  strconv.Itoa(c.Redis.DB),
+			MaxActive: 10,
+			Timeout:   redis.DefaultRedisTimeout,
+			Prefix:    c.Redis.Prefix,
+			Driver:    redis.GoRedis(),
+		})
+	default:
+		return nil, fmt.Errorf("unsupported session store %s", c.Store)
+	}
+	db.SetLogger(golog.Default)
+	return db, nil
+}
+
+// reloadableExpiresDB 使新会话的有效期取自 SessionExpires 而不是会话管理器创建时的配置，
+// 从而在配置热加载后对新会话生效
+type reloadableExpiresDB struct {
+	sessions.Database
+}
+
+func (d reloadableExpiresDB) Acquire(sid string, expires time.Duration) sessions.LifeTime {
+	if e := SessionExpires(); e > 0 {
+		expires = e
+	}
+	if t := d.Database.Acquire(sid, expires).Time; !t.IsZero() {
+		return sessions.LifeTime{Time: t}
+	}
+	if expires > 0 {
+		return sessions.LifeTime{Time: time.Now().Add(expires)}
+	}
+	return sessions.LifeTime{}
+}
+
+type gobTranscoder struct{}
+
+func (gobTranscoder) Marshal(value interface{}) ([]byte, error) {
+	return sessions.GobTranscoder{}.Marshal(value)
+}
+
+// Unmarshal 数据总是以 interface 的形式编码，解码到具体类型时需要先解码为 interface
+func (gobTranscoder) Unmarshal(b []byte, outPtr interface{}) error {
+	if _, ok := outPtr.(*interface{}); ok {
+		return gob.NewDecoder(bytes.NewBuffer(b)).Decode(outPtr)
+	}
+	var v interface{}
+	if err := gob.NewDecoder(bytes.NewBuffer(b)).Decode(&v); err != nil {
+		return err
+	}
+	out := reflect.ValueOf(outPtr).Elem()
+	value := reflect.ValueOf(v)
+	if !value.Type().AssignableTo(out.Type()) {
+		return fmt.Errorf("can not decode %s into %s", value.Type(), out.Type())
+	}
+	out.Set(value)
+	return nil
+}
+
+// memorySessionDB 是基于内存的会话存储，与 iris 默认实现相同，iris 没有导出默认实现，
+// 而会话管理器需要通过 UseDatabase 使用 reloadableExpiresDB 包装后的存储
+type memorySessionDB struct {
+	values map[string]*memstore.Store
+	mu     sync.RWMutex
+}
+
+var _ sessions.Database = (*memorySessionDB)(nil)
+
+func newMemorySessionDB() *memorySessionDB {
+	return &memorySessionDB{values: make(map[string]*memstore.Store)}
+}
+
+func (s *memorySessionDB) SetLogger(*golog.Logger) {}
+
+func (s *memorySessionDB) Acquire(sid string, _ time.Duration) sessions.LifeTime {
+	s.mu.Lock()
+	s.values[sid] = new(memstore.Store)
+	s.mu.Unlock()
+	return sessions.LifeTime{}
+}
+
+func (s *memorySessionDB) OnUpdateExpiration(string, time.Duration) error { return nil }
+
+func (s *memorySessionDB) store(sid string) (*memstore.Store, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	store, ok := s.values[sid]
+	return store, ok
+}
+
+func (s *memorySessionDB) Set(sid string, key string, value interface{}, _ time.Duration, immutable bool) error {
+	if store, ok := s.store(sid); ok {
+		store.Save(key, value, immutable)
+	}
+	return nil
+}
+
+func (s *memorySessionDB) Get(sid string, key string) interface{} {
+	if store, ok := s.store(sid); ok {
+		return store.Get(key)
+	}
+	return nil
+}
+
+func (s *memorySessionDB) Decode(sid string, key string, outPtr interface{}) error {
+	if v := s.Get(sid, key); v != nil {
+		reflect.ValueOf(outPtr).Elem().Set(reflect.ValueOf(v))
+	}
+	return nil
+}
+
+func (s *memorySessionDB) Visit(sid string, cb func(key string, value interface{})) error {
+	if store, ok := s.store(sid); ok {
+		store.Visit(cb)
+	}
+	return nil
+}
+
+func (s *memorySessionDB) Len(sid string) int {
+	if store, ok := s.store(sid); ok {
+		return store.Len()
+	}
+	return 0
+}
+
+func (s *memorySessionDB) Delete(sid string, key string) bool {
+	if store, ok := s.store(sid); ok {
+		return store.Remove(key)
+	}
+	return false
+}
+
+func (s *memorySessionDB) Clear(sid string) error {
+	if store, ok := s.store(sid); ok {
+		store.Reset()
+	}
+	return nil
+}
+
+func (s *memorySessionDB) Release(sid string) error {
+	s.mu.Lock()
+	delete(s.values, sid)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memorySessionDB) Close() error { return nil }
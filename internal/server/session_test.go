@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/gob"
+	"path"
+	"testing"
+	"time"
+
+	v1Config "github.com/ClusterOperator/kubepi/internal/model/v1/config"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/kataras/iris/v12/sessions"
+)
+
+type testProfile struct {
+	Name string
+}
+
+func TestGobTranscoder(t *testing.T) {
+	gob.Register(testProfile{})
+	bs, err := gobTranscoder{}.Marshal(testProfile{Name: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v interface{}
+	if err := (gobTranscoder{}).Unmarshal(bs, &v); err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := v.(testProfile); !ok || p.Name != "admin" {
+		t.Fatalf("unexpected value %#v", v)
+	}
+	var p testProfile
+	if err := (gobTranscoder{}).Unmarshal(bs, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "admin" {
+		t.Fatalf("unexpected value %#v", p)
+	}
+	var s string
+	if err := (gobTranscoder{}).Unmarshal(bs, &s); err == nil {
+		t.Fatal("expect error when decoding into mismatched type")
+	}
+}
+
+func TestTicket(t *testing.T) {
+	setSessionExpires(1)
+	type data struct {
+		Cluster string `json:"cluster"`
+	}
+
+	es = &KubePiServer{sessionDB: newMemorySessionDB()}
+	if err := SaveTicket(TicketKindTerminal, "id", data{Cluster: "c1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTicket(TicketKindTerminal, "id"); err != ErrTicketNotFound {
+		t.Fatalf("memory store should not keep tickets, got %v", err)
+	}
+
+	db, err := OpenSessionDB(v1Config.SessionConfig{Store: SessionStoreBolt, Path: path.Join(t.TempDir(), sessionFileName)}, v1Config.DBConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	es = &KubePiServer{sessionDB: db, advertiseAddress: "http://10.0.0.1:80"}
+	if err := SaveTicket(TicketKindTerminal, "id", data{Cluster: "c1"}); err != nil {
+		t.Fatal(err)
+	}
+	ticket, err := LoadTicket(TicketKindTerminal, "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ticket.Instance != InstanceID() || ticket.Address != "http://10.0.0.1:80" {
+		t.Fatalf("unexpected ticket %#v", ticket)
+	}
+	var d data
+	if err := LoadTicketData(TicketKindTerminal, "id", &d); err != nil || d.Cluster != "c1" {
+		t.Fatalf("unexpected ticket data %#v, %v", d, err)
+	}
+	DeleteTicket(TicketKindTerminal, "id")
+	if _, err := LoadTicket(TicketKindTerminal, "id"); err != ErrTicketNotFound {
+		t.Fatalf("ticket should be deleted, got %v", err)
+	}
+}
+
+func TestReloadableExpiresDB(t *testing.T) {
+	setSessionExpires(2)
+	db := reloadableExpiresDB{Database: newMemorySessionDB()}
+	lt := db.Acquire("sid", time.Hour)
+	if d := time.Until(lt.Time); d < time.Hour || d > 2*time.Hour {
+		t.Fatalf("expect session to expire in 2 hours, got %s", d)
+	}
+}
+
+func TestRedisSessionDB(t *testing.T) {
+	setSessionExpires(1)
+	gob.Register(testProfile{})
+	mr := miniredis.RunT(t)
+	c := v1Config.SessionConfig{Store: SessionStoreRedis, Redis: v1Config.RedisConfig{Addr: mr.Addr(), Prefix: "kubepi:"}}
+	open := func() sessions.Database {
+		db, err := OpenSessionDB(c, v1Config.DBConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = db.Close() })
+		return db
+	}
+
+	// 两个实例连接同一个 redis，一个实例写入的会话和票据另一个实例可以读取
+	db := open()
+	db.Acquire("sid", time.Hour)
+	if err := db.Set("sid", "profile", testProfile{Name: "admin"}, time.Hour, false); err != nil {
+		t.Fatal(err)
+	}
+	es = &KubePiServer{sessionDB: db, advertiseAddress: "http://10.0.0.1:80"}
+	if err := SaveTicket(TicketKindWebkubectl, "id", "c1"); err != nil {
+		t.Fatal(err)
+	}
+
+	other := open()
+	var p testProfile
+	if err := other.Decode("sid", "profile", &p); err != nil || p.Name != "admin" {
+		t.Fatalf("unexpected profile %#v, %v", p, err)
+	}
+	es = &KubePiServer{sessionDB: other}
+	ticket, err := LoadTicket(TicketKindWebkubectl, "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ticket.Address != "http://10.0.0.1:80" {
+		t.Fatalf("unexpected ticket %#v", ticket)
+	}
+	DeleteTicket(TicketKindWebkubectl, "id")
+	es = &KubePiServer{sessionDB: db}
+	if _, err := LoadTicket(TicketKindWebkubectl, "id"); err != ErrTicketNotFound {
+		t.Fatalf("ticket should be deleted, got %v", err)
+	}
+
+	mr.Close()
+	if _, err := OpenSessionDB(c, v1Config.DBConfig{}); err == nil {
+		t.Fatal("expect error when redis is unreachable")
+	}
+}
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+)
+
+const (
+	TicketKindTerminal   = "terminal"
+	TicketKindLogging    = "logging"
+	TicketKindWebkubectl = "webkubectl"
+	TicketKindTunnel     = "tunnel"
+
+	ticketKey       = "ticket"
+	forwardedHeader = "X-KubePi-Forwarded-By"
+)
+
+var ErrTicketNotFound = errors.New("ticket not found")
+
+// Ticket 记录终端、日志等长连接会话的创建参数及创建会话的实例，保存在共享的会话存储中，
+// 连接落到其他副本时可以据此在当前实例恢复会话，或者转发到创建会话的实例
+type Ticket struct {
+	Instance string          `json:"instance"`
+	Address  string          `json:"address"`
+	Data     json.RawMessage `json:"data"`
+}
+
+func ticketSID(kind, id string) string {
+	return fmt.Sprintf("ticket-%s-%s", kind, id)
+}
+
+// sharedTickets 内存会话存储只在当前进程中有效，此时会话总是由创建它的实例处理，无需保存 ticket
+func sharedTickets() bool {
+	_, ok := es.sessionDB.(*memorySessionDB)
+	return !ok
+}
+
+// SaveTicket 保存会话的创建参数，有效期与登录会话相同，会话结束时需要调用 DeleteTicket
+func SaveTicket(kind, id string, data interface{}) error {
+	if !sharedTickets() {
+		return nil
+	}
+	bs, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	tbs, err := json.Marshal(Ticket{Instance: InstanceID(), Address: es.advertiseAddress, Data: bs})
+	if err != nil {
+		return err
+	}
+	sid := ticketSID(kind, id)
+	es.sessionDB.Acquire(sid, SessionExpires())
+	return es.sessionDB.Set(sid, ticketKey, string(tbs), SessionExpires(), false)
+}
+
+func LoadTicket(kind, id string) (*Ticket, error) {
+	if !sharedTickets() {
+		return nil, ErrTicketNotFound
+	}
+	s, ok := es.sessionDB.Get(ticketSID(kind, id), ticketKey).(string)
+	if !ok {
+		return nil, ErrTicketNotFound
+	}
+	var t Ticket
+	if err := json.Unmarshal([]byte(s), &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// LoadTicketData 读取会话的创建参数到 data 中
+func LoadTicketData(kind, id string, data interface{}) error {
+	t, err := LoadTicket(kind, id)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(t.Data, data)
+}
+
+func DeleteTicket(kind, id string) {
+	if !sharedTickets() {
+		return
+	}
+	if err := es.sessionDB.Release(ticketSID(kind, id)); err != nil {
+		es.logger.Debugf("delete %s ticket %s failed: %s", kind, id, err.Error())
+	}
+}
+
+// SessionIDFunc 从请求中读取会话 ID
+type SessionIDFunc func(ctx *context.Context) string
+
+// SockJSSessionID 读取前端创建 sockjs 连接时放在查询参数中的会话 ID，例如 /sockjs?<id>，
+// sockjs 后续的 info、websocket 和 xhr 请求会保留这个查询参数。同时兼容 session=<id> 的写法
+func SockJSSessionID(ctx *context.Context) string {
+	if id := ctx.URLParam("session"); id != "" {
+		return id
+	}
+	for _, kv := range strings.Split(ctx.Request().URL.RawQuery, "&") {
+		key, value, _ := strings.Cut(kv, "=")
+		if key == "" || value != "" {
+			continue
+		}
+		if id, err := url.QueryUnescape(key); err == nil {
+			return id
+		}
+	}
+	return ""
+}
+
+// URLParamSessionID 从名称为 name 的查询参数中读取会话 ID
+func URLParamSessionID(name string) SessionIDFunc {
+	return func(ctx *context.Context) string {
+		return ctx.URLParam(name)
+	}
+}
+
+// ForwardToOwner 请求指定的会话由其他实例创建时，将请求转发到该实例，由创建会话的实例清理会话；
+// 没有指定会话或者转发失败时由当前实例处理，在绑定会话时根据 ticket 恢复会话
+func ForwardToOwner(kind string, sessionID SessionIDFunc) iris.Handler {
+	return func(ctx *context.Context) {
+		id := sessionID(ctx)
+		if id == "" || ctx.GetHeader(forwardedHeader) != "" {
+			ctx.Next()
+			return
+		}
+		t, err := LoadTicket(kind, id)
+		if err != nil || t.Instance == InstanceID() || t.Address == "" {
+			ctx.Next()
+			return
+		}
+		u, err := url.Parse(t.Address)
+		if err != nil {
+			ctx.Next()
+			return
+		}
+		transport, err := replicaTransport(u)
+		if err != nil {
+			es.logger.Errorf("forward %s session %s to %s failed: %s", kind, id, t.Address, err.Error())
+			ctx.Next()
+			return
+		}
+		defer transport.CloseIdleConnections()
+		unreachable := false
+		proxy := httputil.NewSingleHostReverseProxy(u)
+		proxy.Transport = transport
+		proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			es.logger.Warnf("forward %s session %s to %s failed: %s", kind, id, t.Address, err.Error())
+			unreachable = true
+		}
+		ctx.Request().Header.Set(forwardedHeader, InstanceID())
+		proxy.ServeHTTP(ctx.ResponseWriter(), ctx.Request())
+		if unreachable && ctx.ResponseWriter().Written() < 0 {
+			ctx.Request().Header.Del(forwardedHeader)
+			ctx.Next()
+		}
+	}
+}
+
+// DialOwner 以 websocket 连接创建 ticket 的实例上的 path，用于无法按请求转发的连接，例如经由 agent 隧道访问集群
+func DialOwner(t *Ticket, path string, header http.Header) (*websocket.Conn, error) {
+	if t.Instance == InstanceID() || t.Address == "" {
+		return nil, fmt.Errorf("can not dial the owner of ticket from %s", t.Instance)
+	}
+	u, err := url.Parse(t.Address)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := replicaTransport(u)
+	if err != nil {
+		return nil, err
+	}
+	wsURL := *u
+	wsURL.Scheme = "ws"
+	if u.Scheme == "https" {
+		wsURL.Scheme = "wss"
+	}
+	wsURL.Path = path
+	h := header.Clone()
+	if h == nil {
+		h = http.Header{}
+	}
+	h.Set(forwardedHeader, InstanceID())
+	dialer := websocket.Dialer{TLSClientConfig: transport.TLSClientConfig, HandshakeTimeout: 10 * time.Second}
+	ws, resp, err := dialer.Dial(wsURL.String(), h)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("dial %s failed: %s", t.Address, resp.Status)
+		}
+		return nil, err
+	}
+	return ws, nil
+}
+
+// replicaTransport 返回访问其他副本的 Transport，https 地址使用与服务端相同的证书配置校验对方证书
+func replicaTransport(u *url.URL) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	ssl := es.config.Load().Spec.Server.SSL
+	if u.Scheme != "https" || !ssl.Enable {
+		return transport, nil
+	}
+	tlsConfig, err := peerTLSConfig(ssl, u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+var instanceID = func() string {
+	if name, err := os.Hostname(); err == nil && name != "" {
+		return name
+	}
+	return fmt.Sprintf("kubepi-%d", time.Now().UnixNano())
+}()
+
+// InstanceID 返回当前实例的标识
+func InstanceID() string {
+	return instanceID
+}
+
+func (e *KubePiServer) setUpInstance() {
+	e.advertiseAddress = e.config.Load().Spec.Server.AdvertiseAddress
+	if e.advertiseAddress != "" {
+		return
+	}
+	scheme := "http"
+	if e.config.Load().Spec.Server.SSL.Enable {
+		scheme = "https"
+	}
+	ip := localIP()
+	if ip == "" {
+		e.logger.Warn("can not detect local ip, sessions can not be forwarded to this instance, please set server.advertiseAddress")
+		return
+	}
+	e.advertiseAddress = fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(ip, fmt.Sprint(e.config.Load().Spec.Server.Bind.Port)))
+}
+
+func localIP() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+	for _, addr := range addrs {
+		if n, ok := addr.(*net.IPNet); ok && !n.IP.IsLoopback() && n.IP.To4() != nil {
+			return n.IP.String()
+		}
+	}
+	return ""
+}
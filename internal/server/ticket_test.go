@@ -0,0 +1,95 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	gohttptest "net/http/httptest"
+	"path"
+	"testing"
+
+	v1Config "github.com/ClusterOperator/kubepi/internal/model/v1/config"
+	"github.com/gorilla/websocket"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+	"github.com/kataras/iris/v12/httptest"
+	"github.com/sirupsen/logrus"
+)
+
+func TestForwardToOwner(t *testing.T) {
+	setSessionExpires(1)
+	db, err := OpenSessionDB(v1Config.SessionConfig{Store: SessionStoreBolt, Path: path.Join(t.TempDir(), sessionFileName)}, v1Config.DBConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	es = &KubePiServer{sessionDB: db, logger: logrus.New()}
+	es.logger.SetOutput(io.Discard)
+	es.config.Store(getDefaultConfig())
+
+	owner := gohttptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("owner " + r.URL.Path))
+	}))
+	bs, _ := json.Marshal(Ticket{Instance: "other", Address: owner.URL})
+	sid := ticketSID(TicketKindTerminal, "abc")
+	db.Acquire(sid, SessionExpires())
+	if err := db.Set(sid, ticketKey, string(bs), SessionExpires(), false); err != nil {
+		t.Fatal(err)
+	}
+
+	app := iris.New()
+	app.Get("/sockjs/{p:path}", ForwardToOwner(TicketKindTerminal, SockJSSessionID), func(ctx *context.Context) {
+		_, _ = ctx.WriteString("local " + SockJSSessionID(ctx))
+	})
+	e := httptest.New(t, app)
+	e.GET("/sockjs/info").WithQueryString("abc&t=1").Expect().Body().IsEqual("owner /sockjs/info")
+	e.GET("/sockjs/info").WithQueryString("session=abc").Expect().Body().IsEqual("owner /sockjs/info")
+	e.GET("/sockjs/info").WithQueryString("def&t=1").Expect().Body().IsEqual("local def")
+
+	owner.Close()
+	e.GET("/sockjs/info").WithQueryString("abc&t=2").Expect().Body().IsEqual("local abc")
+}
+
+func TestDialOwner(t *testing.T) {
+	es = &KubePiServer{logger: logrus.New()}
+	es.config.Store(getDefaultConfig())
+
+	upgrader := websocket.Upgrader{}
+	owner := gohttptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/forward" || r.Header.Get("X-Test") != "1" || r.Header.Get(forwardedHeader) != InstanceID() {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		mt, bs, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		_ = ws.WriteMessage(mt, append([]byte("owner "), bs...))
+	}))
+	defer owner.Close()
+
+	header := http.Header{}
+	header.Set("X-Test", "1")
+	if _, err := DialOwner(&Ticket{Instance: InstanceID(), Address: owner.URL}, "/forward", header); err == nil {
+		t.Fatal("expect error when the ticket is created by current instance")
+	}
+	if _, err := DialOwner(&Ticket{Instance: "other", Address: owner.URL}, "/forward", nil); err == nil {
+		t.Fatal("expect error when the owner rejects the request")
+	}
+	ws, err := DialOwner(&Ticket{Instance: "other", Address: owner.URL}, "/forward", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	if err := ws.WriteMessage(websocket.BinaryMessage, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if _, bs, err := ws.ReadMessage(); err != nil || string(bs) != "owner ping" {
+		t.Fatalf("unexpected message %q %v", bs, err)
+	}
+}
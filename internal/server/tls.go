@@ -0,0 +1,234 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	v1Config "github.com/ClusterOperator/kubepi/internal/model/v1/config"
+	"github.com/ClusterOperator/kubepi/pkg/file"
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+// certReloader 持有当前生效的证书，并在证书文件变化后重新加载，
+// 使 cert-manager 等工具轮换证书后无需重启服务
+type certReloader struct {
+	lock     sync.RWMutex
+	certFile string
+	keyFile  string
+	caFile   string
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	logger   *logrus.Logger
+}
+
+func newCertReloader(c v1Config.SSLConfig, logger *logrus.Logger) (*certReloader, error) {
+	if c.Certificate == "" || c.CertificateKey == "" {
+		return nil, errors.New("ssl is enabled but certificate or certificateKey is empty")
+	}
+	r := &certReloader{
+		certFile: file.ReplaceHomeDir(c.Certificate),
+		keyFile:  file.ReplaceHomeDir(c.CertificateKey),
+		logger:   logger,
+	}
+	if c.ClientAuth.Enable {
+		if c.ClientAuth.CaCertificate == "" {
+			return nil, errors.New("ssl client auth is enabled but caCertificate is empty")
+		}
+		r.caFile = file.ReplaceHomeDir(c.ClientAuth.CaCertificate)
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("can not load certificate %s: %s", r.certFile, err.Error())
+	}
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		bs, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return fmt.Errorf("can not read client ca certificate %s: %s", r.caFile, err.Error())
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bs) {
+			return fmt.Errorf("can not parse client ca certificate %s", r.caFile)
+		}
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.cert = &cert
+	r.clientCA = pool
+	return nil
+}
+
+// watch 监听证书所在目录而不是文件本身，因为 kubernetes secret 挂载是通过替换软链接完成更新的
+func (r *certReloader) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	dirs := map[string]struct{}{}
+	for _, f := range []string{r.certFile, r.keyFile, r.caFile} {
+		if f != "" {
+			dirs[filepath.Dir(f)] = struct{}{}
+		}
+	}
+	for d := range dirs {
+		if err := watcher.Add(d); err != nil {
+			_ = watcher.Close()
+			return err
+		}
+	}
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) == 0 {
+					continue
+				}
+				if err := r.reload(); err != nil {
+					// 证书和私钥可能尚未全部写入，保留旧证书等待下一次事件
+					r.logger.Debugf("reload certificate failed, keep the current one: %s", err.Error())
+					continue
+				}
+				r.logger.Infof("certificate %s reloaded", r.certFile)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				r.logger.Errorf("watch certificate failed: %s", err.Error())
+			}
+		}
+	}()
+	return nil
+}
+
+func (r *certReloader) tlsConfig() *tls.Config {
+	base := &tls.Config{MinVersion: tls.VersionTLS12}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.lock.RLock()
+		defer r.lock.RUnlock()
+		c := base.Clone()
+		c.GetConfigForClient = nil
+		c.Certificates = []tls.Certificate{*r.cert}
+		if r.clientCA != nil {
+			c.ClientCAs = r.clientCA
+			c.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		return c, nil
+	}
+	return base
+}
+
+// peerTLSConfig 返回访问本机或其他副本时使用的 TLS 配置。副本之间通常共享同一张证书，
+// 对方证书与配置的证书相同时直接信任，否则使用系统根证书、证书文件中的证书链和客户端 CA 校验证书和 serverName。
+// 开启客户端认证时使用服务端证书作为客户端证书，需要服务端证书由客户端 CA 签发
+func peerTLSConfig(c v1Config.SSLConfig, serverName string) (*tls.Config, error) {
+	certFile := file.ReplaceHomeDir(c.Certificate)
+	cert, err := tls.LoadX509KeyPair(certFile, file.ReplaceHomeDir(c.CertificateKey))
+	if err != nil {
+		return nil, err
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	files := []string{certFile}
+	if c.ClientAuth.Enable && c.ClientAuth.CaCertificate != "" {
+		files = append(files, file.ReplaceHomeDir(c.ClientAuth.CaCertificate))
+	}
+	for _, f := range files {
+		if bs, err := os.ReadFile(f); err == nil {
+			roots.AppendCertsFromPEM(bs)
+		}
+	}
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// 证书由 VerifyPeerCertificate 校验，回环地址和副本的 IP 通常不在证书中，需要先比较证书本身
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("no peer certificate")
+			}
+			if bytes.Equal(rawCerts[0], cert.Certificate[0]) {
+				return nil
+			}
+			certs := make([]*x509.Certificate, 0, len(rawCerts))
+			for _, raw := range rawCerts {
+				c, err := x509.ParseCertificate(raw)
+				if err != nil {
+					return err
+				}
+				certs = append(certs, c)
+			}
+			intermediates := x509.NewCertPool()
+			for _, c := range certs[1:] {
+				intermediates.AddCert(c)
+			}
+			_, err := certs[0].Verify(x509.VerifyOptions{DNSName: serverName, Roots: roots, Intermediates: intermediates})
+			return err
+		},
+	}
+	if c.ClientAuth.Enable {
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func (e *KubePiServer) tlsListener() (net.Listener, error) {
+	reloader, err := newCertReloader(e.config.Load().Spec.Server.SSL, e.logger)
+	if err != nil {
+		return nil, err
+	}
+	if err := reloader.watch(); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("tcp", fmt.Sprintf("%s:%d", e.config.Load().Spec.Server.Bind.Host, e.config.Load().Spec.Server.Bind.Port))
+	if err != nil {
+		return nil, err
+	}
+	return tls.NewListener(ln, reloader.tlsConfig()), nil
+}
+
+func (e *KubePiServer) startHttpRedirect() {
+	redirect := e.config.Load().Spec.Server.SSL.Redirect
+	if !redirect.Enable {
+		return
+	}
+	httpsPort := e.config.Load().Spec.Server.Bind.Port
+	srv := &http.Server{
+		Addr: fmt.Sprintf("%s:%d", e.config.Load().Spec.Server.Bind.Host, redirect.Port),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host, _, err := net.SplitHostPort(r.Host)
+			if err != nil {
+				host = r.Host
+			}
+			if httpsPort != 443 {
+				host = net.JoinHostPort(host, fmt.Sprintf("%d", httpsPort))
+			}
+			http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+		}),
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			e.logger.Errorf("http redirect server stopped: %s", err.Error())
+		}
+	}()
+}
@@ -8,11 +8,13 @@ import (
 	"github.com/ClusterOperator/kubepi/internal/service/v1/clusterapp"
 	"github.com/ClusterOperator/kubepi/internal/service/v1/common"
 	"github.com/ClusterOperator/kubepi/pkg/kubernetes"
+	"github.com/ClusterOperator/kubepi/pkg/metrics"
 	"github.com/ClusterOperator/kubepi/pkg/util/helm"
 	"github.com/asdine/storm/v3"
 	"helm.sh/helm/v3/cmd/helm/search"
 	"helm.sh/helm/v3/pkg/release"
 	"helm.sh/helm/v3/pkg/repo"
+	"time"
 )
 
 type Service interface {
@@ -32,6 +34,8 @@ type Service interface {
 	GetChartsUpdate(cluster, chart, name string) (*v1Chart.UpdateResult, error)
 	UpgradeChart(cluster, namespace, repoName, name, chartName, chartVersion string, values map[string]interface{}) error
 	SyncRepo(cluster, name string) error
+	// AsUser 返回以用户身份操作集群的 Service，集群开启模拟用户模式时 helm 使用该用户的权限
+	AsUser(user string) Service
 }
 
 func NewService() Service {
@@ -45,10 +49,17 @@ type service struct {
 	common.DefaultDBService
 	clusterService    cluster.Service
 	clusterAppService clusterapp.Service
+	user              string
+}
+
+func (c *service) AsUser(user string) Service {
+	s := *c
+	s.user = user
+	return &s
 }
 
 func (c *service) SearchRepo(cluster string) ([]*repo.Entry, error) {
-	helmClient, err := NewHelmClient(cluster, "")
+	helmClient, err := c.helmClient(cluster, "")
 	if err != nil {
 		return nil, err
 	}
@@ -60,11 +71,13 @@ func (c *service) SearchRepo(cluster string) ([]*repo.Entry, error) {
 }
 
 func (c *service) AddRepo(cluster string, create *v1Chart.RepoCreate) error {
-	helmClient, err := NewHelmClient(cluster, "")
+	helmClient, err := c.helmClient(cluster, "")
 	if err != nil {
 		return err
 	}
+	start := time.Now()
 	err = helmClient.AddRepo(create.Name, create.Url, create.UserName, create.Password)
+	metrics.ObserveHelmOperation(cluster, "add_repo", start, err)
 	if err != nil {
 		return err
 	}
@@ -72,7 +85,7 @@ func (c *service) AddRepo(cluster string, create *v1Chart.RepoCreate) error {
 }
 
 func (c *service) GetRepo(cluster string, name string) (*v1Chart.Repo, error) {
-	helmClient, err := NewHelmClient(cluster, "")
+	helmClient, err := c.helmClient(cluster, "")
 	if err != nil {
 		return nil, err
 	}
@@ -84,7 +97,7 @@ func (c *service) GetRepo(cluster string, name string) (*v1Chart.Repo, error) {
 }
 
 func (c *service) UpdateRepo(cluster string, update *v1Chart.RepoUpdate) error {
-	helmClient, err := NewHelmClient(cluster, "")
+	helmClient, err := c.helmClient(cluster, "")
 	if err != nil {
 		return err
 	}
@@ -103,7 +116,7 @@ func (c *service) UpdateRepo(cluster string, update *v1Chart.RepoUpdate) error {
 }
 
 func (c *service) RemoveRepo(cluster string, name string) error {
-	helmClient, err := NewHelmClient(cluster, "")
+	helmClient, err := c.helmClient(cluster, "")
 	if err != nil {
 		return err
 	}
@@ -118,7 +131,7 @@ func (c *service) RemoveRepo(cluster string, name string) error {
 }
 
 func (c *service) ListCharts(cluster, repo string, num, size int, pattern string) ([]*search.Result, int, error) {
-	helmClient, err := NewHelmClient(cluster, "")
+	helmClient, err := c.helmClient(cluster, "")
 	if err != nil {
 		return nil, 0, err
 	}
@@ -152,7 +165,7 @@ func (c *service) ListCharts(cluster, repo string, num, size int, pattern string
 }
 
 func (c *service) GetCharts(cluster, repo, name string) (*v1Chart.ChArrayResult, error) {
-	helmClient, err := NewHelmClient(cluster, "")
+	helmClient, err := c.helmClient(cluster, "")
 	if err != nil {
 		return nil, err
 	}
@@ -185,7 +198,7 @@ func (c *service) GetCharts(cluster, repo, name string) (*v1Chart.ChArrayResult,
 }
 
 func (c *service) GetChartsUpdate(cluster, chart, name string) (*v1Chart.UpdateResult, error) {
-	helmClient, err := NewHelmClient(cluster, "")
+	helmClient, err := c.helmClient(cluster, "")
 	if err != nil {
 		return nil, err
 	}
@@ -211,7 +224,7 @@ func (c *service) GetChartsUpdate(cluster, chart, name string) (*v1Chart.UpdateR
 }
 
 func (c *service) GetChartByVersion(cluster, repo, name, version string) (*v1Chart.ChDetail, error) {
-	helmClient, err := NewHelmClient(cluster, "")
+	helmClient, err := c.helmClient(cluster, "")
 	if err != nil {
 		return nil, err
 	}
@@ -231,11 +244,13 @@ func (c *service) GetChartByVersion(cluster, repo, name, version string) (*v1Cha
 }
 
 func (c *service) InstallChart(cluster, repoName, namespace, name, chartName, chartVersion string, values map[string]interface{}) error {
-	helmClient, err := NewHelmClient(cluster, namespace)
+	helmClient, err := c.helmClient(cluster, namespace)
 	if err != nil {
 		return err
 	}
+	start := time.Now()
 	_, err = helmClient.Install(name, repoName, chartName, chartVersion, values)
+	metrics.ObserveHelmOperation(cluster, "install", start, err)
 	if err != nil {
 		return err
 	}
@@ -251,11 +266,13 @@ func (c *service) InstallChart(cluster, repoName, namespace, name, chartName, ch
 }
 
 func (c *service) UpgradeChart(cluster, namespace, repoName, name, chartName, chartVersion string, values map[string]interface{}) error {
-	helmClient, err := NewHelmClient(cluster, namespace)
+	helmClient, err := c.helmClient(cluster, namespace)
 	if err != nil {
 		return err
 	}
+	start := time.Now()
 	_, err = helmClient.Upgrade(name, repoName, chartName, chartVersion, values)
+	metrics.ObserveHelmOperation(cluster, "upgrade", start, err)
 	if err != nil {
 		return err
 	}
@@ -263,11 +280,13 @@ func (c *service) UpgradeChart(cluster, namespace, repoName, name, chartName, ch
 }
 
 func (c *service) UnInstallChart(cluster, namespace, name string) error {
-	helmClient, err := NewHelmClient(cluster, namespace)
+	helmClient, err := c.helmClient(cluster, namespace)
 	if err != nil {
 		return err
 	}
+	start := time.Now()
 	_, err = helmClient.Uninstall(name)
+	metrics.ObserveHelmOperation(cluster, "uninstall", start, err)
 	if err != nil {
 		return err
 	}
@@ -279,7 +298,7 @@ func (c *service) UnInstallChart(cluster, namespace, name string) error {
 }
 
 func (c *service) ListAllInstalled(cluster, namespace string, num, size int, pattern string) ([]*release.Release, int, error) {
-	helmClient, err := NewHelmClient(cluster, namespace)
+	helmClient, err := c.helmClient(cluster, namespace)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -291,7 +310,7 @@ func (c *service) ListAllInstalled(cluster, namespace string, num, size int, pat
 }
 
 func (c *service) GetAppDetail(cluster string, name string) (*release.Release, error) {
-	helmClient, err := NewHelmClient(cluster, "")
+	helmClient, err := c.helmClient(cluster, "")
 	if err != nil {
 		return nil, err
 	}
@@ -299,19 +318,34 @@ func (c *service) GetAppDetail(cluster string, name string) (*release.Release, e
 }
 
 func (c *service) SyncRepo(cluster, name string) error {
-	helmClient, err := NewHelmClient(cluster, "")
+	helmClient, err := c.helmClient(cluster, "")
 	if err != nil {
 		return err
 	}
-	return helmClient.UpdateRepo(name)
+	start := time.Now()
+	err = helmClient.UpdateRepo(name)
+	metrics.ObserveHelmOperation(cluster, "sync_repo", start, err)
+	return err
+}
+
+func (c *service) helmClient(clusterName, namespace string) (*helm.Client, error) {
+	return newHelmClient(clusterName, namespace, c.user)
 }
 
 func NewHelmClient(clusterName, namespace string) (*helm.Client, error) {
+	return newHelmClient(clusterName, namespace, "")
+}
+
+func newHelmClient(clusterName, namespace, user string) (*helm.Client, error) {
 	clu, err := cluster.NewService().Get(clusterName, common.DBOptions{})
 	if err != nil {
 		return nil, err
 	}
-	kubeConfig, err := kubernetes.NewKubernetes(clu).Config()
+	k := kubernetes.NewKubernetes(clu)
+	kubeConfig, err := k.Config()
+	if kubernetes.Impersonation(clu) && user != "" {
+		kubeConfig, err = k.ImpersonatedConfig(user)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -7,6 +7,7 @@ import (
 	"github.com/ClusterOperator/kubepi/pkg/util/lang"
 	"github.com/asdine/storm/v3/q"
 	"github.com/google/uuid"
+	"k8s.io/apimachinery/pkg/labels"
 	"time"
 )
 
@@ -14,8 +15,20 @@ type Service interface {
 	common.DBService
 	Create(cluster *v1Cluster.Cluster, options common.DBOptions) error
 	Update(name string, cluster *v1Cluster.Cluster, options common.DBOptions) error
+	// UpdateStatus 只更新集群的状态，零值字段同样写入
+	UpdateStatus(name string, status v1Cluster.Status, options common.DBOptions) error
+	// UpdateAuthentication 只更新集群的认证信息，用于刷新 serviceAccount 模式的 token
+	UpdateAuthentication(name string, authentication v1Cluster.Authentication, options common.DBOptions) error
+	// UpdateInformerCache 只更新集群的 informer 缓存开关，关闭时同样写入
+	UpdateInformerCache(name string, informerCache v1Cluster.InformerCache, options common.DBOptions) error
+	// UpdateReverse 只更新 agent 注册时上报的 token、CA 和 reverse 连接信息
+	UpdateReverse(name string, bearerToken string, caCertificate []byte, reverse v1Cluster.Reverse, options common.DBOptions) error
+	// UpdatePrivateKey 只更新签发用户证书使用的私钥
+	UpdatePrivateKey(name string, privateKey []byte, options common.DBOptions) error
 	Get(name string, options common.DBOptions) (*v1Cluster.Cluster, error)
 	List(options common.DBOptions) ([]v1Cluster.Cluster, error)
+	// Select 返回标签匹配 selector 的集群
+	Select(selector labels.Selector, options common.DBOptions) ([]v1Cluster.Cluster, error)
 	Delete(name string, options common.DBOptions) error
 	Search(num, size int, conditions common.Conditions, options common.DBOptions) ([]v1Cluster.Cluster, int, error)
 }
@@ -42,9 +55,64 @@ func (c *cluster) Update(name string, cluster *v1Cluster.Cluster, options common
 	return db.Update(cluster)
 }
 
+func (c *cluster) UpdateStatus(name string, status v1Cluster.Status, options common.DBOptions) error {
+	db := c.GetDB(options)
+	r, err := c.Get(name, options)
+	if err != nil {
+		return err
+	}
+	return db.UpdateField(r, "Status", status)
+}
+
+func (c *cluster) UpdateAuthentication(name string, authentication v1Cluster.Authentication, options common.DBOptions) error {
+	db := c.GetDB(options)
+	r, err := c.Get(name, options)
+	if err != nil {
+		return err
+	}
+	r.Spec.Authentication = authentication
+	return db.UpdateField(r, "Spec", r.Spec)
+}
+
+func (c *cluster) UpdateInformerCache(name string, informerCache v1Cluster.InformerCache, options common.DBOptions) error {
+	db := c.GetDB(options)
+	r, err := c.Get(name, options)
+	if err != nil {
+		return err
+	}
+	r.Spec.InformerCache = informerCache
+	return db.UpdateField(r, "Spec", r.Spec)
+}
+
+func (c *cluster) UpdateReverse(name string, bearerToken string, caCertificate []byte, reverse v1Cluster.Reverse, options common.DBOptions) error {
+	db := c.GetDB(options)
+	r, err := c.Get(name, options)
+	if err != nil {
+		return err
+	}
+	r.Spec.Authentication.BearerToken = bearerToken
+	r.Spec.Connect.Reverse = reverse
+	if err := db.UpdateField(r, "Spec", r.Spec); err != nil {
+		return err
+	}
+	r.CaCertificate.CertData = caCertificate
+	return db.UpdateField(r, "CaCertificate", r.CaCertificate)
+}
+
+func (c *cluster) UpdatePrivateKey(name string, privateKey []byte, options common.DBOptions) error {
+	db := c.GetDB(options)
+	r, err := c.Get(name, options)
+	if err != nil {
+		return err
+	}
+	return db.UpdateField(r, "PrivateKey", privateKey)
+}
+
 func (c *cluster) Create(cluster *v1Cluster.Cluster, options common.DBOptions) error {
 	db := c.GetDB(options)
-	cluster.UUID = uuid.New().String()
+	if cluster.UUID == "" {
+		cluster.UUID = uuid.New().String()
+	}
 	cluster.CreateAt = time.Now()
 	cluster.UpdateAt = time.Now()
 	return db.Save(cluster)
@@ -68,6 +136,37 @@ func (c *cluster) List(options common.DBOptions) ([]v1Cluster.Cluster, error) {
 	return clusters, nil
 }
 
+func (c *cluster) Select(selector labels.Selector, options common.DBOptions) ([]v1Cluster.Cluster, error) {
+	all, err := c.List(options)
+	if err != nil {
+		return nil, err
+	}
+	clusters := make([]v1Cluster.Cluster, 0)
+	for i := range all {
+		if selector.Matches(all[i].LabelSet()) {
+			clusters = append(clusters, all[i])
+		}
+	}
+	return clusters, nil
+}
+
+type selectorMatcher struct {
+	selector labels.Selector
+}
+
+func (m *selectorMatcher) MatchField(v interface{}) (bool, error) {
+	ls, ok := v.([]string)
+	if !ok {
+		return false, nil
+	}
+	return m.selector.Matches(v1Cluster.ParseLabels(ls)), nil
+}
+
+// SelectorMatcher 选择标签匹配 selector 的集群
+func SelectorMatcher(selector labels.Selector) q.Matcher {
+	return q.NewFieldMatcher("Labels", &selectorMatcher{selector: selector})
+}
+
 func (c *cluster) Search(num, size int, conditions common.Conditions, options common.DBOptions) ([]v1Cluster.Cluster, int, error) {
 	db := c.GetDB(options)
 
@@ -75,6 +174,12 @@ func (c *cluster) Search(num, size int, conditions common.Conditions, options co
 	for k := range conditions {
 		if k == "quick" {
 			ms = append(ms, storm.Like("Name", conditions[k].Value))
+		} else if k == "labelSelector" {
+			selector, err := labels.Parse(conditions[k].Value)
+			if err != nil {
+				return nil, 0, err
+			}
+			ms = append(ms, SelectorMatcher(selector))
 		} else if k == "labels" {
 			switch conditions[k].Operator {
 			case "like":
@@ -0,0 +1,103 @@
+package cluster
+
+import (
+	"path"
+	"testing"
+
+	v1 "github.com/ClusterOperator/kubepi/internal/model/v1"
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/common"
+	"github.com/ClusterOperator/kubepi/pkg/storage/boltdb"
+)
+
+func TestUpdateReverse(t *testing.T) {
+	db, err := boltdb.Open(path.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	options := common.DBOptions{DB: db}
+	s := NewService()
+	c := &v1Cluster.Cluster{Metadata: v1.Metadata{Name: "c1"}, Labels: []string{"env=prod"}}
+	c.Spec.Connect.Direction = v1Cluster.DirectionReverse
+	c.Spec.Connect.Reverse.RegistrationToken = "registration"
+	if err := s.Create(c, options); err != nil {
+		t.Fatal(err)
+	}
+	status := v1Cluster.Status{Phase: "Ready", History: []v1Cluster.HealthRecord{{Phase: "Ready"}}}
+	if err := s.UpdateStatus("c1", status, options); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.UpdateReverse("c1", "token", []byte("ca"), v1Cluster.Reverse{AgentTokenHash: "hash"}, options); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Get("c1", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Spec.Authentication.BearerToken != "token" || string(got.CaCertificate.CertData) != "ca" ||
+		got.Spec.Connect.Reverse != (v1Cluster.Reverse{AgentTokenHash: "hash"}) {
+		t.Fatalf("unexpected reverse connection %+v %+v", got.Spec, got.CaCertificate)
+	}
+	if got.Spec.Connect.Direction != v1Cluster.DirectionReverse || got.Status.Phase != "Ready" || len(got.Status.History) != 1 || len(got.Labels) != 1 {
+		t.Fatalf("other fields should be kept, got %+v", got)
+	}
+}
+
+func TestUpdateInformerCache(t *testing.T) {
+	db, err := boltdb.Open(path.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	options := common.DBOptions{DB: db}
+	s := NewService()
+	c := &v1Cluster.Cluster{Metadata: v1.Metadata{Name: "c1"}}
+	c.Spec.InformerCache.Enabled = true
+	c.Spec.Authentication.BearerToken = "token"
+	if err := s.Create(c, options); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateStatus("c1", v1Cluster.Status{Phase: "Ready"}, options); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateInformerCache("c1", v1Cluster.InformerCache{}, options); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Get("c1", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Spec.InformerCache.Enabled || got.Spec.Authentication.BearerToken != "token" || got.Status.Phase != "Ready" {
+		t.Fatalf("unexpected cluster %+v", got)
+	}
+}
+
+func TestUpdatePrivateKey(t *testing.T) {
+	db, err := boltdb.Open(path.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	options := common.DBOptions{DB: db}
+	s := NewService()
+	c := &v1Cluster.Cluster{Metadata: v1.Metadata{Name: "c1"}, PrivateKey: []byte("old")}
+	c.Spec.Authentication.BearerToken = "token"
+	if err := s.Create(c, options); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateStatus("c1", v1Cluster.Status{Phase: "Ready"}, options); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdatePrivateKey("c1", []byte("new"), options); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Get("c1", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got.PrivateKey) != "new" || got.Spec.Authentication.BearerToken != "token" || got.Status.Phase != "Ready" {
+		t.Fatalf("unexpected cluster %+v", got)
+	}
+}
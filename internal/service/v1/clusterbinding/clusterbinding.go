@@ -2,8 +2,12 @@ package clusterbinding
 
 import (
 	"errors"
+	"fmt"
+	v1 "github.com/ClusterOperator/kubepi/internal/model/v1"
 	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
 	"github.com/ClusterOperator/kubepi/internal/service/v1/common"
+	"github.com/ClusterOperator/kubepi/pkg/certificate"
+	"github.com/asdine/storm/v3"
 	"github.com/asdine/storm/v3/q"
 	"github.com/google/uuid"
 	"time"
@@ -17,6 +21,12 @@ type Service interface {
 	GetBindingByClusterNameAndUserName(clusterName string, userName string, options common.DBOptions) (*v1Cluster.Binding, error)
 	GetBindingsByUserName(userName string, options common.DBOptions) ([]v1Cluster.Binding, error)
 	Delete(name string, options common.DBOptions) error
+	// Revoke 记录成员被移除或者被替换的证书，没有证书或者证书已经过期时不记录
+	Revoke(binding *v1Cluster.Binding, reason string, options common.DBOptions) error
+	ListRevoked(clusterName string, options common.DBOptions) ([]v1Cluster.RevokedCertificate, error)
+	// PruneRevoked 删除已经过期的证书记录
+	PruneRevoked(options common.DBOptions) error
+	DeleteRevokedByCluster(clusterName string, options common.DBOptions) error
 }
 
 func NewService() Service {
@@ -93,3 +103,62 @@ func (s *service) Delete(name string, options common.DBOptions) error {
 	}
 	return db.DeleteStruct(&binding)
 }
+
+func (s *service) Revoke(binding *v1Cluster.Binding, reason string, options common.DBOptions) error {
+	if len(binding.Certificate) == 0 {
+		return nil
+	}
+	cert, err := certificate.ParseX509Certificate(binding.Certificate)
+	if err != nil || time.Now().After(cert.NotAfter) {
+		return nil
+	}
+	db := s.GetDB(options)
+	serial := cert.SerialNumber.Text(16)
+	revoked := v1Cluster.RevokedCertificate{
+		BaseModel: v1.BaseModel{
+			Kind:     "RevokedCertificate",
+			CreateAt: time.Now(),
+			UpdateAt: time.Now(),
+		},
+		Metadata: v1.Metadata{
+			Name: fmt.Sprintf("%s-%s-%s", binding.ClusterRef, binding.UserRef, serial),
+			UUID: uuid.New().String(),
+		},
+		UserRef:      binding.UserRef,
+		ClusterRef:   binding.ClusterRef,
+		SerialNumber: serial,
+		NotAfter:     cert.NotAfter,
+		Reason:       reason,
+	}
+	if err := db.Save(&revoked); err != nil && !errors.Is(err, storm.ErrAlreadyExists) {
+		return err
+	}
+	return nil
+}
+
+func (s *service) ListRevoked(clusterName string, options common.DBOptions) ([]v1Cluster.RevokedCertificate, error) {
+	db := s.GetDB(options)
+	var revoked []v1Cluster.RevokedCertificate
+	if err := db.Select(q.Eq("ClusterRef", clusterName), q.Gt("NotAfter", time.Now())).Find(&revoked); err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return nil, err
+	}
+	return revoked, nil
+}
+
+func (s *service) PruneRevoked(options common.DBOptions) error {
+	db := s.GetDB(options)
+	err := db.Select(q.Lte("NotAfter", time.Now())).Delete(new(v1Cluster.RevokedCertificate))
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+func (s *service) DeleteRevokedByCluster(clusterName string, options common.DBOptions) error {
+	db := s.GetDB(options)
+	err := db.Select(q.Eq("ClusterRef", clusterName)).Delete(new(v1Cluster.RevokedCertificate))
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	return nil
+}
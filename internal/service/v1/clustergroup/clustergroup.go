@@ -0,0 +1,148 @@
+package clustergroup
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/cluster"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/common"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/role"
+	costomStorm "github.com/ClusterOperator/kubepi/pkg/storm"
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+	"github.com/google/uuid"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+type Service interface {
+	common.DBService
+	Create(group *v1Cluster.Group, options common.DBOptions) error
+	Get(name string, options common.DBOptions) (*v1Cluster.Group, error)
+	List(options common.DBOptions) ([]v1Cluster.Group, error)
+	Update(name string, group *v1Cluster.Group, options common.DBOptions) error
+	// Delete 删除分组，分组仍被角色规则引用时返回错误
+	Delete(name string, options common.DBOptions) error
+	Search(num, size int, conditions common.Conditions, options common.DBOptions) ([]v1Cluster.Group, int, error)
+	// Selector 返回分组的 label selector
+	Selector(name string, options common.DBOptions) (labels.Selector, error)
+	// Clusters 返回分组当前包含的集群
+	Clusters(name string, options common.DBOptions) ([]v1Cluster.Cluster, error)
+}
+
+func NewService() Service {
+	return &service{
+		clusterService: cluster.NewService(),
+		roleService:    role.NewService(),
+	}
+}
+
+type service struct {
+	common.DefaultDBService
+	clusterService cluster.Service
+	roleService    role.Service
+}
+
+func (s *service) Create(group *v1Cluster.Group, options common.DBOptions) error {
+	db := s.GetDB(options)
+	group.Kind = "ClusterGroup"
+	group.UUID = uuid.New().String()
+	group.CreateAt = time.Now()
+	group.UpdateAt = time.Now()
+	return db.Save(group)
+}
+
+func (s *service) Get(name string, options common.DBOptions) (*v1Cluster.Group, error) {
+	db := s.GetDB(options)
+	var group v1Cluster.Group
+	if err := db.One("Name", name, &group); err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+func (s *service) List(options common.DBOptions) ([]v1Cluster.Group, error) {
+	db := s.GetDB(options)
+	groups := make([]v1Cluster.Group, 0)
+	if err := db.All(&groups); err != nil {
+		return groups, err
+	}
+	return groups, nil
+}
+
+func (s *service) Update(name string, group *v1Cluster.Group, options common.DBOptions) error {
+	db := s.GetDB(options)
+	g, err := s.Get(name, options)
+	if err != nil {
+		return err
+	}
+	group.Name = g.Name
+	group.Kind = g.Kind
+	group.UUID = g.UUID
+	group.CreateAt = g.CreateAt
+	group.CreatedBy = g.CreatedBy
+	group.UpdateAt = time.Now()
+	return db.Save(group)
+}
+
+func (s *service) Delete(name string, options common.DBOptions) error {
+	db := s.GetDB(options)
+	g, err := s.Get(name, options)
+	if err != nil {
+		return err
+	}
+	roles, err := s.roleService.List(options)
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	for i := range roles {
+		for _, rule := range roles[i].Rules {
+			for _, ref := range rule.ClusterGroups {
+				if ref == name {
+					return fmt.Errorf("cluster group %s is referenced by role %s", name, roles[i].Name)
+				}
+			}
+		}
+	}
+	return db.DeleteStruct(g)
+}
+
+func (s *service) Search(num, size int, conditions common.Conditions, options common.DBOptions) ([]v1Cluster.Group, int, error) {
+	db := s.GetDB(options)
+	var ms []q.Matcher
+	for k := range conditions {
+		if k == "quick" {
+			ms = append(ms, costomStorm.Like("Name", conditions[k].Value))
+		}
+	}
+	query := db.Select(ms...).OrderBy("Name")
+	count, err := query.Count(&v1Cluster.Group{})
+	if err != nil {
+		return nil, 0, err
+	}
+	if size != 0 {
+		query.Limit(size).Skip((num - 1) * size)
+	}
+	groups := make([]v1Cluster.Group, 0)
+	if err := query.Find(&groups); err != nil {
+		return groups, 0, err
+	}
+	return groups, count, nil
+}
+
+func (s *service) Selector(name string, options common.DBOptions) (labels.Selector, error) {
+	g, err := s.Get(name, options)
+	if err != nil {
+		return nil, err
+	}
+	return labels.Parse(g.Selector)
+}
+
+func (s *service) Clusters(name string, options common.DBOptions) ([]v1Cluster.Cluster, error) {
+	selector, err := s.Selector(name, options)
+	if err != nil {
+		return nil, err
+	}
+	return s.clusterService.Select(selector, options)
+}
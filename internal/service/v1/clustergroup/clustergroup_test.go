@@ -0,0 +1,52 @@
+package clustergroup
+
+import (
+	"path"
+	"testing"
+
+	v1 "github.com/ClusterOperator/kubepi/internal/model/v1"
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
+	v1Role "github.com/ClusterOperator/kubepi/internal/model/v1/role"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/common"
+	"github.com/ClusterOperator/kubepi/internal/service/v1/role"
+	"github.com/ClusterOperator/kubepi/pkg/storage/boltdb"
+)
+
+func TestUpdateAndDelete(t *testing.T) {
+	db, err := boltdb.Open(path.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	options := common.DBOptions{DB: db}
+	s := NewService()
+	if err := s.Create(&v1Cluster.Group{Metadata: v1.Metadata{Name: "prod"}, Selector: "env=prod"}, options); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Update("prod", &v1Cluster.Group{Selector: "env in (prod,staging)"}, options); err != nil {
+		t.Fatal(err)
+	}
+	g, err := s.Get("prod", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Kind != "ClusterGroup" || g.Selector != "env in (prod,staging)" {
+		t.Fatalf("unexpected group %+v", g)
+	}
+
+	r := &v1Role.Role{Metadata: v1.Metadata{Name: "prod-admin"}, Rules: []v1Role.PolicyRule{
+		{Resource: []string{"clusters"}, Verbs: []string{"get"}, ClusterGroups: []string{"prod"}},
+	}}
+	if err := role.NewService().Create(r, options); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("prod", options); err == nil {
+		t.Fatal("group referenced by role should not be deleted")
+	}
+	if err := role.NewService().Delete("prod-admin", options); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("prod", options); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,17 +2,17 @@ package common
 
 import (
 	"github.com/ClusterOperator/kubepi/internal/server"
-	"github.com/asdine/storm/v3"
+	"github.com/ClusterOperator/kubepi/pkg/storage"
 )
 
 type DBService interface {
-	GetDB(options DBOptions) storm.Node
+	GetDB(options DBOptions) storage.Node
 }
 
 type DefaultDBService struct {
 }
 
-func (d *DefaultDBService) GetDB(options DBOptions) storm.Node {
+func (d *DefaultDBService) GetDB(options DBOptions) storage.Node {
 	if options.DB != nil {
 		return options.DB
 	}
@@ -20,5 +20,5 @@ func (d *DefaultDBService) GetDB(options DBOptions) storm.Node {
 }
 
 type DBOptions struct {
-	DB storm.Node
+	DB storage.Node
 }
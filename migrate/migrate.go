@@ -2,64 +2,177 @@ package migrate
 
 import (
 	"errors"
+	"fmt"
 	"github.com/ClusterOperator/kubepi/migrate/migrations"
 	v1 "github.com/ClusterOperator/kubepi/migrate/v1"
+	"github.com/ClusterOperator/kubepi/pkg/storage"
 	"github.com/asdine/storm/v3"
 	"github.com/sirupsen/logrus"
 	"os"
 	"sort"
 )
 
-var definedMigrations = append([]migrations.Migration{}, v1.Migrations...)
+var definedMigrations = sortMigrations(append([]migrations.Migration{}, v1.Migrations...))
 
-func RunMigrate(db *storm.DB, logger *logrus.Logger) {
+func sortMigrations(ms []migrations.Migration) []migrations.Migration {
+	sort.Slice(ms, func(i, j int) bool {
+		return ms[i].Version < ms[j].Version
+	})
+	return ms
+}
+
+// LatestVersion 返回当前程序中定义的最新数据库版本
+func LatestVersion() int {
+	latest := 0
+	for i := range definedMigrations {
+		if definedMigrations[i].Version > latest {
+			latest = definedMigrations[i].Version
+		}
+	}
+	return latest
+}
+
+// CurrentVersion 返回数据库当前的版本，未执行过任何迁移时为 0
+func CurrentVersion(db storage.Node) (int, error) {
 	var currentDbVersion int
 	if err := db.Get("db", "current_db_version", &currentDbVersion); err != nil {
 		if errors.Is(err, storm.ErrNotFound) {
-			currentDbVersion = 0
-		} else {
-			logger.Errorf("can not get current db version ,%s", err.Error())
-			os.Exit(1)
+			return 0, nil
 		}
+		return 0, err
 	}
-	logger.Infof("current db version: %d", currentDbVersion)
+	return currentDbVersion, nil
+}
+
+// MigrationStatus 描述一个迁移在数据库中的执行情况
+type MigrationStatus struct {
+	Version    int
+	Message    string
+	Applied    bool
+	Reversible bool
+}
 
-	var prepareExecuteMigrationVersions []int
+// Status 返回数据库当前版本及所有已定义迁移的执行情况
+func Status(db storage.Node) (int, []MigrationStatus, error) {
+	current, err := CurrentVersion(db)
+	if err != nil {
+		return 0, nil, err
+	}
+	var ss []MigrationStatus
+	for _, m := range definedMigrations {
+		ss = append(ss, MigrationStatus{
+			Version:    m.Version,
+			Message:    m.Message,
+			Applied:    m.Version <= current,
+			Reversible: m.Down != nil,
+		})
+	}
+	return current, ss, nil
+}
 
-	for i := range definedMigrations {
-		if definedMigrations[i].Version > currentDbVersion {
-			prepareExecuteMigrationVersions = append(prepareExecuteMigrationVersions, definedMigrations[i].Version)
+// Pending 返回从当前版本升级到 to 需要执行的迁移
+func Pending(db storage.Node, to int) ([]migrations.Migration, error) {
+	current, err := CurrentVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	var ms []migrations.Migration
+	for _, m := range definedMigrations {
+		if m.Version > current && m.Version <= to {
+			ms = append(ms, m)
 		}
 	}
+	return ms, nil
+}
 
-	if len(prepareExecuteMigrationVersions) > 0 {
-		sort.Ints(prepareExecuteMigrationVersions)
-		lastVersion := prepareExecuteMigrationVersions[len(prepareExecuteMigrationVersions)-1]
+// Up 在一个事务中执行当前版本到 to 之间的迁移，dryRun 时执行完成后回滚事务，返回执行后的版本
+func Up(db storage.DB, to int, dryRun bool, logger *logrus.Logger) (int, error) {
+	if to > LatestVersion() {
+		return 0, fmt.Errorf("version %d is newer than the latest version %d", to, LatestVersion())
+	}
+	ms, err := Pending(db, to)
+	if err != nil {
+		return 0, err
+	}
+	current, err := CurrentVersion(db)
+	if err != nil {
+		return 0, err
+	}
+	if len(ms) == 0 {
+		return current, nil
+	}
+	steps := make([]step, 0, len(ms))
+	for _, m := range ms {
+		steps = append(steps, step{version: m.Version, message: m.Message, handler: m.Handler})
+	}
+	return ms[len(ms)-1].Version, runSteps(db, steps, ms[len(ms)-1].Version, dryRun, logger)
+}
 
-		tx, err := db.Begin(true)
-		if err != nil {
-			logger.Errorf("can not open transaction ,%s", err.Error())
-			os.Exit(1)
+// Down 在一个事务中按版本倒序回滚当前版本到 to 之间的迁移，任何一个迁移没有 Down 时不做任何修改
+func Down(db storage.DB, to int, dryRun bool, logger *logrus.Logger) (int, error) {
+	if to < 0 {
+		return 0, fmt.Errorf("invalid version %d", to)
+	}
+	current, err := CurrentVersion(db)
+	if err != nil {
+		return 0, err
+	}
+	if to >= current {
+		return current, nil
+	}
+	var steps []step
+	for i := len(definedMigrations) - 1; i >= 0; i-- {
+		m := definedMigrations[i]
+		if m.Version <= to || m.Version > current {
+			continue
 		}
-		for i := range definedMigrations {
-			for j := range prepareExecuteMigrationVersions {
-				if definedMigrations[i].Version == prepareExecuteMigrationVersions[j] {
-					logger.Infof("executing db migration: [%d]  %s", definedMigrations[i].Version, definedMigrations[i].Message)
-					if err := definedMigrations[i].Handler(tx); err != nil {
-						_ = tx.Rollback()
-						logger.Errorf("execute migration: [%d] %s failed,rollback it", definedMigrations[i].Version, err.Error())
-						os.Exit(1)
-					}
-				}
-			}
+		if m.Down == nil {
+			return 0, fmt.Errorf("migration [%d] %s can not be rolled back", m.Version, m.Message)
 		}
-		currentDbVersion = lastVersion
-		if err := tx.Set("db", "current_db_version", currentDbVersion); err != nil {
+		steps = append(steps, step{version: m.Version, message: "rollback " + m.Message, handler: m.Down})
+	}
+	return to, runSteps(db, steps, to, dryRun, logger)
+}
+
+type step struct {
+	version int
+	message string
+	handler migrations.MigrationFUNC
+}
+
+func runSteps(db storage.DB, steps []step, target int, dryRun bool, logger *logrus.Logger) error {
+	tx, err := db.Begin(true)
+	if err != nil {
+		return fmt.Errorf("can not open transaction, %s", err.Error())
+	}
+	for _, s := range steps {
+		logger.Infof("executing db migration: [%d]  %s", s.version, s.message)
+		if err := s.handler(tx); err != nil {
 			_ = tx.Rollback()
-			logger.Errorf("update db version failed %s ,rollback it", err.Error())
-			os.Exit(1)
+			return fmt.Errorf("execute migration: [%d] %s failed, rollback it", s.version, err.Error())
 		}
-		logger.Infof("update db to version: %d", currentDbVersion)
-		_ = tx.Commit()
+	}
+	if err := tx.Set("db", "current_db_version", target); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("update db version failed %s, rollback it", err.Error())
+	}
+	if dryRun {
+		logger.Infof("dry run, rollback db to version: %d", target)
+		return tx.Rollback()
+	}
+	logger.Infof("update db to version: %d", target)
+	return tx.Commit()
+}
+
+func RunMigrate(db storage.DB, logger *logrus.Logger) {
+	currentDbVersion, err := CurrentVersion(db)
+	if err != nil {
+		logger.Errorf("can not get current db version ,%s", err.Error())
+		os.Exit(1)
+	}
+	logger.Infof("current db version: %d", currentDbVersion)
+	if _, err := Up(db, LatestVersion(), false, logger); err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
 	}
 }
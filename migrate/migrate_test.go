@@ -0,0 +1,56 @@
+package migrate
+
+import (
+	"io"
+	"path"
+	"testing"
+
+	v1Role "github.com/ClusterOperator/kubepi/internal/model/v1/role"
+	"github.com/ClusterOperator/kubepi/pkg/storage/boltdb"
+	"github.com/sirupsen/logrus"
+)
+
+func TestUpAndDown(t *testing.T) {
+	db, err := boltdb.Open(path.Join(t.TempDir(), "kubepi.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	if _, err := Up(db, LatestVersion(), true, logger); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := CurrentVersion(db); v != 0 {
+		t.Fatalf("dry run should not change db version, got %d", v)
+	}
+
+	if v, err := Up(db, LatestVersion(), false, logger); err != nil || v != LatestVersion() {
+		t.Fatalf("expect version %d, got %d, %v", LatestVersion(), v, err)
+	}
+	var role v1Role.Role
+	if err := db.One("Name", "Manage Image Registries", &role); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Down(db, 0, false, logger); err == nil {
+		t.Fatal("expect error when rolling back an irreversible migration")
+	}
+	if v, err := Down(db, 1, false, logger); err != nil || v != 1 {
+		t.Fatalf("expect version 1, got %d, %v", v, err)
+	}
+	if v, _ := CurrentVersion(db); v != 1 {
+		t.Fatalf("expect db version 1, got %d", v)
+	}
+	if err := db.One("Name", "Manage Image Registries", &role); err == nil {
+		t.Fatal("role should be deleted by rollback")
+	}
+	_, ss, err := Status(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ss[0].Applied || ss[0].Reversible || ss[1].Applied || !ss[1].Reversible {
+		t.Fatalf("unexpected status %#v", ss)
+	}
+}
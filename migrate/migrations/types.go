@@ -1,12 +1,13 @@
 package migrations
 
-import "github.com/asdine/storm/v3"
+import "github.com/ClusterOperator/kubepi/pkg/storage"
 
-type MigrationFUNC func(db storm.Node) error
+type MigrationFUNC func(db storage.Node) error
 
 type Migration struct {
 	Version int
 	Handler MigrationFUNC
+	// Down 回滚 Handler 所做的修改，为空时该版本不能回滚
+	Down    MigrationFUNC
 	Message string
 }
-
@@ -0,0 +1,27 @@
+package migrate
+
+import (
+	"github.com/ClusterOperator/kubepi/pkg/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// CopyStorage 将源存储中的所有记录(包括数据库版本)原样复制到目标存储，
+// 用于从单文件的 bolt 迁移到 SQL 后端
+func CopyStorage(from storage.Exporter, to storage.Importer, logger *logrus.Logger) error {
+	records, err := from.Export()
+	if err != nil {
+		return err
+	}
+	counts := map[string]int{}
+	for i := range records {
+		counts[records[i].Bucket]++
+	}
+	for bucket, count := range counts {
+		logger.Infof("copying %d records from bucket %s", count, bucket)
+	}
+	if err := to.Import(records); err != nil {
+		return err
+	}
+	logger.Infof("copied %d records", len(records))
+	return nil
+}
@@ -1,12 +1,14 @@
 package v1
 
 import (
+	"errors"
 	"time"
 
 	v1 "github.com/ClusterOperator/kubepi/internal/model/v1"
 	v1Role "github.com/ClusterOperator/kubepi/internal/model/v1/role"
 	v1User "github.com/ClusterOperator/kubepi/internal/model/v1/user"
 	"github.com/ClusterOperator/kubepi/migrate/migrations"
+	"github.com/ClusterOperator/kubepi/pkg/storage"
 	"github.com/asdine/storm/v3"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
@@ -24,7 +26,7 @@ var Migrations = []migrations.Migration{
 var CreateAdministrator = migrations.Migration{
 	Version: 1,
 	Message: "Create default user and cluster",
-	Handler: func(db storm.Node) error {
+	Handler: func(db storage.Node) error {
 		//
 
 		roleManageClusters := v1Role.Role{
@@ -148,7 +150,7 @@ var CreateAdministrator = migrations.Migration{
 var AddRoleManagerRepo = migrations.Migration{
 	Version: 2,
 	Message: "Add role repo manager",
-	Handler: func(db storm.Node) error {
+	Handler: func(db storage.Node) error {
 		roleManageRepo := v1Role.Role{
 			BaseModel: v1.BaseModel{
 				ApiVersion: "v1",
@@ -171,4 +173,23 @@ var AddRoleManagerRepo = migrations.Migration{
 		}
 		return db.Save(&roleManageRepo)
 	},
+	Down: func(db storage.Node) error {
+		var role v1Role.Role
+		if err := db.One("Name", "Manage Image Registries", &role); err != nil {
+			if errors.Is(err, storm.ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+		var bindings []v1Role.Binding
+		if err := db.Find("RoleRef", role.Name, &bindings); err != nil && !errors.Is(err, storm.ErrNotFound) {
+			return err
+		}
+		for i := range bindings {
+			if err := db.DeleteStruct(&bindings[i]); err != nil {
+				return err
+			}
+		}
+		return db.DeleteStruct(&role)
+	},
 }
@@ -0,0 +1,279 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ClusterOperator/kubepi/pkg/storage"
+	"github.com/ClusterOperator/kubepi/pkg/storage/boltdb"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	ManifestFileName = "manifest.json"
+	BoltFileName     = "kubepi.db"
+	RecordsFileName  = "records.json"
+
+	filePrefix = "kubepi-backup-"
+	fileSuffix = ".tar.gz"
+	timeFormat = "20060102150405.000"
+	// legacyTimeFormat 是旧版本备份文件名中精确到秒的时间格式
+	legacyTimeFormat = "20060102150405"
+)
+
+type Manifest struct {
+	Version          string    `json:"version"`
+	MigrationVersion int       `json:"migrationVersion"`
+	StorageType      string    `json:"storageType"`
+	CreateAt         time.Time `json:"createAt"`
+}
+
+// Archive 是解压后的备份内容，bolt 备份包含完整的数据库文件，其余后端备份为原始记录
+type Archive struct {
+	Manifest Manifest
+	Bolt     []byte
+	Records  []storage.Record
+}
+
+type File struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	CreateAt time.Time `json:"createAt"`
+}
+
+type unwrapper interface {
+	Unwrap() storage.DB
+}
+
+type boltHolder interface {
+	Bolt() *bolt.DB
+}
+
+// Write 将数据库的一致性快照写入 w，bolt 通过只读事务完成热备份，不阻塞写入
+func Write(w io.Writer, db storage.DB, manifest Manifest) error {
+	for {
+		u, ok := db.(unwrapper)
+		if !ok {
+			break
+		}
+		db = u.Unwrap()
+	}
+	manifest.StorageType = db.Type()
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	mbs, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeEntry(tw, ManifestFileName, int64(len(mbs)), bytes.NewReader(mbs)); err != nil {
+		return err
+	}
+	if b, ok := db.(boltHolder); ok {
+		err = b.Bolt().View(func(tx *bolt.Tx) error {
+			if err := tw.WriteHeader(&tar.Header{Name: BoltFileName, Mode: 0600, Size: tx.Size(), ModTime: manifest.CreateAt}); err != nil {
+				return err
+			}
+			_, err := tx.WriteTo(tw)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		exporter, ok := db.(storage.Exporter)
+		if !ok {
+			return fmt.Errorf("database type %s does not support backup", db.Type())
+		}
+		records, err := exporter.Export()
+		if err != nil {
+			return err
+		}
+		rbs, err := json.Marshal(records)
+		if err != nil {
+			return err
+		}
+		if err := writeEntry(tw, RecordsFileName, int64(len(rbs)), bytes.NewReader(rbs)); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func writeEntry(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: size, ModTime: time.Now()}); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, r)
+	return err
+}
+
+func Read(r io.Reader) (*Archive, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	var a Archive
+	hasManifest := false
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		bs, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		switch hdr.Name {
+		case ManifestFileName:
+			if err := json.Unmarshal(bs, &a.Manifest); err != nil {
+				return nil, err
+			}
+			hasManifest = true
+		case BoltFileName:
+			a.Bolt = bs
+		case RecordsFileName:
+			if err := json.Unmarshal(bs, &a.Records); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !hasManifest {
+		return nil, errors.New("invalid backup: manifest not found")
+	}
+	if a.Bolt == nil && a.Records == nil {
+		return nil, errors.New("invalid backup: no data found")
+	}
+	return &a, nil
+}
+
+// RestoreBolt 使用备份覆盖 bolt 数据库文件，原文件会被重命名保留
+func (a *Archive) RestoreBolt(dbFile string) error {
+	if a.Bolt == nil {
+		return fmt.Errorf("backup of %s storage can not be restored to bolt", a.Manifest.StorageType)
+	}
+	tmp := dbFile + ".restore"
+	if err := os.WriteFile(tmp, a.Bolt, 0600); err != nil {
+		return err
+	}
+	if _, err := os.Stat(dbFile); err == nil {
+		if err := os.Rename(dbFile, fmt.Sprintf("%s.%s.bak", dbFile, time.Now().Format(timeFormat))); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp, dbFile)
+}
+
+// RestoreRecords 将备份中的记录导入到 importer，bolt 备份会先被解析为原始记录
+func (a *Archive) RestoreRecords(importer storage.Importer) error {
+	records := a.Records
+	if a.Bolt != nil {
+		f, err := os.CreateTemp("", "kubepi-restore-*.db")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		if _, err := f.Write(a.Bolt); err != nil {
+			_ = f.Close()
+			return err
+		}
+		_ = f.Close()
+		db, err := boltdb.Open(f.Name())
+		if err != nil {
+			return err
+		}
+		records, err = db.Export()
+		_ = db.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return importer.Import(records)
+}
+
+// NewFileName 返回备份文件名，时间精确到毫秒
+func NewFileName(t time.Time) string {
+	return filePrefix + t.Format(timeFormat) + fileSuffix
+}
+
+// IsValidFileName 用于校验外部传入的备份文件名，防止访问备份目录之外的文件
+func IsValidFileName(name string) bool {
+	if name != path.Base(name) || !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+		return false
+	}
+	_, err := parseFileTime(name)
+	return err == nil
+}
+
+// parseFileTime 解析备份文件名中的时间，兼容旧版本精确到秒的文件名
+func parseFileTime(name string) (time.Time, error) {
+	s := strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix)
+	t, err := time.ParseInLocation(timeFormat, s, time.Local)
+	if err != nil {
+		return time.ParseInLocation(legacyTimeFormat, s, time.Local)
+	}
+	return t, nil
+}
+
+// List 按时间倒序列出目录中的备份文件
+func List(dir string) ([]File, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []File{}, nil
+		}
+		return nil, err
+	}
+	files := make([]File, 0)
+	for _, e := range entries {
+		if e.IsDir() || !IsValidFileName(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		t, _ := parseFileTime(e.Name())
+		files = append(files, File{Name: e.Name(), Size: info.Size(), CreateAt: t})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].CreateAt.After(files[j].CreateAt)
+	})
+	return files, nil
+}
+
+// Prune 只保留最新的 retention 个备份，retention 小于等于 0 时不清理
+func Prune(dir string, retention int) ([]string, error) {
+	if retention <= 0 {
+		return nil, nil
+	}
+	files, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for i := retention; i < len(files); i++ {
+		if err := os.Remove(path.Join(dir, files[i].Name)); err != nil {
+			return removed, err
+		}
+		removed = append(removed, files[i].Name)
+	}
+	return removed, nil
+}
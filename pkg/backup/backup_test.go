@@ -0,0 +1,143 @@
+package backup
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/ClusterOperator/kubepi/pkg/storage"
+	"github.com/ClusterOperator/kubepi/pkg/storage/boltdb"
+	"github.com/ClusterOperator/kubepi/pkg/storage/sqldb"
+	"github.com/asdine/storm/v3"
+)
+
+func TestWriteAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	db, err := boltdb.Open(path.Join(dir, "kubepi.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("db", "current_db_version", 2); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, db, Manifest{Version: "v1", MigrationVersion: 2, CreateAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	_ = db.Close()
+
+	a, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Manifest.StorageType != storage.TypeBolt || a.Manifest.MigrationVersion != 2 || a.Bolt == nil {
+		t.Fatalf("unexpected manifest %+v", a.Manifest)
+	}
+
+	restored := path.Join(dir, "restored.db")
+	if err := a.RestoreBolt(restored); err != nil {
+		t.Fatal(err)
+	}
+	rdb, err := boltdb.Open(restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	if err := rdb.Get("db", "current_db_version", &v); err != nil || v != 2 {
+		t.Fatalf("unexpected version %d %v", v, err)
+	}
+	_ = rdb.Close()
+
+	sdb, err := sqldb.Open(storage.TypeSqlite, path.Join(dir, "kubepi.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sdb.Close()
+	if err := a.RestoreRecords(sdb); err != nil {
+		t.Fatal(err)
+	}
+	if err := sdb.Get("db", "current_db_version", &v); err != nil || v != 2 {
+		t.Fatalf("unexpected version %d %v", v, err)
+	}
+}
+
+func TestRestoreRecordsReplacesData(t *testing.T) {
+	db, err := sqldb.Open(storage.TypeSqlite, path.Join(t.TempDir(), "kubepi.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Set("users", "admin", "before"); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, db, Manifest{Version: "v1", CreateAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("users", "admin", "after"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("users", "tom", "after"); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.RestoreRecords(db); err != nil {
+		t.Fatal(err)
+	}
+	var v string
+	if err := db.Get("users", "admin", &v); err != nil || v != "before" {
+		t.Fatalf("unexpected value %s %v", v, err)
+	}
+	if err := db.Get("users", "tom", &v); err != storm.ErrNotFound {
+		t.Fatalf("record created after the backup should be removed, got %v", err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		name := NewFileName(now.Add(time.Duration(i) * time.Hour))
+		if err := os.WriteFile(path.Join(dir, name), []byte("x"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	removed, err := Prune(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, _ := List(dir)
+	if len(removed) != 2 || len(files) != 2 || files[0].Name != NewFileName(now.Add(3*time.Hour)) {
+		t.Fatalf("unexpected files %+v removed %v", files, removed)
+	}
+	if IsValidFileName("../" + files[0].Name) {
+		t.Fatal("path should be rejected")
+	}
+}
+
+func TestFileName(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)
+	if NewFileName(now) == NewFileName(now.Add(10*time.Millisecond)) {
+		t.Fatal("backups created in the same second should have different names")
+	}
+	dir := t.TempDir()
+	legacy := filePrefix + now.Format(legacyTimeFormat) + fileSuffix
+	for _, name := range []string{legacy, NewFileName(now.Add(time.Second))} {
+		if err := os.WriteFile(path.Join(dir, name), []byte("x"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[1].Name != legacy || !files[1].CreateAt.Equal(now) {
+		t.Fatalf("unexpected files %+v", files)
+	}
+}
@@ -0,0 +1,172 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// 密文格式: enc:v1:<主密钥ID>:<被主密钥加密的数据密钥>:<被数据密钥加密的数据>
+const prefix = "enc:v1:"
+
+const keySize = 32
+
+var ErrUnknownKey = errors.New("data is encrypted by an unknown master key")
+
+type Encryptor struct {
+	primaryID string
+	keys      map[string][]byte
+}
+
+// NewEncryptor 使用 primary 加密新数据，previous 中的密钥只用于解密轮换前的数据
+func NewEncryptor(primary []byte, previous ...[]byte) (*Encryptor, error) {
+	e := &Encryptor{keys: map[string][]byte{}}
+	for i, k := range append([][]byte{primary}, previous...) {
+		if len(k) != keySize {
+			return nil, fmt.Errorf("master key must be %d bytes, got %d", keySize, len(k))
+		}
+		id := keyID(k)
+		if i == 0 {
+			e.primaryID = id
+		}
+		e.keys[id] = k
+	}
+	return e, nil
+}
+
+// ParseKey 解析 base64 编码的主密钥
+func ParseKey(s string) ([]byte, error) {
+	k, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("master key must be base64 encoded: %s", err.Error())
+	}
+	return k, nil
+}
+
+// GenerateKey 生成一个 base64 编码的随机主密钥
+func GenerateKey() (string, error) {
+	k := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, k); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(k), nil
+}
+
+func keyID(k []byte) string {
+	sum := sha256.Sum256(k)
+	return hex.EncodeToString(sum[:4])
+}
+
+func IsEncrypted(data []byte) bool {
+	return strings.HasPrefix(string(data), prefix)
+}
+
+// Encrypt 加密数据，空数据和可以被解密的密文原样返回。
+// 只是以密文前缀开头、无法解密的数据作为明文加密，避免这样的明文以原样保存
+func (e *Encryptor) Encrypt(plain []byte) ([]byte, error) {
+	if len(plain) == 0 || e.decryptable(plain) {
+		return plain, nil
+	}
+	dek := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return nil, err
+	}
+	wrapped, err := seal(e.keys[e.primaryID], dek)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := seal(dek, plain)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(prefix + e.primaryID + ":" +
+		base64.StdEncoding.EncodeToString(wrapped) + ":" +
+		base64.StdEncoding.EncodeToString(ciphertext)), nil
+}
+
+// Decrypt 解密数据，未加密的数据原样返回，以兼容加密启用前保存的数据
+func (e *Encryptor) Decrypt(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return data, nil
+	}
+	ss := strings.Split(strings.TrimPrefix(string(data), prefix), ":")
+	if len(ss) != 3 {
+		return nil, errors.New("invalid encrypted data")
+	}
+	master, ok := e.keys[ss[0]]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, ss[0])
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(ss[1])
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(ss[2])
+	if err != nil {
+		return nil, err
+	}
+	dek, err := open(master, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return open(dek, ciphertext)
+}
+
+// decryptable 判断数据是否是可以使用已知主密钥解密的密文
+func (e *Encryptor) decryptable(data []byte) bool {
+	if !IsEncrypted(data) {
+		return false
+	}
+	_, err := e.Decrypt(data)
+	return err == nil
+}
+
+// Rotate 将数据重新使用当前主密钥加密
+func (e *Encryptor) Rotate(data []byte) ([]byte, error) {
+	if IsEncrypted(data) && strings.HasPrefix(string(data), prefix+e.primaryID+":") {
+		return data, nil
+	}
+	plain, err := e.Decrypt(data)
+	if err != nil {
+		return nil, err
+	}
+	return e.Encrypt(plain)
+}
+
+func seal(key, plain []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, nil), nil
+}
+
+func open(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("invalid encrypted data")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,70 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func newTestEncryptor(t *testing.T, previous ...[]byte) (*Encryptor, []byte) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := ParseKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEncryptor(k, previous...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e, k
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	e, _ := newTestEncryptor(t)
+	plain := []byte("bearer-token")
+	ciphertext, err := e.Encrypt(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(ciphertext) || bytes.Contains(ciphertext, plain) {
+		t.Fatalf("unexpected ciphertext %s", ciphertext)
+	}
+	again, err := e.Encrypt(ciphertext)
+	if err != nil || !bytes.Equal(again, ciphertext) {
+		t.Fatalf("encrypt should be idempotent, %v", err)
+	}
+	got, err := e.Decrypt(ciphertext)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("unexpected plaintext %s %v", got, err)
+	}
+	got, err = e.Decrypt(plain)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("plaintext should pass through, %s %v", got, err)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	old, oldKey := newTestEncryptor(t)
+	ciphertext, err := old.Encrypt([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, _ := newTestEncryptor(t, oldKey)
+	rotated, err := current.Rotate(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(rotated, ciphertext) {
+		t.Fatal("data should be re-encrypted by the current key")
+	}
+	if _, err := old.Decrypt(rotated); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("expect ErrUnknownKey, got %v", err)
+	}
+	got, err := current.Decrypt(rotated)
+	if err != nil || string(got) != "secret" {
+		t.Fatalf("unexpected plaintext %s %v", got, err)
+	}
+}
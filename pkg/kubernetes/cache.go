@@ -0,0 +1,367 @@
+package kubernetes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+)
+
+const (
+	DefaultCacheTTL = 10 * time.Minute
+	// ImpersonatedGroup 是以成员身份访问集群时的用户组，与为成员签发的证书中的用户组相同
+	ImpersonatedGroup      = "system:authenticated"
+	impersonatedUserPrefix = "impersonate:"
+	// discoveryRefreshInterval 是资源在发现信息中不存在时重新获取的最小间隔，避免新建的 CRD 要等到缓存过期才能识别
+	discoveryRefreshInterval = 30 * time.Second
+)
+
+// ClientCache 按集群缓存 rest config、clientset、transport、版本和 API 发现信息，
+// 集群的连接配置变化或缓存过期后重新创建
+type ClientCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	entries   map[string]*cacheEntry
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+type cacheEntry struct {
+	fingerprint string
+	expireAt    time.Time
+	config      *rest.Config
+	clientset   *kubernetes.Clientset
+	discovery   discovery.CachedDiscoveryInterface
+	mapper      *restmapper.DeferredDiscoveryRESTMapper
+
+	mu                 sync.Mutex
+	transport          http.RoundTripper
+	userTransports     map[string]userTransport
+	discoveryRefreshAt time.Time
+
+	// versionMu 与 mu 分开，获取版本的请求不会阻塞 transport 的获取
+	versionMu sync.Mutex
+	version   *version.Info
+}
+
+type userTransport struct {
+	fingerprint string
+	transport   http.RoundTripper
+}
+
+type CacheStats struct {
+	Clusters   int    `json:"clusters"`
+	Transports int    `json:"transports"`
+	Hits       uint64 `json:"hits"`
+	Misses     uint64 `json:"misses"`
+	Evictions  uint64 `json:"evictions"`
+	TTLSeconds int    `json:"ttlSeconds"`
+}
+
+// DefaultCache 是 Kubernetes 使用的缓存
+var DefaultCache = NewClientCache(DefaultCacheTTL)
+
+func NewClientCache(ttl time.Duration) *ClientCache {
+	return &ClientCache{ttl: ttl, entries: map[string]*cacheEntry{}}
+}
+
+// SetTTL 修改缓存有效期并清空缓存，ttl 小于 0 时不缓存
+func (c *ClientCache) SetTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+	c.evictions.Add(uint64(len(c.entries)))
+	c.entries = map[string]*cacheEntry{}
+}
+
+// Invalidate 删除集群的缓存，在集群更新或删除时调用
+func (c *ClientCache) Invalidate(cluster string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[cluster]; ok {
+		delete(c.entries, cluster)
+		c.evictions.Add(1)
+	}
+}
+
+// InvalidateUser 删除用户在集群中的 transport，在用户证书更新或成员被移除时调用
+func (c *ClientCache) InvalidateUser(cluster, user string) {
+	c.mu.Lock()
+	e := c.entries[cluster]
+	c.mu.Unlock()
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, key := range []string{user, impersonatedUserPrefix + user} {
+		if _, ok := e.userTransports[key]; ok {
+			delete(e.userTransports, key)
+			c.evictions.Add(1)
+		}
+	}
+}
+
+func (c *ClientCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := CacheStats{
+		Clusters:   len(c.entries),
+		Hits:       c.hits.Load(),
+		Misses:     c.misses.Load(),
+		Evictions:  c.evictions.Load(),
+		TTLSeconds: int(c.ttl.Seconds()),
+	}
+	for _, e := range c.entries {
+		e.mu.Lock()
+		s.Transports += len(e.userTransports)
+		if e.transport != nil {
+			s.Transports++
+		}
+		e.mu.Unlock()
+	}
+	return s
+}
+
+// entry 返回集群的缓存，未保存的集群(例如创建前的连通性检查)不缓存
+func (c *ClientCache) entry(k *Kubernetes) (*cacheEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cacheable := c.ttl >= 0 && k.UUID != ""
+	fingerprint := clusterFingerprint(k.Cluster)
+	if cacheable {
+		if e, ok := c.entries[k.Name]; ok {
+			if e.fingerprint == fingerprint && time.Now().Before(e.expireAt) {
+				c.hits.Add(1)
+				return e, nil
+			}
+			delete(c.entries, k.Name)
+			c.evictions.Add(1)
+		}
+	}
+	c.misses.Add(1)
+	cfg, err := k.buildConfig()
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("unsupported connect direction %q", k.Spec.Connect.Direction)
+	}
+	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	d := memory.NewMemCacheClient(clientset.Discovery())
+	e := &cacheEntry{
+		fingerprint:    fingerprint,
+		expireAt:       time.Now().Add(c.ttl),
+		config:         cfg,
+		clientset:      clientset,
+		discovery:      d,
+		mapper:         restmapper.NewDeferredDiscoveryRESTMapper(d),
+		userTransports: map[string]userTransport{},
+	}
+	if cacheable {
+		c.entries[k.Name] = e
+	}
+	return e, nil
+}
+
+// Config 返回集群 rest config 的副本，调用者可以修改
+func (c *ClientCache) Config(k *Kubernetes) (*rest.Config, error) {
+	e, err := c.entry(k)
+	if err != nil {
+		return nil, err
+	}
+	return rest.CopyConfig(e.config), nil
+}
+
+func (c *ClientCache) Clientset(k *Kubernetes) (*kubernetes.Clientset, error) {
+	e, err := c.entry(k)
+	if err != nil {
+		return nil, err
+	}
+	return e.clientset, nil
+}
+
+// Version 返回缓存的集群版本
+func (c *ClientCache) Version(k *Kubernetes) (*version.Info, error) {
+	e, err := c.entry(k)
+	if err != nil {
+		return nil, err
+	}
+	e.versionMu.Lock()
+	defer e.versionMu.Unlock()
+	if e.version == nil {
+		v, err := e.clientset.ServerVersion()
+		if err != nil {
+			return nil, err
+		}
+		e.version = v
+	}
+	return e.version, nil
+}
+
+// Discovery 返回缓存的 API 发现信息
+func (c *ClientCache) Discovery(k *Kubernetes) (discovery.CachedDiscoveryInterface, error) {
+	e, err := c.entry(k)
+	if err != nil {
+		return nil, err
+	}
+	return e.discovery, nil
+}
+
+// RESTMapper 返回基于缓存的 API 发现信息的 RESTMapper
+func (c *ClientCache) RESTMapper(k *Kubernetes) (meta.RESTMapper, error) {
+	e, err := c.entry(k)
+	if err != nil {
+		return nil, err
+	}
+	return e.mapper, nil
+}
+
+// refreshDiscovery 在资源不存在于发现信息中时重新获取，两次获取的间隔不小于 discoveryRefreshInterval
+func (c *ClientCache) refreshDiscovery(k *Kubernetes) bool {
+	e, err := c.entry(k)
+	if err != nil {
+		return false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if time.Since(e.discoveryRefreshAt) < discoveryRefreshInterval {
+		return false
+	}
+	e.discoveryRefreshAt = time.Now()
+	e.mapper.Reset()
+	return true
+}
+
+// Transport 返回使用集群凭据的 transport
+func (c *ClientCache) Transport(k *Kubernetes) (http.RoundTripper, error) {
+	e, err := c.entry(k)
+	if err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.transport == nil {
+		rt, err := rest.TransportFor(e.config)
+		if err != nil {
+			return nil, err
+		}
+		e.transport = rt
+	}
+	return e.transport, nil
+}
+
+// UserTransport 返回使用用户证书的 transport，证书变化后重新创建
+func (c *ClientCache) UserTransport(k *Kubernetes, user string, cert []byte) (http.RoundTripper, error) {
+	e, err := c.entry(k)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := hash(cert, k.PrivateKey)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if t, ok := e.userTransports[user]; ok && t.fingerprint == fingerprint {
+		return t.transport, nil
+	}
+	cfg, err := k.UserConfig(cert)
+	if err != nil {
+		return nil, err
+	}
+	rt, err := rest.TransportFor(cfg)
+	if err != nil {
+		return nil, err
+	}
+	e.userTransports[user] = userTransport{fingerprint: fingerprint, transport: rt}
+	return rt, nil
+}
+
+// ImpersonatedTransport 返回使用集群凭据并以用户身份访问集群的 transport
+func (c *ClientCache) ImpersonatedTransport(k *Kubernetes, user string) (http.RoundTripper, error) {
+	e, err := c.entry(k)
+	if err != nil {
+		return nil, err
+	}
+	// 与证书用户的 transport 保存在同一个 map 中，使用前缀区分
+	key := impersonatedUserPrefix + user
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if t, ok := e.userTransports[key]; ok {
+		return t.transport, nil
+	}
+	cfg, err := k.ImpersonatedConfig(user)
+	if err != nil {
+		return nil, err
+	}
+	rt, err := rest.TransportFor(cfg)
+	if err != nil {
+		return nil, err
+	}
+	e.userTransports[key] = userTransport{transport: rt}
+	return rt, nil
+}
+
+// ImpersonatedConfig 返回使用集群凭据并以用户身份访问集群的配置，用户的权限与证书模式相同
+func (k *Kubernetes) ImpersonatedConfig(user string) (*rest.Config, error) {
+	cfg, err := k.Config()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Impersonate = rest.ImpersonationConfig{
+		UserName: user,
+		Groups:   []string{ImpersonatedGroup},
+	}
+	return cfg, nil
+}
+
+// UserConfig 返回使用 KubePi 为用户签发的证书访问集群的配置
+func (k *Kubernetes) UserConfig(cert []byte) (*rest.Config, error) {
+	cfg := &rest.Config{
+		Host: k.Spec.Connect.Forward.ApiServer,
+		TLSClientConfig: rest.TLSClientConfig{
+			Insecure: true,
+			CertData: cert,
+			KeyData:  pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: k.PrivateKey}),
+		},
+	}
+	if err := k.ApplyProxy(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// clusterFingerprint 只包含与连接相关的字段，状态变化不会使缓存失效
+func clusterFingerprint(c *v1Cluster.Cluster) string {
+	bs, _ := json.Marshal(struct {
+		UUID          string
+		Spec          v1Cluster.Spec
+		CaCertificate v1Cluster.Certificate
+	}{c.UUID, c.Spec, c.CaCertificate})
+	return hash(bs)
+}
+
+func hash(data ...[]byte) string {
+	h := sha256.New()
+	for _, d := range data {
+		h.Write(d)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
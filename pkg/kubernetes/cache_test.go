@@ -0,0 +1,205 @@
+package kubernetes
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v1 "github.com/ClusterOperator/kubepi/internal/model/v1"
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
+	"github.com/ClusterOperator/kubepi/pkg/certificate"
+)
+
+func TestClientCache(t *testing.T) {
+	var versionRequests, discoveryRequests atomic.Int32
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/version":
+			versionRequests.Add(1)
+			_, _ = w.Write([]byte(`{"major":"1","minor":"29","gitVersion":"v1.29.0"}`))
+		case "/api":
+			discoveryRequests.Add(1)
+			_, _ = w.Write([]byte(`{"kind":"APIVersions","versions":["v1"]}`))
+		case "/apis":
+			_, _ = w.Write([]byte(`{"kind":"APIGroupList","groups":[]}`))
+		case "/api/v1":
+			_, _ = w.Write([]byte(`{"kind":"APIResourceList","groupVersion":"v1","resources":[
+				{"name":"pods","namespaced":true,"kind":"Pod","verbs":["list"]},
+				{"name":"nodes","namespaced":false,"kind":"Node","verbs":["list"]}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer apiServer.Close()
+
+	old := DefaultCache
+	DefaultCache = NewClientCache(time.Minute)
+	defer func() { DefaultCache = old }()
+
+	c := &v1Cluster.Cluster{Metadata: v1.Metadata{Name: "c1", UUID: "u1"}}
+	c.Spec.Connect.Direction = v1Cluster.DirectionForward
+	c.Spec.Connect.Forward.ApiServer = apiServer.URL
+	k := NewKubernetes(c)
+
+	for i := 0; i < 3; i++ {
+		if _, err := k.VersionMinor(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := versionRequests.Load(); n != 1 {
+		t.Fatalf("expect version requested once, got %d", n)
+	}
+	for _, r := range []struct {
+		name       string
+		namespaced bool
+	}{{"pods", true}, {"nodes", false}, {"pods", true}} {
+		namespaced, err := k.IsNamespacedResource(r.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if namespaced != r.namespaced {
+			t.Fatalf("expect %s namespaced %v", r.name, r.namespaced)
+		}
+	}
+	if n := discoveryRequests.Load(); n != 1 {
+		t.Fatalf("expect discovery requested once, got %d", n)
+	}
+	for _, r := range []struct {
+		arg        string
+		resource   string
+		namespaced bool
+	}{{"Pod", "pods", true}, {"pods", "pods", true}, {"node", "nodes", false}} {
+		gvr, namespaced, err := k.ResolveResource(r.arg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gvr.Resource != r.resource || gvr.Version != "v1" || namespaced != r.namespaced {
+			t.Fatalf("unexpected resource %v for %s", gvr, r.arg)
+		}
+	}
+
+	key, err := certificate.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.PrivateKey = key
+	cert1, cert2 := selfSignedCert(t, key, "user"), selfSignedCert(t, key, "user")
+	rt1, err := k.UserTransport("user", cert1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt2, _ := k.UserTransport("user", cert1)
+	if rt1 != rt2 {
+		t.Fatal("expect the same transport for the same certificate")
+	}
+	rt3, _ := k.UserTransport("user", cert2)
+	if rt1 == rt3 {
+		t.Fatal("expect a new transport after the certificate changed")
+	}
+
+	// 状态变化不影响缓存，连接配置变化后重新创建
+	c.Status.Phase = "Completed"
+	if _, err := k.Version(); err != nil || versionRequests.Load() != 1 {
+		t.Fatalf("expect cached version, err %v", err)
+	}
+	c.Spec.Authentication.Mode = "bearer"
+	c.Spec.Authentication.BearerToken = "token"
+	if _, err := k.Version(); err != nil || versionRequests.Load() != 2 {
+		t.Fatalf("expect version requested again after connection changed, err %v", err)
+	}
+
+	DefaultCache.Invalidate("c1")
+	if s := DefaultCache.Stats(); s.Clusters != 0 || s.Hits == 0 || s.Evictions != 2 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	// 未保存的集群不缓存
+	unsaved := &v1Cluster.Cluster{Metadata: v1.Metadata{Name: "c2"}}
+	unsaved.Spec.Connect.Direction = v1Cluster.DirectionForward
+	unsaved.Spec.Connect.Forward.ApiServer = apiServer.URL
+	if _, err := NewKubernetes(unsaved).Version(); err != nil {
+		t.Fatal(err)
+	}
+	if s := DefaultCache.Stats(); s.Clusters != 0 {
+		t.Fatalf("unsaved cluster should not be cached, %+v", s)
+	}
+}
+
+func TestImpersonatedTransport(t *testing.T) {
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer admin" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("Impersonate-User") != "alice" || r.Header.Get("Impersonate-Group") != ImpersonatedGroup {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer apiServer.Close()
+
+	old := DefaultCache
+	DefaultCache = NewClientCache(time.Minute)
+	defer func() { DefaultCache = old }()
+
+	c := &v1Cluster.Cluster{Metadata: v1.Metadata{Name: "c1", UUID: "u1"}}
+	c.Spec.Connect.Direction = v1Cluster.DirectionForward
+	c.Spec.Connect.Forward.ApiServer = apiServer.URL
+	c.Spec.Authentication.Mode = "bearer"
+	c.Spec.Authentication.BearerToken = "admin"
+	c.Spec.MemberAuthMode = v1Cluster.MemberAuthImpersonation
+	k := NewKubernetes(c)
+	if !Impersonation(c) {
+		t.Fatal("expect impersonation mode")
+	}
+
+	rt, err := k.ImpersonatedTransport("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := k.ImpersonatedTransport("alice"); again != rt {
+		t.Fatal("expect transport to be cached")
+	}
+	req, _ := http.NewRequest(http.MethodGet, apiServer.URL+"/api", nil)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+	// 管理员配置不受影响
+	cfg, _ := k.Config()
+	if cfg.Impersonate.UserName != "" {
+		t.Fatal("cached config should not be impersonated")
+	}
+}
+
+func selfSignedCert(t *testing.T, key []byte, cn string) []byte {
+	k, err := x509.ParsePKCS1PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &k.PublicKey, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
@@ -0,0 +1,315 @@
+package kubernetes
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// VersionMapping 是同一资源在不同版本的集群中可以互相替代的 API 版本，Versions 按优先级排列
+type VersionMapping struct {
+	Resource string
+	Versions []schema.GroupVersion
+}
+
+// VersionMappings 是页面请求的资源在旧版本或新版本集群中的替代版本，请求的版本集群不提供时按顺序使用第一个提供的版本
+var VersionMappings = []VersionMapping{
+	{Resource: "ingresses", Versions: []schema.GroupVersion{
+		{Group: "networking.k8s.io", Version: "v1"},
+		{Group: "networking.k8s.io", Version: "v1beta1"},
+		{Group: "extensions", Version: "v1beta1"},
+	}},
+	{Resource: "ingressclasses", Versions: []schema.GroupVersion{
+		{Group: "networking.k8s.io", Version: "v1"},
+		{Group: "networking.k8s.io", Version: "v1beta1"},
+	}},
+	{Resource: "cronjobs", Versions: []schema.GroupVersion{
+		{Group: "batch", Version: "v1"},
+		{Group: "batch", Version: "v1beta1"},
+	}},
+	{Resource: "poddisruptionbudgets", Versions: []schema.GroupVersion{
+		{Group: "policy", Version: "v1"},
+		{Group: "policy", Version: "v1beta1"},
+	}},
+	{Resource: "horizontalpodautoscalers", Versions: []schema.GroupVersion{
+		{Group: "autoscaling", Version: "v2"},
+		{Group: "autoscaling", Version: "v2beta2"},
+		{Group: "autoscaling", Version: "v1"},
+	}},
+	{Resource: "endpointslices", Versions: []schema.GroupVersion{
+		{Group: "discovery.k8s.io", Version: "v1"},
+		{Group: "discovery.k8s.io", Version: "v1beta1"},
+	}},
+	{Resource: "runtimeclasses", Versions: []schema.GroupVersion{
+		{Group: "node.k8s.io", Version: "v1"},
+		{Group: "node.k8s.io", Version: "v1beta1"},
+	}},
+	{Resource: "priorityclasses", Versions: []schema.GroupVersion{
+		{Group: "scheduling.k8s.io", Version: "v1"},
+		{Group: "scheduling.k8s.io", Version: "v1beta1"},
+	}},
+}
+
+// VersionConverter 转换 JSON 解码后的对象，apiVersion 由调用者修改
+type VersionConverter func(obj map[string]interface{})
+
+type conversion struct {
+	resource string
+	from, to schema.GroupVersion
+}
+
+// versionConverters 是字段不兼容的版本之间的转换，没有注册的版本之间只修改 apiVersion
+var versionConverters = map[conversion]VersionConverter{}
+
+func registerConverter(resource string, from, to []schema.GroupVersion, fn VersionConverter) {
+	for _, f := range from {
+		for _, t := range to {
+			versionConverters[conversion{resource: resource, from: f, to: t}] = fn
+		}
+	}
+}
+
+func init() {
+	ingressV1 := []schema.GroupVersion{{Group: "networking.k8s.io", Version: "v1"}}
+	ingressV1beta1 := []schema.GroupVersion{{Group: "networking.k8s.io", Version: "v1beta1"}, {Group: "extensions", Version: "v1beta1"}}
+	registerConverter("ingresses", ingressV1, ingressV1beta1, ingressToV1beta1)
+	registerConverter("ingresses", ingressV1beta1, ingressV1, ingressToV1)
+
+	hpaV2 := []schema.GroupVersion{{Group: "autoscaling", Version: "v2"}, {Group: "autoscaling", Version: "v2beta2"}}
+	hpaV1 := []schema.GroupVersion{{Group: "autoscaling", Version: "v1"}}
+	registerConverter("horizontalpodautoscalers", hpaV2, hpaV1, hpaToV1)
+	registerConverter("horizontalpodautoscalers", hpaV1, hpaV2, hpaToV2)
+}
+
+// ResolveVersion 返回集群实际提供的资源版本，served 判断集群是否提供 group/version 下的资源。
+// 请求的版本可用、资源不在映射表中或者所有替代版本都不可用时返回请求的版本
+func ResolveVersion(gvr schema.GroupVersionResource, served func(gv schema.GroupVersion, resource string) bool) schema.GroupVersionResource {
+	if served(gvr.GroupVersion(), gvr.Resource) {
+		return gvr
+	}
+	for _, m := range VersionMappings {
+		if m.Resource != gvr.Resource || !containsGroupVersion(m.Versions, gvr.GroupVersion()) {
+			continue
+		}
+		for _, gv := range m.Versions {
+			if gv != gvr.GroupVersion() && served(gv, gvr.Resource) {
+				return gv.WithResource(gvr.Resource)
+			}
+		}
+	}
+	return gvr
+}
+
+func containsGroupVersion(gvs []schema.GroupVersion, gv schema.GroupVersion) bool {
+	for i := range gvs {
+		if gvs[i] == gv {
+			return true
+		}
+	}
+	return false
+}
+
+// ConvertObject 将对象或者对象列表从 from 版本转换为 to 版本，对象中没有 apiVersion 时（例如 patch）只转换字段
+func ConvertObject(resource string, from, to schema.GroupVersion, obj map[string]interface{}) {
+	if from == to {
+		return
+	}
+	if _, ok := obj["apiVersion"]; ok {
+		obj["apiVersion"] = to.String()
+	}
+	if kind, _ := obj["kind"].(string); strings.HasSuffix(kind, "List") {
+		items, _ := obj["items"].([]interface{})
+		for i := range items {
+			if item, ok := items[i].(map[string]interface{}); ok {
+				ConvertObject(resource, from, to, item)
+			}
+		}
+		return
+	}
+	if fn, ok := versionConverters[conversion{resource: resource, from: from, to: to}]; ok {
+		fn(obj)
+	}
+}
+
+// ServedVersion 使用缓存的 API 发现信息返回集群实际提供的资源版本
+func (k *Kubernetes) ServedVersion(gvr schema.GroupVersionResource) (schema.GroupVersionResource, error) {
+	d, err := DefaultCache.Discovery(k)
+	if err != nil {
+		return gvr, err
+	}
+	return ResolveVersion(gvr, func(gv schema.GroupVersion, resource string) bool {
+		list, err := d.ServerResourcesForGroupVersion(gv.String())
+		if err != nil || list == nil {
+			return false
+		}
+		for i := range list.APIResources {
+			if list.APIResources[i].Name == resource {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+func objectField(obj map[string]interface{}, fields ...string) map[string]interface{} {
+	for _, f := range fields {
+		next, ok := obj[f].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		obj = next
+	}
+	return obj
+}
+
+// ingressPaths 遍历 ingress 规则中的所有 path
+func ingressPaths(obj map[string]interface{}, fn func(path map[string]interface{})) {
+	spec := objectField(obj, "spec")
+	if spec == nil {
+		return
+	}
+	rules, _ := spec["rules"].([]interface{})
+	for _, r := range rules {
+		rule, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		paths, _ := objectField(rule, "http")["paths"].([]interface{})
+		for _, p := range paths {
+			if path, ok := p.(map[string]interface{}); ok {
+				fn(path)
+			}
+		}
+	}
+}
+
+// v1 中 backend.service.{name,port} 对应 v1beta1 中的 serviceName 和 servicePort
+func ingressBackendToV1beta1(backend map[string]interface{}) {
+	service := objectField(backend, "service")
+	if service == nil {
+		return
+	}
+	delete(backend, "service")
+	backend["serviceName"] = service["name"]
+	port := objectField(service, "port")
+	if n, ok := port["number"]; ok {
+		backend["servicePort"] = n
+	} else if name, ok := port["name"]; ok {
+		backend["servicePort"] = name
+	}
+}
+
+func ingressBackendToV1(backend map[string]interface{}) {
+	name, ok := backend["serviceName"]
+	if !ok {
+		return
+	}
+	port := map[string]interface{}{}
+	switch p := backend["servicePort"].(type) {
+	case string:
+		port["name"] = p
+	case nil:
+	default:
+		port["number"] = p
+	}
+	delete(backend, "serviceName")
+	delete(backend, "servicePort")
+	backend["service"] = map[string]interface{}{"name": name, "port": port}
+}
+
+func ingressToV1beta1(obj map[string]interface{}) {
+	if spec := objectField(obj, "spec"); spec != nil {
+		if b, ok := spec["defaultBackend"].(map[string]interface{}); ok {
+			delete(spec, "defaultBackend")
+			ingressBackendToV1beta1(b)
+			spec["backend"] = b
+		}
+	}
+	ingressPaths(obj, func(path map[string]interface{}) {
+		if b, ok := path["backend"].(map[string]interface{}); ok {
+			ingressBackendToV1beta1(b)
+		}
+	})
+}
+
+func ingressToV1(obj map[string]interface{}) {
+	if spec := objectField(obj, "spec"); spec != nil {
+		if b, ok := spec["backend"].(map[string]interface{}); ok {
+			delete(spec, "backend")
+			ingressBackendToV1(b)
+			spec["defaultBackend"] = b
+		}
+	}
+	ingressPaths(obj, func(path map[string]interface{}) {
+		if b, ok := path["backend"].(map[string]interface{}); ok {
+			ingressBackendToV1(b)
+		}
+		// v1 中 pathType 是必填字段
+		if _, ok := path["pathType"]; !ok {
+			path["pathType"] = "ImplementationSpecific"
+		}
+	})
+}
+
+// autoscaling/v1 只支持 CPU 使用率，转换时只保留 CPU 使用率指标
+func hpaToV1(obj map[string]interface{}) {
+	if spec := objectField(obj, "spec"); spec != nil {
+		metrics, _ := spec["metrics"].([]interface{})
+		for _, m := range metrics {
+			if target := cpuUtilization(m, "target"); target != nil {
+				spec["targetCPUUtilizationPercentage"] = target["averageUtilization"]
+			}
+		}
+		delete(spec, "metrics")
+		delete(spec, "behavior")
+	}
+	if status := objectField(obj, "status"); status != nil {
+		metrics, _ := status["currentMetrics"].([]interface{})
+		for _, m := range metrics {
+			if current := cpuUtilization(m, "current"); current != nil {
+				status["currentCPUUtilizationPercentage"] = current["averageUtilization"]
+			}
+		}
+		delete(status, "currentMetrics")
+		delete(status, "conditions")
+	}
+}
+
+func hpaToV2(obj map[string]interface{}) {
+	if spec := objectField(obj, "spec"); spec != nil {
+		if v, ok := spec["targetCPUUtilizationPercentage"]; ok {
+			delete(spec, "targetCPUUtilizationPercentage")
+			spec["metrics"] = []interface{}{cpuMetric("target", map[string]interface{}{"type": "Utilization", "averageUtilization": v})}
+		}
+	}
+	if status := objectField(obj, "status"); status != nil {
+		if v, ok := status["currentCPUUtilizationPercentage"]; ok {
+			delete(status, "currentCPUUtilizationPercentage")
+			status["currentMetrics"] = []interface{}{cpuMetric("current", map[string]interface{}{"averageUtilization": v})}
+		}
+	}
+}
+
+func cpuMetric(field string, value map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"type":     "Resource",
+		"resource": map[string]interface{}{"name": "cpu", field: value},
+	}
+}
+
+// cpuUtilization 返回 CPU 使用率指标的 target 或 current 字段，其他指标返回 nil
+func cpuUtilization(metric interface{}, field string) map[string]interface{} {
+	m, ok := metric.(map[string]interface{})
+	if !ok || m["type"] != "Resource" {
+		return nil
+	}
+	resource := objectField(m, "resource")
+	if resource == nil || resource["name"] != "cpu" {
+		return nil
+	}
+	value := objectField(resource, field)
+	if value == nil || value["averageUtilization"] == nil {
+		return nil
+	}
+	return value
+}
@@ -0,0 +1,150 @@
+package kubernetes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "github.com/ClusterOperator/kubepi/internal/model/v1"
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestResolveVersion(t *testing.T) {
+	gvr := func(g, v, r string) schema.GroupVersionResource {
+		return schema.GroupVersionResource{Group: g, Version: v, Resource: r}
+	}
+	cases := []struct {
+		name      string
+		requested schema.GroupVersionResource
+		served    []schema.GroupVersionResource
+		expect    schema.GroupVersionResource
+	}{
+		{"served", gvr("batch", "v1", "cronjobs"), []schema.GroupVersionResource{gvr("batch", "v1", "cronjobs")}, gvr("batch", "v1", "cronjobs")},
+		{"old cronjob", gvr("batch", "v1", "cronjobs"), []schema.GroupVersionResource{gvr("batch", "v1beta1", "cronjobs")}, gvr("batch", "v1beta1", "cronjobs")},
+		{"new cronjob", gvr("batch", "v1beta1", "cronjobs"), []schema.GroupVersionResource{gvr("batch", "v1", "cronjobs")}, gvr("batch", "v1", "cronjobs")},
+		{"old ingress", gvr("networking.k8s.io", "v1", "ingresses"), []schema.GroupVersionResource{gvr("extensions", "v1beta1", "ingresses")}, gvr("extensions", "v1beta1", "ingresses")},
+		{"preferred order", gvr("autoscaling", "v2", "horizontalpodautoscalers"),
+			[]schema.GroupVersionResource{gvr("autoscaling", "v1", "horizontalpodautoscalers"), gvr("autoscaling", "v2beta2", "horizontalpodautoscalers")},
+			gvr("autoscaling", "v2beta2", "horizontalpodautoscalers")},
+		{"not mapped", gvr("apps", "v1", "deployments"), nil, gvr("apps", "v1", "deployments")},
+		{"not served", gvr("policy", "v1", "poddisruptionbudgets"), nil, gvr("policy", "v1", "poddisruptionbudgets")},
+	}
+	for _, c := range cases {
+		served := func(gv schema.GroupVersion, resource string) bool {
+			for _, s := range c.served {
+				if s == gv.WithResource(resource) {
+					return true
+				}
+			}
+			return false
+		}
+		if got := ResolveVersion(c.requested, served); got != c.expect {
+			t.Errorf("%s: expect %v, got %v", c.name, c.expect, got)
+		}
+	}
+}
+
+func TestConvertObject(t *testing.T) {
+	networkingV1 := schema.GroupVersion{Group: "networking.k8s.io", Version: "v1"}
+	extensionsV1beta1 := schema.GroupVersion{Group: "extensions", Version: "v1beta1"}
+	ingress := map[string]interface{}{
+		"apiVersion": "networking.k8s.io/v1",
+		"kind":       "Ingress",
+		"spec": map[string]interface{}{
+			"defaultBackend": map[string]interface{}{"service": map[string]interface{}{"name": "default", "port": map[string]interface{}{"number": 80.0}}},
+			"rules": []interface{}{map[string]interface{}{"http": map[string]interface{}{"paths": []interface{}{
+				map[string]interface{}{"path": "/", "pathType": "Prefix", "backend": map[string]interface{}{"service": map[string]interface{}{"name": "web", "port": map[string]interface{}{"name": "http"}}}},
+			}}}},
+		},
+	}
+	ConvertObject("ingresses", networkingV1, extensionsV1beta1, ingress)
+	expect := map[string]interface{}{
+		"apiVersion": "extensions/v1beta1",
+		"kind":       "Ingress",
+		"spec": map[string]interface{}{
+			"backend": map[string]interface{}{"serviceName": "default", "servicePort": 80.0},
+			"rules": []interface{}{map[string]interface{}{"http": map[string]interface{}{"paths": []interface{}{
+				map[string]interface{}{"path": "/", "pathType": "Prefix", "backend": map[string]interface{}{"serviceName": "web", "servicePort": "http"}},
+			}}}},
+		},
+	}
+	if !reflect.DeepEqual(ingress, expect) {
+		t.Fatalf("unexpected ingress %v", ingress)
+	}
+	ConvertObject("ingresses", extensionsV1beta1, networkingV1, ingress)
+	backend := ingress["spec"].(map[string]interface{})["defaultBackend"].(map[string]interface{})
+	if !reflect.DeepEqual(backend, map[string]interface{}{"service": map[string]interface{}{"name": "default", "port": map[string]interface{}{"number": 80.0}}}) {
+		t.Fatalf("unexpected backend %v", backend)
+	}
+
+	list := map[string]interface{}{
+		"apiVersion": "autoscaling/v1",
+		"kind":       "HorizontalPodAutoscalerList",
+		"items": []interface{}{map[string]interface{}{
+			"spec":   map[string]interface{}{"maxReplicas": 3.0, "targetCPUUtilizationPercentage": 50.0},
+			"status": map[string]interface{}{"currentCPUUtilizationPercentage": 20.0},
+		}},
+	}
+	autoscalingV1 := schema.GroupVersion{Group: "autoscaling", Version: "v1"}
+	autoscalingV2 := schema.GroupVersion{Group: "autoscaling", Version: "v2"}
+	ConvertObject("horizontalpodautoscalers", autoscalingV1, autoscalingV2, list)
+	if list["apiVersion"] != "autoscaling/v2" {
+		t.Fatalf("unexpected apiVersion %v", list["apiVersion"])
+	}
+	hpa := list["items"].([]interface{})[0].(map[string]interface{})
+	ConvertObject("horizontalpodautoscalers", autoscalingV2, autoscalingV1, hpa)
+	if spec := hpa["spec"].(map[string]interface{}); spec["targetCPUUtilizationPercentage"] != 50.0 || spec["metrics"] != nil {
+		t.Fatalf("unexpected spec %v", spec)
+	}
+	if status := hpa["status"].(map[string]interface{}); status["currentCPUUtilizationPercentage"] != 20.0 {
+		t.Fatalf("unexpected status %v", status)
+	}
+}
+
+func TestServedVersion(t *testing.T) {
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api":
+			_, _ = w.Write([]byte(`{"kind":"APIVersions","versions":["v1"]}`))
+		case "/api/v1":
+			_, _ = w.Write([]byte(`{"kind":"APIResourceList","groupVersion":"v1","resources":[]}`))
+		case "/apis":
+			_, _ = w.Write([]byte(`{"kind":"APIGroupList","groups":[{"name":"batch","versions":[{"groupVersion":"batch/v1","version":"v1"},{"groupVersion":"batch/v1beta1","version":"v1beta1"}],"preferredVersion":{"groupVersion":"batch/v1","version":"v1"}}]}`))
+		case "/apis/batch/v1":
+			_, _ = w.Write([]byte(`{"kind":"APIResourceList","groupVersion":"batch/v1","resources":[{"name":"jobs","namespaced":true,"kind":"Job","verbs":["list"]}]}`))
+		case "/apis/batch/v1beta1":
+			_, _ = w.Write([]byte(`{"kind":"APIResourceList","groupVersion":"batch/v1beta1","resources":[{"name":"cronjobs","namespaced":true,"kind":"CronJob","verbs":["list"]}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer apiServer.Close()
+
+	old := DefaultCache
+	DefaultCache = NewClientCache(time.Minute)
+	defer func() { DefaultCache = old }()
+
+	c := &v1Cluster.Cluster{Metadata: v1.Metadata{Name: "c1", UUID: "u1"}}
+	c.Spec.Connect.Direction = v1Cluster.DirectionForward
+	c.Spec.Connect.Forward.ApiServer = apiServer.URL
+	k := NewKubernetes(c)
+
+	for _, r := range []struct {
+		requested, expect schema.GroupVersionResource
+	}{
+		{schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "cronjobs"}, schema.GroupVersionResource{Group: "batch", Version: "v1beta1", Resource: "cronjobs"}},
+		{schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}, schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}},
+	} {
+		got, err := k.ServedVersion(r.requested)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != r.expect {
+			t.Fatalf("expect %v, got %v", r.expect, got)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// DefaultInformerIdleTimeout 是集群的 informer 没有被访问后停止的时间
+	DefaultInformerIdleTimeout = 30 * time.Minute
+	// DefaultInformerMaxStaleness 是 watch 出错后不使用缓存的时间，期间 informer 重新 list 追上集群的数据
+	DefaultInformerMaxStaleness = time.Minute
+	// DefaultInformerMaxObjects 是单个资源缓存的最大对象数量，超过后停止缓存该资源
+	DefaultInformerMaxObjects = 20000
+)
+
+// InformerResource 是可以使用 informer 缓存的资源
+type InformerResource struct {
+	schema.GroupVersionResource
+	ListKind string
+}
+
+// InformerResources 是页面中频繁查询的资源，只缓存这些资源
+var InformerResources = []InformerResource{
+	{GroupVersionResource: schema.GroupVersionResource{Version: "v1", Resource: "pods"}, ListKind: "PodList"},
+	{GroupVersionResource: schema.GroupVersionResource{Version: "v1", Resource: "events"}, ListKind: "EventList"},
+	{GroupVersionResource: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, ListKind: "DeploymentList"},
+}
+
+// InformerCache 为开启了缓存的集群按需启动 informer，列表请求直接从本地缓存返回。
+// 集群长时间没有访问时停止 informer，watch 出错后的一段时间内以及对象数量超过限制时不使用缓存
+type InformerCache struct {
+	mu           sync.Mutex
+	idleTimeout  time.Duration
+	maxStaleness time.Duration
+	maxObjects   int
+	clusters     map[string]*clusterInformers
+}
+
+type clusterInformers struct {
+	fingerprint string
+	client      dynamic.Interface
+	stop        chan struct{}
+
+	mu         sync.Mutex
+	lastAccess time.Time
+	resources  map[schema.GroupVersionResource]*resourceInformer
+}
+
+type resourceInformer struct {
+	informer cache.SharedIndexInformer
+	stop     chan struct{}
+
+	mu             sync.Mutex
+	disabled       bool
+	lastWatchError time.Time
+	watchError     string
+}
+
+// CachedList 是从 informer 中读取的列表，Items 与缓存共享，调用者不能修改
+type CachedList struct {
+	Kind            string
+	APIVersion      string
+	ResourceVersion string
+	Items           []interface{}
+}
+
+type InformerStats struct {
+	Cluster   string                  `json:"cluster"`
+	Resources []ResourceInformerStats `json:"resources"`
+}
+
+type ResourceInformerStats struct {
+	Resource       string    `json:"resource"`
+	Synced         bool      `json:"synced"`
+	Disabled       bool      `json:"disabled"`
+	Objects        int       `json:"objects"`
+	LastWatchError time.Time `json:"lastWatchError"`
+	WatchError     string    `json:"watchError"`
+}
+
+// DefaultInformers 是代理使用的 informer 缓存
+var DefaultInformers = NewInformerCache(DefaultInformerIdleTimeout, DefaultInformerMaxStaleness, DefaultInformerMaxObjects)
+
+func NewInformerCache(idleTimeout, maxStaleness time.Duration, maxObjects int) *InformerCache {
+	return &InformerCache{
+		idleTimeout:  idleTimeout,
+		maxStaleness: maxStaleness,
+		maxObjects:   maxObjects,
+		clusters:     map[string]*clusterInformers{},
+	}
+}
+
+// SetLimits 修改缓存的限制，maxObjects 小于等于 0 时不限制对象数量。已经启动的 informer 全部停止，下次访问时按新的限制启动
+func (c *InformerCache) SetLimits(idleTimeout, maxStaleness time.Duration, maxObjects int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.idleTimeout = idleTimeout
+	c.maxStaleness = maxStaleness
+	c.maxObjects = maxObjects
+	for name, ci := range c.clusters {
+		close(ci.stop)
+		delete(c.clusters, name)
+	}
+}
+
+// Stop 停止集群的 informer，在集群删除或关闭缓存时调用
+func (c *InformerCache) Stop(cluster string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ci, ok := c.clusters[cluster]; ok {
+		close(ci.stop)
+		delete(c.clusters, cluster)
+	}
+}
+
+func (c *InformerCache) Stats() []InformerStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := make([]InformerStats, 0, len(c.clusters))
+	for name, ci := range c.clusters {
+		s := InformerStats{Cluster: name, Resources: []ResourceInformerStats{}}
+		ci.mu.Lock()
+		for gvr, ri := range ci.resources {
+			ri.mu.Lock()
+			s.Resources = append(s.Resources, ResourceInformerStats{
+				Resource:       gvr.String(),
+				Synced:         ri.informer.HasSynced(),
+				Disabled:       ri.disabled,
+				Objects:        len(ri.informer.GetStore().ListKeys()),
+				LastWatchError: ri.lastWatchError,
+				WatchError:     ri.watchError,
+			})
+			ri.mu.Unlock()
+		}
+		ci.mu.Unlock()
+		result = append(result, s)
+	}
+	return result
+}
+
+// Cacheable 判断资源是否可以使用 informer 缓存
+func Cacheable(gvr schema.GroupVersionResource) (InformerResource, bool) {
+	for _, r := range InformerResources {
+		if r.GroupVersionResource == gvr {
+			return r, true
+		}
+	}
+	return InformerResource{}, false
+}
+
+// List 从 informer 中读取资源，namespace 为空时返回所有 namespace 的资源。
+// ok 为 false 时表示集群未开启缓存、资源不缓存、informer 还未同步完成或者数据可能已经过期，调用者应当直接请求集群
+func (c *InformerCache) List(cluster *v1Cluster.Cluster, gvr schema.GroupVersionResource, namespace string, selector labels.Selector) (*CachedList, bool) {
+	if !cluster.Spec.InformerCache.Enabled {
+		return nil, false
+	}
+	r, ok := Cacheable(gvr)
+	if !ok {
+		return nil, false
+	}
+	ci, err := c.cluster(cluster)
+	if err != nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	maxStaleness, maxObjects := c.maxStaleness, c.maxObjects
+	c.mu.Unlock()
+	ri := ci.resource(gvr, maxObjects)
+	if !ri.informer.HasSynced() {
+		return nil, false
+	}
+
+	ri.mu.Lock()
+	if !ri.disabled && maxObjects > 0 && len(ri.informer.GetStore().ListKeys()) > maxObjects {
+		// 对象太多时停止缓存，释放内存
+		ri.disabled = true
+		close(ri.stop)
+	}
+	usable := !ri.disabled && time.Since(ri.lastWatchError) > maxStaleness
+	ri.mu.Unlock()
+	if !usable {
+		return nil, false
+	}
+
+	var objs []interface{}
+	if namespace == "" {
+		objs = ri.informer.GetStore().List()
+	} else {
+		objs, err = ri.informer.GetIndexer().ByIndex(cache.NamespaceIndex, namespace)
+		if err != nil {
+			return nil, false
+		}
+	}
+	list := &CachedList{
+		Kind:            r.ListKind,
+		APIVersion:      gvr.GroupVersion().String(),
+		ResourceVersion: ri.informer.LastSyncResourceVersion(),
+		Items:           make([]interface{}, 0, len(objs)),
+	}
+	for _, obj := range objs {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		if selector != nil && !selector.Matches(labels.Set(u.GetLabels())) {
+			continue
+		}
+		list.Items = append(list.Items, u.UnstructuredContent())
+	}
+	return list, true
+}
+
+// cluster 返回集群的 informer，连接配置变化时重新创建
+func (c *InformerCache) cluster(cluster *v1Cluster.Cluster) (*clusterInformers, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fingerprint := clusterFingerprint(cluster)
+	if ci, ok := c.clusters[cluster.Name]; ok {
+		if ci.fingerprint == fingerprint {
+			ci.touch()
+			return ci, nil
+		}
+		close(ci.stop)
+		delete(c.clusters, cluster.Name)
+	}
+	if cluster.UUID == "" {
+		return nil, fmt.Errorf("cluster %s is not saved", cluster.Name)
+	}
+	cfg, err := NewKubernetes(cluster).Config()
+	if err != nil {
+		return nil, err
+	}
+	client, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ci := &clusterInformers{
+		fingerprint: fingerprint,
+		client:      client,
+		stop:        make(chan struct{}),
+		lastAccess:  time.Now(),
+		resources:   map[schema.GroupVersionResource]*resourceInformer{},
+	}
+	c.clusters[cluster.Name] = ci
+	go c.stopWhenIdle(cluster.Name, ci, c.idleTimeout)
+	return ci, nil
+}
+
+// stopWhenIdle 在集群长时间没有访问后停止 informer，idleTimeout 小于等于 0 时一直运行
+func (c *InformerCache) stopWhenIdle(name string, ci *clusterInformers, idleTimeout time.Duration) {
+	if idleTimeout <= 0 {
+		return
+	}
+	interval := idleTimeout / 2
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ci.stop:
+			return
+		case <-ticker.C:
+			if ci.idle(idleTimeout) {
+				c.mu.Lock()
+				if c.clusters[name] == ci {
+					close(ci.stop)
+					delete(c.clusters, name)
+				}
+				c.mu.Unlock()
+				return
+			}
+		}
+	}
+}
+
+func (ci *clusterInformers) touch() {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	ci.lastAccess = time.Now()
+}
+
+func (ci *clusterInformers) idle(idleTimeout time.Duration) bool {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	return time.Since(ci.lastAccess) > idleTimeout
+}
+
+// resource 返回资源的 informer，第一次访问时启动。informer 同步前会把整个列表读入内存，
+// 因此启动前先检查对象数量，超过 maxObjects 时不启动 informer
+func (ci *clusterInformers) resource(gvr schema.GroupVersionResource, maxObjects int) *resourceInformer {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	if ri, ok := ci.resources[gvr]; ok {
+		return ri
+	}
+	ri := &resourceInformer{stop: make(chan struct{})}
+	ri.informer = dynamicinformer.NewFilteredDynamicInformer(ci.client, gvr, "", 0,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, nil).Informer()
+	// managedFields 占用大量内存且页面中不使用
+	_ = ri.informer.SetTransform(func(obj interface{}) (interface{}, error) {
+		if u, ok := obj.(*unstructured.Unstructured); ok {
+			u.SetManagedFields(nil)
+		}
+		return obj, nil
+	})
+	_ = ri.informer.SetWatchErrorHandler(func(r *cache.Reflector, err error) {
+		ri.mu.Lock()
+		ri.lastWatchError = time.Now()
+		ri.watchError = err.Error()
+		ri.mu.Unlock()
+		cache.DefaultWatchErrorHandler(r, err)
+	})
+	ci.resources[gvr] = ri
+	go func() {
+		select {
+		case <-ci.stop:
+		case <-ri.stop:
+		}
+		ri.mu.Lock()
+		if !ri.disabled {
+			ri.disabled = true
+			close(ri.stop)
+		}
+		ri.mu.Unlock()
+	}()
+	go func() {
+		tooMany, err := ci.exceeds(gvr, maxObjects)
+		if err != nil {
+			// 检查失败时移除 informer，下次访问时重新检查
+			ci.mu.Lock()
+			if ci.resources[gvr] == ri {
+				delete(ci.resources, gvr)
+			}
+			ci.mu.Unlock()
+		}
+		if err != nil || tooMany {
+			ri.mu.Lock()
+			if !ri.disabled {
+				ri.disabled = true
+				close(ri.stop)
+			}
+			ri.mu.Unlock()
+			return
+		}
+		ri.informer.Run(ri.stop)
+	}()
+	return ri
+}
+
+// exceeds 判断集群中资源的数量是否超过 maxObjects，只读取 maxObjects+1 个对象，maxObjects 小于等于 0 时不限制
+func (ci *clusterInformers) exceeds(gvr schema.GroupVersionResource, maxObjects int) (bool, error) {
+	if maxObjects <= 0 {
+		return false, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	go func() {
+		select {
+		case <-ci.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	list, err := ci.client.Resource(gvr).List(ctx, metav1.ListOptions{Limit: int64(maxObjects) + 1})
+	if err != nil {
+		return false, err
+	}
+	return list.GetContinue() != "" || len(list.Items) > maxObjects, nil
+}
@@ -0,0 +1,135 @@
+package kubernetes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "github.com/ClusterOperator/kubepi/internal/model/v1"
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestInformerCache(t *testing.T) {
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/api/v1/pods" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("watch") == "true" {
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		_, _ = w.Write([]byte(`{"kind":"PodList","apiVersion":"v1","metadata":{"resourceVersion":"10"},"items":[
+			{"kind":"Pod","apiVersion":"v1","metadata":{"name":"web","namespace":"default","labels":{"app":"web"},"managedFields":[{"manager":"kubectl"}]}},
+			{"kind":"Pod","apiVersion":"v1","metadata":{"name":"db","namespace":"default","labels":{"app":"db"}}},
+			{"kind":"Pod","apiVersion":"v1","metadata":{"name":"web","namespace":"prod","labels":{"app":"web"}}}]}`))
+	}))
+	defer apiServer.Close()
+
+	cache := NewInformerCache(time.Minute, time.Minute, 0)
+	c := &v1Cluster.Cluster{Metadata: v1.Metadata{Name: "informer", UUID: "u-informer"}}
+	c.Spec.Connect.Direction = v1Cluster.DirectionForward
+	c.Spec.Connect.Forward.ApiServer = apiServer.URL
+	pods := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+	if _, ok := cache.List(c, pods, "", nil); ok {
+		t.Fatal("cache should not be used when disabled")
+	}
+	c.Spec.InformerCache.Enabled = true
+	defer cache.Stop(c.Name)
+	if _, ok := cache.List(c, schema.GroupVersionResource{Version: "v1", Resource: "secrets"}, "", nil); ok {
+		t.Fatal("secrets should not be cached")
+	}
+
+	var list *CachedList
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var ok bool
+		if list, ok = cache.List(c, pods, "", nil); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("informer not synced")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if len(list.Items) != 3 || list.Kind != "PodList" || list.APIVersion != "v1" || list.ResourceVersion != "10" {
+		t.Fatalf("unexpected list %+v", list)
+	}
+	for _, item := range list.Items {
+		md := item.(map[string]interface{})["metadata"].(map[string]interface{})
+		if _, ok := md["managedFields"]; ok {
+			t.Fatal("managed fields should be removed")
+		}
+	}
+
+	list, _ = cache.List(c, pods, "default", labels.SelectorFromSet(labels.Set{"app": "web"}))
+	if len(list.Items) != 1 {
+		t.Fatalf("expect 1 pod, got %d", len(list.Items))
+	}
+	if s := cache.Stats(); len(s) != 1 || len(s[0].Resources) != 1 || s[0].Resources[0].Objects != 3 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	cache.mu.Lock()
+	cache.maxObjects = 2
+	cache.mu.Unlock()
+	if _, ok := cache.List(c, pods, "", nil); ok {
+		t.Fatal("cache should not be used when there are too many objects")
+	}
+}
+
+func TestInformerCacheTooManyObjects(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.URL.RawQuery)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("limit") != "3" {
+			// informer 的完整 list 和 watch 永远不返回，不应该被请求
+			<-r.Context().Done()
+			return
+		}
+		_, _ = w.Write([]byte(`{"kind":"PodList","apiVersion":"v1","metadata":{"resourceVersion":"10","continue":"next"},"items":[
+			{"kind":"Pod","apiVersion":"v1","metadata":{"name":"a","namespace":"default"}},
+			{"kind":"Pod","apiVersion":"v1","metadata":{"name":"b","namespace":"default"}},
+			{"kind":"Pod","apiVersion":"v1","metadata":{"name":"c","namespace":"default"}}]}`))
+	}))
+	defer apiServer.Close()
+
+	cache := NewInformerCache(time.Minute, time.Minute, 2)
+	c := &v1Cluster.Cluster{Metadata: v1.Metadata{Name: "informer-large", UUID: "u-informer-large"}}
+	c.Spec.Connect.Direction = v1Cluster.DirectionForward
+	c.Spec.Connect.Forward.ApiServer = apiServer.URL
+	c.Spec.InformerCache.Enabled = true
+	defer cache.Stop(c.Name)
+	pods := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := cache.List(c, pods, "", nil); ok {
+			t.Fatal("cache should not be used when there are too many objects")
+		}
+		if s := cache.Stats(); len(s) == 1 && len(s[0].Resources) == 1 && s[0].Resources[0].Disabled {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("informer not disabled")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 1 {
+		t.Fatalf("expect only the limited list, got %v", requests)
+	}
+}
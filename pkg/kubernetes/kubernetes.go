@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"regexp"
 	"sort"
 	"strconv"
@@ -13,6 +15,7 @@ import (
 	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
 	"github.com/ClusterOperator/kubepi/pkg/certificate"
 	"github.com/ClusterOperator/kubepi/pkg/collectons"
+	"github.com/ClusterOperator/kubepi/pkg/tunnel"
 	v1 "k8s.io/api/authorization/v1"
 	certv1 "k8s.io/api/certificates/v1"
 	certv1beta1 "k8s.io/api/certificates/v1beta1"
@@ -20,10 +23,14 @@ import (
 	apiextensionv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	apiextension "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	k8sError "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/clientcmd"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
@@ -33,17 +40,29 @@ type Interface interface {
 	Version() (*version.Info, error)
 	VersionMinor() (int, error)
 	Config() (*rest.Config, error)
+	ProxyURL() (*url.URL, error)
+	ApplyProxy(cfg *rest.Config) error
 	Client() (*kubernetes.Clientset, error)
+	Transport() (http.RoundTripper, error)
+	UserTransport(user string, cert []byte) (http.RoundTripper, error)
+	ImpersonatedConfig(user string) (*rest.Config, error)
+	ImpersonatedTransport(user string) (http.RoundTripper, error)
 	HasPermission(attributes v1.ResourceAttributes) (PermissionCheckResult, error)
+	UserHasPermission(username string, attributes v1.ResourceAttributes) (PermissionCheckResult, error)
 	CreateCommonUser(commonName string) ([]byte, error)
 	CreateDefaultClusterRoles() error
 	GetUserNamespaceNames(username string, options ...interface{}) ([]string, error)
 	CanVisitAllNamespace(username string) (bool, error)
 	IsNamespacedResource(resourceName string) (bool, error)
+	ResolveResource(resource string) (schema.GroupVersionResource, bool, error)
+	ServedVersion(gvr schema.GroupVersionResource) (schema.GroupVersionResource, error)
 	CleanManagedClusterRole() error
 	CleanManagedClusterRoleBinding(username string) error
 	CleanManagedRoleBinding(username string) error
 	CleanAllRBACResource() error
+	CreateServiceAccount() (bool, error)
+	RequestServiceAccountToken(expiration time.Duration) (string, time.Time, error)
+	DeleteServiceAccount() error
 	CreateOrUpdateClusterRoleBinding(clusterRoleName string, username string, builtIn bool) error
 	CreateOrUpdateRolebinding(namespace string, clusterRoleName string, username string, builtIn bool) error
 	CreateAppMarketCRD() error
@@ -322,22 +341,66 @@ func (k *Kubernetes) IsNamespacedResource(resourceName string) (bool, error) {
 	if resourceName == "events" {
 		return false, nil
 	}
-	client, err := k.Client()
+	d, err := DefaultCache.Discovery(k)
 	if err != nil {
 		return false, err
 	}
-	apiList, err := client.ServerPreferredNamespacedResources()
-	if err != nil && len(apiList) == 0 {
+	namespaced, found, err := findResource(d, resourceName)
+	if err != nil {
 		return false, err
 	}
+	// 资源可能是缓存后新建的 CRD
+	if !found && DefaultCache.refreshDiscovery(k) {
+		namespaced, _, err = findResource(d, resourceName)
+	}
+	return namespaced, err
+}
+
+// ResolveResource 将 kind、资源名称或简称解析为集群中的资源，例如 Deployment、deployments、deploy 和 deployments.apps，
+// 返回资源的首选版本以及资源是否属于 namespace
+func (k *Kubernetes) ResolveResource(resource string) (schema.GroupVersionResource, bool, error) {
+	mapper, err := DefaultCache.RESTMapper(k)
+	if err != nil {
+		return schema.GroupVersionResource{}, false, err
+	}
+	d, err := DefaultCache.Discovery(k)
+	if err != nil {
+		return schema.GroupVersionResource{}, false, err
+	}
+	expander := restmapper.NewShortcutExpander(mapper, d, nil)
+	gr := schema.ParseGroupResource(strings.ToLower(resource))
+	gvr, err := expander.ResourceFor(gr.WithVersion(""))
+	// 资源可能是缓存后新建的 CRD
+	if meta.IsNoMatchError(err) && DefaultCache.refreshDiscovery(k) {
+		gvr, err = expander.ResourceFor(gr.WithVersion(""))
+	}
+	if err != nil {
+		return schema.GroupVersionResource{}, false, err
+	}
+	gvk, err := expander.KindFor(gvr)
+	if err != nil {
+		return schema.GroupVersionResource{}, false, err
+	}
+	mapping, err := expander.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return schema.GroupVersionResource{}, false, err
+	}
+	return gvr, mapping.Scope.Name() == meta.RESTScopeNameNamespace, nil
+}
+
+func findResource(d discovery.DiscoveryInterface, resourceName string) (namespaced bool, found bool, err error) {
+	apiList, err := discovery.ServerPreferredResources(d)
+	if err != nil && len(apiList) == 0 {
+		return false, false, err
+	}
 	for i := range apiList {
 		for j := range apiList[i].APIResources {
 			if apiList[i].APIResources[j].Name == resourceName {
-				return true, nil
+				return apiList[i].APIResources[j].Namespaced, true, nil
 			}
 		}
 	}
-	return false, nil
+	return false, false, nil
 }
 
 type PermissionCheckResult struct {
@@ -517,11 +580,40 @@ func (k *Kubernetes) HasPermission(attributes v1.ResourceAttributes) (Permission
 	}, nil
 
 }
+
+// UserHasPermission 检查 KubePi 用户在集群中的权限，用户证书的 CN 为用户名
+func (k *Kubernetes) UserHasPermission(username string, attributes v1.ResourceAttributes) (PermissionCheckResult, error) {
+	client, err := k.Client()
+	if err != nil {
+		return PermissionCheckResult{}, err
+	}
+	resp, err := client.AuthorizationV1().SubjectAccessReviews().Create(context.TODO(), &v1.SubjectAccessReview{
+		Spec: v1.SubjectAccessReviewSpec{
+			ResourceAttributes: &attributes,
+			User:               username,
+			Groups:             []string{"system:authenticated"},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return PermissionCheckResult{}, err
+	}
+	return PermissionCheckResult{
+		Resource: attributes,
+		Allowed:  resp.Status.Allowed,
+	}, nil
+}
+
+// Config 返回集群 rest config 的副本，连接配置未变化时使用缓存
 func (k *Kubernetes) Config() (*rest.Config, error) {
+	return DefaultCache.Config(k)
+}
+
+func (k *Kubernetes) buildConfig() (*rest.Config, error) {
 	if k.Spec.Local {
 		return rest.InClusterConfig()
 	}
-	if k.Spec.Connect.Direction == "forward" {
+	// reverse 模式的集群通过 agent 建立的隧道访问，除代理外与 forward 模式相同
+	if k.Spec.Connect.Direction == v1Cluster.DirectionForward || k.Spec.Connect.Direction == v1Cluster.DirectionReverse {
 		kubeConf := &rest.Config{
 			Host: k.Spec.Connect.Forward.ApiServer,
 		}
@@ -531,7 +623,7 @@ func (k *Kubernetes) Config() (*rest.Config, error) {
 			kubeConf.Insecure = true
 		}
 		switch strings.ToLower(k.Spec.Authentication.Mode) {
-		case "bearer":
+		case "bearer", "serviceaccount":
 			kubeConf.BearerToken = k.Spec.Authentication.BearerToken
 		case "certificate":
 			kubeConf.TLSClientConfig.CertData = k.Spec.Authentication.Certificate.CertData
@@ -545,25 +637,80 @@ func (k *Kubernetes) Config() (*rest.Config, error) {
 			}
 			kubeConf = cfg
 		}
+		if err := k.ApplyProxy(kubeConf); err != nil {
+			return nil, err
+		}
 		return kubeConf, nil
 	}
 	return nil, nil
 }
 
-func (k *Kubernetes) Client() (*kubernetes.Clientset, error) {
-	cfg, err := k.Config()
+// ProxyURL 返回集群配置的代理地址，未配置时返回 nil，支持 http(s) CONNECT 和 socks5 代理，
+// 用户名和密码设置在地址中，由 http.Transport 和 SPDY 连接负责认证，reverse 模式返回本地隧道代理的地址
+func (k *Kubernetes) ProxyURL() (*url.URL, error) {
+	if k.Spec.Connect.Direction == v1Cluster.DirectionReverse {
+		return tunnel.ProxyURL(k.Name)
+	}
+	p := k.Spec.Connect.Forward.Proxy
+	if p.URL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(p.URL)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("invalid proxy url %s: %s", p.URL, err.Error())
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, expect http, https or socks5", u.Scheme)
+	}
+	if p.Username != "" {
+		u.User = url.UserPassword(p.Username, p.Password)
 	}
-	return kubernetes.NewForConfig(cfg)
+	return u, nil
 }
 
-func (k *Kubernetes) Version() (*version.Info, error) {
-	client, err := k.Client()
-	if err != nil {
-		return nil, err
+// ApplyProxy 使通过 cfg 建立的所有连接(包括 exec 等 SPDY 连接)都经过集群配置的代理
+func (k *Kubernetes) ApplyProxy(cfg *rest.Config) error {
+	u, err := k.ProxyURL()
+	if err != nil || u == nil {
+		return err
 	}
-	return client.ServerVersion()
+	cfg.Proxy = http.ProxyURL(u)
+	return nil
+}
+
+func (k *Kubernetes) Client() (*kubernetes.Clientset, error) {
+	return DefaultCache.Clientset(k)
+}
+
+// Transport 返回使用集群凭据的 transport，transport 按集群缓存
+func (k *Kubernetes) Transport() (http.RoundTripper, error) {
+	return DefaultCache.Transport(k)
+}
+
+// UserTransport 返回使用用户证书的 transport，transport 按集群和用户缓存
+func (k *Kubernetes) UserTransport(user string, cert []byte) (http.RoundTripper, error) {
+	return DefaultCache.UserTransport(k, user, cert)
+}
+
+// Impersonation 判断集群成员是否以 impersonation 的方式访问集群
+func Impersonation(c *v1Cluster.Cluster) bool {
+	return c.Spec.MemberAuthMode == v1Cluster.MemberAuthImpersonation
+}
+
+// ServiceAccountMode 判断集群是否使用 KubePi 创建的 ServiceAccount 的 token 访问集群
+func ServiceAccountMode(c *v1Cluster.Cluster) bool {
+	return strings.EqualFold(c.Spec.Authentication.Mode, v1Cluster.AuthModeServiceAccount)
+}
+
+// ImpersonatedTransport 返回以用户身份访问集群的 transport，transport 按集群和用户缓存
+func (k *Kubernetes) ImpersonatedTransport(user string) (http.RoundTripper, error) {
+	return DefaultCache.ImpersonatedTransport(k, user)
+}
+
+func (k *Kubernetes) Version() (*version.Info, error) {
+	return DefaultCache.Version(k)
 }
 
 func (k *Kubernetes) Ping() error {
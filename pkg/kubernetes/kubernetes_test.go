@@ -1,10 +1,16 @@
 package kubernetes
 
 import (
+	"encoding/base64"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"testing"
+
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-	"testing"
 )
 
 func TestNewKubernetes(t *testing.T) {
@@ -29,3 +35,37 @@ func TestNewKubernetes(t *testing.T) {
 	fmt.Println(v.String())
 
 }
+
+func TestConfigWithProxy(t *testing.T) {
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"major":"1","minor":"29","gitVersion":"v1.29.0"}`))
+	}))
+	defer apiServer.Close()
+	var proxyAuth string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxyAuth = r.Header.Get("Proxy-Authorization")
+		httputil.NewSingleHostReverseProxy(r.URL).ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+
+	c := &v1Cluster.Cluster{}
+	c.Spec.Connect.Direction = "forward"
+	c.Spec.Connect.Forward.ApiServer = apiServer.URL
+	c.Spec.Connect.Forward.Proxy = v1Cluster.Proxy{URL: proxy.URL, Username: "user", Password: "pass"}
+	v, err := NewKubernetes(c).Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.GitVersion != "v1.29.0" {
+		t.Fatalf("unexpected version %s", v.GitVersion)
+	}
+	if proxyAuth != "Basic "+base64.StdEncoding.EncodeToString([]byte("user:pass")) {
+		t.Fatalf("request should be sent through the proxy with basic auth, got %q", proxyAuth)
+	}
+
+	c.Spec.Connect.Forward.Proxy = v1Cluster.Proxy{URL: "ftp://127.0.0.1"}
+	if _, err := NewKubernetes(c).Config(); err == nil {
+		t.Fatal("expect error for unsupported proxy scheme")
+	}
+}
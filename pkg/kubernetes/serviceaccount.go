@@ -0,0 +1,134 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	authenticationV1 "k8s.io/api/authentication/v1"
+	coreV1 "k8s.io/api/core/v1"
+	rbacV1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ServiceAccountNamespace 是 serviceAccount 模式下 KubePi 在集群中创建 ServiceAccount 的 namespace
+const ServiceAccountNamespace = "kube-system"
+
+// serviceAccountName 返回 KubePi 在集群中使用的 ServiceAccount 和为其绑定 cluster-admin 的 ClusterRoleBinding 的名称，
+// 名称中包含集群的 UUID，多个 KubePi 或者同一个集群被多次添加时不会互相覆盖
+func (k *Kubernetes) serviceAccountName() (string, error) {
+	if k.UUID == "" {
+		return "", errors.New("cluster uuid is required for service account")
+	}
+	return fmt.Sprintf("kubepi-%s", k.UUID), nil
+}
+
+// managedByKubePi 判断已经存在的资源是否由 KubePi 创建
+func managedByKubePi(meta metav1.ObjectMeta) bool {
+	return meta.Labels[LabelManageKey] == "kubepi"
+}
+
+// CreateServiceAccount 创建 KubePi 专用的 ServiceAccount 并绑定 cluster-admin，已经存在且由 KubePi 创建时直接使用，
+// 不是由 KubePi 创建时返回错误，created 表示 ClusterRoleBinding 是否由本次调用创建。ServiceAccount 的 owner 是
+// ClusterRoleBinding，删除 ClusterRoleBinding 后由集群回收 ServiceAccount，这样可以使用 ServiceAccount 自己的 token 完成删除
+func (k *Kubernetes) CreateServiceAccount() (created bool, err error) {
+	name, err := k.serviceAccountName()
+	if err != nil {
+		return false, err
+	}
+	client, err := k.Client()
+	if err != nil {
+		return false, err
+	}
+	labels := map[string]string{LabelManageKey: "kubepi"}
+	binding, err := client.RbacV1().ClusterRoleBindings().Create(context.TODO(), &rbacV1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+		Subjects: []rbacV1.Subject{{
+			Kind:      "ServiceAccount",
+			Name:      name,
+			Namespace: ServiceAccountNamespace,
+		}},
+		RoleRef: rbacV1.RoleRef{
+			APIGroup: "rbac.authorization.k8s.io",
+			Kind:     "ClusterRole",
+			Name:     "cluster-admin",
+		},
+	}, metav1.CreateOptions{})
+	created = err == nil
+	if apierrors.IsAlreadyExists(err) {
+		binding, err = client.RbacV1().ClusterRoleBindings().Get(context.TODO(), name, metav1.GetOptions{})
+		if err == nil && !managedByKubePi(binding.ObjectMeta) {
+			return false, fmt.Errorf("cluster role binding %s already exists and is not managed by KubePi", name)
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+	_, err = client.CoreV1().ServiceAccounts(ServiceAccountNamespace).Create(context.TODO(), &coreV1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "rbac.authorization.k8s.io/v1",
+				Kind:       "ClusterRoleBinding",
+				Name:       binding.Name,
+				UID:        binding.UID,
+			}},
+		},
+	}, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		var sa *coreV1.ServiceAccount
+		sa, err = client.CoreV1().ServiceAccounts(ServiceAccountNamespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err == nil && !managedByKubePi(sa.ObjectMeta) {
+			return created, fmt.Errorf("service account %s/%s already exists and is not managed by KubePi", ServiceAccountNamespace, name)
+		}
+	}
+	if err != nil {
+		return created, err
+	}
+	return created, nil
+}
+
+// RequestServiceAccountToken 使用 TokenRequest 为 ServiceAccount 签发 token，集群可能缩短请求的有效期，
+// 返回的过期时间以集群为准
+func (k *Kubernetes) RequestServiceAccountToken(expiration time.Duration) (string, time.Time, error) {
+	name, err := k.serviceAccountName()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	client, err := k.Client()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	seconds := int64(expiration.Seconds())
+	tr, err := client.CoreV1().ServiceAccounts(ServiceAccountNamespace).CreateToken(context.TODO(), name, &authenticationV1.TokenRequest{
+		Spec: authenticationV1.TokenRequestSpec{ExpirationSeconds: &seconds},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return tr.Status.Token, tr.Status.ExpirationTimestamp.Time, nil
+}
+
+// DeleteServiceAccount 删除 ClusterRoleBinding，ServiceAccount 由集群的垃圾回收删除
+func (k *Kubernetes) DeleteServiceAccount() error {
+	name, err := k.serviceAccountName()
+	if err != nil {
+		return err
+	}
+	client, err := k.Client()
+	if err != nil {
+		return err
+	}
+	policy := metav1.DeletePropagationBackground
+	err = client.RbacV1().ClusterRoleBindings().Delete(context.TODO(), name, metav1.DeleteOptions{PropagationPolicy: &policy})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
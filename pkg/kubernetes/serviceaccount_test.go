@@ -0,0 +1,119 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "github.com/ClusterOperator/kubepi/internal/model/v1"
+	v1Cluster "github.com/ClusterOperator/kubepi/internal/model/v1/cluster"
+)
+
+func TestServiceAccount(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]string{}
+	var serviceAccount map[string]interface{}
+	expireAt := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		key := r.Method + " " + r.URL.Path
+		requests[key] = r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		body, _ := io.ReadAll(r.Body)
+		switch key {
+		case "POST /apis/rbac.authorization.k8s.io/v1/clusterrolebindings":
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"kind":"ClusterRoleBinding","apiVersion":"rbac.authorization.k8s.io/v1","metadata":{"name":"kubepi-1","uid":"crb-uid"},"roleRef":{"apiGroup":"rbac.authorization.k8s.io","kind":"ClusterRole","name":"cluster-admin"}}`))
+		case "POST /api/v1/namespaces/kube-system/serviceaccounts":
+			_ = json.Unmarshal(body, &serviceAccount)
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write(body)
+		case "POST /api/v1/namespaces/kube-system/serviceaccounts/kubepi-1/token":
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"kind":"TokenRequest","apiVersion":"authentication.k8s.io/v1","status":{"token":"sa-token","expirationTimestamp":"` + expireAt.Format(time.RFC3339) + `"}}`))
+		case "DELETE /apis/rbac.authorization.k8s.io/v1/clusterrolebindings/kubepi-1":
+			if !strings.Contains(string(body), `"propagationPolicy":"Background"`) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Success"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer apiServer.Close()
+
+	c := &v1Cluster.Cluster{Metadata: v1.Metadata{Name: "sa", UUID: "1"}}
+	c.Spec.Connect.Direction = v1Cluster.DirectionForward
+	c.Spec.Connect.Forward.ApiServer = apiServer.URL
+	c.Spec.Authentication = v1Cluster.Authentication{Mode: "bearer", BearerToken: "bootstrap-token"}
+	bootstrap := NewKubernetes(c)
+	created, err := bootstrap.CreateServiceAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created {
+		t.Error("expect cluster role binding created")
+	}
+	owners, _ := serviceAccount["metadata"].(map[string]interface{})["ownerReferences"].([]interface{})
+	if len(owners) != 1 || owners[0].(map[string]interface{})["uid"] != "crb-uid" {
+		t.Fatalf("service account should be owned by the cluster role binding, got %v", serviceAccount["metadata"])
+	}
+	token, expire, err := bootstrap.RequestServiceAccountToken(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "sa-token" || !expire.Equal(expireAt) {
+		t.Fatalf("unexpected token %s expire at %s", token, expire)
+	}
+
+	c.Spec.Authentication = v1Cluster.Authentication{Mode: v1Cluster.AuthModeServiceAccount, BearerToken: token}
+	if !ServiceAccountMode(c) {
+		t.Fatal("expect service account mode")
+	}
+	if err := NewKubernetes(c).DeleteServiceAccount(); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if auth := requests["POST /api/v1/namespaces/kube-system/serviceaccounts"]; auth != "Bearer bootstrap-token" {
+		t.Errorf("service account should be created with bootstrap credential, got %q", auth)
+	}
+	if auth := requests["DELETE /apis/rbac.authorization.k8s.io/v1/clusterrolebindings/kubepi-1"]; auth != "Bearer sa-token" {
+		t.Errorf("service account should be deleted with its own token, got %q", auth)
+	}
+}
+
+func TestServiceAccountNotManaged(t *testing.T) {
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method + " " + r.URL.Path {
+		case "POST /apis/rbac.authorization.k8s.io/v1/clusterrolebindings":
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"AlreadyExists","code":409}`))
+		case "GET /apis/rbac.authorization.k8s.io/v1/clusterrolebindings/kubepi-1":
+			_, _ = w.Write([]byte(`{"kind":"ClusterRoleBinding","apiVersion":"rbac.authorization.k8s.io/v1","metadata":{"name":"kubepi-1","uid":"crb-uid"},"roleRef":{"apiGroup":"rbac.authorization.k8s.io","kind":"ClusterRole","name":"cluster-admin"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer apiServer.Close()
+
+	c := &v1Cluster.Cluster{Metadata: v1.Metadata{Name: "sa", UUID: "1"}}
+	c.Spec.Connect.Direction = v1Cluster.DirectionForward
+	c.Spec.Connect.Forward.ApiServer = apiServer.URL
+	c.Spec.Authentication = v1Cluster.Authentication{Mode: "bearer", BearerToken: "bootstrap-token"}
+	created, err := NewKubernetes(c).CreateServiceAccount()
+	if err == nil || !strings.Contains(err.Error(), "not managed by KubePi") {
+		t.Fatalf("expect error for cluster role binding not created by KubePi, got %v", err)
+	}
+	if created {
+		t.Error("existing cluster role binding should not be reported as created")
+	}
+}
@@ -49,6 +49,12 @@ func (sm *SessionMap) Set(sessionId string, session LogSession) {
 	sm.Sessions[sessionId] = session
 }
 
+func (sm *SessionMap) Len() int {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+	return len(sm.Sessions)
+}
+
 func (sm *SessionMap) Close(sessionId, reason string, status uint32) {
 	if _, ok := sm.Sessions[sessionId]; !ok {
 		return
@@ -71,6 +77,11 @@ func (sm *SessionMap) Clean() {
 
 var LogSessions = SessionMap{Sessions: make(map[string]LogSession)}
 
+// SessionRestorer is called when a session can not be found in LogSessions,
+// e.g. the session is created by another replica. It should recreate the session
+// and start WaitForLoggingStream, returns false if the session does not exist
+var SessionRestorer func(sessionId string) bool
+
 type LogMessage struct {
 	SessionID string
 	Data      string
@@ -97,6 +108,11 @@ func logHandler(session sockjs.Session) {
 		return
 	}
 	if logSession = LogSessions.Get(msg.SessionID); logSession.Id == "" {
+		if SessionRestorer != nil && SessionRestorer(msg.SessionID) {
+			logSession = LogSessions.Get(msg.SessionID)
+		}
+	}
+	if logSession.Id == "" {
 		log.Printf("handleLogSession: can't find session '%s'", msg.SessionID)
 		return
 	}
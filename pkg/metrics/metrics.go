@@ -0,0 +1,165 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "kubepi"
+
+// Registry 保存 KubePi 的所有指标，不使用默认的全局 Registry，避免依赖库注册的指标混入
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Total number of HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+
+	proxyRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "proxy_request_duration_seconds",
+		Help:      "Latency of requests proxied to the Kubernetes API server by cluster.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"cluster", "method", "code"})
+
+	loginTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "login_total",
+		Help:      "Total number of login attempts by method and result.",
+	}, []string{"method", "result"})
+
+	helmOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "helm_operation_duration_seconds",
+		Help:      "Duration of helm operations by cluster, operation and result.",
+		Buckets:   []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"cluster", "operation", "result"})
+
+	clusterHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cluster_healthy",
+		Help:      "Whether the cluster API server is reachable (1) or not (0).",
+	}, []string{"cluster"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequestsTotal,
+		httpRequestDuration,
+		proxyRequestDuration,
+		loginTotal,
+		helmOperationDuration,
+		clusterHealthy,
+	)
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+func ObserveHTTPRequest(route, method string, code int, start time.Time) {
+	c := strconv.Itoa(code)
+	httpRequestsTotal.WithLabelValues(route, method, c).Inc()
+	httpRequestDuration.WithLabelValues(route, method, c).Observe(time.Since(start).Seconds())
+}
+
+// InstrumentRoundTripper 记录通过 rt 发往集群的请求耗时
+func InstrumentRoundTripper(cluster string, rt http.RoundTripper) http.RoundTripper {
+	return promhttp.InstrumentRoundTripperDuration(proxyRequestDuration.MustCurryWith(prometheus.Labels{"cluster": cluster}), rt)
+}
+
+func ObserveLogin(method string, success bool) {
+	loginTotal.WithLabelValues(method, result(success)).Inc()
+}
+
+func ObserveHelmOperation(cluster, operation string, start time.Time, err error) {
+	helmOperationDuration.WithLabelValues(cluster, operation, result(err == nil)).Observe(time.Since(start).Seconds())
+}
+
+func SetClusterHealth(cluster string, healthy bool) {
+	v := float64(0)
+	if healthy {
+		v = 1
+	}
+	clusterHealthy.WithLabelValues(cluster).Set(v)
+}
+
+// DeleteCluster 删除集群相关的指标，在集群被删除时调用
+func DeleteCluster(cluster string) {
+	clusterHealthy.DeleteLabelValues(cluster)
+	proxyRequestDuration.DeletePartialMatch(prometheus.Labels{"cluster": cluster})
+	helmOperationDuration.DeletePartialMatch(prometheus.Labels{"cluster": cluster})
+}
+
+// RegisterActiveSessions 注册一类会话的当前数量，数量在采集时通过 count 获取
+func RegisterActiveSessions(kind string, count func() int) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "active_sessions",
+		Help:        "Number of active terminal, log and webkubectl sessions.",
+		ConstLabels: prometheus.Labels{"type": kind},
+	}, func() float64 {
+		return float64(count())
+	}))
+}
+
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+}
+
+// RegisterCache 注册一个缓存的命中、未命中、淘汰次数和条目数，数值在采集时通过 stats 获取
+func RegisterCache(name string, stats func() CacheStats) {
+	labels := prometheus.Labels{"cache": name}
+	Registry.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "cache_hits_total",
+			Help:        "Total number of cache hits.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(stats().Hits) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "cache_misses_total",
+			Help:        "Total number of cache misses.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(stats().Misses) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "cache_evictions_total",
+			Help:        "Total number of entries evicted from the cache.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(stats().Evictions) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "cache_entries",
+			Help:        "Number of entries in the cache.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(stats().Entries) }),
+	)
+}
+
+func result(success bool) string {
+	if success {
+		return "success"
+	}
+	return "failure"
+}
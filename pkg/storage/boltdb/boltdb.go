@@ -0,0 +1,111 @@
+package boltdb
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ClusterOperator/kubepi/pkg/storage"
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+	bolt "go.etcd.io/bbolt"
+)
+
+type DB struct {
+	node
+	db *storm.DB
+}
+
+// openTimeout 避免在数据库文件被其他进程(例如正在运行的服务)锁定时无限等待
+const openTimeout = 10 * time.Second
+
+func Open(path string) (*DB, error) {
+	d, err := storm.Open(path, storm.BoltOptions(0600, &bolt.Options{Timeout: openTimeout}))
+	if err != nil {
+		return nil, err
+	}
+	return &DB{node: node{Node: d}, db: d}, nil
+}
+
+func (d *DB) Type() string {
+	return storage.TypeBolt
+}
+
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// Bolt 返回底层的 bolt 数据库，用于备份等需要直接访问文件的场景
+func (d *DB) Bolt() *bolt.DB {
+	return d.db.Bolt
+}
+
+type node struct {
+	storm.Node
+}
+
+func (n node) All(to interface{}) error {
+	return n.Node.All(to)
+}
+
+func (n node) Find(fieldName string, value interface{}, to interface{}) error {
+	return n.Node.Find(fieldName, value, to)
+}
+
+func (n node) Select(matchers ...q.Matcher) storage.Query {
+	return query{Query: n.Node.Select(matchers...)}
+}
+
+func (n node) Begin(writable bool) (storage.Node, error) {
+	tx, err := n.Node.Begin(writable)
+	if err != nil {
+		return nil, err
+	}
+	return node{Node: tx}, nil
+}
+
+type query struct {
+	storm.Query
+}
+
+func (q query) Skip(n int) storage.Query {
+	return query{Query: q.Query.Skip(n)}
+}
+
+func (q query) Limit(n int) storage.Query {
+	return query{Query: q.Query.Limit(n)}
+}
+
+func (q query) OrderBy(fields ...string) storage.Query {
+	return query{Query: q.Query.OrderBy(fields...)}
+}
+
+func (q query) Reverse() storage.Query {
+	return query{Query: q.Query.Reverse()}
+}
+
+// Export 导出所有 bucket 中的原始记录，跳过 storm 自身维护的索引等嵌套 bucket
+func (d *DB) Export() ([]storage.Record, error) {
+	var records []storage.Record
+	err := d.db.Bolt.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if strings.HasPrefix(string(name), "__storm") {
+				return nil
+			}
+			return b.ForEach(func(k, v []byte) error {
+				if v == nil {
+					return nil
+				}
+				records = append(records, storage.Record{
+					Bucket: string(name),
+					Key:    string(k),
+					Value:  append([]byte{}, v...),
+				})
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
@@ -0,0 +1,263 @@
+package encrypted
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/ClusterOperator/kubepi/pkg/encryption"
+	"github.com/ClusterOperator/kubepi/pkg/storage"
+	"github.com/asdine/storm/v3/q"
+)
+
+// Fields 描述每种结构体中需要加密的字段，字段使用 . 分隔的路径表示，
+// 字段类型只能是 string 或 []byte
+type Fields map[reflect.Type][]string
+
+type DB struct {
+	node
+	db storage.DB
+}
+
+// NewDB 返回一个在写入时加密、读取时解密指定字段的存储
+func NewDB(db storage.DB, encryptor *encryption.Encryptor, fields Fields) *DB {
+	return &DB{
+		node: node{Node: db, encryptor: encryptor, fields: fields},
+		db:   db,
+	}
+}
+
+func (d *DB) Type() string {
+	return d.db.Type()
+}
+
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// Unwrap 返回未加密的原始存储，读取到的敏感字段为密文
+func (d *DB) Unwrap() storage.DB {
+	return d.db
+}
+
+type node struct {
+	storage.Node
+	encryptor *encryption.Encryptor
+	fields    Fields
+}
+
+// transform 对 v 中的敏感字段执行 fn，v 必须是结构体的 reflect.Value 且可以被修改
+func (n node) transform(v reflect.Value, fn func([]byte) ([]byte, error)) error {
+	paths, ok := n.fields[v.Type()]
+	if !ok {
+		return nil
+	}
+	for _, p := range paths {
+		f := v.FieldByName(strings.Split(p, ".")[0])
+		for _, name := range strings.Split(p, ".")[1:] {
+			f = f.FieldByName(name)
+		}
+		if !f.IsValid() {
+			return fmt.Errorf("field %s not found in %s", p, v.Type().Name())
+		}
+		switch {
+		case f.Kind() == reflect.String:
+			bs, err := fn([]byte(f.String()))
+			if err != nil {
+				return err
+			}
+			f.SetString(string(bs))
+		case f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.Uint8:
+			bs, err := fn(f.Bytes())
+			if err != nil {
+				return err
+			}
+			f.SetBytes(bs)
+		default:
+			return fmt.Errorf("field %s in %s is neither string nor []byte", p, v.Type().Name())
+		}
+	}
+	return nil
+}
+
+// encryptCopy 返回 data 的加密副本，不修改调用方持有的数据
+func (n node) encryptCopy(data interface{}) (interface{}, error) {
+	ref := reflect.ValueOf(data)
+	if ref.Kind() != reflect.Ptr || ref.Elem().Kind() != reflect.Struct {
+		return data, nil
+	}
+	if _, ok := n.fields[ref.Elem().Type()]; !ok {
+		return data, nil
+	}
+	c := reflect.New(ref.Elem().Type())
+	c.Elem().Set(ref.Elem())
+	if err := n.transform(c.Elem(), n.encryptor.Encrypt); err != nil {
+		return nil, err
+	}
+	return c.Interface(), nil
+}
+
+// decrypt 解密读取到的结构体或结构体切片
+func (n node) decrypt(to interface{}) error {
+	ref := reflect.Indirect(reflect.ValueOf(to))
+	switch ref.Kind() {
+	case reflect.Struct:
+		return n.transform(ref, n.encryptor.Decrypt)
+	case reflect.Slice:
+		for i := 0; i < ref.Len(); i++ {
+			item := reflect.Indirect(ref.Index(i))
+			if item.Kind() != reflect.Struct {
+				continue
+			}
+			if err := n.transform(item, n.encryptor.Decrypt); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (n node) Save(data interface{}) error {
+	c, err := n.encryptCopy(data)
+	if err != nil {
+		return err
+	}
+	return n.Node.Save(c)
+}
+
+func (n node) Update(data interface{}) error {
+	c, err := n.encryptCopy(data)
+	if err != nil {
+		return err
+	}
+	return n.Node.Update(c)
+}
+
+func (n node) UpdateField(data interface{}, fieldName string, value interface{}) error {
+	ref := reflect.ValueOf(data)
+	if ref.Kind() != reflect.Ptr || ref.Elem().Kind() != reflect.Struct {
+		return n.Node.UpdateField(data, fieldName, value)
+	}
+	if _, ok := n.fields[ref.Elem().Type()]; !ok {
+		return n.Node.UpdateField(data, fieldName, value)
+	}
+	// 在副本上设置字段后整体加密，从而支持更新包含敏感字段的嵌套结构体
+	c := reflect.New(ref.Elem().Type())
+	c.Elem().Set(ref.Elem())
+	f := c.Elem().FieldByName(fieldName)
+	if f.IsValid() && reflect.ValueOf(value).Type().AssignableTo(f.Type()) {
+		f.Set(reflect.ValueOf(value))
+		if err := n.transform(c.Elem(), n.encryptor.Encrypt); err != nil {
+			return err
+		}
+		value = f.Interface()
+	}
+	return n.Node.UpdateField(c.Interface(), fieldName, value)
+}
+
+func (n node) One(fieldName string, value interface{}, to interface{}) error {
+	if err := n.Node.One(fieldName, value, to); err != nil {
+		return err
+	}
+	return n.decrypt(to)
+}
+
+func (n node) Find(fieldName string, value interface{}, to interface{}) error {
+	if err := n.Node.Find(fieldName, value, to); err != nil {
+		return err
+	}
+	return n.decrypt(to)
+}
+
+func (n node) All(to interface{}) error {
+	if err := n.Node.All(to); err != nil {
+		return err
+	}
+	return n.decrypt(to)
+}
+
+func (n node) Select(matchers ...q.Matcher) storage.Query {
+	return &query{Query: n.Node.Select(matchers...), node: n}
+}
+
+func (n node) Begin(writable bool) (storage.Node, error) {
+	tx, err := n.Node.Begin(writable)
+	if err != nil {
+		return nil, err
+	}
+	return node{Node: tx, encryptor: n.encryptor, fields: n.fields}, nil
+}
+
+type query struct {
+	storage.Query
+	node node
+}
+
+func (s *query) Skip(i int) storage.Query {
+	s.Query = s.Query.Skip(i)
+	return s
+}
+
+func (s *query) Limit(i int) storage.Query {
+	s.Query = s.Query.Limit(i)
+	return s
+}
+
+func (s *query) OrderBy(fields ...string) storage.Query {
+	s.Query = s.Query.OrderBy(fields...)
+	return s
+}
+
+func (s *query) Reverse() storage.Query {
+	s.Query = s.Query.Reverse()
+	return s
+}
+
+func (s *query) Find(to interface{}) error {
+	if err := s.Query.Find(to); err != nil {
+		return err
+	}
+	return s.node.decrypt(to)
+}
+
+func (s *query) First(to interface{}) error {
+	if err := s.Query.First(to); err != nil {
+		return err
+	}
+	return s.node.decrypt(to)
+}
+
+// Rotate 使用当前主密钥重新加密所有记录中的敏感字段，
+// 明文保存的数据会被加密，使用旧主密钥加密的数据会被重新加密，返回被更新的记录数
+func (d *DB) Rotate() (int, error) {
+	tx, err := d.db.Begin(true)
+	if err != nil {
+		return 0, err
+	}
+	updated := 0
+	for t := range d.fields {
+		items := reflect.New(reflect.SliceOf(t))
+		if err := tx.All(items.Interface()); err != nil {
+			_ = tx.Rollback()
+			return 0, err
+		}
+		for i := 0; i < items.Elem().Len(); i++ {
+			item := items.Elem().Index(i)
+			before := reflect.New(t)
+			before.Elem().Set(item)
+			if err := d.transform(item, d.encryptor.Rotate); err != nil {
+				_ = tx.Rollback()
+				return 0, fmt.Errorf("rotate %s failed: %s", t.Name(), err.Error())
+			}
+			if reflect.DeepEqual(before.Elem().Interface(), item.Interface()) {
+				continue
+			}
+			if err := tx.Save(item.Addr().Interface()); err != nil {
+				_ = tx.Rollback()
+				return 0, err
+			}
+			updated++
+		}
+	}
+	return updated, tx.Commit()
+}
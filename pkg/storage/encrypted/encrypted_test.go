@@ -0,0 +1,131 @@
+package encrypted
+
+import (
+	"bytes"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ClusterOperator/kubepi/pkg/encryption"
+	"github.com/ClusterOperator/kubepi/pkg/storage/boltdb"
+)
+
+type Auth struct {
+	Token string `json:"token"`
+	Key   []byte `json:"key"`
+}
+
+type Spec struct {
+	Server string `json:"server"`
+	Auth   Auth   `json:"auth"`
+}
+
+type Item struct {
+	ID   string `json:"id" storm:"id"`
+	Name string `json:"name" storm:"unique"`
+	Spec Spec   `json:"spec"`
+}
+
+func openTestDB(t *testing.T) *DB {
+	db, err := boltdb.Open(path.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	key, err := encryption.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := encryption.ParseKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := encryption.NewEncryptor(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewDB(db, e, Fields{reflect.TypeOf(Item{}): {"Spec.Auth.Token", "Spec.Auth.Key"}})
+}
+
+// raw 返回数据库中保存的原始记录
+func raw(t *testing.T, db *DB, id string) Item {
+	var item Item
+	if err := db.Unwrap().One("ID", id, &item); err != nil {
+		t.Fatal(err)
+	}
+	return item
+}
+
+func TestRoundTrip(t *testing.T) {
+	db := openTestDB(t)
+	item := Item{ID: "1", Name: "a", Spec: Spec{Server: "https://a", Auth: Auth{Token: "token-a", Key: []byte("key-a")}}}
+	if err := db.Save(&item); err != nil {
+		t.Fatal(err)
+	}
+	if item.Spec.Auth.Token != "token-a" {
+		t.Fatal("save should not modify the caller's data")
+	}
+	stored := raw(t, db, "1")
+	if !encryption.IsEncrypted([]byte(stored.Spec.Auth.Token)) || !encryption.IsEncrypted(stored.Spec.Auth.Key) {
+		t.Fatalf("secret fields should be encrypted, got %+v", stored.Spec.Auth)
+	}
+	if stored.Spec.Server != "https://a" {
+		t.Fatalf("other fields should be kept, got %s", stored.Spec.Server)
+	}
+
+	var got Item
+	if err := db.One("Name", "a", &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, item) {
+		t.Fatalf("expect %+v, got %+v", item, got)
+	}
+
+	if err := db.Update(&Item{ID: "1", Name: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.One("Name", "b", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Spec.Auth.Token != "token-a" || !bytes.Equal(got.Spec.Auth.Key, []byte("key-a")) || got.Spec.Server != "https://a" {
+		t.Fatalf("unexpected item after update %+v", got)
+	}
+
+	spec := Spec{Server: "https://c", Auth: Auth{Token: "token-c", Key: []byte("key-c")}}
+	if err := db.UpdateField(&Item{ID: "1"}, "Spec", spec); err != nil {
+		t.Fatal(err)
+	}
+	if stored := raw(t, db, "1"); strings.Contains(stored.Spec.Auth.Token, "token-c") || bytes.Contains(stored.Spec.Auth.Key, []byte("key-c")) {
+		t.Fatalf("updated secret fields should be encrypted, got %+v", stored.Spec.Auth)
+	}
+
+	if err := db.Save(&Item{ID: "2", Name: "c", Spec: Spec{Auth: Auth{Token: "token-d"}}}); err != nil {
+		t.Fatal(err)
+	}
+	var items []Item
+	if err := db.All(&items); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || !reflect.DeepEqual(items[0].Spec, spec) || items[1].Spec.Auth.Token != "token-d" {
+		t.Fatalf("unexpected items %+v", items)
+	}
+}
+
+func TestPlaintextWithPrefix(t *testing.T) {
+	db := openTestDB(t)
+	secret := "enc:v1:not-a-ciphertext"
+	if err := db.Save(&Item{ID: "1", Name: "a", Spec: Spec{Auth: Auth{Token: secret}}}); err != nil {
+		t.Fatal(err)
+	}
+	if stored := raw(t, db, "1"); stored.Spec.Auth.Token == secret {
+		t.Fatal("plaintext with the ciphertext prefix should still be encrypted")
+	}
+	var got Item
+	if err := db.One("ID", "1", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Spec.Auth.Token != secret {
+		t.Fatalf("expect %s, got %s", secret, got.Spec.Auth.Token)
+	}
+}
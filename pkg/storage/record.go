@@ -0,0 +1,17 @@
+package storage
+
+// Record 是与存储后端无关的原始数据，Value 为 storm 默认编码(json)后的内容
+type Record struct {
+	Bucket string
+	Key    string
+	Value  []byte
+}
+
+type Exporter interface {
+	Export() ([]Record, error)
+}
+
+// Importer 用导入的记录替换存储中的所有数据
+type Importer interface {
+	Import(records []Record) error
+}
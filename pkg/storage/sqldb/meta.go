@@ -0,0 +1,109 @@
+package sqldb
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm/v3"
+)
+
+// meta 是从结构体中解析出的 storm 标签信息
+type meta struct {
+	bucket  string
+	id      string
+	uniques [][]int
+}
+
+// extract 按照 storm 的规则解析结构体: bucket 名称为类型名, 主键为 storm:"id" 或名为 ID 的字段,
+// 嵌入字段和 storm:"inline" 字段会被展开
+func extract(data interface{}) (reflect.Value, *meta, error) {
+	ref := reflect.ValueOf(data)
+	if !ref.IsValid() || ref.Kind() != reflect.Ptr || ref.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, nil, storm.ErrStructPtrNeeded
+	}
+	ref = ref.Elem()
+	m := &meta{bucket: ref.Type().Name()}
+	if m.bucket == "" {
+		return reflect.Value{}, nil, storm.ErrNoName
+	}
+	var idIndex []int
+	walkFields(ref.Type(), nil, func(f reflect.StructField, index []int) {
+		tags := strings.Split(f.Tag.Get("storm"), ",")
+		for _, tag := range tags {
+			switch tag {
+			case "id":
+				idIndex = index
+			case "unique":
+				m.uniques = append(m.uniques, index)
+			}
+		}
+		if idIndex == nil && f.Name == "ID" {
+			idIndex = index
+		}
+	})
+	if idIndex == nil {
+		return reflect.Value{}, nil, storm.ErrNoID
+	}
+	idValue := ref.FieldByIndex(idIndex)
+	if idValue.IsZero() {
+		return reflect.Value{}, nil, storm.ErrZeroID
+	}
+	id, err := toKey(idValue.Interface())
+	if err != nil {
+		return reflect.Value{}, nil, err
+	}
+	m.id = id
+	return ref, m, nil
+}
+
+func walkFields(t reflect.Type, parent []int, fn func(f reflect.StructField, index []int)) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		index := append(append([]int{}, parent...), i)
+		if f.Type.Kind() == reflect.Struct && (f.Anonymous || strings.Contains(f.Tag.Get("storm"), "inline")) {
+			walkFields(f.Type, index, fn)
+			continue
+		}
+		fn(f, index)
+	}
+}
+
+// toKey 与 storm 的主键编码保持一致: 字符串原样保存，其余类型使用 json 编码
+func toKey(key interface{}) (string, error) {
+	switch k := key.(type) {
+	case string:
+		return k, nil
+	case []byte:
+		return string(k), nil
+	}
+	bs, err := json.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+	return string(bs), nil
+}
+
+func less(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.String:
+		return a.String() < b.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() < b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return a.Uint() < b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() < b.Float()
+	case reflect.Bool:
+		return !a.Bool() && b.Bool()
+	case reflect.Struct:
+		if t, ok := a.Interface().(time.Time); ok {
+			return t.Before(b.Interface().(time.Time))
+		}
+	}
+	return false
+}
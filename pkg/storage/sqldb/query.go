@@ -0,0 +1,190 @@
+package sqldb
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+
+	"github.com/ClusterOperator/kubepi/pkg/storage"
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+)
+
+// query 在内存中执行 storm 的 matcher，KubePi 中每类记录的数量都很有限，
+// 因此不需要把查询条件翻译为 SQL
+type query struct {
+	node     node
+	matchers []q.Matcher
+	skip     int
+	limit    int
+	orderBy  []string
+	reverse  bool
+}
+
+func (s *query) Skip(n int) storage.Query {
+	s.skip = n
+	return s
+}
+
+func (s *query) Limit(n int) storage.Query {
+	s.limit = n
+	return s
+}
+
+func (s *query) OrderBy(fields ...string) storage.Query {
+	s.orderBy = fields
+	return s
+}
+
+func (s *query) Reverse() storage.Query {
+	s.reverse = true
+	return s
+}
+
+func (s *query) Find(to interface{}) error {
+	ref := reflect.ValueOf(to)
+	if !ref.IsValid() || ref.Kind() != reflect.Ptr || ref.Elem().Kind() != reflect.Slice {
+		return storm.ErrSlicePtrNeeded
+	}
+	elemType := ref.Elem().Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	if isPtr {
+		elemType = elemType.Elem()
+	}
+	items, err := s.run(elemType)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return storm.ErrNotFound
+	}
+	results := reflect.MakeSlice(ref.Elem().Type(), 0, len(items))
+	for _, item := range items {
+		if isPtr {
+			results = reflect.Append(results, item)
+		} else {
+			results = reflect.Append(results, item.Elem())
+		}
+	}
+	ref.Elem().Set(results)
+	return nil
+}
+
+func (s *query) First(to interface{}) error {
+	ref := reflect.ValueOf(to)
+	if !ref.IsValid() || ref.Kind() != reflect.Ptr || ref.Elem().Kind() != reflect.Struct {
+		return storm.ErrStructPtrNeeded
+	}
+	s.limit = 1
+	items, err := s.run(ref.Elem().Type())
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return storm.ErrNotFound
+	}
+	ref.Elem().Set(items[0].Elem())
+	return nil
+}
+
+func (s *query) Delete(kind interface{}) error {
+	ref := reflect.ValueOf(kind)
+	if !ref.IsValid() || ref.Kind() != reflect.Ptr || ref.Elem().Kind() != reflect.Struct {
+		return storm.ErrStructPtrNeeded
+	}
+	return s.node.readWriteTx(func(n node) error {
+		s.node = n
+		items, err := s.run(ref.Elem().Type())
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			if err := n.DeleteStruct(item.Interface()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *query) Count(kind interface{}) (int, error) {
+	ref := reflect.ValueOf(kind)
+	if !ref.IsValid() || ref.Kind() != reflect.Ptr || ref.Elem().Kind() != reflect.Struct {
+		return 0, storm.ErrStructPtrNeeded
+	}
+	items, err := s.run(ref.Elem().Type())
+	if err != nil {
+		return 0, err
+	}
+	return len(items), nil
+}
+
+// run 返回匹配的记录，每个元素都是指向 elemType 的指针
+func (s *query) run(elemType reflect.Type) ([]reflect.Value, error) {
+	items, err := s.node.decodeAll(elemType)
+	if err != nil {
+		return nil, err
+	}
+	var matched []reflect.Value
+	for _, item := range items {
+		if len(s.matchers) > 0 {
+			ok, err := q.And(s.matchers...).Match(item.Interface())
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		matched = append(matched, item)
+	}
+	if len(s.orderBy) > 0 {
+		sort.SliceStable(matched, func(i, j int) bool {
+			for _, field := range s.orderBy {
+				a := matched[i].Elem().FieldByName(field)
+				b := matched[j].Elem().FieldByName(field)
+				if !a.IsValid() || !b.IsValid() {
+					continue
+				}
+				if less(a, b) {
+					return true
+				}
+				if less(b, a) {
+					return false
+				}
+			}
+			return false
+		})
+	}
+	if s.reverse {
+		for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+			matched[i], matched[j] = matched[j], matched[i]
+		}
+	}
+	if s.skip > 0 {
+		if s.skip >= len(matched) {
+			return nil, nil
+		}
+		matched = matched[s.skip:]
+	}
+	if s.limit >= 0 && s.limit < len(matched) {
+		matched = matched[:s.limit]
+	}
+	return matched, nil
+}
+
+func (n node) decodeAll(elemType reflect.Type) ([]reflect.Value, error) {
+	raws, err := n.listRaw(elemType.Name())
+	if err != nil {
+		return nil, err
+	}
+	items := make([]reflect.Value, 0, len(raws))
+	for _, raw := range raws {
+		item := reflect.New(elemType)
+		if err := json.Unmarshal(raw, item.Interface()); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
@@ -0,0 +1,399 @@
+package sqldb
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/ClusterOperator/kubepi/pkg/storage"
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+const tableName = "kubepi_records"
+
+var ErrAlreadyInTransaction = errors.New("already in a transaction")
+
+// 每条记录以 storm 的编码方式(json)存放，bucket 与 storm 中的 bucket 一一对应，
+// 这样 bolt 文件可以直接按原始记录复制到 SQL 中
+var createTableSQL = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	bucket VARCHAR(128) NOT NULL,
+	id VARCHAR(255) NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (bucket, id)
+)`, tableName)
+
+type DB struct {
+	node
+}
+
+func Open(dialect string, dsn string) (*DB, error) {
+	var driver string
+	switch dialect {
+	case storage.TypeSqlite:
+		driver = "sqlite"
+	case storage.TypePostgres:
+		driver = "postgres"
+	default:
+		return nil, fmt.Errorf("unsupported sql dialect %s", dialect)
+	}
+	d, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if dialect == storage.TypeSqlite {
+		// sqlite 只允许单个写连接，避免 database is locked
+		d.SetMaxOpenConns(1)
+	}
+	if err := d.Ping(); err != nil {
+		_ = d.Close()
+		return nil, err
+	}
+	for _, stmt := range append([]string{createTableSQL}, createUniqueTableSQLs...) {
+		if _, err := d.Exec(stmt); err != nil {
+			_ = d.Close()
+			return nil, err
+		}
+	}
+	return &DB{node: node{db: d, dialect: dialect}}, nil
+}
+
+func (d *DB) Type() string {
+	return d.dialect
+}
+
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// Import 在一个事务中用原始记录替换所有数据，导入前已有的记录会被删除，与 bolt 替换整个数据库文件的行为一致
+func (d *DB) Import(records []storage.Record) error {
+	return d.readWriteTx(func(n node) error {
+		for _, table := range []string{tableName, uniqueTableName, uniqueFieldTableName} {
+			if _, err := n.conn().Exec(fmt.Sprintf("DELETE FROM %s", table)); err != nil {
+				return err
+			}
+		}
+		for i := range records {
+			if err := n.putRaw(records[i].Bucket, records[i].Key, records[i].Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Export 导出所有原始记录
+func (d *DB) Export() ([]storage.Record, error) {
+	rows, err := d.db.Query(fmt.Sprintf("SELECT bucket, id, data FROM %s ORDER BY bucket, id", tableName))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var records []storage.Record
+	for rows.Next() {
+		var r storage.Record
+		var data string
+		if err := rows.Scan(&r.Bucket, &r.Key, &data); err != nil {
+			return nil, err
+		}
+		r.Value = []byte(data)
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+type node struct {
+	db      *sql.DB
+	tx      *sql.Tx
+	dialect string
+}
+
+func (n node) conn() execer {
+	if n.tx != nil {
+		return n.tx
+	}
+	return n.db
+}
+
+// rebind 将 ? 占位符转换为 postgres 的 $n 形式
+func (n node) rebind(query string) string {
+	if n.dialect != storage.TypePostgres {
+		return query
+	}
+	var b strings.Builder
+	index := 0
+	for _, c := range query {
+		if c == '?' {
+			index++
+			b.WriteString(fmt.Sprintf("$%d", index))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func (n node) readWriteTx(fn func(n node) error) error {
+	if n.tx != nil {
+		return fn(n)
+	}
+	tx, err := n.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(node{db: n.db, tx: tx, dialect: n.dialect}); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (n node) getRaw(bucket, id string) ([]byte, error) {
+	var data string
+	err := n.conn().QueryRow(n.rebind(fmt.Sprintf("SELECT data FROM %s WHERE bucket = ? AND id = ?", tableName)), bucket, id).Scan(&data)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storm.ErrNotFound
+		}
+		return nil, err
+	}
+	return []byte(data), nil
+}
+
+func (n node) listRaw(bucket string) ([][]byte, error) {
+	rows, err := n.conn().Query(n.rebind(fmt.Sprintf("SELECT data FROM %s WHERE bucket = ? ORDER BY id", tableName)), bucket)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result [][]byte
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		result = append(result, []byte(data))
+	}
+	return result, rows.Err()
+}
+
+func (n node) putRaw(bucket, id string, data []byte) error {
+	_, err := n.conn().Exec(n.rebind(fmt.Sprintf(
+		"INSERT INTO %s (bucket, id, data) VALUES (?, ?, ?) ON CONFLICT (bucket, id) DO UPDATE SET data = excluded.data", tableName)),
+		bucket, id, string(data))
+	return err
+}
+
+func (n node) deleteRaw(bucket, id string) error {
+	result, err := n.conn().Exec(n.rebind(fmt.Sprintf("DELETE FROM %s WHERE bucket = ? AND id = ?", tableName)), bucket, id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return storm.ErrNotFound
+	}
+	return nil
+}
+
+func (n node) Save(data interface{}) error {
+	ref, m, err := extract(data)
+	if err != nil {
+		return err
+	}
+	return n.readWriteTx(func(n node) error {
+		return n.save(ref, m)
+	})
+}
+
+func (n node) save(ref reflect.Value, m *meta) error {
+	if err := n.saveUniques(ref, m); err != nil {
+		return err
+	}
+	bs, err := json.Marshal(ref.Addr().Interface())
+	if err != nil {
+		return err
+	}
+	return n.putRaw(m.bucket, m.id, bs)
+}
+
+func (n node) Update(data interface{}) error {
+	return n.update(data, func(ref reflect.Value, current reflect.Value) error {
+		for i := 0; i < ref.NumField(); i++ {
+			if ref.Type().Field(i).PkgPath != "" {
+				continue
+			}
+			f := ref.Field(i)
+			if !f.IsZero() {
+				current.Field(i).Set(f)
+			}
+		}
+		return nil
+	})
+}
+
+func (n node) UpdateField(data interface{}, fieldName string, value interface{}) error {
+	return n.update(data, func(ref reflect.Value, current reflect.Value) error {
+		f := current.FieldByName(fieldName)
+		if !f.IsValid() {
+			return storm.ErrNotFound
+		}
+		v := reflect.ValueOf(value)
+		if v.Kind() != f.Kind() {
+			return storm.ErrIncompatibleValue
+		}
+		f.Set(v)
+		return nil
+	})
+}
+
+func (n node) update(data interface{}, fn func(ref reflect.Value, current reflect.Value) error) error {
+	ref, m, err := extract(data)
+	if err != nil {
+		return err
+	}
+	return n.readWriteTx(func(n node) error {
+		raw, err := n.getRaw(m.bucket, m.id)
+		if err != nil {
+			return err
+		}
+		current := reflect.New(ref.Type())
+		if err := json.Unmarshal(raw, current.Interface()); err != nil {
+			return err
+		}
+		if err := fn(ref, current.Elem()); err != nil {
+			return err
+		}
+		return n.save(current.Elem(), m)
+	})
+}
+
+func (n node) DeleteStruct(data interface{}) error {
+	_, m, err := extract(data)
+	if err != nil {
+		return err
+	}
+	return n.readWriteTx(func(n node) error {
+		if err := n.deleteRaw(m.bucket, m.id); err != nil {
+			return err
+		}
+		return n.deleteUniques(m.bucket, m.id)
+	})
+}
+
+func (n node) One(fieldName string, value interface{}, to interface{}) error {
+	return n.Select(q.Eq(fieldName, value)).First(to)
+}
+
+func (n node) Find(fieldName string, value interface{}, to interface{}) error {
+	return n.Select(q.Eq(fieldName, value)).Find(to)
+}
+
+func (n node) All(to interface{}) error {
+	err := n.Select().Find(to)
+	if errors.Is(err, storm.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (n node) Select(matchers ...q.Matcher) storage.Query {
+	return &query{node: n, matchers: matchers, limit: -1}
+}
+
+func (n node) Get(bucketName string, key interface{}, to interface{}) error {
+	id, err := toKey(key)
+	if err != nil {
+		return err
+	}
+	raw, err := n.getRaw(bucketName, id)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, to)
+}
+
+func (n node) Set(bucketName string, key interface{}, value interface{}) error {
+	id, err := toKey(key)
+	if err != nil {
+		return err
+	}
+	bs, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return n.putRaw(bucketName, id, bs)
+}
+
+func (n node) Insert(bucketName string, key interface{}, value interface{}) error {
+	id, err := toKey(key)
+	if err != nil {
+		return err
+	}
+	bs, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	result, err := n.conn().Exec(n.rebind(fmt.Sprintf(
+		"INSERT INTO %s (bucket, id, data) VALUES (?, ?, ?) ON CONFLICT (bucket, id) DO NOTHING", tableName)),
+		bucketName, id, string(bs))
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return storm.ErrAlreadyExists
+	}
+	return nil
+}
+
+func (n node) Delete(bucketName string, key interface{}) error {
+	id, err := toKey(key)
+	if err != nil {
+		return err
+	}
+	return n.deleteRaw(bucketName, id)
+}
+
+func (n node) Begin(writable bool) (storage.Node, error) {
+	if n.tx != nil {
+		return nil, ErrAlreadyInTransaction
+	}
+	tx, err := n.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return node{db: n.db, tx: tx, dialect: n.dialect}, nil
+}
+
+func (n node) Commit() error {
+	if n.tx == nil {
+		return storm.ErrNotInTransaction
+	}
+	return n.tx.Commit()
+}
+
+func (n node) Rollback() error {
+	if n.tx == nil {
+		return storm.ErrNotInTransaction
+	}
+	return n.tx.Rollback()
+}
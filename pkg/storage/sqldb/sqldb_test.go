@@ -0,0 +1,202 @@
+package sqldb
+
+import (
+	"errors"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/ClusterOperator/kubepi/pkg/storage"
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+)
+
+type Meta struct {
+	Name string `json:"name" storm:"unique"`
+	UUID string `json:"uuid" storm:"id,index,unique"`
+}
+
+type Item struct {
+	Meta     `storm:"inline"`
+	Labels   []string  `json:"labels"`
+	Enable   bool      `json:"enable"`
+	CreateAt time.Time `json:"createAt"`
+}
+
+func openTestDB(t *testing.T) *DB {
+	db, err := Open(storage.TypeSqlite, path.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func TestSaveAndQuery(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Now()
+	for i, name := range []string{"b", "a", "c"} {
+		item := Item{Meta: Meta{Name: name, UUID: name + "-id"}, CreateAt: now.Add(time.Duration(i) * time.Minute)}
+		if err := db.Save(&item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Save(&Item{Meta: Meta{Name: "a", UUID: "other"}}); !errors.Is(err, storm.ErrAlreadyExists) {
+		t.Fatalf("expect ErrAlreadyExists, got %v", err)
+	}
+
+	var items []Item
+	query := db.Select().OrderBy("CreateAt").Reverse()
+	query.Limit(2).Skip(1)
+	if err := query.Find(&items); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].Name != "a" || items[1].Name != "b" {
+		t.Fatalf("unexpected result %+v", items)
+	}
+	count, err := db.Select(q.Not(q.Eq("Name", "a"))).Count(&Item{})
+	if err != nil || count != 2 {
+		t.Fatalf("unexpected count %d %v", count, err)
+	}
+	var one Item
+	if err := db.One("Name", "missing", &one); !errors.Is(err, storm.ErrNotFound) {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+}
+
+func TestUpdateKeepsZeroFields(t *testing.T) {
+	db := openTestDB(t)
+	item := Item{Meta: Meta{Name: "a", UUID: "a-id"}, Labels: []string{"x"}, Enable: true}
+	if err := db.Save(&item); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(&Item{Meta: Meta{Name: "b", UUID: "a-id"}}); err != nil {
+		t.Fatal(err)
+	}
+	var got Item
+	if err := db.One("UUID", "a-id", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "b" || !got.Enable || len(got.Labels) != 1 {
+		t.Fatalf("unexpected result %+v", got)
+	}
+	if err := db.UpdateField(&got, "Enable", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.One("UUID", "a-id", &got); err != nil || got.Enable {
+		t.Fatalf("unexpected result %+v %v", got, err)
+	}
+}
+
+func TestTransactionRollback(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.Set("db", "current_db_version", 1); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Set("db", "current_db_version", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Save(&Item{Meta: Meta{Name: "a", UUID: "a-id"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	var version int
+	if err := db.Get("db", "current_db_version", &version); err != nil || version != 1 {
+		t.Fatalf("unexpected version %d %v", version, err)
+	}
+	var items []Item
+	if err := db.All(&items); err != nil || len(items) != 0 {
+		t.Fatalf("unexpected items %+v %v", items, err)
+	}
+}
+
+func TestInsert(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.Insert("lock", "job-1", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Insert("lock", "job-1", "b"); !errors.Is(err, storm.ErrAlreadyExists) {
+		t.Fatalf("expect ErrAlreadyExists, got %v", err)
+	}
+	var v string
+	if err := db.Get("lock", "job-1", &v); err != nil || v != "a" {
+		t.Fatalf("unexpected value %s %v", v, err)
+	}
+}
+
+func TestUniqueIndex(t *testing.T) {
+	dsn := "file:" + path.Join(t.TempDir(), "test.sqlite") + "?_pragma=busy_timeout(5000)"
+	db, err := Open(storage.TypeSqlite, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Save(&Item{Meta: Meta{Name: "a", UUID: "1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(&Item{Meta: Meta{Name: "b", UUID: "1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Save(&Item{Meta: Meta{Name: "a", UUID: "2"}}); err != nil {
+		t.Fatalf("renamed value should be released, got %v", err)
+	}
+	if err := db.DeleteStruct(&Item{Meta: Meta{UUID: "1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Save(&Item{Meta: Meta{Name: "b", UUID: "3"}}); err != nil {
+		t.Fatalf("deleted value should be released, got %v", err)
+	}
+
+	// 其他实例写入的值同样受唯一约束
+	other, err := Open(storage.TypeSqlite, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if err := other.Save(&Item{Meta: Meta{Name: "b", UUID: "4"}}); !errors.Is(err, storm.ErrAlreadyExists) {
+		t.Fatalf("expect ErrAlreadyExists, got %v", err)
+	}
+
+	// 导入的原始记录在第一次写入时建立索引
+	records, err := db.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Import(records); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Save(&Item{Meta: Meta{Name: "a", UUID: "5"}}); !errors.Is(err, storm.ErrAlreadyExists) {
+		t.Fatalf("expect ErrAlreadyExists for imported value, got %v", err)
+	}
+	if err := db.Save(&Item{Meta: Meta{Name: "a", UUID: "2"}, Enable: true}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUniqueConflictInTransaction(t *testing.T) {
+	db := openTestDB(t)
+	for _, item := range []Item{{Meta: Meta{Name: "a", UUID: "1"}}, {Meta: Meta{Name: "b", UUID: "2"}}} {
+		if err := db.Save(&item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tx, err := db.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Update(&Item{Meta: Meta{Name: "b", UUID: "1"}}); !errors.Is(err, storm.ErrAlreadyExists) {
+		t.Fatalf("expect ErrAlreadyExists, got %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Save(&Item{Meta: Meta{Name: "a", UUID: "3"}}); !errors.Is(err, storm.ErrAlreadyExists) {
+		t.Fatalf("failed update should keep the index of the record, got %v", err)
+	}
+}
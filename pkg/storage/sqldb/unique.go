@@ -0,0 +1,146 @@
+package sqldb
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/asdine/storm/v3"
+)
+
+const (
+	uniqueTableName      = "kubepi_uniques"
+	uniqueFieldTableName = "kubepi_unique_fields"
+)
+
+// unique 字段的值保存在 kubepi_uniques 中，由主键保证同一个 bucket 内的值不重复，
+// 多个实例同时写入时由数据库保证只有一个成功。kubepi_unique_fields 记录已经建立索引的字段，
+// 导入的原始记录没有类型信息，对应的索引在第一次写入该类型时建立
+var createUniqueTableSQLs = []string{
+	fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	bucket VARCHAR(128) NOT NULL,
+	field VARCHAR(128) NOT NULL,
+	value TEXT NOT NULL,
+	id VARCHAR(255) NOT NULL,
+	PRIMARY KEY (bucket, field, value)
+)`, uniqueTableName),
+	fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_id ON %s (bucket, id)", uniqueTableName, uniqueTableName),
+	fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	bucket VARCHAR(128) NOT NULL,
+	field VARCHAR(128) NOT NULL,
+	PRIMARY KEY (bucket, field)
+)`, uniqueFieldTableName),
+}
+
+// saveUniques 更新记录的 unique 字段索引，值已经被其他记录使用时返回 storm.ErrAlreadyExists，需要在事务中调用
+func (n node) saveUniques(ref reflect.Value, m *meta) error {
+	if len(m.uniques) == 0 {
+		return nil
+	}
+	// 先检查冲突再修改索引，调用方在同一个事务中忽略错误继续提交时不会丢失当前记录的索引
+	for _, index := range m.uniques {
+		if err := n.ensureUniqueField(ref.Type(), m.bucket, index); err != nil {
+			return err
+		}
+		owner, err := n.uniqueOwner(ref, m.bucket, index)
+		if err != nil {
+			return err
+		}
+		if owner != "" && owner != m.id {
+			return storm.ErrAlreadyExists
+		}
+	}
+	if err := n.deleteUniques(m.bucket, m.id); err != nil {
+		return err
+	}
+	for _, index := range m.uniques {
+		ok, err := n.insertUnique(ref, m.bucket, m.id, index)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return storm.ErrAlreadyExists
+		}
+	}
+	return nil
+}
+
+func (n node) deleteUniques(bucket, id string) error {
+	_, err := n.conn().Exec(n.rebind(fmt.Sprintf("DELETE FROM %s WHERE bucket = ? AND id = ?", uniqueTableName)), bucket, id)
+	return err
+}
+
+// uniqueValue 返回 unique 字段的名称和编码后的值，零值不建立索引，返回的 ok 为 false
+func uniqueValue(ref reflect.Value, index []int) (field string, value string, ok bool, err error) {
+	v := ref.FieldByIndex(index)
+	if v.IsZero() {
+		return "", "", false, nil
+	}
+	bs, err := json.Marshal(v.Interface())
+	if err != nil {
+		return "", "", false, err
+	}
+	return ref.Type().FieldByIndex(index).Name, string(bs), true, nil
+}
+
+// uniqueOwner 返回使用了记录中 unique 字段的值的记录 id，没有记录使用或者是零值时返回空字符串
+func (n node) uniqueOwner(ref reflect.Value, bucket string, index []int) (string, error) {
+	field, value, ok, err := uniqueValue(ref, index)
+	if err != nil || !ok {
+		return "", err
+	}
+	var id string
+	err = n.conn().QueryRow(n.rebind(fmt.Sprintf("SELECT id FROM %s WHERE bucket = ? AND field = ? AND value = ?", uniqueTableName)),
+		bucket, field, value).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return id, err
+}
+
+// insertUnique 写入一个 unique 字段的值，值已经存在时返回 false
+func (n node) insertUnique(ref reflect.Value, bucket, id string, index []int) (bool, error) {
+	field, value, ok, err := uniqueValue(ref, index)
+	if err != nil || !ok {
+		return true, err
+	}
+	result, err := n.conn().Exec(n.rebind(fmt.Sprintf(
+		"INSERT INTO %s (bucket, field, value, id) VALUES (?, ?, ?, ?) ON CONFLICT (bucket, field, value) DO NOTHING", uniqueTableName)),
+		bucket, field, value, id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// ensureUniqueField 为还没有建立索引的字段写入 bucket 中已有记录的值，已有数据中重复的值只保留一个
+func (n node) ensureUniqueField(t reflect.Type, bucket string, index []int) error {
+	field := t.FieldByIndex(index).Name
+	var exists int
+	err := n.conn().QueryRow(n.rebind(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE bucket = ? AND field = ?", uniqueFieldTableName)), bucket, field).Scan(&exists)
+	if err != nil || exists > 0 {
+		return err
+	}
+	items, err := n.decodeAll(t)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		_, m, err := extract(item.Interface())
+		if err != nil {
+			return err
+		}
+		if _, err := n.insertUnique(item.Elem(), bucket, m.id, index); err != nil {
+			return err
+		}
+	}
+	_, err = n.conn().Exec(n.rebind(fmt.Sprintf(
+		"INSERT INTO %s (bucket, field) VALUES (?, ?) ON CONFLICT (bucket, field) DO NOTHING", uniqueFieldTableName)), bucket, field)
+	return err
+}
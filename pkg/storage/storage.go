@@ -0,0 +1,54 @@
+package storage
+
+import "github.com/asdine/storm/v3/q"
+
+const (
+	TypeBolt     = "bolt"
+	TypeSqlite   = "sqlite"
+	TypePostgres = "postgres"
+)
+
+// Query 是 storm.Query 中被业务使用到的子集
+type Query interface {
+	Skip(int) Query
+	Limit(int) Query
+	OrderBy(...string) Query
+	Reverse() Query
+	Find(to interface{}) error
+	First(to interface{}) error
+	Delete(kind interface{}) error
+	Count(kind interface{}) (int, error)
+}
+
+// Node 是 storm.Node 中被业务使用到的子集，所有后端都需要保持与 storm 一致的语义,
+// 例如查询不到数据时返回 storm.ErrNotFound，Update 只更新非零值字段
+type Node interface {
+	Save(data interface{}) error
+	Update(data interface{}) error
+	UpdateField(data interface{}, fieldName string, value interface{}) error
+	DeleteStruct(data interface{}) error
+	One(fieldName string, value interface{}, to interface{}) error
+	Find(fieldName string, value interface{}, to interface{}) error
+	All(to interface{}) error
+	Select(matchers ...q.Matcher) Query
+
+	Get(bucketName string, key interface{}, to interface{}) error
+	Set(bucketName string, key interface{}, value interface{}) error
+	Delete(bucketName string, key interface{}) error
+
+	Begin(writable bool) (Node, error)
+	Commit() error
+	Rollback() error
+}
+
+type DB interface {
+	Node
+	Type() string
+	Close() error
+}
+
+// Inserter 由多个实例可以同时访问的后端实现，Insert 只在 key 不存在时写入，
+// 已存在时返回 storm.ErrAlreadyExists，并发写入时只有一个成功
+type Inserter interface {
+	Insert(bucketName string, key interface{}, value interface{}) error
+}
@@ -157,6 +157,13 @@ func (sm *SessionMap) Set(sessionId string, session TerminalSession) {
 	sm.Sessions[sessionId] = session
 }
 
+// Len return the number of TerminalSession in SessionMap
+func (sm *SessionMap) Len() int {
+	sm.Lock.RLock()
+	defer sm.Lock.RUnlock()
+	return len(sm.Sessions)
+}
+
 // Close shuts down the SockJS connection and sends the status code and reason to the client
 // Can happen if the process exits or if there is an error starting up the process
 // For now the status code is unused and reason is shown to the user (unless "")
@@ -184,6 +191,11 @@ func (sm *SessionMap) Clean() {
 
 var TerminalSessions = SessionMap{Sessions: make(map[string]TerminalSession)}
 
+// SessionRestorer is called when a session can not be found in TerminalSessions,
+// e.g. the session is created by another replica. It should recreate the session
+// and start WaitForTerminal, returns false if the session does not exist
+var SessionRestorer func(sessionId string) bool
+
 // handleTerminalSession is Called by net/http for any new /api/sockjs connections
 func handleTerminalSession(session sockjs.Session) {
 	var (
@@ -209,6 +221,11 @@ func handleTerminalSession(session sockjs.Session) {
 	}
 
 	if terminalSession = TerminalSessions.Get(msg.SessionID); terminalSession.Id == "" {
+		if SessionRestorer != nil && SessionRestorer(msg.SessionID) {
+			terminalSession = TerminalSessions.Get(msg.SessionID)
+		}
+	}
+	if terminalSession.Id == "" {
 		log.Printf("handleTerminalSession: can't find session '%s'", msg.SessionID)
 		return
 	}
@@ -0,0 +1,319 @@
+// Package tunnel connects KubePi to clusters that can not be reached directly.
+//
+// An agent running inside the cluster dials KubePi over a websocket and the
+// connection is multiplexed with yamux: KubePi opens a stream for every
+// connection to the cluster and the agent pipes the stream to the API server.
+// Clients reach the tunnels through a local HTTP CONNECT proxy, which works for
+// plain requests as well as for the SPDY connections used by exec.
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/yamux"
+)
+
+var ErrNotConnected = errors.New("agent is not connected")
+
+// NewToken returns a random token used for registration and agent authentication
+func NewToken() (string, error) {
+	bs := make([]byte, 32)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bs), nil
+}
+
+// HashToken returns the hash of an agent token, only the hash is saved by KubePi
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyToken compares token with the saved hash in constant time
+func VerifyToken(token, hash string) bool {
+	if token == "" || hash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(HashToken(token)), []byte(hash)) == 1
+}
+
+// Registry keeps the tunnel of every connected cluster
+type Registry struct {
+	mu       sync.RWMutex
+	sessions map[string]*yamux.Session
+	fallback func(cluster string) (net.Conn, error)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{sessions: map[string]*yamux.Session{}}
+}
+
+// Default is the registry used by the server and pkg/kubernetes
+var Default = NewRegistry()
+
+var defaultProxy *Proxy
+
+// StartDefaultProxy starts the proxy of Default, it must be called before ProxyURL
+func StartDefaultProxy() error {
+	p, err := Default.StartProxy()
+	if err != nil {
+		return err
+	}
+	defaultProxy = p
+	return nil
+}
+
+// ProxyURL returns the url of the default proxy for cluster
+func ProxyURL(cluster string) (*url.URL, error) {
+	if defaultProxy == nil {
+		return nil, errors.New("tunnel proxy is not started")
+	}
+	return defaultProxy.URL(cluster), nil
+}
+
+// Open multiplexes conn and registers it as the tunnel of cluster, an existing
+// tunnel of the same cluster is closed. The returned channel is closed after the
+// tunnel is closed and unregistered.
+func (r *Registry) Open(cluster string, conn net.Conn) (<-chan struct{}, error) {
+	s, err := yamux.Client(conn, yamuxConfig())
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	old := r.sessions[cluster]
+	r.sessions[cluster] = s
+	r.mu.Unlock()
+	if old != nil {
+		_ = old.Close()
+	}
+	done := make(chan struct{})
+	go func() {
+		<-s.CloseChan()
+		r.mu.Lock()
+		if r.sessions[cluster] == s {
+			delete(r.sessions, cluster)
+		}
+		r.mu.Unlock()
+		close(done)
+	}()
+	return done, nil
+}
+
+// Serve is like Open but blocks until the tunnel is closed
+func (r *Registry) Serve(cluster string, conn net.Conn) error {
+	done, err := r.Open(cluster, conn)
+	if err != nil {
+		return err
+	}
+	<-done
+	return nil
+}
+
+func (r *Registry) Connected(cluster string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.sessions[cluster]
+	return ok
+}
+
+// Disconnect closes the tunnel of cluster, for example when the cluster is removed
+func (r *Registry) Disconnect(cluster string) {
+	r.mu.RLock()
+	s := r.sessions[cluster]
+	r.mu.RUnlock()
+	if s != nil {
+		_ = s.Close()
+	}
+}
+
+// SetFallback sets the dial function used when cluster has no tunnel in this
+// registry, for example to reach a tunnel held by another KubePi replica
+func (r *Registry) SetFallback(dial func(cluster string) (net.Conn, error)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fallback = dial
+}
+
+// Dial opens a connection to the API server of cluster through its tunnel
+func (r *Registry) Dial(cluster string) (net.Conn, error) {
+	r.mu.RLock()
+	s, fallback := r.sessions[cluster], r.fallback
+	r.mu.RUnlock()
+	if s == nil && fallback != nil {
+		return fallback(cluster)
+	}
+	if s == nil {
+		return nil, fmt.Errorf("cluster %s: %w", cluster, ErrNotConnected)
+	}
+	return s.Open()
+}
+
+// Proxy is a local HTTP CONNECT proxy, the cluster name is passed as the proxy user name
+type Proxy struct {
+	registry *Registry
+	secret   string
+	listener net.Listener
+}
+
+// StartProxy listens on a random loopback port, connections are accepted in background
+func (r *Registry) StartProxy() (*Proxy, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	bs := make([]byte, 16)
+	if _, err := rand.Read(bs); err != nil {
+		_ = l.Close()
+		return nil, err
+	}
+	p := &Proxy{registry: r, secret: hex.EncodeToString(bs), listener: l}
+	go func() {
+		_ = http.Serve(l, p)
+	}()
+	return p, nil
+}
+
+// URL returns the proxy url for cluster
+func (p *Proxy) URL(cluster string) *url.URL {
+	return &url.URL{
+		Scheme: "http",
+		User:   url.UserPassword(cluster, p.secret),
+		Host:   p.listener.Addr().String(),
+	}
+}
+
+func (p *Proxy) Close() error {
+	return p.listener.Close()
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		http.Error(w, "only CONNECT is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	cluster, ok := p.authenticate(r.Header.Get("Proxy-Authorization"))
+	if !ok {
+		w.Header().Set("Proxy-Authenticate", "Basic")
+		http.Error(w, "proxy authentication required", http.StatusProxyAuthRequired)
+		return
+	}
+	upstream, err := p.registry.Dial(cluster)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		_ = upstream.Close()
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, buf, err := hj.Hijack()
+	if err != nil {
+		_ = upstream.Close()
+		return
+	}
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		_ = conn.Close()
+		_ = upstream.Close()
+		return
+	}
+	Pipe(&bufferedConn{Conn: conn, r: buf.Reader}, upstream)
+}
+
+func (p *Proxy) authenticate(header string) (string, bool) {
+	bs, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(header, "Basic "))
+	if err != nil {
+		return "", false
+	}
+	user, password, ok := strings.Cut(string(bs), ":")
+	if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(p.secret)) != 1 {
+		return "", false
+	}
+	return user, true
+}
+
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// Pipe copies data between a and b until either side is closed
+func Pipe(a, b net.Conn) {
+	var once sync.Once
+	closeBoth := func() {
+		_ = a.Close()
+		_ = b.Close()
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(a, b)
+		once.Do(closeBoth)
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(b, a)
+		once.Do(closeBoth)
+	}()
+	wg.Wait()
+}
+
+// ServeAgent accepts the streams opened by KubePi on conn and pipes each of them
+// to a new connection returned by dial. It blocks until conn is closed.
+func ServeAgent(ctx context.Context, conn net.Conn, dial func() (net.Conn, error)) error {
+	s, err := yamux.Server(conn, yamuxConfig())
+	if err != nil {
+		return err
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = s.Close()
+		case <-s.CloseChan():
+		}
+	}()
+	for {
+		stream, err := s.Accept()
+		if err != nil {
+			if s.IsClosed() {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			upstream, err := dial()
+			if err != nil {
+				_ = stream.Close()
+				return
+			}
+			Pipe(stream, upstream)
+		}()
+	}
+}
+
+func yamuxConfig() *yamux.Config {
+	c := yamux.DefaultConfig()
+	c.LogOutput = io.Discard
+	return c
+}
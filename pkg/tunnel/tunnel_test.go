@@ -0,0 +1,166 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestTunnel(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok "+r.URL.Path)
+	}))
+	defer api.Close()
+
+	registry := NewRegistry()
+	opened := make(chan (<-chan struct{}), 1)
+	upgrader := websocket.Upgrader{}
+	kubepi := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		done, err := registry.Open("c1", NewConn(ws))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		opened <- done
+	}))
+	defer kubepi.Close()
+
+	proxy, err := registry.StartProxy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+	// get sends a request through the tunnel like the SPDY and https transports do
+	get := func() (string, error) {
+		conn, err := net.Dial("tcp", proxy.listener.Addr().String())
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		req, _ := http.NewRequest(http.MethodConnect, "http://"+api.Listener.Addr().String(), nil)
+		req.Host = api.Listener.Addr().String()
+		req.Header.Set("Proxy-Authorization", "Basic "+basicAuth("c1", proxy.secret))
+		if err := req.Write(conn); err != nil {
+			return "", err
+		}
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return "", err
+		}
+		if !strings.HasPrefix(string(buf[:n]), "HTTP/1.1 200") {
+			return "", errors.New(string(buf[:n]))
+		}
+		if _, err := io.WriteString(conn, "GET /version HTTP/1.1\r\nHost: api\r\nConnection: close\r\n\r\n"); err != nil {
+			return "", err
+		}
+		bs, err := io.ReadAll(conn)
+		return string(bs), err
+	}
+
+	if _, err := get(); err == nil {
+		t.Fatal("expect error before the agent is connected")
+	}
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(kubepi.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	agentDone := make(chan error, 1)
+	go func() {
+		agentDone <- ServeAgent(ctx, NewConn(ws), func() (net.Conn, error) {
+			return net.Dial("tcp", api.Listener.Addr().String())
+		})
+	}()
+	done := <-opened
+	if !registry.Connected("c1") {
+		t.Fatal("expect c1 connected")
+	}
+	body, err := get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(body, "ok /version") {
+		t.Fatalf("unexpected response %q", body)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("tunnel is not closed")
+	}
+	if registry.Connected("c1") {
+		t.Fatal("expect c1 disconnected")
+	}
+	if err := <-agentDone; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFallback(t *testing.T) {
+	registry := NewRegistry()
+	if _, err := registry.Dial("c1"); !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("expect not connected, got %v", err)
+	}
+	remote, local := net.Pipe()
+	defer remote.Close()
+	registry.SetFallback(func(cluster string) (net.Conn, error) {
+		if cluster != "c1" {
+			return nil, ErrNotConnected
+		}
+		return local, nil
+	})
+	conn, err := registry.Dial("c1")
+	if err != nil || conn != local {
+		t.Fatalf("expect the fallback connection, got %v %v", conn, err)
+	}
+	if registry.Connected("c1") {
+		t.Fatal("fallback should not make c1 connected")
+	}
+}
+
+func TestProxyAuthenticate(t *testing.T) {
+	p := &Proxy{secret: "s"}
+	if cluster, ok := p.authenticate("Basic " + basicAuth("c1", "s")); !ok || cluster != "c1" {
+		t.Fatalf("expect c1 authenticated, got %s %v", cluster, ok)
+	}
+	if _, ok := p.authenticate("Basic " + basicAuth("c1", "x")); ok {
+		t.Fatal("expect wrong secret rejected")
+	}
+	if _, ok := p.authenticate(""); ok {
+		t.Fatal("expect empty header rejected")
+	}
+}
+
+func TestToken(t *testing.T) {
+	token, err := NewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := HashToken(token)
+	if !VerifyToken(token, hash) {
+		t.Fatal("expect token verified")
+	}
+	if VerifyToken(token+"x", hash) || VerifyToken("", "") {
+		t.Fatal("expect invalid token rejected")
+	}
+}
+
+func basicAuth(user, password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(user + ":" + password))
+}
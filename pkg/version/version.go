@@ -0,0 +1,4 @@
+package version
+
+// Version 在构建时通过 -ldflags "-X github.com/ClusterOperator/kubepi/pkg/version.Version=xxx" 注入
+var Version = "dev"